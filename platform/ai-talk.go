@@ -37,21 +37,21 @@ type ASRResult struct {
 	Duration time.Duration
 }
 
-type openaiASRService struct {
-	conf openai.ClientConfig
+type asrService struct {
+	provider ASRProvider
 	// The callback before start ASR request.
 	onBeforeRequest func()
 }
 
-func NewOpenAIASRService(conf openai.ClientConfig, opts ...func(service *openaiASRService)) *openaiASRService {
-	v := &openaiASRService{conf: conf}
+func NewASRService(provider ASRProvider, opts ...func(service *asrService)) *asrService {
+	v := &asrService{provider: provider}
 	for _, opt := range opts {
 		opt(v)
 	}
 	return v
 }
 
-func (v *openaiASRService) RequestASR(ctx context.Context, inputFile, language, prompt string) (*ASRResult, error) {
+func (v *asrService) RequestASR(ctx context.Context, inputFile, language, prompt string) (*ASRResult, error) {
 	outputFile := fmt.Sprintf("%v.mp4", inputFile)
 	defer os.Remove(outputFile)
 
@@ -74,18 +74,7 @@ func (v *openaiASRService) RequestASR(ctx context.Context, inputFile, language,
 	}
 
 	// Request ASR.
-	client := openai.NewClientWithConfig(v.conf)
-	resp, err := client.CreateTranscription(
-		ctx,
-		openai.AudioRequest{
-			Model:    openai.Whisper1,
-			FilePath: outputFile,
-			// Note that must use verbose JSON, to get the duration of file.
-			Format:   openai.AudioResponseFormatVerboseJSON,
-			Language: language,
-			Prompt:   prompt,
-		},
-	)
+	resp, err := v.provider.Transcribe(ctx, outputFile, language, prompt)
 	if err != nil {
 		return nil, errors.Wrapf(err, "asr")
 	}
@@ -613,11 +602,16 @@ func (v *StageRequest) FastDispose() error {
 	return nil
 }
 
-func (v *StageRequest) asrAudioToText(ctx context.Context, aiConfig openai.ClientConfig, asrLanguage, previousAsrText string) error {
+func (v *StageRequest) asrAudioToText(ctx context.Context, asrProvider *SrsAssistantProvider, asrLanguage, previousAsrText string) error {
 	var asrText string
 	var asrDuration time.Duration
 
-	asrService := NewOpenAIASRService(aiConfig, func(*openaiASRService) {
+	provider, err := NewASRProvider(asrProvider)
+	if err != nil {
+		return errors.Wrapf(err, "asr provider")
+	}
+
+	asrService := NewASRService(provider, func(*asrService) {
 		v.lastExtractAudio = time.Now()
 	})
 
@@ -1047,6 +1041,8 @@ type Stage struct {
 
 	// The AI configuration.
 	aiConfig openai.ClientConfig
+	// The AI provider for ASR.
+	asrProvider SrsAssistantProvider
 	// The room it belongs to. Note that it's a caching object, update when updating the room. The room object
 	// is not the same one, even the uuid is the same. The room is always available when stage is not expired.
 	room *SrsLiveRoom
@@ -1138,6 +1134,7 @@ func (v *Stage) UpdateFromRoom(room *SrsLiveRoom) {
 	v.aiConfig = openai.DefaultConfig(room.AISecretKey)
 	v.aiConfig.OrgID = room.AIOrganization
 	v.aiConfig.BaseURL = room.AIBaseURL
+	v.asrProvider = *room.ASRProvider()
 
 	// Bind stage to room.
 	room.StageUUID = v.sid
@@ -1789,7 +1786,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 				// Do ASR, convert to text.
 				asrLanguage := ChooseNotEmpty(user.Language, stage.asrLanguage)
-				if err := sreq.asrAudioToText(ctx, stage.aiConfig, asrLanguage, user.previousAsrText); err != nil {
+				if err := sreq.asrAudioToText(ctx, &stage.asrProvider, asrLanguage, user.previousAsrText); err != nil {
 					return errors.Wrapf(err, "asr lang=%v, previous=%v", asrLanguage, user.previousAsrText)
 				}
 				logger.Tf(ctx, "ASR ok, sid=%v, rid=%v, user=%v, lang=%v, prompt=<%v>, resp is <%v>",
@@ -0,0 +1,446 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+const (
+	// The admin scope grants all permissions, which is the scope of API secret and tokens without scope.
+	ScopeAdmin = "admin"
+	// The scopes for streams, to query or kickoff the streams.
+	ScopeStreamsRead  = "streams:read"
+	ScopeStreamsWrite = "streams:write"
+	// The scopes for recording, to query or update the config and files of recording.
+	ScopeRecordRead  = "record:read"
+	ScopeRecordWrite = "record:write"
+	// The scope for live rooms, to create, update or remove the rooms.
+	ScopeRoomsManage = "rooms:manage"
+	// The scope for Prometheus to scrape the metrics.
+	ScopeMetricsRead = "metrics:read"
+	// The scope to subscribe the realtime events, such as stream published.
+	ScopeEventsRead = "events:read"
+)
+
+// apiKeyScopes is all the valid scopes of API key.
+var apiKeyScopes = []string{
+	ScopeAdmin, ScopeStreamsRead, ScopeStreamsWrite, ScopeRecordRead, ScopeRecordWrite, ScopeRoomsManage,
+	ScopeMetricsRead, ScopeEventsRead,
+}
+
+// The interval to update the last used time of API key, to avoid writing redis for each request.
+const apiKeyUsedInterval = time.Minute
+
+// APIKey is a named key with scopes, which is a JWT signed by the API secret, and can be revoked.
+type APIKey struct {
+	// The key ID, which is the jti of JWT.
+	ID string `json:"id"`
+	// The name of key, such as ci or dashboard.
+	Name string `json:"name"`
+	// The scopes of key, such as streams:read and record:write.
+	Scopes []string `json:"scopes"`
+	// The create and expire time in RFC3339, never expire if empty.
+	CreateAt string `json:"createAt"`
+	ExpireAt string `json:"expireAt,omitempty"`
+	// Whether the key is revoked, and the revoke time in RFC3339.
+	Revoked  bool   `json:"revoked,omitempty"`
+	RevokeAt string `json:"revokeAt,omitempty"`
+	// The last used time in RFC3339, only for query.
+	LastUsed string `json:"lastUsed,omitempty"`
+}
+
+func (v *APIKey) String() string {
+	return fmt.Sprintf("id=%v, name=%v, scopes=%v, create=%v, expire=%v, revoked=%v",
+		v.ID, v.Name, strings.Join(v.Scopes, " "), v.CreateAt, v.ExpireAt, v.Revoked)
+}
+
+// Expired returns whether the key is expired at the time of now.
+func (v *APIKey) Expired(now time.Time) bool {
+	if v.ExpireAt == "" {
+		return false
+	}
+	if expireAt, err := time.Parse(time.RFC3339, v.ExpireAt); err == nil && now.After(expireAt) {
+		return true
+	}
+	return false
+}
+
+// apiKeyClaims is the claims of JWT, the scope is separated by space, see RFC8693.
+// For user session, the sub is the user ID and the jti is the session ID, see UserSession.
+type apiKeyClaims struct {
+	Version string `json:"v"`
+	Scope   string `json:"scope,omitempty"`
+	// The role of user, only for user session.
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// parseScopes parse and validate the scopes, which are separated by comma or space.
+func parseScopes(scopes []string) ([]string, error) {
+	var r []string
+	exists := make(map[string]bool)
+	for _, scope := range scopes {
+		for _, s := range strings.FieldsFunc(scope, func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			if s = strings.ToLower(s); exists[s] {
+				continue
+			}
+
+			valid := false
+			for _, scope := range apiKeyScopes {
+				valid = valid || s == scope
+			}
+			if !valid {
+				return nil, errors.Errorf("invalid scope %v", s)
+			}
+
+			r = append(r, s)
+			exists[s] = true
+		}
+	}
+
+	if len(r) == 0 {
+		return nil, errors.New("no scope")
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+// scopeAllowed returns whether the granted scopes allow the required scope. The admin scope allows all, and
+// the write scope implies the read scope, for example, streams:write allows streams:read.
+func scopeAllowed(granted []string, required string) bool {
+	for _, scope := range granted {
+		if scope == ScopeAdmin || scope == required {
+			return true
+		}
+		if strings.HasSuffix(required, ":read") && scope == strings.TrimSuffix(required, ":read")+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// createAPIKeyToken build the JWT of API key, signed by the API secret.
+func createAPIKeyToken(apiSecret string, key *APIKey) (string, error) {
+	createAt, err := time.Parse(time.RFC3339, key.CreateAt)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse create %v", key.CreateAt)
+	}
+
+	claims := apiKeyClaims{
+		Version: "1.0",
+		Scope:   strings.Join(key.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       key.ID,
+			IssuedAt: jwt.NewNumericDate(createAt),
+		},
+	}
+	if key.ExpireAt != "" {
+		expireAt, err := time.Parse(time.RFC3339, key.ExpireAt)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse expire %v", key.ExpireAt)
+		}
+		claims.ExpiresAt = jwt.NewNumericDate(expireAt)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(apiSecret))
+	if err != nil {
+		return "", errors.Wrapf(err, "jwt sign")
+	}
+	return token, nil
+}
+
+// parseAPIKeyToken verify the JWT by the API secret, and returns the claims.
+func parseAPIKeyToken(apiSecret, token string) (*apiKeyClaims, error) {
+	var claims apiKeyClaims
+	// See https://pkg.go.dev/github.com/golang-jwt/jwt/v4#example-Parse-Hmac
+	if _, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		// Don't forget to validate the alg is what you expect:
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiSecret), nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "verify token %v", token)
+	}
+	return &claims, nil
+}
+
+// verifyAPIKeyToken verify the JWT and check the required scope. The token without scope is issued by the token
+// API, which is allowed for all scopes. The token with key ID must be an active API key. The token with subject
+// is a user session, which is allowed by the current role of user.
+func verifyAPIKeyToken(ctx context.Context, apiSecret, token, scope string) error {
+	claims, err := parseAPIKeyToken(apiSecret, token)
+	if err != nil {
+		return err
+	}
+
+	if claims.Subject != "" {
+		user, err := verifyUserSession(ctx, claims)
+		if err != nil {
+			return errors.Wrapf(err, "verify session")
+		}
+		if !scopeAllowed(userRoleScopes[user.Role], scope) {
+			return errors.Errorf("scope %v not allowed, user %v role %v", scope, user.Name, user.Role)
+		}
+		return nil
+	}
+
+	if claims.Scope == "" {
+		return nil
+	}
+
+	if claims.ID != "" {
+		key, err := loadAPIKey(ctx, claims.ID)
+		if err != nil {
+			return errors.Wrapf(err, "load key %v", claims.ID)
+		}
+		if key == nil {
+			return errors.Errorf("no key %v", claims.ID)
+		}
+		if key.Revoked {
+			return errors.Errorf("key %v revoked", claims.ID)
+		}
+		if key.Expired(time.Now()) {
+			return errors.Errorf("key %v expired", claims.ID)
+		}
+
+		apiKeyUsed.update(ctx, key.ID)
+	}
+
+	if scopes := strings.Fields(claims.Scope); !scopeAllowed(scopes, scope) {
+		return errors.Errorf("scope %v not allowed, granted %v", scope, claims.Scope)
+	}
+	return nil
+}
+
+// loadAPIKey load the API key by ID, returns nil if not exists.
+func loadAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	b, err := rdb.HGet(ctx, SRS_API_KEYS, id).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_API_KEYS, id)
+	}
+	if b == "" {
+		return nil, nil
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(b), &key); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &key, nil
+}
+
+func saveAPIKey(ctx context.Context, key *APIKey) error {
+	if b, err := json.Marshal(key); err != nil {
+		return errors.Wrapf(err, "marshal %v", key.String())
+	} else if err := rdb.HSet(ctx, SRS_API_KEYS, key.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_API_KEYS, key.ID)
+	}
+	return nil
+}
+
+// apiKeyUsedTracker update the last used time of API keys, at most once per interval for each key.
+type apiKeyUsedTracker struct {
+	// The last update time of keys, key is ID, value is time.Time.
+	updates sync.Map
+}
+
+var apiKeyUsed = &apiKeyUsedTracker{}
+
+func (v *apiKeyUsedTracker) update(ctx context.Context, id string) {
+	now := time.Now()
+	if last, ok := v.updates.Load(id); ok && now.Sub(last.(time.Time)) < apiKeyUsedInterval {
+		return
+	}
+	v.updates.Store(id, now)
+
+	if err := rdb.HSet(ctx, SRS_API_KEYS_USED, id, now.Format(time.RFC3339)).Err(); err != nil && err != redis.Nil {
+		logger.Wf(ctx, "ignore update key %v used err %+v", id, err)
+	}
+}
+
+func handleMgmtAPIKeys(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/keys/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, name string
+			var scopes []string
+			var expire int64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string   `json:"token"`
+				Name   *string   `json:"name"`
+				Scopes *[]string `json:"scopes"`
+				Expire *int64    `json:"expire"`
+			}{
+				Token: &token, Name: &name, Scopes: &scopes, Expire: &expire,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if name = strings.TrimSpace(name); name == "" {
+				return errors.New("no name")
+			}
+			if expire < 0 {
+				return errors.Errorf("invalid expire %v", expire)
+			}
+
+			scopes, err := parseScopes(scopes)
+			if err != nil {
+				return errors.Wrapf(err, "parse scopes")
+			}
+
+			now := time.Now()
+			key := &APIKey{
+				ID: uuid.NewString(), Name: name, Scopes: scopes, CreateAt: now.Format(time.RFC3339),
+			}
+			if expire > 0 {
+				key.ExpireAt = now.Add(time.Duration(expire) * time.Second).Format(time.RFC3339)
+			}
+
+			keyToken, err := createAPIKeyToken(apiSecret, key)
+			if err != nil {
+				return errors.Wrapf(err, "create token for %v", key.String())
+			}
+
+			if err := saveAPIKey(ctx, key); err != nil {
+				return errors.Wrapf(err, "save %v", key.String())
+			}
+
+			// Note that the token is only responded when created, we never store it.
+			ohttp.WriteData(ctx, w, r, &struct {
+				*APIKey
+				Token string `json:"token"`
+			}{
+				APIKey: key, Token: keyToken,
+			})
+			logger.Tf(ctx, "keys create ok, %v, token=%vB", key.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/keys/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			values, err := rdb.HGetAll(ctx, SRS_API_KEYS).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_API_KEYS)
+			}
+
+			used, err := rdb.HGetAll(ctx, SRS_API_KEYS_USED).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_API_KEYS_USED)
+			}
+
+			keys := []*APIKey{}
+			for id, value := range values {
+				var key APIKey
+				if err := json.Unmarshal([]byte(value), &key); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", id, value)
+				}
+
+				key.LastUsed = used[id]
+				keys = append(keys, &key)
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i].CreateAt < keys[j].CreateAt
+			})
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Keys []*APIKey `json:"keys"`
+			}{
+				Keys: keys,
+			})
+			logger.Tf(ctx, "keys query ok, keys=%v, token=%vB", len(keys), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/keys/revoke"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if id == "" {
+				return errors.New("no id")
+			}
+
+			key, err := loadAPIKey(ctx, id)
+			if err != nil {
+				return errors.Wrapf(err, "load key %v", id)
+			}
+			if key == nil {
+				return errors.Errorf("no key %v", id)
+			}
+
+			if !key.Revoked {
+				key.Revoked, key.RevokeAt = true, time.Now().Format(time.RFC3339)
+				if err := saveAPIKey(ctx, key); err != nil {
+					return errors.Wrapf(err, "save %v", key.String())
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, key)
+			logger.Tf(ctx, "keys revoke ok, %v, token=%vB", key.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "Single", scopes: []string{"streams:read"}, want: []string{"streams:read"}},
+		{name: "Sorted", scopes: []string{"record:write", "streams:read"}, want: []string{"record:write", "streams:read"}},
+		{name: "Separated", scopes: []string{"rooms:manage, Streams:Read"}, want: []string{"rooms:manage", "streams:read"}},
+		{name: "Duplicated", scopes: []string{"admin", "admin"}, want: []string{"admin"}},
+		{name: "Invalid", scopes: []string{"streams:delete"}, wantErr: true},
+		{name: "Empty", scopes: []string{" "}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScopes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required string
+		want     bool
+	}{
+		{name: "Admin", granted: []string{ScopeAdmin}, required: ScopeRecordWrite, want: true},
+		{name: "Exact", granted: []string{ScopeStreamsRead}, required: ScopeStreamsRead, want: true},
+		{name: "WriteImpliesRead", granted: []string{ScopeRecordWrite}, required: ScopeRecordRead, want: true},
+		{name: "ReadNotWrite", granted: []string{ScopeRecordRead}, required: ScopeRecordWrite, want: false},
+		{name: "OtherResource", granted: []string{ScopeStreamsWrite}, required: ScopeRecordRead, want: false},
+		{name: "NotAdmin", granted: []string{ScopeRoomsManage}, required: ScopeAdmin, want: false},
+		{name: "Empty", granted: nil, required: ScopeStreamsRead, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scopeAllowed(tt.granted, tt.required); got != tt.want {
+				t.Errorf("scopeAllowed(%v, %v) = %v, want %v", tt.granted, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyToken(t *testing.T) {
+	apiSecret := "test-secret"
+	now := time.Now()
+
+	key := &APIKey{
+		ID: "key-id", Name: "ci", Scopes: []string{ScopeRecordWrite, ScopeStreamsRead},
+		CreateAt: now.Format(time.RFC3339), ExpireAt: now.Add(time.Hour).Format(time.RFC3339),
+	}
+	token, err := createAPIKeyToken(apiSecret, key)
+	if err != nil {
+		t.Fatalf("createAPIKeyToken() error = %v", err)
+	}
+
+	claims, err := parseAPIKeyToken(apiSecret, token)
+	if err != nil {
+		t.Fatalf("parseAPIKeyToken() error = %v", err)
+	}
+	if claims.ID != key.ID || claims.Scope != "record:write streams:read" || claims.ExpiresAt == nil {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if _, err := parseAPIKeyToken("other-secret", token); err == nil {
+		t.Errorf("expect error for other secret")
+	}
+
+	key.ExpireAt = now.Add(-time.Hour).Format(time.RFC3339)
+	if !key.Expired(now) {
+		t.Errorf("expect key expired")
+	}
+	if token, err = createAPIKeyToken(apiSecret, key); err != nil {
+		t.Fatalf("createAPIKeyToken() error = %v", err)
+	}
+	if _, err := parseAPIKeyToken(apiSecret, token); err == nil {
+		t.Errorf("expect error for expired token")
+	}
+}
+
+func TestAuthenticateWithScope(t *testing.T) {
+	apiSecret := "test-secret"
+	ctx := context.Background()
+
+	// The token with scope claim but without key ID, which doesn't require redis.
+	scoped, err := jwt.NewWithClaims(jwt.SigningMethodHS256, apiKeyClaims{
+		Version: "1.0", Scope: ScopeStreamsRead,
+	}).SignedString([]byte(apiSecret))
+	if err != nil {
+		t.Fatalf("sign token error = %v", err)
+	}
+
+	// The legacy token without scope claim, which is allowed for all scopes.
+	_, _, legacy, err := createToken(ctx, apiSecret)
+	if err != nil {
+		t.Fatalf("createToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		header  http.Header
+		scope   string
+		wantErr bool
+	}{
+		{name: "SecretAdmin", header: http.Header{"Authorization": []string{"Bearer " + apiSecret}}, scope: ScopeAdmin},
+		{name: "LegacyAdmin", token: legacy, header: http.Header{}, scope: ScopeAdmin},
+		{name: "ScopedToken", token: scoped, header: http.Header{}, scope: ScopeStreamsRead},
+		{name: "ScopedBearer", header: http.Header{"Authorization": []string{"Bearer " + scoped}}, scope: ScopeStreamsRead},
+		{name: "ScopedDenied", token: scoped, header: http.Header{}, scope: ScopeStreamsWrite, wantErr: true},
+		{name: "ScopedNotAdmin", header: http.Header{"Authorization": []string{"Bearer " + scoped}}, scope: ScopeAdmin, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthenticateWithScope(ctx, apiSecret, tt.token, tt.header, tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AuthenticateWithScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// The OpenAI or OpenAI compatible ASR service, such as faster-whisper-server. This is the default
+	// provider if not specified.
+	ASRProviderOpenAI = "openai"
+	// The self-hosted whisper.cpp server, or any ASR service which accepts a multipart form with the
+	// audio file and responds the whisper JSON, see https://github.com/ggerganov/whisper.cpp
+	ASRProviderWhisper = "whisper"
+)
+
+// The timeout for a request to whisper server, note that the audio of dubbing might be long, so it
+// should be large enough to transcribe the whole file.
+const whisperRequestTimeout = 10 * time.Minute
+
+// whisperClient is the HTTP client for whisper server, never use the default client which has no
+// timeout, because the self-hosted server might hang and block the task forever.
+var whisperClient = &http.Client{Timeout: whisperRequestTimeout}
+
+// ASRProvider is the ASR service to convert audio file to text, used by transcript, AI talk and
+// dubbing. All providers normalize the segments to TranscriptAsrResult, with timestamps in seconds.
+type ASRProvider interface {
+	// Transcribe the audio file in language, with optional prompt which is the previous text.
+	Transcribe(ctx context.Context, file, language, prompt string) (*TranscriptAsrResult, error)
+}
+
+// NewASRProvider create the ASR provider by AIProvider, note that empty provider means OpenAI.
+func NewASRProvider(provider *SrsAssistantProvider) (ASRProvider, error) {
+	switch provider.AIProvider {
+	case "", ASRProviderOpenAI:
+		aiConfig := openai.DefaultConfig(provider.AISecretKey)
+		aiConfig.OrgID = provider.AIOrganization
+		aiConfig.BaseURL = provider.AIBaseURL
+		return &openaiASRProvider{conf: aiConfig}, nil
+	case ASRProviderWhisper:
+		if provider.AIBaseURL == "" {
+			return nil, errors.Errorf("no base URL for %v", provider.AIProvider)
+		}
+		return &whisperASRProvider{
+			endpoint: whisperEndpoint(provider.AIBaseURL), secretKey: provider.AISecretKey,
+		}, nil
+	default:
+		return nil, errors.Errorf("invalid ASR provider %v", provider.AIProvider)
+	}
+}
+
+// validateASRProvider check whether the ASR provider is supported.
+func validateASRProvider(provider string) error {
+	switch provider {
+	case "", ASRProviderOpenAI, ASRProviderWhisper:
+		return nil
+	}
+	return errors.Errorf("invalid ASR provider %v", provider)
+}
+
+type openaiASRProvider struct {
+	conf openai.ClientConfig
+}
+
+func (v *openaiASRProvider) Transcribe(ctx context.Context, file, language, prompt string) (*TranscriptAsrResult, error) {
+	client := openai.NewClientWithConfig(v.conf)
+	resp, err := client.CreateTranscription(
+		ctx,
+		openai.AudioRequest{
+			Model:    openai.Whisper1,
+			FilePath: file,
+			// Note that must use verbose JSON, to get the duration and segments.
+			Format:   openai.AudioResponseFormatVerboseJSON,
+			Language: language,
+			Prompt:   prompt,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "transcription %v", file)
+	}
+
+	res := &TranscriptAsrResult{
+		Task: resp.Task, Language: resp.Language, Duration: resp.Duration, Text: resp.Text,
+	}
+	for _, s := range resp.Segments {
+		res.Segments = append(res.Segments, TranscriptAsrSegment{
+			ID: s.ID, Seek: s.Seek, Start: s.Start, End: s.End, Text: s.Text,
+			NoSpeechProb: s.NoSpeechProb,
+		})
+	}
+	return res, nil
+}
+
+// whisperEndpoint returns the inference API of whisper.cpp server, if only host is specified.
+func whisperEndpoint(baseURL string) string {
+	if u, err := url.Parse(baseURL); err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = "/inference"
+		return u.String()
+	}
+	return baseURL
+}
+
+type whisperASRProvider struct {
+	// The full URL of API, such as http://127.0.0.1:8080/inference
+	endpoint string
+	// The optional bearer token.
+	secretKey string
+}
+
+func (v *whisperASRProvider) Transcribe(ctx context.Context, file, language, prompt string) (*TranscriptAsrResult, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %v", file)
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if fw, err := mw.CreateFormFile("file", path.Base(file)); err != nil {
+		return nil, errors.Wrapf(err, "create form file")
+	} else if _, err = io.Copy(fw, f); err != nil {
+		return nil, errors.Wrapf(err, "copy %v", file)
+	}
+
+	fields := [][]string{{"response_format", "verbose_json"}, {"language", language}, {"prompt", prompt}}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return nil, errors.Wrapf(err, "write field %v", field[0])
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrapf(err, "close multipart")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, &body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request %v", v.endpoint)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if v.secretKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", v.secretKey))
+	}
+
+	resp, err := whisperClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "post %v", v.endpoint)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read body")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("post %v status %v, body %v", v.endpoint, resp.StatusCode, string(b))
+	}
+
+	res, err := parseWhisperResponse(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", string(b))
+	}
+	return res, nil
+}
+
+// parseWhisperResponse normalize the response of whisper server to TranscriptAsrResult. The segments
+// might be the verbose JSON of OpenAI with start and end in seconds, or the transcription of whisper.cpp
+// with offsets in milliseconds.
+func parseWhisperResponse(b []byte) (*TranscriptAsrResult, error) {
+	type whisperOffsets struct {
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+	}
+	type whisperSegment struct {
+		ID           int             `json:"id"`
+		Seek         int             `json:"seek"`
+		Start        float64         `json:"start"`
+		End          float64         `json:"end"`
+		Text         string          `json:"text"`
+		NoSpeechProb float64         `json:"no_speech_prob"`
+		Offsets      *whisperOffsets `json:"offsets"`
+	}
+	var resp struct {
+		Task          string           `json:"task"`
+		Language      string           `json:"language"`
+		Duration      float64          `json:"duration"`
+		Text          string           `json:"text"`
+		Segments      []whisperSegment `json:"segments"`
+		Transcription []whisperSegment `json:"transcription"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal")
+	}
+
+	res := &TranscriptAsrResult{
+		Task: resp.Task, Language: resp.Language, Duration: resp.Duration, Text: resp.Text,
+	}
+	if res.Task == "" {
+		res.Task = "transcribe"
+	}
+
+	var texts []string
+	for index, s := range append(resp.Segments, resp.Transcription...) {
+		segment := TranscriptAsrSegment{
+			ID: s.ID, Seek: s.Seek, Start: s.Start, End: s.End, Text: s.Text, NoSpeechProb: s.NoSpeechProb,
+		}
+		if s.Offsets != nil {
+			segment.ID = index
+			segment.Start, segment.End = float64(s.Offsets.From)/1000, float64(s.Offsets.To)/1000
+		}
+		if segment.End < segment.Start {
+			return nil, errors.Errorf("invalid segment %v start=%v, end=%v", index, segment.Start, segment.End)
+		}
+
+		res.Segments = append(res.Segments, segment)
+		texts = append(texts, strings.TrimSpace(s.Text))
+	}
+
+	if res.Text == "" {
+		res.Text = strings.Join(texts, " ")
+	}
+	if res.Duration == 0 && len(res.Segments) > 0 {
+		res.Duration = res.Segments[len(res.Segments)-1].End
+	}
+	return res, nil
+}
+
+// checkWhisperServer check whether the whisper server is available, any HTTP response is ok, because the
+// inference API only accepts POST.
+func checkWhisperServer(ctx context.Context, baseURL string) error {
+	if baseURL == "" {
+		return errors.New("no base URL")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "new request %v", baseURL)
+	}
+
+	resp, err := whisperClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "get %v", baseURL)
+	}
+	defer resp.Body.Close()
+	return nil
+}
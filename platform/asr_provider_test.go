@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestNewASRProvider(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		provider *SrsAssistantProvider
+		hasError bool
+	}{
+		{"default", &SrsAssistantProvider{}, false},
+		{"openai", &SrsAssistantProvider{AIProvider: ASRProviderOpenAI}, false},
+		{"whisper", &SrsAssistantProvider{AIProvider: ASRProviderWhisper, AIBaseURL: "http://127.0.0.1:8080"}, false},
+		{"whisper-no-url", &SrsAssistantProvider{AIProvider: ASRProviderWhisper}, true},
+		{"unknown", &SrsAssistantProvider{AIProvider: "unknown"}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := NewASRProvider(c.provider); (err != nil) != c.hasError {
+				t.Errorf("Expected error %v, got %v", c.hasError, err)
+			}
+		})
+	}
+}
+
+func TestSrsAssistant_ASRProvider(t *testing.T) {
+	assistant := NewAssistant()
+	assistant.AIProvider, assistant.AISecretKey, assistant.AIBaseURL = "openai", "sk-chat", "https://api.openai.com/v1"
+
+	// Use the AI provider for chat if not specified.
+	if p := assistant.ASRProvider(); p.AIProvider != ASRProviderOpenAI || p.AISecretKey != "sk-chat" ||
+		p.AIBaseURL != "https://api.openai.com/v1" {
+		t.Errorf("unexpected provider %v", p.String())
+	}
+
+	assistant.AIASRBaseURL = "http://127.0.0.1:8000/v1"
+	if p := assistant.ASRProvider(); p.AIProvider != ASRProviderOpenAI || p.AISecretKey != "sk-chat" ||
+		p.AIBaseURL != "http://127.0.0.1:8000/v1" {
+		t.Errorf("unexpected provider %v", p.String())
+	}
+
+	// Never use the secret key of AI provider for whisper.
+	assistant.AIASRProvider, assistant.AIASRBaseURL = ASRProviderWhisper, "http://127.0.0.1:8080"
+	if p := assistant.ASRProvider(); p.AIProvider != ASRProviderWhisper || p.AISecretKey != "" ||
+		p.AIBaseURL != "http://127.0.0.1:8080" {
+		t.Errorf("unexpected provider %v", p.String())
+	}
+}
+
+func TestWhisperEndpoint(t *testing.T) {
+	for _, c := range []struct {
+		url    string
+		expect string
+	}{
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080/inference"},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080/inference"},
+		{"http://127.0.0.1:8000/v1/audio/transcriptions", "http://127.0.0.1:8000/v1/audio/transcriptions"},
+	} {
+		if r := whisperEndpoint(c.url); r != c.expect {
+			t.Errorf("Expected %v, got %v", c.expect, r)
+		}
+	}
+}
+
+func TestParseWhisperResponse(t *testing.T) {
+	// The verbose JSON, with timestamps in seconds.
+	res, err := parseWhisperResponse([]byte(`{"task":"transcribe","language":"en","duration":3.5,"text":"Hello world",
+		"segments":[{"id":0,"start":0.5,"end":1.2,"text":"Hello","no_speech_prob":0.1},{"id":1,"start":1.2,"end":3.5,"text":"world"}]}`))
+	if err != nil {
+		t.Fatalf("parse err %+v", err)
+	}
+	if res.Duration != 3.5 || len(res.Segments) != 2 || res.Segments[1].Start != 1.2 || res.Segments[0].NoSpeechProb != 0.1 {
+		t.Errorf("Unexpected %v", res.String())
+	}
+
+	// The whisper.cpp transcription, with offsets in milliseconds.
+	res, err = parseWhisperResponse([]byte(`{"transcription":[{"offsets":{"from":0,"to":1500},"text":" Hello"},
+		{"offsets":{"from":1500,"to":4000},"text":" world"}]}`))
+	if err != nil {
+		t.Fatalf("parse err %+v", err)
+	}
+	if res.Text != "Hello world" || res.Duration != 4 || res.Segments[1].ID != 1 || res.Segments[1].Start != 1.5 {
+		t.Errorf("Unexpected %v", res.String())
+	}
+
+	if _, err := parseWhisperResponse([]byte(`{"segments":[{"start":2,"end":1}]}`)); err == nil {
+		t.Errorf("Expected error for invalid segment")
+	}
+}
+
+func TestWhisperASRProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inference" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if f, _, err := r.FormFile("file"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else {
+			f.Close()
+		}
+		if r.FormValue("language") != "en" || r.FormValue("response_format") != "verbose_json" {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"text":"Hello","segments":[{"id":0,"start":0,"end":1.5,"text":"Hello"}]}`))
+	}))
+	defer server.Close()
+
+	file := path.Join(t.TempDir(), "audio.m4a")
+	if err := os.WriteFile(file, []byte("audio"), 0644); err != nil {
+		t.Fatalf("write file err %+v", err)
+	}
+
+	provider, err := NewASRProvider(&SrsAssistantProvider{
+		AIProvider: ASRProviderWhisper, AIBaseURL: server.URL, AISecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("new provider err %+v", err)
+	}
+
+	res, err := provider.Transcribe(context.Background(), file, "en", "")
+	if err != nil {
+		t.Fatalf("transcribe err %+v", err)
+	}
+	if res.Text != "Hello" || res.Duration != 1.5 || len(res.Segments) != 1 {
+		t.Errorf("Unexpected %v", res.String())
+	}
+
+	// Should fail if server responds error.
+	if _, err := provider.Transcribe(context.Background(), file, "zh", ""); err == nil {
+		t.Errorf("Expected error for invalid form")
+	}
+}
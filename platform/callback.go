@@ -6,11 +6,15 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"io/ioutil"
 	"net/http"
+	"path"
 	"strconv"
 	"sync"
 	"time"
@@ -34,6 +38,8 @@ type CallbackWorker struct {
 	ephemeralConfig CallbackConfig
 	// Whether update the config immediately.
 	updateConfig chan bool
+	// Whether consume the queue immediately.
+	updateQueue chan bool
 
 	lock sync.Mutex
 }
@@ -41,6 +47,7 @@ type CallbackWorker struct {
 func NewCallbackWorker() *CallbackWorker {
 	return &CallbackWorker{
 		updateConfig: make(chan bool, 1),
+		updateQueue:  make(chan bool, 1),
 	}
 }
 
@@ -123,6 +130,10 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 				}
 			}
 
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
 			if err := rdb.HSet(ctx, SRS_HOOKS, "target", config.Target).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hset %v target %v", SRS_HOOKS, config.Target)
 			}
@@ -144,6 +155,16 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 				return errors.Wrapf(err, "hset %v host %v", SRS_HOOKS, config.Host)
 			}
 
+			// Only update the subscriptions if specified, to be compatible with the legacy clients, which only
+			// update the target.
+			if config.Subscriptions != nil {
+				if b, err := json.Marshal(config.Subscriptions); err != nil {
+					return errors.Wrapf(err, "marshal %v", config.Subscriptions)
+				} else if err := rdb.HSet(ctx, SRS_HOOKS, "subscriptions", string(b)).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v subscriptions %v", SRS_HOOKS, string(b))
+				}
+			}
+
 			// Notify the callback worker to update the config.
 			select {
 			case v.updateConfig <- true:
@@ -159,6 +180,124 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 		}
 	})
 
+	ep = "/terraform/v1/mgmt/hooks/deliveries"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var status CallbackDeliveryStatus
+			var limit int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string                 `json:"token"`
+				Status *CallbackDeliveryStatus `json:"status"`
+				Limit  *int                    `json:"limit"`
+			}{
+				Token: &token, Status: &status, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if limit <= 0 || limit > callbackMaxHistory {
+				limit = 100
+			}
+
+			ids, err := rdb.LRange(ctx, SRS_HOOKS_HISTORY, 0, -1).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "lrange %v", SRS_HOOKS_HISTORY)
+			}
+
+			deliveries := []*CallbackDelivery{}
+			for _, id := range ids {
+				if len(deliveries) >= limit {
+					break
+				}
+
+				delivery, err := LoadCallbackDelivery(ctx, id)
+				if err != nil {
+					return errors.Wrapf(err, "load %v", id)
+				} else if delivery == nil {
+					continue
+				}
+
+				if status != "" && delivery.Status != status {
+					continue
+				}
+
+				// Never expose the opaque, which is the secret to sign the request.
+				delivery.Opaque = ""
+				deliveries = append(deliveries, delivery)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Deliveries []*CallbackDelivery `json:"deliveries"`
+			}{
+				Deliveries: deliveries,
+			})
+			logger.Tf(ctx, "hooks deliveries ok, status=%v, limit=%v, deliveries=%v, token=%vB",
+				status, limit, len(deliveries), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/hooks/replay"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, requestID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string `json:"token"`
+				RequestID *string `json:"request_id"`
+			}{
+				Token: &token, RequestID: &requestID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			delivery, err := LoadCallbackDelivery(ctx, requestID)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", requestID)
+			} else if delivery == nil {
+				return errors.Errorf("delivery %v not exists", requestID)
+			}
+
+			// Reset the delivery, and deliver it again with the same request id.
+			delivery.Status, delivery.Attempts, delivery.NextAttempt = CallbackDeliveryPending, 0, ""
+			delivery.UpdatedAt = time.Now().Format(time.RFC3339)
+			if err := delivery.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save %v", delivery.String())
+			}
+
+			if err := rdb.ZAdd(ctx, SRS_HOOKS_QUEUE, &redis.Z{
+				Score: float64(time.Now().UnixMilli()), Member: delivery.RequestID,
+			}).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "zadd %v %v", SRS_HOOKS_QUEUE, delivery.RequestID)
+			}
+
+			select {
+			case v.updateQueue <- true:
+			default:
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "hooks replay ok, %v, token=%vB", delivery.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	ep = "/terraform/v1/mgmt/hooks/example"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
@@ -243,6 +382,29 @@ func (v *CallbackWorker) Start(ctx context.Context) error {
 		}
 	}()
 
+	// Deliver the callbacks in queue, retry with backoff if failed.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			duration := time.Second
+			if n, err := v.consumeQueue(ctx); err != nil {
+				logger.Wf(ctx, "callback consume queue err %+v", err)
+				duration = 10 * time.Second
+			} else if n > 0 {
+				// There might be more callbacks in queue, consume them immediately.
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			case <-v.updateQueue:
+			}
+		}
+	}()
+
 	return nil
 }
 
@@ -251,410 +413,511 @@ func (v *CallbackWorker) OnStreamMessage(ctx context.Context, action SrsAction,
 		return nil
 	}
 
-	var config CallbackConfig
-	func() {
-		v.lock.Lock()
-		defer v.lock.Unlock()
-		config = v.ephemeralConfig
-	}()
+	return v.dispatch(ctx, action, streamObj.App, streamObj.Stream, func(sub *CallbackSubscription, requestID string) interface{} {
+		req := &struct {
+			RequestID string `json:"request_id"`
+			// The callback parameters.
+			Action string `json:"action"`
+			Opaque string `json:"opaque"`
+			Vhost  string `json:"vhost,omitempty"`
+			App    string `json:"app,omitempty"`
+			Stream string `json:"stream,omitempty"`
+			Param  string `json:"param,omitempty"`
+		}{
+			RequestID: requestID,
+			// The callback parameters.
+			Action: string(action),
+			Opaque: sub.Opaque,
+			Vhost:  streamObj.Vhost,
+			App:    streamObj.App,
+			Stream: streamObj.Stream,
+		}
+		if action == SrsActionOnPublish {
+			req.Param = streamObj.Param
+		}
+		return req
+	})
+}
 
-	if !config.All || config.Target == "" {
+func (v *CallbackWorker) OnRecordMessage(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, artifact *M3u8VoDArtifact) error {
+	if action != SrsActionOnRecordBegin && action != SrsActionOnRecordEnd {
 		return nil
 	}
-
-	if err := ValidateCallbackURL(config.Target); err != nil {
-		logger.Wf(ctx, "Ignore invalid callback target %v, err %+v", config.Target, err)
-		return nil
+	if action == SrsActionOnRecordEnd && artifact == nil {
+		return fmt.Errorf("artifact should not be nil")
 	}
 
-	req := &struct {
-		RequestID string `json:"request_id"`
-		// The callback parameters.
-		Action string `json:"action"`
-		Opaque string `json:"opaque"`
-		Vhost  string `json:"vhost,omitempty"`
-		App    string `json:"app,omitempty"`
-		Stream string `json:"stream,omitempty"`
-		Param  string `json:"param,omitempty"`
-	}{
-		RequestID: uuid.NewString(),
-		// The callback parameters.
-		Action: string(action),
-		Opaque: config.Opaque,
-		Vhost:  streamObj.Vhost,
-		App:    streamObj.App,
-		Stream: streamObj.Stream,
-	}
-	if action == SrsActionOnPublish {
-		req.Param = streamObj.Param
-	}
+	host := v.config().Host
+	return v.dispatch(ctx, action, message.App, message.Stream, func(sub *CallbackSubscription, requestID string) interface{} {
+		req := &struct {
+			RequestID string `json:"request_id"`
+			// The callback parameters.
+			Action       string `json:"action"`
+			Opaque       string `json:"opaque"`
+			Vhost        string `json:"vhost,omitempty"`
+			App          string `json:"app,omitempty"`
+			Stream       string `json:"stream,omitempty"`
+			UUID         string `json:"uuid,omitempty"`
+			ArtifactCode *int   `json:"artifact_code,omitempty"`
+			ArtifactPath string `json:"artifact_path,omitempty"`
+			ArtifactURL  string `json:"artifact_url,omitempty"`
+		}{
+			RequestID: requestID,
+			// The callback parameters.
+			Action: string(action),
+			Opaque: sub.Opaque,
+			UUID:   taskUUID,
+			Vhost:  message.Vhost,
+			App:    message.App,
+			Stream: message.Stream,
+		}
 
-	pfn4 := func(b, b2 []byte, code int) error {
-		if code != 0 {
-			return errors.Errorf("response code %v", code)
+		if action == SrsActionOnRecordEnd {
+			code := 0
+			if artifact.Processing {
+				code = int(SrsStackErrorCallbackRecord)
+			}
+			req.ArtifactCode = &code
+			req.ArtifactPath = fmt.Sprintf("%v/record/%v/index.mp4", serverDataDirectory, artifact.UUID)
+			req.ArtifactURL = fmt.Sprintf("%v/terraform/v1/hooks/record/hls/%v/index.mp4", host, artifact.UUID)
 		}
+		return req
+	})
+}
 
-		logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
+func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, prompt string, result *OCRResult) error {
+	if action != SrsActionOnOcr {
 		return nil
 	}
 
-	pfn3 := func(b, b2 []byte) error {
-		if code, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
-			return pfn4(b, b2, int(code))
-		}
-
-		var code int
-		if err := json.Unmarshal(b2, &struct {
-			Code *int `json:"code"`
+	return v.dispatch(ctx, action, message.App, message.Stream, func(sub *CallbackSubscription, requestID string) interface{} {
+		return &struct {
+			RequestID string `json:"request_id"`
+			// The callback parameters.
+			Action string `json:"action"`
+			Opaque string `json:"opaque"`
+			Vhost  string `json:"vhost,omitempty"`
+			App    string `json:"app,omitempty"`
+			Stream string `json:"stream,omitempty"`
+			// The OCR task UUID.
+			UUID string `json:"uuid,omitempty"`
+			// The OCR prompt.
+			Prompt string `json:"prompt,omitempty"`
+			// The OCR result.
+			Result string `json:"result,omitempty"`
+			// The OCR rule and extracted data, if rules are configured.
+			Rule string                 `json:"rule,omitempty"`
+			Name string                 `json:"name,omitempty"`
+			Data map[string]interface{} `json:"data,omitempty"`
 		}{
-			Code: &code,
-		}); err != nil {
-			return errors.Wrapf(err, "unmarshal response")
+			RequestID: requestID,
+			// The callback parameters.
+			Action: string(action),
+			Opaque: sub.Opaque,
+			Vhost:  message.Vhost,
+			App:    message.App,
+			Stream: message.Stream,
+			// The OCR task UUID.
+			UUID: taskUUID,
+			// The OCR prompt.
+			Prompt: prompt,
+			// The OCR result.
+			Result: result.Text,
+			// The OCR rule and extracted data.
+			Rule: result.Rule,
+			Name: result.Name,
+			Data: result.Data,
 		}
-		return pfn4(b, b2, code)
+	})
+}
+
+func (v *CallbackWorker) OnTranscriptMatch(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, match *TranscriptAlertMatch) error {
+	if action != SrsActionOnTranscriptMatch {
+		return nil
 	}
 
-	pfn2 := func(b []byte) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
-		if err != nil {
-			return errors.Wrapf(err, "new request")
+	return v.dispatch(ctx, action, message.App, message.Stream, func(sub *CallbackSubscription, requestID string) interface{} {
+		return &struct {
+			RequestID string `json:"request_id"`
+			// The callback parameters.
+			Action string `json:"action"`
+			Opaque string `json:"opaque"`
+			Vhost  string `json:"vhost,omitempty"`
+			App    string `json:"app,omitempty"`
+			Stream string `json:"stream,omitempty"`
+			// The transcript task UUID.
+			UUID string `json:"uuid,omitempty"`
+			// The matched alert.
+			*TranscriptAlertMatch
+		}{
+			RequestID: requestID,
+			// The callback parameters.
+			Action: string(action),
+			Opaque: sub.Opaque,
+			Vhost:  message.Vhost,
+			App:    message.App,
+			Stream: message.Stream,
+			// The transcript task UUID.
+			UUID: taskUUID,
+			// The matched alert.
+			TranscriptAlertMatch: match,
 		}
+	})
+}
 
-		req.Header.Set("Content-Type", "application/json")
+// config returns the ephemeral callback config.
+func (v *CallbackWorker) config() CallbackConfig {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.ephemeralConfig
+}
 
-		// We must use a timeout for the http client, to avoid hanging.
-		// And we must verify the certificate for HTTPS, to avoid MITM.
-		client := NewSafeHTTPClient(30 * time.Second)
-		var res *http.Response
-		res, err = client.Do(req)
-		if err != nil {
-			return errors.Wrapf(err, "http post")
+// dispatch the callback event to all subscriptions which match the action and stream, each subscription has
+// its own delivery, and the build function creates the request for it. For on_publish, deliver immediately
+// and concurrently, and reject the stream if any callback server explicitly denies it. Note that we allow the
+// stream if the callback server fails, and retry it later.
+func (v *CallbackWorker) dispatch(ctx context.Context, action SrsAction, app, stream string, build func(sub *CallbackSubscription, requestID string) interface{}) error {
+	var publishes []*CallbackDelivery
+
+	config := v.config()
+	for _, sub := range config.Effective() {
+		if ok, err := sub.Match(action, app, stream); err != nil {
+			logger.Wf(ctx, "Ignore subscription %v, err %+v", sub.String(), err)
+			continue
+		} else if !ok {
+			continue
 		}
-		defer res.Body.Close()
 
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("response status %v", res.StatusCode)
+		if err := ValidateCallbackURL(sub.Target); err != nil {
+			logger.Wf(ctx, "Ignore invalid callback target %v, err %+v", sub.Target, err)
+			continue
 		}
 
-		b2, err := ioutil.ReadAll(res.Body)
+		requestID := uuid.NewString()
+		req := build(sub, requestID)
+
+		delivery, err := NewCallbackDelivery(action, sub, requestID, req)
 		if err != nil {
-			return errors.Wrapf(err, "read body")
+			return errors.Wrapf(err, "create delivery")
 		}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
+		if action == SrsActionOnPublish {
+			publishes = append(publishes, delivery)
+			continue
 		}
 
-		if err := pfn3(b, b2); err != nil {
-			return errors.Wrapf(err, "res body %v", string(b2))
+		if err := v.enqueue(ctx, delivery); err != nil {
+			return errors.Wrapf(err, "enqueue %v", delivery.String())
 		}
+	}
 
+	if len(publishes) == 0 {
 		return nil
 	}
 
-	pfn := func() error {
-		b, err := json.Marshal(req)
-		if err != nil {
-			return errors.Wrapf(err, "marshal req")
-		}
-
-		if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
-		}
-
-		if err := pfn2(b); err != nil {
-			return errors.Wrapf(err, "post with %s", string(b))
-		}
-
-		return nil
+	// Deliver on_publish to all subscriptions concurrently, so the hook of SRS is bounded by one timeout.
+	var wg sync.WaitGroup
+	errs := make([]error, len(publishes))
+	for i, delivery := range publishes {
+		wg.Add(1)
+		go func(i int, delivery *CallbackDelivery) {
+			defer wg.Done()
+			errs[i] = v.deliverNow(ctx, delivery)
+		}(i, delivery)
 	}
+	wg.Wait()
 
-	if err := pfn(); err != nil {
-		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	for i, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "callback %v", publishes[i].String())
+		}
 	}
 	return nil
 }
 
-func (v *CallbackWorker) OnRecordMessage(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, artifact *M3u8VoDArtifact) error {
-	if action != SrsActionOnRecordBegin && action != SrsActionOnRecordEnd {
-		return nil
+// enqueue save the delivery to history and the queue, the worker will deliver it as soon as possible.
+func (v *CallbackWorker) enqueue(ctx context.Context, delivery *CallbackDelivery) error {
+	if err := delivery.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save")
 	}
-	if action == SrsActionOnRecordEnd && artifact == nil {
-		return fmt.Errorf("artifact should not be nil")
-	}
-
-	var config CallbackConfig
-	func() {
-		v.lock.Lock()
-		defer v.lock.Unlock()
-		config = v.ephemeralConfig
-	}()
 
-	if !config.All || config.Target == "" {
-		return nil
+	if err := pushCallbackHistory(ctx, delivery.RequestID); err != nil {
+		return errors.Wrapf(err, "push history")
 	}
 
-	if err := ValidateCallbackURL(config.Target); err != nil {
-		logger.Wf(ctx, "Ignore invalid callback target %v, err %+v", config.Target, err)
-		return nil
+	if err := rdb.ZAdd(ctx, SRS_HOOKS_QUEUE, &redis.Z{
+		Score: float64(time.Now().UnixMilli()), Member: delivery.RequestID,
+	}).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "zadd %v %v", SRS_HOOKS_QUEUE, delivery.RequestID)
 	}
 
-	req := &struct {
-		RequestID string `json:"request_id"`
-		// The callback parameters.
-		Action       string `json:"action"`
-		Opaque       string `json:"opaque"`
-		Vhost        string `json:"vhost,omitempty"`
-		App          string `json:"app,omitempty"`
-		Stream       string `json:"stream,omitempty"`
-		UUID         string `json:"uuid,omitempty"`
-		ArtifactCode *int   `json:"artifact_code,omitempty"`
-		ArtifactPath string `json:"artifact_path,omitempty"`
-		ArtifactURL  string `json:"artifact_url,omitempty"`
-	}{
-		RequestID: uuid.NewString(),
-		// The callback parameters.
-		Action: string(action),
-		Opaque: config.Opaque,
-		UUID:   taskUUID,
-		Vhost:  message.Vhost,
-		App:    message.App,
-		Stream: message.Stream,
+	select {
+	case v.updateQueue <- true:
+	default:
 	}
 
-	if action == SrsActionOnRecordEnd {
-		code := 0
-		if artifact.Processing {
-			code = int(SrsStackErrorCallbackRecord)
-		}
-		req.ArtifactCode = &code
-		req.ArtifactPath = fmt.Sprintf("%v/record/%v/index.mp4", serverDataDirectory, artifact.UUID)
-		req.ArtifactURL = fmt.Sprintf("%v/terraform/v1/hooks/record/hls/%v/index.mp4", config.Host, artifact.UUID)
-	}
+	logger.Tf(ctx, "callback enqueue ok, %v", delivery.String())
+	return nil
+}
 
-	pfn4 := func(b, b2 []byte, code int) error {
-		if code != 0 {
-			return errors.Errorf("response code %v", code)
-		}
+// deliverNow deliver the callback immediately, return error only if callback server explicitly denies it. If
+// the server fails, for example, timeout or error status, retry it later in queue.
+func (v *CallbackWorker) deliverNow(ctx context.Context, delivery *CallbackDelivery) error {
+	if err := delivery.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save")
+	}
 
-		logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
-		return nil
+	if err := pushCallbackHistory(ctx, delivery.RequestID); err != nil {
+		return errors.Wrapf(err, "push history")
 	}
 
-	pfn3 := func(b, b2 []byte) error {
-		if code, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
-			return pfn4(b, b2, int(code))
-		}
+	rejected, err := v.deliver(ctx, delivery, callbackPublishTimeout)
+	if err != nil && rejected {
+		return errors.Wrapf(err, "deliver %v", delivery.String())
+	} else if err != nil {
+		logger.Wf(ctx, "callback allow stream, deliver %v err %+v", delivery.String(), err)
+	}
+	return nil
+}
 
-		var code int
-		if err := json.Unmarshal(b2, &struct {
-			Code *int `json:"code"`
-		}{
-			Code: &code,
-		}); err != nil {
-			return errors.Wrapf(err, "unmarshal response")
-		}
-		return pfn4(b, b2, code)
+// consumeQueue deliver the callbacks which are ready in queue, return the number of consumed callbacks. The
+// callback is claimed by delaying it for a while, and only removed from queue after the attempt is saved, so
+// it's retried if crash or error before that.
+func (v *CallbackWorker) consumeQueue(ctx context.Context) (int, error) {
+	ids, err := rdb.ZRangeByScore(ctx, SRS_HOOKS_QUEUE, &redis.ZRangeBy{
+		Min: "-inf", Max: fmt.Sprintf("%v", time.Now().UnixMilli()), Count: 16,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return 0, errors.Wrapf(err, "zrangebyscore %v", SRS_HOOKS_QUEUE)
 	}
 
-	pfn2 := func(b []byte) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
-		if err != nil {
-			return errors.Wrapf(err, "new request")
+	for _, id := range ids {
+		claim := time.Now().Add(callbackClaimTimeout)
+		if err := rdb.ZAddXX(ctx, SRS_HOOKS_QUEUE, &redis.Z{
+			Score: float64(claim.UnixMilli()), Member: id,
+		}).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "zadd %v %v", SRS_HOOKS_QUEUE, id)
 		}
 
-		req.Header.Set("Content-Type", "application/json")
-
-		// We must use a timeout for the http client, to avoid hanging.
-		// And we must verify the certificate for HTTPS, to avoid MITM.
-		client := NewSafeHTTPClient(30 * time.Second)
-		var res *http.Response
-		res, err = client.Do(req)
+		delivery, err := LoadCallbackDelivery(ctx, id)
 		if err != nil {
-			return errors.Wrapf(err, "http post")
-		}
-		defer res.Body.Close()
-
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("response status %v", res.StatusCode)
+			return 0, errors.Wrapf(err, "load %v", id)
+		} else if delivery == nil || delivery.Status != CallbackDeliveryPending {
+			if err := rdb.ZRem(ctx, SRS_HOOKS_QUEUE, id).Err(); err != nil && err != redis.Nil {
+				return 0, errors.Wrapf(err, "zrem %v %v", SRS_HOOKS_QUEUE, id)
+			}
+			continue
 		}
 
-		b2, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "read body")
+		if _, err := v.deliver(ctx, delivery, callbackPostTimeout); err != nil {
+			logger.Wf(ctx, "callback deliver %v err %+v", delivery.String(), err)
 		}
+	}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
-		}
+	return len(ids), nil
+}
 
-		if err := pfn3(b, b2); err != nil {
-			return errors.Wrapf(err, "res body %v", string(b2))
+// deliver post the callback to target in timeout, and update the status of delivery. If failed, schedule to
+// retry with exponential backoff, or mark as dead if exceed the max attempts. Return whether the callback
+// server explicitly denies it, if failed. The delivery is removed from queue once done, rejected or dead.
+func (v *CallbackWorker) deliver(ctx context.Context, delivery *CallbackDelivery, timeout time.Duration) (rejected bool, err error) {
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	var res []byte
+	res, rejected, err = v.post(ctx, delivery, timeout)
+	delivery.Response = string(res)
+
+	if err == nil {
+		delivery.Status, delivery.NextAttempt, delivery.LastError = CallbackDeliveryDone, "", ""
+	} else {
+		delivery.LastError = err.Error()
+
+		// For on_publish, if callback server denies it, we should not retry it, because the stream is rejected.
+		if delivery.Action == SrsActionOnPublish && rejected {
+			delivery.Status, delivery.NextAttempt = CallbackDeliveryRejected, ""
+		} else if delivery.Attempts >= callbackMaxAttempts {
+			delivery.Status, delivery.NextAttempt = CallbackDeliveryDead, ""
+		} else {
+			next := time.Now().Add(callbackBackoff(delivery.Attempts))
+			delivery.Status, delivery.NextAttempt = CallbackDeliveryPending, next.Format(time.RFC3339)
+
+			if r0 := rdb.ZAdd(ctx, SRS_HOOKS_QUEUE, &redis.Z{
+				Score: float64(next.UnixMilli()), Member: delivery.RequestID,
+			}).Err(); r0 != nil && r0 != redis.Nil {
+				return rejected, errors.Wrapf(r0, "zadd %v %v", SRS_HOOKS_QUEUE, delivery.RequestID)
+			}
 		}
-
-		return nil
 	}
 
-	pfn := func() error {
-		b, err := json.Marshal(req)
-		if err != nil {
-			return errors.Wrapf(err, "marshal req")
-		}
+	if delivery.Status == CallbackDeliveryPending {
+		platformMetrics.OnCallback("retry")
+	} else {
+		platformMetrics.OnCallback(string(delivery.Status))
+	}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
-		}
+	if r0 := delivery.Save(ctx); r0 != nil {
+		return rejected, errors.Wrapf(r0, "save %v", delivery.String())
+	}
 
-		if err := pfn2(b); err != nil {
-			return errors.Wrapf(err, "post with %s", string(b))
+	// Remove from queue after the attempt is saved, note that the pending delivery is already rescheduled.
+	if delivery.Status != CallbackDeliveryPending {
+		if r0 := rdb.ZRem(ctx, SRS_HOOKS_QUEUE, delivery.RequestID).Err(); r0 != nil && r0 != redis.Nil {
+			return rejected, errors.Wrapf(r0, "zrem %v %v", SRS_HOOKS_QUEUE, delivery.RequestID)
 		}
-
-		return nil
 	}
 
-	if err := pfn(); err != nil {
-		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	if err != nil {
+		return rejected, errors.Wrapf(err, "post %v", delivery.String())
 	}
-	return nil
+
+	logger.Tf(ctx, "callback ok, post %v, response %v", delivery.String(), string(res))
+	return false, nil
 }
 
-func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, prompt, result string) error {
-	if action != SrsActionOnOcr {
-		return nil
+// post the callback to target in timeout, with signature and idempotency key in headers. Return whether the
+// callback server explicitly denies it, if failed.
+func (v *CallbackWorker) post(ctx context.Context, delivery *CallbackDelivery, timeout time.Duration) (res []byte, rejected bool, err error) {
+	if err := ValidateCallbackURL(delivery.Target); err != nil {
+		return nil, false, errors.Wrapf(err, "validate target %v", delivery.Target)
 	}
 
-	var config CallbackConfig
-	func() {
-		v.lock.Lock()
-		defer v.lock.Unlock()
-		config = v.ephemeralConfig
-	}()
-
-	if !config.All || config.Target == "" {
-		return nil
+	b := []byte(delivery.Body)
+	if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
+		return nil, false, errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
 	}
 
-	if err := ValidateCallbackURL(config.Target); err != nil {
-		logger.Wf(ctx, "Ignore invalid callback target %v, err %+v", config.Target, err)
-		return nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Target, bytes.NewReader(b))
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "new request")
 	}
 
-	req := &struct {
-		RequestID string `json:"request_id"`
-		// The callback parameters.
-		Action string `json:"action"`
-		Opaque string `json:"opaque"`
-		Vhost  string `json:"vhost,omitempty"`
-		App    string `json:"app,omitempty"`
-		Stream string `json:"stream,omitempty"`
-		// The OCR task UUID.
-		UUID string `json:"uuid,omitempty"`
-		// The OCR prompt.
-		Prompt string `json:"prompt,omitempty"`
-		// The OCR result.
-		Result string `json:"result,omitempty"`
-	}{
-		RequestID: uuid.NewString(),
-		// The callback parameters.
-		Action: string(action),
-		Opaque: config.Opaque,
-		Vhost:  message.Vhost,
-		App:    message.App,
-		Stream: message.Stream,
-		// The OCR task UUID.
-		UUID: taskUUID,
-		// The OCR prompt.
-		Prompt: prompt,
-		// The OCR result.
-		Result: result,
-	}
-
-	pfn4 := func(b, b2 []byte, code int) error {
-		if code != 0 {
-			return errors.Errorf("response code %v", code)
-		}
-
-		logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
-		return nil
+	req.Header.Set("Content-Type", "application/json")
+	// The idempotency key, the receiver should ignore the duplicated request.
+	req.Header.Set("X-Oryx-Request-Id", delivery.RequestID)
+	// Sign the request if opaque is set, so the receiver is able to verify it.
+	if delivery.Opaque != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set("X-Oryx-Timestamp", fmt.Sprintf("%v", timestamp))
+		req.Header.Set("X-Oryx-Signature", signCallbackBody(delivery.Opaque, timestamp, b))
 	}
 
-	pfn3 := func(b, b2 []byte) error {
-		if code, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
-			return pfn4(b, b2, int(code))
-		}
+	// We must use a timeout for the http client, to avoid hanging.
+	// And we must verify the certificate for HTTPS, to avoid MITM.
+	client := NewSafeHTTPClient(timeout)
+	r0, err := client.Do(req)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "http post")
+	}
+	defer r0.Body.Close()
 
-		var code int
-		if err := json.Unmarshal(b2, &struct {
-			Code *int `json:"code"`
-		}{
-			Code: &code,
-		}); err != nil {
-			return errors.Wrapf(err, "unmarshal response")
-		}
-		return pfn4(b, b2, code)
+	b2, err := ioutil.ReadAll(r0.Body)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "read body")
 	}
 
-	pfn2 := func(b []byte) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
-		if err != nil {
-			return errors.Wrapf(err, "new request")
-		}
+	if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
+		return b2, false, errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	rejected, err = checkCallbackResponse(r0.StatusCode, b2)
+	return b2, rejected, err
+}
 
-		// We must use a timeout for the http client, to avoid hanging.
-		// And we must verify the certificate for HTTPS, to avoid MITM.
-		client := NewSafeHTTPClient(30 * time.Second)
-		var res *http.Response
-		res, err = client.Do(req)
-		if err != nil {
-			return errors.Wrapf(err, "http post")
-		}
-		defer res.Body.Close()
+// checkCallbackResponse check the response of callback server. The server explicitly denies it only if
+// response status 200 with non-zero code, other errors such as 500 are failures of server, not deny.
+func checkCallbackResponse(status int, b []byte) (rejected bool, err error) {
+	if status != http.StatusOK {
+		return false, errors.Errorf("response status %v", status)
+	}
 
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("response status %v", res.StatusCode)
-		}
+	if code, err := parseCallbackResponseCode(b); err != nil {
+		return false, errors.Wrapf(err, "res body %v", string(b))
+	} else if code != 0 {
+		return true, errors.Errorf("response code %v", code)
+	}
+	return false, nil
+}
 
-		b2, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "read body")
-		}
+// parseCallbackResponseCode parse the response of callback server, which is an integer, or a JSON object
+// with code field.
+func parseCallbackResponseCode(b []byte) (int, error) {
+	if code, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		return int(code), nil
+	}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
-		}
+	var code int
+	if err := json.Unmarshal(b, &struct {
+		Code *int `json:"code"`
+	}{
+		Code: &code,
+	}); err != nil {
+		return 0, errors.Wrapf(err, "unmarshal response")
+	}
+	return code, nil
+}
 
-		if err := pfn3(b, b2); err != nil {
-			return errors.Wrapf(err, "res body %v", string(b2))
-		}
+// signCallbackBody generate the signature of callback, which is HMAC-SHA256 of timestamp and body by opaque,
+// the format is sha256=hex, for example, sha256=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+func signCallbackBody(opaque string, timestamp int64, body []byte) string {
+	h := hmac.New(sha256.New, []byte(opaque))
+	h.Write([]byte(fmt.Sprintf("%v.", timestamp)))
+	h.Write(body)
+	return fmt.Sprintf("sha256=%v", hex.EncodeToString(h.Sum(nil)))
+}
 
-		return nil
+// callbackBackoff is the delay before the next attempt, exponential backoff from 5s to 30m.
+func callbackBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
 	}
 
-	pfn := func() error {
-		b, err := json.Marshal(req)
-		if err != nil {
-			return errors.Wrapf(err, "marshal req")
-		}
+	delay := 5 * time.Second
+	for i := 1; i < attempts && delay < 30*time.Minute; i++ {
+		delay *= 2
+	}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
-		}
+	if delay > 30*time.Minute {
+		delay = 30 * time.Minute
+	}
+	return delay
+}
 
-		if err := pfn2(b); err != nil {
-			return errors.Wrapf(err, "post with %s", string(b))
-		}
+// pushCallbackHistory push the delivery to history, and remove the oldest deliveries if exceed the max history.
+func pushCallbackHistory(ctx context.Context, requestID string) error {
+	if err := rdb.LPush(ctx, SRS_HOOKS_HISTORY, requestID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "lpush %v %v", SRS_HOOKS_HISTORY, requestID)
+	}
 
+	expired, err := rdb.LRange(ctx, SRS_HOOKS_HISTORY, callbackMaxHistory, -1).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "lrange %v %v -1", SRS_HOOKS_HISTORY, callbackMaxHistory)
+	}
+	if len(expired) == 0 {
 		return nil
 	}
 
-	if err := pfn(); err != nil {
-		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	if err := rdb.LTrim(ctx, SRS_HOOKS_HISTORY, 0, callbackMaxHistory-1).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "ltrim %v 0 %v", SRS_HOOKS_HISTORY, callbackMaxHistory-1)
+	}
+	if err := rdb.HDel(ctx, SRS_HOOKS_DELIVERY, expired...).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_HOOKS_DELIVERY, expired)
+	}
+	if err := rdb.ZRem(ctx, SRS_HOOKS_QUEUE, stringsToInterfaces(expired)...).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "zrem %v %v", SRS_HOOKS_QUEUE, expired)
 	}
 	return nil
 }
 
+// stringsToInterfaces convert the strings to interfaces, for redis commands.
+func stringsToInterfaces(values []string) []interface{} {
+	r := make([]interface{}, len(values))
+	for i, value := range values {
+		r[i] = value
+	}
+	return r
+}
+
 type CallbackConfig struct {
 	// The callback target.
 	Target string `json:"target"`
@@ -664,11 +927,66 @@ type CallbackConfig struct {
 	All bool `json:"all"`
 	// The full host to generate the full URl for callback.
 	Host string `json:"host"`
+	// The subscriptions, each has its own target and subscribes to some actions and streams.
+	Subscriptions []*CallbackSubscription `json:"subscriptions"`
 }
 
 func (v CallbackConfig) String() string {
-	return fmt.Sprintf("target=%v, opaque=%v, all=%v, host=%v",
-		v.Target, v.Opaque, v.All, v.Host)
+	return fmt.Sprintf("target=%v, opaque=%v, all=%v, host=%v, subscriptions=%v",
+		v.Target, v.Opaque, v.All, v.Host, len(v.Subscriptions))
+}
+
+// Effective returns all the subscriptions to callback. The legacy target is a subscription for all actions
+// and streams, if all is enabled.
+func (v *CallbackConfig) Effective() []*CallbackSubscription {
+	var subs []*CallbackSubscription
+	if v.All && v.Target != "" {
+		subs = append(subs, &CallbackSubscription{
+			ID: "default", Target: v.Target, Opaque: v.Opaque, Enabled: true,
+		})
+	}
+
+	for _, sub := range v.Subscriptions {
+		if sub.Enabled && sub.Target != "" {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Validate the subscriptions, and generate the ID if not set.
+func (v *CallbackConfig) Validate() error {
+	ids := make(map[string]bool)
+	for _, sub := range v.Subscriptions {
+		if sub == nil {
+			return errors.New("empty subscription")
+		}
+
+		if sub.ID == "" {
+			sub.ID = uuid.NewString()
+		}
+		if ids[sub.ID] || sub.ID == "default" {
+			return errors.Errorf("duplicated subscription %v", sub.ID)
+		}
+		ids[sub.ID] = true
+
+		if err := ValidateCallbackURL(sub.Target); err != nil {
+			return errors.Wrapf(err, "validate target %v", sub.Target)
+		}
+
+		for _, action := range sub.Actions {
+			if !isCallbackAction(action) {
+				return errors.Errorf("invalid action %v of subscription %v", action, sub.ID)
+			}
+		}
+
+		for _, glob := range sub.Streams {
+			if _, err := path.Match(glob, ""); err != nil {
+				return errors.Wrapf(err, "invalid stream %v of subscription %v", glob, sub.ID)
+			}
+		}
+	}
+	return nil
 }
 
 func (v *CallbackConfig) Load(ctx context.Context) (err error) {
@@ -690,5 +1008,184 @@ func (v *CallbackConfig) Load(ctx context.Context) (err error) {
 		return errors.Wrapf(err, "hget %v host", SRS_HOOKS)
 	}
 
+	if subs, err := rdb.HGet(ctx, SRS_HOOKS, "subscriptions").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v subscriptions", SRS_HOOKS)
+	} else if subs != "" {
+		if err := json.Unmarshal([]byte(subs), &v.Subscriptions); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", subs)
+		}
+	}
+
+	return nil
+}
+
+// The actions for callback, which is able to subscribe to.
+var callbackActions = []SrsAction{
+	SrsActionOnPublish, SrsActionOnUnpublish, SrsActionOnRecordBegin, SrsActionOnRecordEnd, SrsActionOnOcr,
+	SrsActionOnTranscriptMatch,
+}
+
+func isCallbackAction(action SrsAction) bool {
+	for _, v := range callbackActions {
+		if v == action {
+			return true
+		}
+	}
+	return false
+}
+
+// CallbackSubscription is a target to callback, which subscribes to some actions and streams.
+type CallbackSubscription struct {
+	// The subscription ID, generated if empty.
+	ID string `json:"id"`
+	// The name of subscription, for example, billing.
+	Name string `json:"name,omitempty"`
+	// Whether the subscription is enabled.
+	Enabled bool `json:"enabled"`
+	// The callback target.
+	Target string `json:"target"`
+	// The opaque string, for example, the token, also the secret to sign the request.
+	Opaque string `json:"opaque"`
+	// The actions to subscribe to, empty for all actions.
+	Actions []SrsAction `json:"actions,omitempty"`
+	// The glob filters of stream, for example, /live/*, empty for all streams.
+	Streams []string `json:"streams,omitempty"`
+}
+
+func (v *CallbackSubscription) String() string {
+	return fmt.Sprintf("id=%v, name=%v, enabled=%v, target=%v, opaque=%vB, actions=%v, streams=%v",
+		v.ID, v.Name, v.Enabled, v.Target, len(v.Opaque), v.Actions, v.Streams)
+}
+
+// Match whether the subscription subscribes to the action of stream.
+func (v *CallbackSubscription) Match(action SrsAction, app, stream string) (bool, error) {
+	if len(v.Actions) > 0 {
+		var matched bool
+		for _, a := range v.Actions {
+			if a == action {
+				matched = true
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	if len(v.Streams) > 0 {
+		streamURL := fmt.Sprintf("/%v/%v", app, stream)
+		for _, glob := range v.Streams {
+			if ok, err := path.Match(glob, streamURL); err != nil {
+				return false, errors.Wrapf(err, "match %v", glob)
+			} else if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// The max attempts to deliver a callback, mark it as dead if exceed.
+const callbackMaxAttempts = 10
+
+const (
+	// The timeout to post the callback in queue.
+	callbackPostTimeout = 30 * time.Second
+	// The timeout to post on_publish to all subscriptions, which blocks the hook of SRS.
+	callbackPublishTimeout = 3 * time.Second
+	// The callback in queue is claimed for this duration, and retried if not done, should be longer than the
+	// timeout to post.
+	callbackClaimTimeout = 2 * callbackPostTimeout
+)
+
+// The max number of deliveries in history.
+const callbackMaxHistory = 1000
+
+type CallbackDeliveryStatus string
+
+const (
+	// The delivery is waiting in queue.
+	CallbackDeliveryPending CallbackDeliveryStatus = "pending"
+	// The delivery is done, the callback server responds ok.
+	CallbackDeliveryDone CallbackDeliveryStatus = "delivered"
+	// The on_publish delivery is rejected by callback server, never retry.
+	CallbackDeliveryRejected CallbackDeliveryStatus = "rejected"
+	// The delivery failed after max attempts, which is the dead letter, user could replay it.
+	CallbackDeliveryDead CallbackDeliveryStatus = "dead"
+)
+
+// CallbackDelivery is a callback request to deliver to target, which is persistent in redis, and retried if
+// failed. The RequestID is the idempotency key, which is the same for retries and replays.
+type CallbackDelivery struct {
+	// The request ID of callback, also the idempotency key.
+	RequestID string `json:"request_id"`
+	// The callback action, for example, on_publish.
+	Action SrsAction `json:"action"`
+	// The subscription ID.
+	Subscription string `json:"subscription"`
+	// The callback target.
+	Target string `json:"target"`
+	// The opaque to sign the request.
+	Opaque string `json:"opaque"`
+	// The request body in JSON.
+	Body string `json:"body"`
+	// The delivery status.
+	Status CallbackDeliveryStatus `json:"status"`
+	// The number of attempts.
+	Attempts int `json:"attempts"`
+	// The time for next attempt, if pending.
+	NextAttempt string `json:"next,omitempty"`
+	// The error of last attempt.
+	LastError string `json:"error,omitempty"`
+	// The response of last attempt.
+	Response string `json:"response,omitempty"`
+	// Create time.
+	CreatedAt string `json:"created_at"`
+	// Update time.
+	UpdatedAt string `json:"updated_at"`
+}
+
+func NewCallbackDelivery(action SrsAction, sub *CallbackSubscription, requestID string, req interface{}) (*CallbackDelivery, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal req")
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	return &CallbackDelivery{
+		RequestID: requestID, Action: action, Subscription: sub.ID, Target: sub.Target, Opaque: sub.Opaque,
+		Body: string(b), Status: CallbackDeliveryPending, CreatedAt: now, UpdatedAt: now,
+	}, nil
+}
+
+func (v *CallbackDelivery) String() string {
+	return fmt.Sprintf("id=%v, action=%v, subscription=%v, target=%v, status=%v, attempts=%v, next=%v, error=%v",
+		v.RequestID, v.Action, v.Subscription, v.Target, v.Status, v.Attempts, v.NextAttempt, v.LastError)
+}
+
+// Save the delivery to redis.
+func (v *CallbackDelivery) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err := rdb.HSet(ctx, SRS_HOOKS_DELIVERY, v.RequestID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_HOOKS_DELIVERY, v.RequestID, string(b))
+	}
 	return nil
 }
+
+// LoadCallbackDelivery load the delivery from redis, return nil if not exists.
+func LoadCallbackDelivery(ctx context.Context, requestID string) (*CallbackDelivery, error) {
+	r0, err := rdb.HGet(ctx, SRS_HOOKS_DELIVERY, requestID).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_HOOKS_DELIVERY, requestID)
+	} else if r0 == "" {
+		return nil, nil
+	}
+
+	var delivery CallbackDelivery
+	if err := json.Unmarshal([]byte(r0), &delivery); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", r0)
+	}
+	return &delivery, nil
+}
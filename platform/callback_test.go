@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCallbackBackoff(t *testing.T) {
+	for _, c := range []struct {
+		attempts int
+		expect   time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{9, 21*time.Minute + 20*time.Second},
+		{10, 30 * time.Minute},
+		{100, 30 * time.Minute},
+	} {
+		if r0 := callbackBackoff(c.attempts); r0 != c.expect {
+			t.Errorf("Expected %v for attempts %v, got %v", c.expect, c.attempts, r0)
+		}
+	}
+}
+
+func TestSignCallbackBody(t *testing.T) {
+	body := []byte(`{"request_id":"xxx","action":"on_publish"}`)
+
+	h := hmac.New(sha256.New, []byte("opaque"))
+	h.Write([]byte("1700000000." + string(body)))
+	expect := "sha256=" + hex.EncodeToString(h.Sum(nil))
+
+	if r0 := signCallbackBody("opaque", 1700000000, body); r0 != expect {
+		t.Errorf("Expected %v, got %v", expect, r0)
+	}
+	if r0 := signCallbackBody("other", 1700000000, body); r0 == expect {
+		t.Errorf("Expected different signature for other opaque")
+	}
+	if r0 := signCallbackBody("opaque", 1700000001, body); r0 == expect {
+		t.Errorf("Expected different signature for other timestamp")
+	}
+}
+
+func TestParseCallbackResponseCode(t *testing.T) {
+	for _, c := range []struct {
+		body   string
+		expect int
+		err    bool
+	}{
+		{"0", 0, false},
+		{"100", 100, false},
+		{`{"code":0}`, 0, false},
+		{`{"code":2049,"data":null}`, 2049, false},
+		{`{}`, 0, false},
+		{"ok", 0, true},
+	} {
+		code, err := parseCallbackResponseCode([]byte(c.body))
+		if (err != nil) != c.err {
+			t.Errorf("Expected err=%v for %v, got %v", c.err, c.body, err)
+		} else if code != c.expect {
+			t.Errorf("Expected %v for %v, got %v", c.expect, c.body, code)
+		}
+	}
+}
+
+func TestCallbackSubscription_Match(t *testing.T) {
+	sub := &CallbackSubscription{
+		Actions: []SrsAction{SrsActionOnPublish, SrsActionOnUnpublish},
+		Streams: []string{"/live/*"},
+	}
+	for _, c := range []struct {
+		action SrsAction
+		app    string
+		stream string
+		expect bool
+	}{
+		{SrsActionOnPublish, "live", "livestream", true},
+		{SrsActionOnUnpublish, "live", "livestream", true},
+		{SrsActionOnRecordEnd, "live", "livestream", false},
+		{SrsActionOnPublish, "other", "livestream", false},
+	} {
+		if ok, err := sub.Match(c.action, c.app, c.stream); err != nil {
+			t.Errorf("Match %v %v/%v failed, err %v", c.action, c.app, c.stream, err)
+		} else if ok != c.expect {
+			t.Errorf("Expected %v for %v %v/%v, got %v", c.expect, c.action, c.app, c.stream, ok)
+		}
+	}
+
+	// Empty actions and streams match all.
+	if ok, err := (&CallbackSubscription{}).Match(SrsActionOnOcr, "any", "stream"); err != nil || !ok {
+		t.Errorf("Expected match all, got %v, err %v", ok, err)
+	}
+}
+
+func TestCallbackConfig_Effective(t *testing.T) {
+	config := &CallbackConfig{
+		All: true, Target: "http://127.0.0.1/hooks", Opaque: "legacy",
+		Subscriptions: []*CallbackSubscription{
+			{ID: "a", Enabled: true, Target: "http://127.0.0.1/a"},
+			{ID: "b", Enabled: false, Target: "http://127.0.0.1/b"},
+		},
+	}
+
+	subs := config.Effective()
+	if len(subs) != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %v", len(subs))
+	}
+	if subs[0].ID != "default" || subs[0].Target != config.Target || subs[0].Opaque != config.Opaque {
+		t.Errorf("Expected legacy subscription, got %v", subs[0].String())
+	}
+	if subs[1].ID != "a" {
+		t.Errorf("Expected subscription a, got %v", subs[1].String())
+	}
+
+	config.All = false
+	if subs := config.Effective(); len(subs) != 1 || subs[0].ID != "a" {
+		t.Errorf("Expected only subscription a, got %v", subs)
+	}
+}
+
+func TestCallbackConfig_Validate(t *testing.T) {
+	config := &CallbackConfig{Subscriptions: []*CallbackSubscription{
+		{Enabled: true, Target: "http://8.8.8.8/a", Actions: []SrsAction{SrsActionOnPublish}},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected valid, got %v", err)
+	} else if config.Subscriptions[0].ID == "" {
+		t.Errorf("Expected generated ID")
+	}
+
+	config.Subscriptions[0].Actions = []SrsAction{"on_unknown"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected error for invalid action")
+	}
+
+	config.Subscriptions[0].Actions = nil
+	config.Subscriptions[0].Streams = []string{"/live/["}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected error for invalid stream glob")
+	}
+}
+
+func TestCheckCallbackResponse(t *testing.T) {
+	for _, c := range []struct {
+		status   int
+		body     string
+		rejected bool
+		err      bool
+	}{
+		{http.StatusOK, "0", false, false},
+		{http.StatusOK, `{"code":0}`, false, false},
+		{http.StatusOK, `{"code":100}`, true, true},
+		{http.StatusOK, "ok", false, true},
+		{http.StatusInternalServerError, `{"code":100}`, false, true},
+		{http.StatusForbidden, "", false, true},
+		{http.StatusBadGateway, "", false, true},
+	} {
+		rejected, err := checkCallbackResponse(c.status, []byte(c.body))
+		if (err != nil) != c.err || rejected != c.rejected {
+			t.Errorf("Expected rejected=%v, err=%v for %v %v, got %v, %v", c.rejected, c.err, c.status, c.body, rejected, err)
+		}
+	}
+}
@@ -93,6 +93,9 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				if len(userConf.Streams) == 0 {
 					return errors.New("no files")
 				}
+				if err := ValidateFallbacks(userConf.Fallbacks); err != nil {
+					return errors.Wrapf(err, "validate fallbacks")
+				}
 			}
 
 			if action == "update" {
@@ -628,11 +631,13 @@ type CameraConfigure struct {
 
 	// The input files for IP camera.
 	Streams []*FFprobeSource `json:"files"`
+	// The fallback sources in order, used when the input is not available.
+	Fallbacks []*FFprobeSource `json:"fallbacks,omitempty"`
 }
 
 func (v CameraConfigure) String() string {
-	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, extraAudio=%v",
-		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Streams, v.ExtraAudio,
+	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, extraAudio=%v, fallbacks=%v",
+		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Streams, v.ExtraAudio, len(v.Fallbacks),
 	)
 }
 
@@ -644,6 +649,10 @@ func (v *CameraConfigure) Update(u *CameraConfigure) error {
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
 	v.Streams = append([]*FFprobeSource{}, u.Streams...)
+	// Keep the fallbacks if not specified, for the legacy clients.
+	if u.Fallbacks != nil {
+		v.Fallbacks = append([]*FFprobeSource{}, u.Fallbacks...)
+	}
 	v.ExtraAudio = u.ExtraAudio
 	return nil
 }
@@ -675,6 +684,8 @@ type CameraTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// The failover of input.
+	failover FailoverInputs
 
 	// The configure for IP camera task.
 	config *CameraConfigure
@@ -725,6 +736,9 @@ func (v *CameraTask) Restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	// Use the primary input for new configure, before cancel the FFmpeg.
+	v.failover.Reset()
+
 	if v.cancel != nil {
 		v.cancel()
 	}
@@ -811,14 +825,56 @@ func (v *CameraTask) Run(ctx context.Context) error {
 			return nil
 		}
 
+		// Use the fallback if the primary input failed.
+		fallbacks := v.config.Fallbacks
+		active, generation := v.failover.Active()
+		if active > 0 && active <= len(fallbacks) {
+			input = fallbacks[active-1]
+			logger.Tf(ctx, "Camera: Use fallback=%v as input for platform=%v", input.Target, v.Platform)
+		}
+
 		// Start IP camera task.
-		if err := v.doCameraStreaming(ctx, input); err != nil {
+		err := v.doCameraStreaming(ctx, input)
+
+		// Switch to next input if failed, not caused by user or recovered. Note that the clean exit of FFmpeg,
+		// for example, the end of stream, is not a failure of input.
+		if err != nil && ctx.Err() == nil && v.failover.OnFailed(generation, len(fallbacks)+1) {
+			logger.Wf(ctx, "Camera: Switch input for platform=%v, failover %v, err %v", v.Platform, v.failover.String(), err)
+			return nil
+		}
+
+		if err != nil {
 			return errors.Wrapf(err, "do IP camera")
 		}
 
 		return nil
 	}
 
+	// Probe the primary input, switch back when it's recovered. Wait for the probe when the task quit.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v.failover.Probe(ctx, func(ctx context.Context) bool {
+			v.lock.Lock()
+			var primary *FFprobeSource
+			if len(v.config.Streams) > 0 {
+				primary = v.config.Streams[0]
+			}
+			v.lock.Unlock()
+
+			return primary != nil && probeFailoverSource(ctx, primary)
+		}, func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			if v.cancel != nil {
+				v.cancel()
+			}
+		})
+	}()
+
 	for ctx.Err() == nil {
 		if err := pfn(ctx); err != nil {
 			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
@@ -843,7 +899,10 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 	// Create context for current task.
 	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
+
+	v.lock.Lock()
 	v.cancel = cancel
+	v.lock.Unlock()
 
 	// Build input URL.
 	host := "localhost"
@@ -857,6 +916,7 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
+	heartbeat.MetricsTask, heartbeat.MetricsID = FFmpegTaskCamera, v.Platform
 	v.starttime, v.firstReadyTime = &heartbeat.starttime, nil
 	defer func() {
 		v.starttime = nil
@@ -864,6 +924,10 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 
 	// Start FFmpeg process.
 	args := []string{}
+	// Loop the fallback file, for example, the slate file.
+	if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL {
+		args = append(args, "-stream_loop", "-1")
+	}
 	args = append(args, "-re",
 		"-fflags", "nobuffer", // Reduce the latency introduced by optional buffering.
 	)
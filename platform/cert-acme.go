@@ -0,0 +1,288 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+const (
+	// The ACME challenge by HTTP-01, lego writes the token to the webroot served by nginx.
+	ACMEChallengeHTTP = "http"
+	// The ACME challenge by DNS-01, lego writes the TXT record by a DNS provider.
+	ACMEChallengeDNS = "dns"
+)
+
+const (
+	// The DNS provider by RFC2136, dynamic updates to a DNS server, such as BIND or a local DNS stand-in.
+	ACMEDNSProviderRFC2136 = "rfc2136"
+)
+
+// The default ACME account email, for compatibility with previous versions.
+const defaultACMEEmail = "srs.stack@gmail.com"
+
+// CertACMEConfig is the config of ACME client to request the certificate.
+type CertACMEConfig struct {
+	// The email of ACME account.
+	Email string `json:"email,omitempty"`
+	// The directory URL of ACME server, use Let's Encrypt if empty. For example, https://localhost:14000/dir
+	// for Pebble, and set env LEGO_CA_CERTIFICATES to trust the CA of Pebble.
+	Server string `json:"server,omitempty"`
+	// The challenge type, http or dns, use http if empty.
+	Challenge string `json:"challenge,omitempty"`
+	// The DNS provider for DNS-01 challenge.
+	DNS *CertDNSConfig `json:"dns,omitempty"`
+}
+
+func NewCertACMEConfig() *CertACMEConfig {
+	return &CertACMEConfig{
+		Email: defaultACMEEmail, Challenge: ACMEChallengeHTTP,
+	}
+}
+
+func (v *CertACMEConfig) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("email=%v, server=%v, challenge=%v", v.Email, v.Server, v.Challenge))
+	if v.DNS != nil {
+		sb.WriteString(fmt.Sprintf(", dns=<%v>", v.DNS.String()))
+	}
+	return sb.String()
+}
+
+func (v *CertACMEConfig) Validate() error {
+	if v.Email == "" {
+		v.Email = defaultACMEEmail
+	}
+	if v.Challenge == "" {
+		v.Challenge = ACMEChallengeHTTP
+	}
+
+	if !strings.Contains(v.Email, "@") {
+		return errors.Errorf("invalid email %v", v.Email)
+	}
+
+	if v.Server != "" {
+		if u, err := url.Parse(v.Server); err != nil {
+			return errors.Wrapf(err, "parse server %v", v.Server)
+		} else if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.Errorf("invalid server %v", v.Server)
+		}
+	}
+
+	switch v.Challenge {
+	case ACMEChallengeHTTP:
+	case ACMEChallengeDNS:
+		if v.DNS == nil {
+			return errors.New("no dns provider for dns challenge")
+		}
+		if _, err := NewACMEDNSProvider(v.DNS); err != nil {
+			return errors.Wrapf(err, "dns provider")
+		}
+	default:
+		return errors.Errorf("invalid challenge %v", v.Challenge)
+	}
+	return nil
+}
+
+func (v *CertACMEConfig) Load(ctx context.Context) error {
+	if b, err := rdb.Get(ctx, SRS_HTTPS_ACME).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "get %v", SRS_HTTPS_ACME)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+// rfc2136 returns the RFC2136 config of DNS provider, or nil if not configured.
+func (v *CertACMEConfig) rfc2136() *RFC2136Config {
+	if v.DNS == nil {
+		return nil
+	}
+	return v.DNS.RFC2136
+}
+
+func (v *CertACMEConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err := rdb.Set(ctx, SRS_HTTPS_ACME, string(b), 0).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "set %v %v", SRS_HTTPS_ACME, string(b))
+	}
+	return nil
+}
+
+// CertDNSConfig is the config of DNS provider, to solve the DNS-01 challenge.
+type CertDNSConfig struct {
+	// The DNS provider, such as rfc2136.
+	Provider string `json:"provider"`
+	// The DNS resolvers to check the TXT record, such as 127.0.0.1:53, use the system resolvers if empty.
+	Resolvers []string `json:"resolvers,omitempty"`
+	// The config for RFC2136 provider.
+	RFC2136 *RFC2136Config `json:"rfc2136,omitempty"`
+}
+
+func (v *CertDNSConfig) String() string {
+	return fmt.Sprintf("provider=%v, resolvers=%v", v.Provider, v.Resolvers)
+}
+
+// RFC2136Config is the config to update the TXT record by RFC2136, see
+// https://go-acme.github.io/lego/dns/rfc2136/
+type RFC2136Config struct {
+	// The DNS server, such as 127.0.0.1:53
+	Nameserver string `json:"nameserver"`
+	// The TSIG key name, optional.
+	TSIGKey string `json:"tsigKey,omitempty"`
+	// The TSIG secret in base64, required if key is set.
+	TSIGSecret string `json:"tsigSecret,omitempty"`
+	// The TSIG algorithm, such as hmac-sha256.
+	TSIGAlgorithm string `json:"tsigAlgorithm,omitempty"`
+	// The timeout in seconds to wait for the TXT record to propagate, optional.
+	PropagationTimeout int `json:"propagationTimeout,omitempty"`
+}
+
+// ACMEDNSProvider is the DNS provider to solve the DNS-01 challenge by lego, which is configured by the name
+// of provider and the environment variables.
+type ACMEDNSProvider interface {
+	// Name returns the lego name of provider, such as rfc2136.
+	Name() string
+	// Env returns the environment variables for lego.
+	Env() []string
+}
+
+// NewACMEDNSProvider create the DNS provider by config.
+func NewACMEDNSProvider(config *CertDNSConfig) (ACMEDNSProvider, error) {
+	switch config.Provider {
+	case ACMEDNSProviderRFC2136:
+		if config.RFC2136 == nil {
+			return nil, errors.New("no rfc2136 config")
+		}
+		if err := config.RFC2136.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "rfc2136")
+		}
+		return &rfc2136DNSProvider{config: config.RFC2136}, nil
+	default:
+		return nil, errors.Errorf("invalid dns provider %v", config.Provider)
+	}
+}
+
+func (v *RFC2136Config) Validate() error {
+	if v.Nameserver == "" {
+		return errors.New("no nameserver")
+	}
+	if v.TSIGKey != "" && v.TSIGSecret == "" {
+		return errors.Errorf("no secret for tsig key %v", v.TSIGKey)
+	}
+	if v.PropagationTimeout < 0 {
+		return errors.Errorf("invalid propagation timeout %v", v.PropagationTimeout)
+	}
+	return nil
+}
+
+type rfc2136DNSProvider struct {
+	config *RFC2136Config
+}
+
+func (v *rfc2136DNSProvider) Name() string {
+	return ACMEDNSProviderRFC2136
+}
+
+func (v *rfc2136DNSProvider) Env() []string {
+	env := []string{fmt.Sprintf("RFC2136_NAMESERVER=%v", v.config.Nameserver)}
+	if v.config.TSIGKey != "" {
+		env = append(env,
+			fmt.Sprintf("RFC2136_TSIG_KEY=%v", v.config.TSIGKey),
+			fmt.Sprintf("RFC2136_TSIG_SECRET=%v", v.config.TSIGSecret),
+		)
+	}
+	if v.config.TSIGAlgorithm != "" {
+		env = append(env, fmt.Sprintf("RFC2136_TSIG_ALGORITHM=%v", v.config.TSIGAlgorithm))
+	}
+	if v.config.PropagationTimeout > 0 {
+		env = append(env, fmt.Sprintf("RFC2136_PROPAGATION_TIMEOUT=%v", v.config.PropagationTimeout))
+	}
+	return env
+}
+
+// parseCertDomains parse the domains separated by comma or space, such as "a.com,*.b.com".
+func parseCertDomains(s string) []string {
+	var domains []string
+	exists := make(map[string]bool)
+	for _, domain := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		if domain = strings.ToLower(domain); !exists[domain] {
+			domains = append(domains, domain)
+			exists[domain] = true
+		}
+	}
+	return domains
+}
+
+// validateCertDomains check the domains for certificate, the wildcard domain requires DNS-01 challenge.
+func validateCertDomains(domains []string, challenge string) error {
+	if len(domains) == 0 {
+		return errors.New("empty domain")
+	}
+
+	for _, domain := range domains {
+		name := domain
+		if strings.HasPrefix(domain, "*.") {
+			if challenge != ACMEChallengeDNS {
+				return errors.Errorf("wildcard domain %v requires dns challenge", domain)
+			}
+			name = domain[len("*."):]
+		}
+
+		if name == "" || strings.ContainsAny(name, "*/:@ ") || !strings.Contains(name, ".") {
+			return errors.Errorf("invalid domain %v", domain)
+		}
+	}
+	return nil
+}
+
+// legoCertificateName returns the file name of certificate generated by lego, which is the first domain with
+// the wildcard replaced by underscore, such as _.example.com for *.example.com.
+func legoCertificateName(domains []string) string {
+	return strings.ReplaceAll(domains[0], "*", "_")
+}
+
+// buildLegoArgs build the args and environment variables to run lego, for the domains and commands, such as
+// run or renew.
+func buildLegoArgs(config *CertACMEConfig, domains []string, commands ...string) ([]string, []string, error) {
+	args := []string{"--email", config.Email}
+	for _, domain := range domains {
+		args = append(args, "--domains", domain)
+	}
+	if config.Server != "" {
+		args = append(args, "--server", config.Server)
+	}
+
+	var env []string
+	if config.Challenge == ACMEChallengeDNS {
+		provider, err := NewACMEDNSProvider(config.DNS)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "dns provider")
+		}
+
+		args = append(args, "--dns", provider.Name())
+		for _, resolver := range config.DNS.Resolvers {
+			args = append(args, "--dns.resolvers", resolver)
+		}
+		env = provider.Env()
+	} else {
+		args = append(args, "--http.webroot", path.Join(conf.Pwd, "containers/data"), "--http")
+	}
+
+	args = append(args, commands...)
+	return args, env, nil
+}
@@ -0,0 +1,367 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// CertDomain is the certificate of a domain, for HTTPS server to select by SNI.
+type CertDomain struct {
+	// The domain of certificate, such as example.com or *.example.com
+	Domain string `json:"domain"`
+	// The private key in PEM.
+	Key string `json:"key"`
+	// The certificate chain in PEM.
+	Crt string `json:"crt"`
+	// The update time in RFC3339.
+	Update string `json:"update"`
+}
+
+func (v *CertDomain) String() string {
+	return fmt.Sprintf("domain=%v, key=%vB, crt=%vB, update=%v", v.Domain, len(v.Key), len(v.Crt), v.Update)
+}
+
+// CertInfo is the information of certificate, to show the expiry.
+type CertInfo struct {
+	// The domain of certificate, empty for the default certificate.
+	Domain string `json:"domain,omitempty"`
+	// The DNS names of certificate.
+	Names []string `json:"names"`
+	// The issuer of certificate.
+	Issuer string `json:"issuer"`
+	// The valid time range of certificate.
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// Whether the certificate is expired.
+	Expired bool `json:"expired"`
+}
+
+// parseCertificate parse the key and crt in PEM, returns the certificate with leaf.
+func parseCertificate(key, crt string) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair([]byte(crt), []byte(key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "load key pair")
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, errors.Wrapf(err, "parse leaf")
+	}
+	return &cert, nil
+}
+
+// certNames returns the DNS names of certificate, or the common name if no DNS names.
+func certNames(leaf *x509.Certificate) []string {
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames
+	}
+	if leaf.Subject.CommonName != "" {
+		return []string{leaf.Subject.CommonName}
+	}
+	return nil
+}
+
+// buildCertInfo build the information of certificate, at the time of now.
+func buildCertInfo(domain string, leaf *x509.Certificate, now time.Time) *CertInfo {
+	return &CertInfo{
+		Domain: domain, Names: certNames(leaf), Issuer: leaf.Issuer.CommonName,
+		NotBefore: leaf.NotBefore, NotAfter: leaf.NotAfter, Expired: now.After(leaf.NotAfter),
+	}
+}
+
+// selectDomainCertificate select the certificate for the server name, by exact domain first, then by the
+// names of certificate such as wildcard. Prefer the certificate which is not expired.
+func selectDomainCertificate(certs map[string]*tls.Certificate, serverName string, now time.Time) *tls.Certificate {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName == "" {
+		return nil
+	}
+
+	if cert, ok := certs[serverName]; ok && !now.After(cert.Leaf.NotAfter) {
+		return cert
+	}
+
+	var domains []string
+	for domain := range certs {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var expired *tls.Certificate
+	for _, domain := range domains {
+		cert := certs[domain]
+		if err := cert.Leaf.VerifyHostname(serverName); err != nil {
+			continue
+		}
+		if !now.After(cert.Leaf.NotAfter) {
+			return cert
+		}
+		if expired == nil {
+			expired = cert
+		}
+	}
+	return expired
+}
+
+// GetCertificate select the certificate by SNI for HTTPS server, fallback to the default certificate, or the
+// self-signed certificate if no default one.
+func (v *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	v.domainLock.RLock()
+	cert := selectDomainCertificate(v.domainCertificates, hello.ServerName, time.Now())
+	v.domainLock.RUnlock()
+
+	if cert != nil {
+		return cert, nil
+	}
+
+	if cert = v.httpsCertificate; cert != nil {
+		return cert, nil
+	}
+
+	v.domainLock.Lock()
+	defer v.domainLock.Unlock()
+
+	if v.fallbackCertificate == nil {
+		key, crt, err := generateSelfSignedCertificate()
+		if err != nil {
+			return nil, errors.Wrapf(err, "generate self-signed certificate")
+		}
+
+		if v.fallbackCertificate, err = parseCertificate(key, crt); err != nil {
+			return nil, errors.Wrapf(err, "parse self-signed certificate")
+		}
+	}
+	return v.fallbackCertificate, nil
+}
+
+// loadCertDomains load the certificates of domains from redis.
+func loadCertDomains(ctx context.Context) ([]*CertDomain, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_HTTPS_CERTS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_HTTPS_CERTS)
+	}
+
+	var domains []*CertDomain
+	for domain, obj := range objs {
+		var cd CertDomain
+		if err := json.Unmarshal([]byte(obj), &cd); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", domain, obj)
+		}
+		domains = append(domains, &cd)
+	}
+
+	sort.Slice(domains, func(i, j int) bool {
+		return domains[i].Domain < domains[j].Domain
+	})
+	return domains, nil
+}
+
+// reloadDomainCertificates reload the certificates of domains from redis.
+func (v *CertManager) reloadDomainCertificates(ctx context.Context) error {
+	domains, err := loadCertDomains(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load domains")
+	}
+
+	certs := make(map[string]*tls.Certificate)
+	for _, cd := range domains {
+		cert, err := parseCertificate(cd.Key, cd.Crt)
+		if err != nil {
+			logger.Wf(ctx, "cert: ignore invalid certificate %v, err %+v", cd.String(), err)
+			continue
+		}
+		certs[cd.Domain] = cert
+	}
+
+	v.domainLock.Lock()
+	defer v.domainLock.Unlock()
+	v.domainCertificates = certs
+
+	logger.Tf(ctx, "cert: reload certificates of domains ok, domains=%v", len(certs))
+	return nil
+}
+
+// queryDomainCertificates returns the information of certificates of domains.
+func (v *CertManager) queryDomainCertificates() []*CertInfo {
+	v.domainLock.RLock()
+	defer v.domainLock.RUnlock()
+
+	infos := []*CertInfo{}
+	now := time.Now()
+	for domain, cert := range v.domainCertificates {
+		infos = append(infos, buildCertInfo(domain, cert.Leaf, now))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Domain < infos[j].Domain
+	})
+	return infos
+}
+
+// queryDefaultCertificate returns the information of the default certificate, nil if no certificate.
+func (v *CertManager) queryDefaultCertificate() *CertInfo {
+	cert := v.httpsCertificate
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil
+		}
+	}
+	return buildCertInfo("", leaf, time.Now())
+}
+
+func handleMgmtCertDomains(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/cert/domains/upload"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var domain, key, crt string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Domain *string `json:"domain"`
+				Key    *string `json:"key"`
+				Crt    *string `json:"crt"`
+			}{
+				Token: &token, Domain: &domain, Key: &key, Crt: &crt,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if key = strings.TrimSpace(key); key == "" {
+				return errors.New("empty key")
+			}
+			if crt = strings.TrimSpace(crt); crt == "" {
+				return errors.New("empty crt")
+			}
+
+			cert, err := parseCertificate(key, crt)
+			if err != nil {
+				return errors.Wrapf(err, "parse certificate")
+			}
+
+			// Use the first name of certificate, if domain not specified.
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain == "" {
+				if names := certNames(cert.Leaf); len(names) > 0 {
+					domain = strings.ToLower(names[0])
+				}
+			}
+			if err := validateCertDomains([]string{domain}, ACMEChallengeDNS); err != nil {
+				return errors.Wrapf(err, "validate domain %v", domain)
+			}
+			// For wildcard domain, verify by a subdomain of it.
+			if err := cert.Leaf.VerifyHostname(strings.Replace(domain, "*", "oryx", 1)); err != nil {
+				return errors.Wrapf(err, "certificate not match domain %v", domain)
+			}
+
+			cd := &CertDomain{
+				Domain: domain, Key: key + "\n", Crt: crt + "\n", Update: time.Now().Format(time.RFC3339),
+			}
+			if b, err := json.Marshal(cd); err != nil {
+				return errors.Wrapf(err, "marshal %v", cd.String())
+			} else if err := rdb.HSet(ctx, SRS_HTTPS_CERTS, domain, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v", SRS_HTTPS_CERTS, domain)
+			}
+
+			if err := certManager.reloadDomainCertificates(ctx); err != nil {
+				return errors.Wrapf(err, "reload certificates")
+			}
+
+			ohttp.WriteData(ctx, w, r, buildCertInfo(domain, cert.Leaf, time.Now()))
+			logger.Tf(ctx, "cert upload domain ok, %v, token=%vB", cd.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/cert/domains/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			certs := certManager.queryDomainCertificates()
+			ohttp.WriteData(ctx, w, r, certs)
+			logger.Tf(ctx, "cert query domains ok, certs=%v, token=%vB", len(certs), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/cert/domains/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, domain string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Domain *string `json:"domain"`
+			}{
+				Token: &token, Domain: &domain,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain == "" {
+				return errors.New("empty domain")
+			}
+
+			if err := rdb.HDel(ctx, SRS_HTTPS_CERTS, domain).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_HTTPS_CERTS, domain)
+			}
+
+			if err := certManager.reloadDomainCertificates(ctx); err != nil {
+				return errors.Wrapf(err, "reload certificates")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "cert remove domain ok, domain=%v, token=%vB", domain, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -37,11 +37,19 @@ type CertManager struct {
 
 	// certFileLock is used to lock the cert file nginx.key and nginx.crt.
 	certFileLock sync.Mutex
+
+	// domainCertificates is the certificates of domains, key is the domain, to select by SNI.
+	domainCertificates map[string]*tls.Certificate
+	// fallbackCertificate is the self-signed certificate, if no certificate for the server.
+	fallbackCertificate *tls.Certificate
+	// domainLock is used to protect the certificates of domains.
+	domainLock sync.RWMutex
 }
 
 func NewCertManager() *CertManager {
 	return &CertManager{
 		httpCertificateReload: make(chan bool, 1),
+		domainCertificates:    make(map[string]*tls.Certificate),
 	}
 }
 
@@ -76,50 +84,11 @@ func (v *CertManager) createSelfSignCertificate(ctx context.Context) error {
 		return nil
 	}
 
-	var key, crt string
-	if true {
-		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err != nil {
-			return errors.Wrapf(err, "generate ecdsa key")
-		}
-
-		template := x509.Certificate{
-			SerialNumber: big.NewInt(1),
-			Subject: pkix.Name{
-				CommonName: "srs.stack.local",
-			},
-			NotBefore: time.Now(),
-			NotAfter:  time.Now().AddDate(10, 0, 0),
-			KeyUsage:  x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-			ExtKeyUsage: []x509.ExtKeyUsage{
-				x509.ExtKeyUsageServerAuth,
-			},
-			BasicConstraintsValid: true,
-		}
-
-		derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
-		if err != nil {
-			return errors.Wrapf(err, "create certificate")
-		}
-
-		privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
-		if err != nil {
-			return errors.Wrapf(err, "marshal ecdsa key")
-		}
-
-		privateKeyBlock := pem.Block{
-			Type:  "EC PRIVATE KEY",
-			Bytes: privateKeyBytes,
-		}
-		key = string(pem.EncodeToMemory(&privateKeyBlock))
-
-		certBlock := pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: derBytes,
-		}
-		crt = string(pem.EncodeToMemory(&certBlock))
-		logger.Tf(ctx, "cert: create self-signed certificate ok, key=%vB, crt=%vB", len(key), len(crt))
+	key, crt, err := generateSelfSignedCertificate()
+	if err != nil {
+		return errors.Wrapf(err, "generate self-signed certificate")
 	}
+	logger.Tf(ctx, "cert: create self-signed certificate ok, key=%vB, crt=%vB", len(key), len(crt))
 
 	if err := v.updateSslFiles(ctx, key+"\n", crt+"\n"); err != nil {
 		return errors.Wrapf(err, "updateSslFiles key=%vB, crt=%vB", len(key), len(crt))
@@ -137,6 +106,52 @@ func (v *CertManager) createSelfSignCertificate(ctx context.Context) error {
 	return nil
 }
 
+// generateSelfSignedCertificate generate a self-signed certificate, returns the key and crt in PEM.
+func generateSelfSignedCertificate() (string, string, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "generate ecdsa key")
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "srs.stack.local",
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().AddDate(10, 0, 0),
+		KeyUsage:  x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+		},
+		BasicConstraintsValid: true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "create certificate")
+	}
+
+	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "marshal ecdsa key")
+	}
+
+	privateKeyBlock := pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	}
+	key := string(pem.EncodeToMemory(&privateKeyBlock))
+
+	certBlock := pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: derBytes,
+	}
+	crt := string(pem.EncodeToMemory(&certBlock))
+
+	return key, crt, nil
+}
+
 func (v *CertManager) ReloadCertificate(ctx context.Context) {
 	select {
 	case v.httpCertificateReload <- true:
@@ -219,41 +234,53 @@ func (v *CertManager) updateSslFiles(ctx context.Context, key, crt string) error
 	return nil
 }
 
-// updateLetsEncrypt request letsencrypt and update the ssl files.
-func (v *CertManager) updateLetsEncrypt(ctx context.Context, domain string) error {
+// runLego run lego with the args and environment variables, in the lego working directory.
+func (v *CertManager) runLego(ctx context.Context, args, env []string) error {
+	cmd := exec.CommandContext(ctx, "lego", args...)
+	cmd.Dir = path.Join(conf.Pwd, "containers/data/lego")
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "run lego %v in %v, stdout %v, stderr %v",
+			args, cmd.Dir, stdout.String(), stderr.String())
+	}
+	logger.Tf(ctx, "run lego %v in %v ok, stdout %v, stderr %v",
+		args, cmd.Dir, stdout.String(), stderr.String(),
+	)
+	return nil
+}
+
+// updateLetsEncrypt request ACME server such as letsencrypt for the domains, and update the ssl files.
+func (v *CertManager) updateLetsEncrypt(ctx context.Context, domains []string, acme *CertACMEConfig) error {
 	v.certFileLock.Lock()
 	defer v.certFileLock.Unlock()
 
 	defer v.ReloadCertificate(ctx)
 
 	if true {
-		args := []string{
-			"--email", "srs.stack@gmail.com", "--domains", domain,
-			"--http.webroot", path.Join(conf.Pwd, "containers/data"), "--http", "--accept-tos",
-			"run",
+		args, env, err := buildLegoArgs(acme, domains, "--accept-tos", "run")
+		if err != nil {
+			return errors.Wrapf(err, "build lego args")
 		}
-		cmd := exec.CommandContext(ctx, "lego", args...)
-		cmd.Dir = path.Join(conf.Pwd, "containers/data/lego")
 
-		var stdout, stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
-
-		if err := cmd.Run(); err != nil {
-			return errors.Wrapf(err, "run lego %v in %v, stdout %v, stderr %v",
-				args, cmd.Dir, stdout.String(), stderr.String())
+		if err := v.runLego(ctx, args, env); err != nil {
+			return errors.Wrapf(err, "request cert for %v", domains)
 		}
-		logger.Tf(ctx, "run lego %v in %v ok, stdout %v, stderr %v",
-			args, cmd.Dir, stdout.String(), stderr.String(),
-		)
 	}
 
-	keyFile := path.Join(conf.Pwd, fmt.Sprintf("containers/data/lego/.lego/certificates/%v.key", domain))
+	name := legoCertificateName(domains)
+	keyFile := path.Join(conf.Pwd, fmt.Sprintf("containers/data/lego/.lego/certificates/%v.key", name))
 	if _, err := os.Stat(keyFile); err != nil {
 		return errors.Wrapf(err, "stat %v", keyFile)
 	}
 
-	crtFile := path.Join(conf.Pwd, fmt.Sprintf("containers/data/lego/.lego/certificates/%v.crt", domain))
+	crtFile := path.Join(conf.Pwd, fmt.Sprintf("containers/data/lego/.lego/certificates/%v.crt", name))
 	if _, err := os.Stat(crtFile); err != nil {
 		return errors.Wrapf(err, "stat %v", crtFile)
 	}
@@ -265,7 +292,7 @@ func (v *CertManager) updateLetsEncrypt(ctx context.Context, domain string) erro
 	}
 
 	if true {
-		source := fmt.Sprintf("../lego/.lego/certificates/%v.key", domain)
+		source := fmt.Sprintf("../lego/.lego/certificates/%v.key", name)
 		cmd := exec.CommandContext(ctx, "ln", "-sf", source, "nginx.key")
 		cmd.Dir = path.Join(conf.Pwd, "containers/data/config")
 		if err := cmd.Run(); err != nil {
@@ -274,7 +301,7 @@ func (v *CertManager) updateLetsEncrypt(ctx context.Context, domain string) erro
 	}
 
 	if true {
-		source := fmt.Sprintf("../lego/.lego/certificates/%v.crt", domain)
+		source := fmt.Sprintf("../lego/.lego/certificates/%v.crt", name)
 		cmd := exec.CommandContext(ctx, "ln", "-sf", source, "nginx.crt")
 		cmd.Dir = path.Join(conf.Pwd, "containers/data/config")
 		if err := cmd.Run(); err != nil {
@@ -285,29 +312,18 @@ func (v *CertManager) updateLetsEncrypt(ctx context.Context, domain string) erro
 	return nil
 }
 
-// renewLetsEncrypt request letsencrypt and update the ssl files.
-func (v *CertManager) renewLetsEncrypt(ctx context.Context, domain string) error {
+// renewLetsEncrypt renew the certificate of domains by ACME server, and update the ssl files.
+func (v *CertManager) renewLetsEncrypt(ctx context.Context, domains []string, acme *CertACMEConfig) error {
 	defer v.ReloadCertificate(ctx)
 
-	args := []string{
-		"--email", "srs.stack@gmail.com", "--domains", domain,
-		"--http.webroot", path.Join(conf.Pwd, "containers/data"), "--http",
-		"renew", "--days", "30",
+	args, env, err := buildLegoArgs(acme, domains, "renew", "--days", "30")
+	if err != nil {
+		return errors.Wrapf(err, "build lego args")
 	}
-	cmd := exec.CommandContext(ctx, "lego", args...)
-	cmd.Dir = path.Join(conf.Pwd, "containers/data/lego")
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return errors.Wrapf(err, "run lego %v in %v, stdout %v, stderr %v",
-			args, cmd.Dir, stdout.String(), stderr.String())
+	if err := v.runLego(ctx, args, env); err != nil {
+		return errors.Wrapf(err, "renew cert for %v", domains)
 	}
-	logger.Tf(ctx, "run lego %v in %v ok, stdout %v, stderr %v",
-		args, cmd.Dir, stdout.String(), stderr.String(),
-	)
 
 	return nil
 }
@@ -326,16 +342,25 @@ func (v *CertManager) refreshSSLCert(ctx context.Context) error {
 	if err != nil && err != redis.Nil {
 		return err
 	}
-	if domain == "" {
+	domains := parseCertDomains(domain)
+	if len(domains) == 0 {
 		logger.Tf(ctx, "cert: ignore ssl domain empty")
 		return nil
 	}
 
-	if err := v.renewLetsEncrypt(ctx, domain); err != nil {
+	acme := NewCertACMEConfig()
+	if err := acme.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load acme config")
+	}
+
+	if err := v.renewLetsEncrypt(ctx, domains, acme); err != nil {
 		return err
 	} else {
 		logger.Tf(ctx, "cert: renew ssl cert ok")
 	}
+	platformEvents.Publish(ctx, EventCertRenew, "", map[string]interface{}{
+		"action": "renew", "domains": domains, "provider": "lets",
+	})
 
 	if err := nginxGenerateConfig(ctx); err != nil {
 		return errors.Wrapf(err, "nginx config and reload")
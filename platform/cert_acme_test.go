@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCertDomains(t *testing.T) {
+	domains := parseCertDomains(" play.example.com, Admin.example.com *.example.com,play.example.com ")
+	if r := strings.Join(domains, ","); r != "play.example.com,admin.example.com,*.example.com" {
+		t.Errorf("Unexpected domains %v", r)
+	}
+	if domains := parseCertDomains(" , "); len(domains) != 0 {
+		t.Errorf("Expected no domains, got %v", domains)
+	}
+}
+
+func TestValidateCertDomains(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		domains   []string
+		challenge string
+		hasError  bool
+	}{
+		{"single", []string{"example.com"}, ACMEChallengeHTTP, false},
+		{"san", []string{"play.example.com", "admin.example.com"}, ACMEChallengeHTTP, false},
+		{"wildcard", []string{"*.example.com", "example.com"}, ACMEChallengeDNS, false},
+		{"wildcard-http", []string{"*.example.com"}, ACMEChallengeHTTP, true},
+		{"empty", nil, ACMEChallengeHTTP, true},
+		{"invalid", []string{"a.*.example.com"}, ACMEChallengeDNS, true},
+		{"no-dot", []string{"localhost"}, ACMEChallengeHTTP, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := validateCertDomains(c.domains, c.challenge); (err != nil) != c.hasError {
+				t.Errorf("Expected error %v, got %v", c.hasError, err)
+			}
+		})
+	}
+}
+
+func TestCertACMEConfig_Validate(t *testing.T) {
+	config := &CertACMEConfig{}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected valid, got %v", err)
+	}
+	if config.Email != defaultACMEEmail || config.Challenge != ACMEChallengeHTTP {
+		t.Errorf("Expected default config, got %v", config.String())
+	}
+
+	for _, c := range []*CertACMEConfig{
+		{Email: "invalid"},
+		{Server: "ftp://localhost/dir"},
+		{Challenge: "tls"},
+		{Challenge: ACMEChallengeDNS},
+		{Challenge: ACMEChallengeDNS, DNS: &CertDNSConfig{Provider: "unknown"}},
+		{Challenge: ACMEChallengeDNS, DNS: &CertDNSConfig{Provider: ACMEDNSProviderRFC2136}},
+		{Challenge: ACMEChallengeDNS, DNS: &CertDNSConfig{Provider: ACMEDNSProviderRFC2136, RFC2136: &RFC2136Config{
+			Nameserver: "127.0.0.1:53", TSIGKey: "oryx.",
+		}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected invalid for %v", c.String())
+		}
+	}
+}
+
+func TestBuildLegoArgs(t *testing.T) {
+	config := &CertACMEConfig{
+		Email: "admin@example.com", Server: "https://localhost:14000/dir", Challenge: ACMEChallengeDNS,
+		DNS: &CertDNSConfig{Provider: ACMEDNSProviderRFC2136, Resolvers: []string{"127.0.0.1:8053"},
+			RFC2136: &RFC2136Config{
+				Nameserver: "127.0.0.1:8053", TSIGKey: "oryx.", TSIGSecret: "c2VjcmV0",
+				TSIGAlgorithm: "hmac-sha256.", PropagationTimeout: 60,
+			},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected valid, got %v", err)
+	}
+
+	domains := []string{"*.example.com", "example.com"}
+	args, env, err := buildLegoArgs(config, domains, "--accept-tos", "run")
+	if err != nil {
+		t.Fatalf("build args err %+v", err)
+	}
+
+	expect := "--email admin@example.com --domains *.example.com --domains example.com " +
+		"--server https://localhost:14000/dir --dns rfc2136 --dns.resolvers 127.0.0.1:8053 --accept-tos run"
+	if r := strings.Join(args, " "); r != expect {
+		t.Errorf("Expected %v, got %v", expect, r)
+	}
+
+	expect = "RFC2136_NAMESERVER=127.0.0.1:8053,RFC2136_TSIG_KEY=oryx.,RFC2136_TSIG_SECRET=c2VjcmV0," +
+		"RFC2136_TSIG_ALGORITHM=hmac-sha256.,RFC2136_PROPAGATION_TIMEOUT=60"
+	if r := strings.Join(env, ","); r != expect {
+		t.Errorf("Expected %v, got %v", expect, r)
+	}
+
+	if name := legoCertificateName(domains); name != "_.example.com" {
+		t.Errorf("Expected _.example.com, got %v", name)
+	}
+}
+
+func TestBuildLegoArgs_HTTP(t *testing.T) {
+	if conf == nil {
+		conf = NewConfig()
+	}
+
+	config := NewCertACMEConfig()
+	args, env, err := buildLegoArgs(config, []string{"example.com"}, "renew", "--days", "30")
+	if err != nil {
+		t.Fatalf("build args err %+v", err)
+	}
+
+	r := strings.Join(args, " ")
+	if !strings.HasPrefix(r, "--email srs.stack@gmail.com --domains example.com --http.webroot ") ||
+		!strings.HasSuffix(r, "containers/data --http renew --days 30") {
+		t.Errorf("Unexpected args %v", r)
+	}
+	if len(env) != 0 {
+		t.Errorf("Expected no env, got %v", env)
+	}
+}
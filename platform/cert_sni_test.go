@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func createTestCertificate(t *testing.T, names []string, notAfter time.Time) (string, string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key err %+v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: names[0]}, DNSNames: names,
+		NotBefore: notAfter.AddDate(-1, 0, 0), NotAfter: notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatalf("create certificate err %+v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("marshal key err %+v", err)
+	}
+
+	key := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return string(key), string(crt)
+}
+
+func TestSelectDomainCertificate(t *testing.T) {
+	now := time.Now()
+	parse := func(names []string, notAfter time.Time) *tls.Certificate {
+		key, crt := createTestCertificate(t, names, notAfter)
+		cert, err := parseCertificate(key, crt)
+		if err != nil {
+			t.Fatalf("parse err %+v", err)
+		}
+		return cert
+	}
+
+	player := parse([]string{"player.example.com"}, now.AddDate(0, 1, 0))
+	wildcard := parse([]string{"*.example.com"}, now.AddDate(0, 2, 0))
+	expired := parse([]string{"admin.example.com"}, now.AddDate(0, 0, -1))
+	certs := map[string]*tls.Certificate{
+		"player.example.com": player, "*.example.com": wildcard, "admin.example.com": expired,
+	}
+
+	for _, c := range []struct {
+		name       string
+		serverName string
+		expect     *tls.Certificate
+	}{
+		{"exact", "player.example.com", player},
+		{"case-and-dot", "Player.Example.com.", player},
+		{"wildcard", "live.example.com", wildcard},
+		{"prefer-valid", "admin.example.com", wildcard},
+		{"no-match", "example.org", nil},
+		{"no-sni", "", nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if r := selectDomainCertificate(certs, c.serverName, now); r != c.expect {
+				t.Errorf("Unexpected certificate for %v", c.serverName)
+			}
+		})
+	}
+
+	// Use the expired certificate if no other choice.
+	delete(certs, "*.example.com")
+	if r := selectDomainCertificate(certs, "admin.example.com", now); r != expired {
+		t.Errorf("Expected expired certificate")
+	}
+}
+
+func TestCertManager_GetCertificate(t *testing.T) {
+	now := time.Now()
+	key, crt := createTestCertificate(t, []string{"player.example.com"}, now.AddDate(0, 1, 0))
+	player, err := parseCertificate(key, crt)
+	if err != nil {
+		t.Fatalf("parse err %+v", err)
+	}
+
+	manager := NewCertManager()
+	manager.domainCertificates["player.example.com"] = player
+
+	if cert, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "player.example.com"}); err != nil || cert != player {
+		t.Errorf("Expected certificate of domain, err %v", err)
+	}
+
+	// Fallback to the self-signed certificate, if no default certificate.
+	fallback, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	if err != nil || fallback == nil || fallback.Leaf.Subject.CommonName != "srs.stack.local" {
+		t.Fatalf("Expected self-signed certificate, err %v", err)
+	}
+	if cert, _ := manager.GetCertificate(&tls.ClientHelloInfo{}); cert != fallback {
+		t.Errorf("Expected the same self-signed certificate")
+	}
+
+	// Fallback to the default certificate.
+	manager.httpsCertificate = player
+	if cert, _ := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); cert != player {
+		t.Errorf("Expected the default certificate")
+	}
+	if info := manager.queryDefaultCertificate(); info == nil || info.Names[0] != "player.example.com" || info.Expired {
+		t.Errorf("Unexpected default certificate info %v", info)
+	}
+}
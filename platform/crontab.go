@@ -91,6 +91,23 @@ func (v *CrontabWorker) Start(ctx context.Context) error {
 		}
 	}()
 
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		for {
+			if err := kickoffInactiveStreamKeyPublishers(ctx); err != nil {
+				logger.Wf(ctx, "crontab: ignore stream keys err %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}()
+
 	if err := certManager.Initialize(ctx); err != nil {
 		return errors.Wrapf(err, "initialize cert manager")
 	}
@@ -104,6 +121,9 @@ func (v *CrontabWorker) Start(ctx context.Context) error {
 			if err := certManager.reloadCertificateFile(ctx); err != nil {
 				logger.Wf(ctx, "crontab: ignore err %v", err)
 			}
+			if err := certManager.reloadDomainCertificates(ctx); err != nil {
+				logger.Wf(ctx, "crontab: ignore err %v", err)
+			}
 
 			select {
 			case <-ctx.Done():
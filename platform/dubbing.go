@@ -1579,7 +1579,7 @@ func (v *AudioResponse) MatchGroups(starttime float64, max int) []*AudioGroup {
 	return matched
 }
 
-func (v *AudioResponse) AppendSegment(resp openai.AudioResponse, starttime float64) {
+func (v *AudioResponse) AppendSegment(resp *TranscriptAsrResult, starttime float64) {
 	v.Task = resp.Task
 	v.Language = resp.Language
 	v.Duration += resp.Duration
@@ -1601,17 +1601,12 @@ func (v *AudioResponse) AppendSegment(resp openai.AudioResponse, starttime float
 					// To identify the segments.
 					OriginalStart: starttime,
 					// ASR Segment.
-					ID:               s.ID,
-					Seek:             s.Seek,
-					Start:            starttime + s.Start,
-					End:              starttime + s.End,
-					Text:             s.Text,
-					Tokens:           s.Tokens,
-					Temperature:      s.Temperature,
-					AvgLogprob:       s.AvgLogprob,
-					CompressionRatio: s.CompressionRatio,
-					NoSpeechProb:     s.NoSpeechProb,
-					Transient:        s.Transient,
+					ID:           s.ID,
+					Seek:         s.Seek,
+					Start:        starttime + s.Start,
+					End:          starttime + s.End,
+					Text:         s.Text,
+					NoSpeechProb: s.NoSpeechProb,
 					// UUID.
 					UUID: uuid.NewString(),
 				},
@@ -1849,21 +1844,13 @@ func (v *SrsDubbingTask) Start(ctx context.Context) error {
 				logger.Tf(ctx, "Convert %v to segment %v ok, starttime=%v", absAsrInputAudio, tmpAsrInputAudio, starttime)
 
 				// Initialize the AI services.
-				aiConfig := openai.DefaultConfig(v.project.ASR.AISecretKey)
-				aiConfig.OrgID = v.project.ASR.AIOrganization
-				aiConfig.BaseURL = v.project.ASR.AIBaseURL
+				provider, err := NewASRProvider(&v.project.ASR.SrsAssistantProvider)
+				if err != nil {
+					return errors.Wrapf(err, "asr provider")
+				}
 
 				// Do ASR, convert to text.
-				client := openai.NewClientWithConfig(aiConfig)
-				resp, err := client.CreateTranscription(
-					ctx,
-					openai.AudioRequest{
-						Model:    openai.Whisper1,
-						FilePath: tmpAsrInputAudio,
-						Format:   openai.AudioResponseFormatVerboseJSON,
-						Language: v.project.ASR.AIASRLanguage,
-					},
-				)
+				resp, err := provider.Transcribe(ctx, tmpAsrInputAudio, v.project.ASR.AIASRLanguage, "")
 				if err != nil {
 					return errors.Wrapf(err, "transcription")
 				}
@@ -14,6 +14,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ossrs/go-oryx-lib/errors"
@@ -40,6 +41,13 @@ type RecordWorker struct {
 	msgs chan *SrsOnHlsObject
 	// The streams we're recording, key is m3u8 URL in string, value is m3u8 object *RecordM3u8Stream.
 	streams sync.Map
+	// Whether reject new recordings for low disk, 1 for reject, updated by retention sweeper.
+	rejecting uint32
+
+	// The cached rules to decide whether to record a stream, reset when changed, see queryRules.
+	rules        *recordRules
+	rulesVersion uint64
+	rulesLock    sync.Mutex
 }
 
 func NewRecordWorker() *RecordWorker {
@@ -63,7 +71,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordRead); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -121,13 +129,14 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
 			if err := rdb.HSet(ctx, SRS_RECORD_PATTERNS, "all", fmt.Sprintf("%v", all)).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hset %v all %v", SRS_RECORD_PATTERNS, all)
 			}
+			v.resetRules()
 
 			ohttp.WriteData(ctx, w, r, nil)
 			logger.Tf(ctx, "record apply ok, all=%v, token=%vB", all, len(token))
@@ -153,7 +162,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -169,6 +178,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			} else if err := rdb.HSet(ctx, SRS_RECORD_PATTERNS, "globs", string(b)).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hset %v globs %v", SRS_RECORD_PATTERNS, string(b))
 			}
+			v.resetRules()
 
 			ohttp.WriteData(ctx, w, r, nil)
 			logger.Tf(ctx, "record update globs ok, glob=%v, token=%vB", filteredGlobs, len(token))
@@ -195,7 +205,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -236,7 +246,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -253,38 +263,66 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				return errors.Wrapf(err, "parse %v", M3u8VoDMetadata)
 			}
 
-			// Remove all ts files.
-			for _, file := range metadata.Files {
-				if _, err := os.Stat(file.Key); err == nil {
-					os.Remove(file.Key)
-				}
+			if err := removeRecordArtifact(ctx, &metadata); err != nil {
+				return errors.Wrapf(err, "remove %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "record remove ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/record/retention"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var retention *RecordRetention
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string           `json:"token"`
+				Retention **RecordRetention `json:"retention"`
+			}{
+				Token: &token, Retention: &retention,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
 			}
 
-			// Remove m3u8 file.
-			m3u8File := path.Join("record", uuid, "index.m3u8")
-			if _, err := os.Stat(m3u8File); err == nil {
-				os.Remove(m3u8File)
+			apiSecret := envApiSecret()
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
+				return errors.Wrapf(err, "authenticate")
 			}
 
-			// Remove mp4 file.
-			mp4File := path.Join("record", uuid, "index.mp4")
-			if _, err := os.Stat(mp4File); err == nil {
-				os.Remove(mp4File)
+			// Update the policy if specified, which is applied by the next sweep.
+			if retention != nil {
+				if err := retention.Validate(); err != nil {
+					return errors.Wrapf(err, "validate %v", retention.String())
+				}
+				if err := retention.Save(ctx); err != nil {
+					return errors.Wrapf(err, "save %v", retention.String())
+				}
 			}
 
-			// Remove ts directory.
-			m3u8Directory := path.Join("record", uuid)
-			if _, err := os.Stat(m3u8Directory); err == nil {
-				os.RemoveAll(m3u8Directory)
+			var policy RecordRetention
+			if err := policy.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load retention")
 			}
 
-			// Remove HLS from list.
-			if err := rdb.HDel(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hdel %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+			var report RecordRetentionReport
+			if err := report.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load report")
 			}
 
-			ohttp.WriteData(ctx, w, r, nil)
-			logger.Tf(ctx, "record remove ok, uuid=%v, token=%vB", uuid, len(token))
+			ohttp.WriteData(ctx, w, r, &struct {
+				Retention *RecordRetention       `json:"retention"`
+				Report    *RecordRetentionReport `json:"report"`
+			}{
+				Retention: &policy, Report: &report,
+			})
+			logger.Tf(ctx, "record retention ok, update=%v, policy=%v, token=%vB",
+				retention != nil, policy.String(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -306,7 +344,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -344,7 +382,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordRead); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -530,10 +568,22 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	// Handle the manual control and schedules of record.
+	v.handleControl(ctx, handler)
+
 	return nil
 }
 
 func (v *RecordWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
+	// Ignore the stream if not to record, by manual control, schedules and patterns.
+	if ok, reason, err := v.shouldRecord(ctx, msg.App, msg.Stream); err != nil {
+		return errors.Wrapf(err, "should record")
+	} else if !ok {
+		return nil
+	} else {
+		logger.Tf(ctx, "record %v by %v", msg.String(), reason)
+	}
+
 	// Copy the ts file to temporary cache dir.
 	tsid := uuid.NewString()
 	tsfile := path.Join("record", fmt.Sprintf("%v.ts", tsid))
@@ -633,31 +683,11 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 	buildM3u8Object := func(ctx context.Context, msg *SrsOnHlsObject) error {
 		logger.Tf(ctx, "Record: Got message %v", msg.String())
 
-		// Filter the stream by glob filters.
-		var globFilters []string
-		if globs, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "globs").Result(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hget %v globs", SRS_RECORD_PATTERNS)
-		} else if globs != "" {
-			if err := json.Unmarshal([]byte(globs), &globFilters); err != nil {
-				return errors.Wrapf(err, "parse %v", globs)
-			}
-		}
-
-		// If glob filters are empty, ignore it, and record all streams.
-		if len(globFilters) > 0 {
-			var globMatched bool
-			streamURL := fmt.Sprintf("/%v/%v", msg.Msg.App, msg.Msg.Stream)
-			for _, globFilter := range globFilters {
-				if ok, err := path.Match(globFilter, streamURL); err != nil {
-					return errors.Wrapf(err, "match %v", globFilter)
-				} else if ok {
-					logger.Tf(ctx, "match stream %v by glob filter %v in %v", streamURL, globFilter, globFilters)
-					globMatched = true
-				}
-			}
-
-			if !globMatched {
-				logger.Wf(ctx, "ignore stream %v by glob filters %v", streamURL, globFilters)
+		// Reject new recordings for low disk, but keep the streams in recording.
+		if atomic.LoadUint32(&v.rejecting) == 1 {
+			if _, ok := v.streams.Load(msg.Msg.M3u8URL); !ok {
+				logger.Wf(ctx, "reject stream %v for low disk", msg.Msg.M3u8URL)
+				os.Remove(msg.TsFile.File)
 				return nil
 			}
 		}
@@ -717,6 +747,24 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 		}
 	}()
 
+	// Sweep the records by retention policy.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			if err := v.sweep(ctx); err != nil {
+				logger.Wf(ctx, "ignore record retention err %+v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(recordRetentionInterval):
+			}
+		}
+	}()
+
 	return nil
 }
 
@@ -843,25 +891,37 @@ func (v *RecordM3u8Stream) removeMessage(msg *SrsOnHlsObject) {
 }
 
 func (v *RecordM3u8Stream) expired(ctx context.Context) bool {
+	// Never hold the lock when query the rules, which might load from redis.
 	v.lock.Lock()
-	defer v.lock.Unlock()
+	expired, updateAt := v.Expired, v.Update
+	var app, stream string
+	if v.artifact != nil {
+		app, stream = v.artifact.App, v.artifact.Stream
+	}
+	v.lock.Unlock()
 
-	if v.Expired {
+	if expired {
 		return true
 	}
 
-	update, err := time.Parse(time.RFC3339, v.Update)
-	if err != nil {
-		return true
+	// Ignore the error, and keep the task, if failed to query the rules.
+	rules, _ := v.recordWorker.queryRules(ctx)
+
+	// Expire the task if the stream is no longer to record, for example, stopped manually or out of schedule.
+	if rules != nil && app != "" {
+		if ok, reason := rules.decide(app, stream, time.Now()); !ok {
+			logger.Tf(ctx, "record expire url=%v, uuid=%v, by %v", v.M3u8URL, v.UUID, reason)
+			return true
+		}
 	}
 
-	var enabled bool
-	if all, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result(); err == nil {
-		enabled = all == "true"
+	update, err := time.Parse(time.RFC3339, updateAt)
+	if err != nil {
+		return true
 	}
 
 	duration := 30 * time.Second
-	if enabled && envNodeEnv() != "development" {
+	if rules != nil && rules.all && envNodeEnv() != "development" {
 		duration = 300 * time.Second
 	}
 
@@ -1096,6 +1156,10 @@ func (v *RecordM3u8Stream) callbackBegin(ctx context.Context) (*SrsOnHlsObject,
 		return message, errors.Wrapf(err, "on record end %v", message)
 	}
 
+	platformEvents.Publish(ctx, EventRecordBegin, eventStreamURL(message.Msg), map[string]string{
+		"uuid": v.UUID, "m3u8_url": message.Msg.M3u8URL,
+	})
+
 	return message, nil
 }
 
@@ -1108,5 +1172,9 @@ func (v *RecordM3u8Stream) callbackEnd(ctx context.Context, message *SrsOnHlsObj
 		return errors.Wrapf(err, "on record end %v", message)
 	}
 
+	platformEvents.Publish(ctx, EventRecordEnd, eventStreamURL(message.Msg), map[string]interface{}{
+		"uuid": v.UUID, "m3u8_url": message.Msg.M3u8URL, "artifact": v.artifact.UUID, "files": len(v.artifact.Files),
+	})
+
 	return nil
 }
@@ -0,0 +1,458 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The storage type of DVR artifact.
+const DvrStorageCOS = "cos"
+const DvrStorageS3 = "s3"
+
+// The threshold to use multipart upload, and the size of part, note that S3 requires at least 5MB for a part.
+const s3MultipartThreshold = 16 * 1024 * 1024
+const s3MultipartPartSize = 8 * 1024 * 1024
+
+// The algorithm of AWS Signature Version 4.
+const s3SignAlgorithm = "AWS4-HMAC-SHA256"
+
+// The payload hash for presigned URL.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// The bucket name of S3, see https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+var s3BucketRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// S3Config is the configure for S3 compatible storage, for example, AWS S3 or MinIO.
+type S3Config struct {
+	// Whether use S3 as DVR storage, instead of Tencent COS.
+	Enabled bool `json:"enabled"`
+	// The endpoint, for example, http://127.0.0.1:9000 for MinIO, empty for AWS S3.
+	Endpoint string `json:"endpoint"`
+	// The region, for example, us-east-1.
+	Region string `json:"region"`
+	// The bucket name.
+	Bucket string `json:"bucket"`
+	// The access key ID.
+	AccessKey string `json:"accessKey"`
+	// The secret access key.
+	SecretKey string `json:"secretKey"`
+	// Whether use path style, for example, http://endpoint/bucket/key, which is required by MinIO.
+	PathStyle bool `json:"pathStyle"`
+	// The prefix of object key, for example, dvr.
+	Prefix string `json:"prefix"`
+	// Whether remux to a MP4 file when DVR done.
+	Mp4 bool `json:"mp4"`
+}
+
+func (v *S3Config) String() string {
+	return fmt.Sprintf("enabled=%v, endpoint=%v, region=%v, bucket=%v, accessKey=%v, secretKey=%vB, pathStyle=%v, prefix=%v, mp4=%v",
+		v.Enabled, v.Endpoint, v.Region, v.Bucket, v.AccessKey, len(v.SecretKey), v.PathStyle, v.Prefix, v.Mp4)
+}
+
+func (v *S3Config) Load(ctx context.Context) error {
+	values, err := rdb.HGetAll(ctx, SRS_DVR_S3).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_DVR_S3)
+	}
+
+	v.Enabled = values["enabled"] == "true"
+	v.Endpoint = values["endpoint"]
+	v.Region = values["region"]
+	v.Bucket = values["bucket"]
+	v.AccessKey = values["accessKey"]
+	v.SecretKey = values["secretKey"]
+	v.PathStyle = values["pathStyle"] == "true"
+	v.Prefix = values["prefix"]
+	v.Mp4 = values["mp4"] == "true"
+	return nil
+}
+
+func (v *S3Config) Save(ctx context.Context) error {
+	if err := rdb.HSet(ctx, SRS_DVR_S3,
+		"enabled", fmt.Sprintf("%v", v.Enabled), "endpoint", v.Endpoint, "region", v.Region,
+		"bucket", v.Bucket, "accessKey", v.AccessKey, "secretKey", v.SecretKey,
+		"pathStyle", fmt.Sprintf("%v", v.PathStyle), "prefix", v.Prefix, "mp4", fmt.Sprintf("%v", v.Mp4),
+	).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_DVR_S3, v.String())
+	}
+	return nil
+}
+
+// Validate the configure, only required when enabled.
+func (v *S3Config) Validate() error {
+	if !v.Enabled {
+		return nil
+	}
+
+	if v.Endpoint != "" {
+		if u, err := url.Parse(v.Endpoint); err != nil {
+			return errors.Wrapf(err, "parse endpoint %v", v.Endpoint)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.Errorf("invalid endpoint scheme %v", v.Endpoint)
+		} else if u.Host == "" {
+			return errors.Errorf("no host of endpoint %v", v.Endpoint)
+		}
+	}
+
+	if v.Region == "" {
+		return errors.New("no region")
+	}
+	if !s3BucketRegex.MatchString(v.Bucket) {
+		return errors.Errorf("invalid bucket %v", v.Bucket)
+	}
+	if v.AccessKey == "" || v.SecretKey == "" {
+		return errors.New("no access key or secret key")
+	}
+	if strings.HasPrefix(v.Prefix, "/") || strings.Contains(v.Prefix, "..") {
+		return errors.Errorf("invalid prefix %v", v.Prefix)
+	}
+	return nil
+}
+
+// S3Client is a client for S3 compatible storage, signed by AWS Signature Version 4.
+type S3Client struct {
+	// The configure of S3.
+	config S3Config
+	// The HTTP client.
+	client *http.Client
+	// The function to get current time, for test.
+	now func() time.Time
+}
+
+func NewS3Client(config *S3Config, opts ...func(*S3Client)) *S3Client {
+	v := &S3Client{
+		config: *config, client: &http.Client{Timeout: 5 * time.Minute}, now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// ObjectKey returns the object key with prefix.
+func (v *S3Client) ObjectKey(key string) string {
+	if v.config.Prefix == "" {
+		return key
+	}
+	return path.Join(v.config.Prefix, key)
+}
+
+// objectURL returns the URL of object, by path style or virtual hosted style.
+func (v *S3Client) objectURL(key string) *url.URL {
+	endpoint := v.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", v.config.Region)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: endpoint}
+	}
+
+	if v.config.PathStyle {
+		u.Path = fmt.Sprintf("/%v/%v", v.config.Bucket, key)
+	} else {
+		u.Host = fmt.Sprintf("%v.%v", v.config.Bucket, u.Host)
+		u.Path = fmt.Sprintf("/%v", key)
+	}
+	return u
+}
+
+// do send the request to S3, and returns the response body.
+func (v *S3Client) do(ctx context.Context, method, key string, query url.Values, headers http.Header, body []byte) (http.Header, []byte, error) {
+	u := v.objectURL(key)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "new request %v %v", method, u.String())
+	}
+	for k, values := range headers {
+		for _, value := range values {
+			req.Header.Add(k, value)
+		}
+	}
+
+	payloadHash := sha256.Sum256(body)
+	signS3Request(req, hex.EncodeToString(payloadHash[:]), v.config.AccessKey, v.config.SecretKey, v.config.Region, v.now())
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "%v %v", method, u.String())
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "read body of %v %v", method, u.String())
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, nil, errors.Errorf("%v %v status %v, body %v", method, u.String(), res.StatusCode, string(b))
+	}
+	return res.Header, b, nil
+}
+
+// PutObject upload the object in a single request.
+func (v *S3Client) PutObject(ctx context.Context, key, contentType string, body []byte) error {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+
+	if _, _, err := v.do(ctx, http.MethodPut, key, nil, headers, body); err != nil {
+		return errors.Wrapf(err, "put object %v", key)
+	}
+	return nil
+}
+
+// UploadFile upload the file, use multipart upload for large file.
+func (v *S3Client) UploadFile(ctx context.Context, key, contentType, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrapf(err, "open %v", filename)
+	}
+	defer f.Close()
+
+	stats, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat %v", filename)
+	}
+
+	if stats.Size() < s3MultipartThreshold {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return errors.Wrapf(err, "read %v", filename)
+		}
+		return v.PutObject(ctx, key, contentType, b)
+	}
+
+	return v.uploadMultipart(ctx, key, contentType, f)
+}
+
+// The part of multipart upload, for complete request.
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadMultipart upload the object by parts, abort the upload if failed.
+// See https://docs.aws.amazon.com/AmazonS3/latest/userguide/mpuoverview.html
+func (v *S3Client) uploadMultipart(ctx context.Context, key, contentType string, r io.Reader) error {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+
+	_, b, err := v.do(ctx, http.MethodPost, key, url.Values{"uploads": []string{""}}, headers, nil)
+	if err != nil {
+		return errors.Wrapf(err, "create multipart upload %v", key)
+	}
+
+	var initiate struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(b, &initiate); err != nil {
+		return errors.Wrapf(err, "parse %v", string(b))
+	} else if initiate.UploadID == "" {
+		return errors.Errorf("no upload id in %v", string(b))
+	}
+	uploadID := initiate.UploadID
+
+	if err := func() error {
+		var parts []s3CompletedPart
+		buf := make([]byte, s3MultipartPartSize)
+		for partNumber := 1; ; partNumber++ {
+			nn, err := io.ReadFull(r, buf)
+			if err == io.EOF {
+				break
+			} else if err != nil && err != io.ErrUnexpectedEOF {
+				return errors.Wrapf(err, "read part %v", partNumber)
+			}
+
+			query := url.Values{"partNumber": []string{strconv.Itoa(partNumber)}, "uploadId": []string{uploadID}}
+			header, _, err := v.do(ctx, http.MethodPut, key, query, nil, buf[:nn])
+			if err != nil {
+				return errors.Wrapf(err, "upload part %v", partNumber)
+			}
+			parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: header.Get("ETag")})
+
+			if nn < len(buf) {
+				break
+			}
+		}
+
+		body, err := xml.Marshal(&struct {
+			XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+			Parts   []s3CompletedPart `xml:"Part"`
+		}{
+			Parts: parts,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "marshal parts")
+		}
+
+		headers := http.Header{}
+		headers.Set("Content-Type", "application/xml")
+		if _, _, err := v.do(ctx, http.MethodPost, key, url.Values{"uploadId": []string{uploadID}}, headers, body); err != nil {
+			return errors.Wrapf(err, "complete multipart upload")
+		}
+		return nil
+	}(); err != nil {
+		// Abort the upload, to free the storage of parts.
+		v.do(ctx, http.MethodDelete, key, url.Values{"uploadId": []string{uploadID}}, nil, nil)
+		return errors.Wrapf(err, "upload %v id %v", key, uploadID)
+	}
+
+	return nil
+}
+
+// PresignGetObject returns a presigned URL to get the object, for private bucket.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (v *S3Client) PresignGetObject(key string, expires time.Duration) string {
+	u := v.objectURL(key)
+	now := v.now().UTC()
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3SignAlgorithm)
+	query.Set("X-Amz-Credential", fmt.Sprintf("%v/%v", v.config.AccessKey, s3Scope(now, v.config.Region)))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalQuery := s3CanonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, s3Escape(u.Path, true), canonicalQuery, fmt.Sprintf("host:%v\n", u.Host), "host", s3UnsignedPayload,
+	}, "\n")
+
+	signature := s3Signature(v.config.SecretKey, v.config.Region, now, canonicalRequest)
+	u.RawQuery = fmt.Sprintf("%v&X-Amz-Signature=%v", canonicalQuery, signature)
+	return u.String()
+}
+
+// signS3Request sign the request by AWS Signature Version 4, all headers of request are signed.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signS3Request(r *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	r.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for k, values := range r.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method, s3Escape(r.URL.Path, true), s3CanonicalQuery(r.URL.Query()), canonicalHeaders.String(),
+		signedHeaders, payloadHash,
+	}, "\n")
+
+	signature := s3Signature(secretKey, region, now, canonicalRequest)
+	r.Header.Set("Authorization", fmt.Sprintf("%v Credential=%v/%v,SignedHeaders=%v,Signature=%v",
+		s3SignAlgorithm, accessKey, s3Scope(now, region), signedHeaders, signature))
+}
+
+// s3Scope returns the credential scope, for example, 20130524/us-east-1/s3/aws4_request
+func s3Scope(now time.Time, region string) string {
+	return fmt.Sprintf("%v/%v/s3/aws4_request", now.Format("20060102"), region)
+}
+
+// s3Signature calculate the signature of canonical request.
+func s3Signature(secretKey, region string, now time.Time, canonicalRequest string) string {
+	hmacSHA256 := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3SignAlgorithm, now.Format("20060102T150405Z"), s3Scope(now, region), hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// s3CanonicalQuery returns the query string sorted by key, and escaped by RFC 3986.
+func s3CanonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, fmt.Sprintf("%v=%v", s3Escape(k, false), s3Escape(value, false)))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape escape the string by RFC 3986, except the unreserved characters, and slash if keepSlash.
+func s3Escape(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && keepSlash) {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// presignVodM3u8 replace the object keys in m3u8 by presigned URLs.
+func presignVodM3u8(m3u8Body string, client *S3Client, expires time.Duration) string {
+	lines := strings.Split(m3u8Body, "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = client.PresignGetObject(line, expires)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -38,6 +38,12 @@ type DvrWorker struct {
 	bucketName string
 	cosClient  *cos.Client
 
+	// The S3 compatible storage, used instead of COS if enabled. Protected by s3Lock, because the
+	// client is updated when configure changed, while used by HTTP handlers and dvr tasks.
+	s3Config S3Config
+	s3Client *S3Client
+	s3Lock   sync.Mutex
+
 	// Got message from SRS, a new TS segment file is generated.
 	msgs chan *SrsOnHlsObject
 	// The streams we're dvring, key is m3u8 URL in string, value is m3u8 object *DvrM3u8Stream.
@@ -51,9 +57,43 @@ func NewDvrWorker() *DvrWorker {
 }
 
 func (v *DvrWorker) ready() bool {
+	_, s3Client := v.s3()
+	return s3Client != nil || v.readyFor(DvrStorageCOS)
+}
+
+// readyFor returns whether the storage of artifact is ready.
+func (v *DvrWorker) readyFor(storage string) bool {
+	if storage == DvrStorageS3 {
+		_, s3Client := v.s3()
+		return s3Client != nil
+	}
 	return v.secretId != "" && v.secretKey != "" && v.bucketName != "" && v.cosClient != nil
 }
 
+// s3 returns the current S3 configure and client, the client is nil if S3 is disabled.
+func (v *DvrWorker) s3() (S3Config, *S3Client) {
+	v.s3Lock.Lock()
+	defer v.s3Lock.Unlock()
+	return v.s3Config, v.s3Client
+}
+
+// s3ClientFor returns the S3 client for the storage target of artifact, which might not be the current
+// one, for example, the bucket is changed after the artifact is created. Returns nil if S3 is disabled.
+func (v *DvrWorker) s3ClientFor(artifact *M3u8VoDArtifact) *S3Client {
+	config, client := v.s3()
+	if client == nil {
+		return nil
+	}
+
+	target := config
+	target.Endpoint, target.Region, target.Bucket = artifact.Endpoint, artifact.Region, artifact.Bucket
+	target.PathStyle, target.Prefix = artifact.PathStyle, artifact.Prefix
+	if target == config {
+		return client
+	}
+	return NewS3Client(&target)
+}
+
 func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/hooks/dvr/query"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -82,12 +122,21 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			secretId, _ := rdb.HGet(ctx, SRS_TENCENT_CAM, "secretId").Result()
 			secretKey, _ := rdb.HGet(ctx, SRS_TENCENT_CAM, "secretKey").Result()
 
+			var s3Config S3Config
+			if err := s3Config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load s3")
+			}
+			// Never response the secret key.
+			s3Config.SecretKey = ""
+
 			ohttp.WriteData(ctx, w, r, &struct {
-				All    bool `json:"all"`
-				Secret bool `json:"secret"`
+				All    bool      `json:"all"`
+				Secret bool      `json:"secret"`
+				S3     *S3Config `json:"s3"`
 			}{
 				All:    all == "true",
 				Secret: appId != "" && secretId != "" && secretKey != "",
+				S3:     &s3Config,
 			})
 
 			logger.Tf(ctx, "dvr query ok, token=%vB", len(token))
@@ -103,11 +152,13 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 		if err := func() error {
 			var token string
 			var all bool
+			var s3Config *S3Config
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
-				All   *bool   `json:"all"`
+				Token *string    `json:"token"`
+				All   *bool      `json:"all"`
+				S3    **S3Config `json:"s3"`
 			}{
-				Token: &token, All: &all,
+				Token: &token, All: &all, S3: &s3Config,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -117,6 +168,25 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			// Only update the S3 configure if specified.
+			if s3Config != nil {
+				// Keep the secret key if not specified, because query never response it.
+				if s3Config.SecretKey == "" {
+					var previous S3Config
+					if err := previous.Load(ctx); err != nil {
+						return errors.Wrapf(err, "load s3")
+					}
+					s3Config.SecretKey = previous.SecretKey
+				}
+
+				if err := s3Config.Validate(); err != nil {
+					return errors.Wrapf(err, "validate s3 %v", s3Config.String())
+				}
+				if err := s3Config.Save(ctx); err != nil {
+					return errors.Wrapf(err, "save s3 %v", s3Config.String())
+				}
+			}
+
 			if all, err := rdb.HSet(ctx, SRS_DVR_PATTERNS, "all", fmt.Sprintf("%v", all)).Result(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hset %v all %v", SRS_DVR_PATTERNS, all)
 			}
@@ -177,8 +247,9 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 					"duration": duration,
 					"size":     size,
 					// For DVR only.
-					"bucket": metadata.Bucket,
-					"region": metadata.Region,
+					"storage": metadata.Storage,
+					"bucket":  metadata.Bucket,
+					"region":  metadata.Region,
 				})
 			}
 
@@ -212,11 +283,26 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "parse %v", m3u8Metadata)
 			}
 
-			contentType, m3u8Body, duration, err := buildVodM3u8(
-				ctx, &metadata, true, "", false, "",
-			)
-			if err != nil {
-				return errors.Wrapf(err, "build vod m3u8 of %v", metadata.String())
+			var contentType, m3u8Body string
+			var duration float64
+			var err error
+			if metadata.Storage == DvrStorageS3 {
+				// Use presigned URLs of ts files, because the bucket is private.
+				s3Client := v.s3ClientFor(&metadata)
+				if s3Client == nil {
+					return errors.Errorf("no s3 for %v", metadata.String())
+				}
+
+				contentType, m3u8Body, duration, err = buildVodM3u8(ctx, &metadata, false, "", true, "")
+				if err != nil {
+					return errors.Wrapf(err, "build vod m3u8 of %v", metadata.String())
+				}
+				m3u8Body = presignVodM3u8(m3u8Body, s3Client, 24*time.Hour)
+			} else {
+				contentType, m3u8Body, duration, err = buildVodM3u8(ctx, &metadata, true, "", false, "")
+				if err != nil {
+					return errors.Wrapf(err, "build vod m3u8 of %v", metadata.String())
+				}
 			}
 
 			w.Header().Set("Content-Type", contentType)
@@ -429,6 +515,26 @@ func (v *DvrWorker) updateCredential(ctx context.Context) error {
 		v.bucketName = bucketName
 	}
 
+	// Load the S3 configure, use S3 if enabled.
+	var s3Config S3Config
+	if err := s3Config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load s3")
+	}
+	func() {
+		v.s3Lock.Lock()
+		defer v.s3Lock.Unlock()
+
+		if !s3Config.Enabled || s3Config.Validate() != nil {
+			if v.s3Client != nil {
+				logger.Tf(ctx, "disable dvr s3 client, %v", s3Config.String())
+			}
+			v.s3Client = nil
+		} else if v.s3Client == nil || v.s3Config != s3Config {
+			v.s3Config, v.s3Client = s3Config, NewS3Client(&s3Config)
+			logger.Tf(ctx, "create dvr s3 client ok, %v", s3Config.String())
+		}
+	}()
+
 	changed := v.bucketName != previous.BucketName || v.secretId != previous.SecretId ||
 		v.secretKey != previous.SecretKey
 	credentialOK := v.secretId != "" && v.secretKey != "" && v.bucketName != ""
@@ -621,9 +727,20 @@ func (v *DvrM3u8Stream) Initialize(ctx context.Context, r *DvrWorker) error {
 			Update:     time.Now().Format(time.RFC3339),
 		}
 
-		// For DVR required parameters.
-		v.artifact.Bucket = r.bucketName
-		v.artifact.Region = conf.Region
+		// For DVR required parameters. Record the storage target, to upload and download the files of
+		// artifact from the same target, even if the configure is changed.
+		if s3Config, s3Client := r.s3(); s3Client != nil {
+			v.artifact.Storage = DvrStorageS3
+			v.artifact.Bucket = s3Config.Bucket
+			v.artifact.Region = s3Config.Region
+			v.artifact.Endpoint = s3Config.Endpoint
+			v.artifact.PathStyle = s3Config.PathStyle
+			v.artifact.Prefix = s3Config.Prefix
+		} else {
+			v.artifact.Storage = DvrStorageCOS
+			v.artifact.Bucket = r.bucketName
+			v.artifact.Region = conf.Region
+		}
 
 		if err := v.saveArtifact(ctx, v.artifact); err != nil {
 			return errors.Wrapf(err, "save artifact %v", v.artifact.String())
@@ -701,7 +818,7 @@ func (v *DvrM3u8Stream) serveMessage(ctx context.Context, msg *SrsOnHlsObject) e
 	defer v.removeMessage(ctx, msg)
 
 	// Ignore file if credential is not ready.
-	if !v.dvrWorker.ready() {
+	if !v.dvrWorker.readyFor(v.artifact.Storage) {
 		return nil
 	}
 
@@ -711,24 +828,38 @@ func (v *DvrM3u8Stream) serveMessage(ctx context.Context, msg *SrsOnHlsObject) e
 	}
 
 	key := fmt.Sprintf("%v/%v.ts", v.UUID, msg.TsFile.TsID)
-	msg.TsFile.Key = key
 
-	f, err := os.Open(msg.TsFile.File)
-	if err != nil {
-		return errors.Wrapf(err, "open file %v", msg.TsFile.File)
-	}
+	if v.artifact.Storage == DvrStorageS3 {
+		// Upload to S3 bucket, the key is with prefix.
+		s3Client := v.dvrWorker.s3ClientFor(v.artifact)
+		if s3Client == nil {
+			return errors.Errorf("no s3 for %v", v.artifact.String())
+		}
 
-	// Upload to COS bucket.
-	// See https://cloud.tencent.com/document/product/436/64980
-	opt := cos.ObjectPutOptions{
-		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
-			ContentType:   "video/MP2T",
-			ContentLength: int64(msg.TsFile.Size),
-		},
-	}
-	if _, err = v.dvrWorker.cosClient.Object.Put(ctx, key, f, &opt); err != nil {
-		return errors.Wrapf(err, "cos put object %v", key)
+		key = s3Client.ObjectKey(key)
+		if err := s3Client.UploadFile(ctx, key, "video/MP2T", msg.TsFile.File); err != nil {
+			return errors.Wrapf(err, "s3 put object %v", key)
+		}
+	} else {
+		f, err := os.Open(msg.TsFile.File)
+		if err != nil {
+			return errors.Wrapf(err, "open file %v", msg.TsFile.File)
+		}
+		defer f.Close()
+
+		// Upload to COS bucket.
+		// See https://cloud.tencent.com/document/product/436/64980
+		opt := cos.ObjectPutOptions{
+			ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+				ContentType:   "video/MP2T",
+				ContentLength: int64(msg.TsFile.Size),
+			},
+		}
+		if _, err = v.dvrWorker.cosClient.Object.Put(ctx, key, f, &opt); err != nil {
+			return errors.Wrapf(err, "cos put object %v", key)
+		}
 	}
+	msg.TsFile.Key = key
 
 	// Update the metadata for m3u8.
 	v.updateArtifact(ctx, v.artifact, msg)
@@ -749,16 +880,36 @@ func (v *DvrM3u8Stream) finishM3u8(ctx context.Context) error {
 	// Upload the ts file to COS.
 	key := fmt.Sprintf("%v/index.m3u8", v.UUID)
 
-	// Upload to COS bucket.
-	// See https://cloud.tencent.com/document/product/436/64980
-	opt := cos.ObjectPutOptions{
-		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
-			ContentType:   contentType,
-			ContentLength: int64(len(m3u8Body)),
-		},
-	}
-	if _, err = v.dvrWorker.cosClient.Object.Put(ctx, key, strings.NewReader(m3u8Body), &opt); err != nil {
-		return errors.Wrapf(err, "cos put object %v", key)
+	if v.artifact.Storage == DvrStorageS3 {
+		s3Config, _ := v.dvrWorker.s3()
+		s3Client := v.dvrWorker.s3ClientFor(v.artifact)
+		if s3Client == nil {
+			return errors.Errorf("no s3 for %v", v.artifact.String())
+		}
+
+		// Upload to S3 bucket, the key is with prefix.
+		key = s3Client.ObjectKey(key)
+		if err := s3Client.PutObject(ctx, key, contentType, []byte(m3u8Body)); err != nil {
+			return errors.Wrapf(err, "s3 put object %v", key)
+		}
+
+		if s3Config.Mp4 {
+			if err := v.remuxS3Mp4(ctx, s3Client); err != nil {
+				return errors.Wrapf(err, "remux mp4")
+			}
+		}
+	} else {
+		// Upload to COS bucket.
+		// See https://cloud.tencent.com/document/product/436/64980
+		opt := cos.ObjectPutOptions{
+			ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+				ContentType:   contentType,
+				ContentLength: int64(len(m3u8Body)),
+			},
+		}
+		if _, err = v.dvrWorker.cosClient.Object.Put(ctx, key, strings.NewReader(m3u8Body), &opt); err != nil {
+			return errors.Wrapf(err, "cos put object %v", key)
+		}
 	}
 	logger.Tf(ctx, "dvr to %v, duration=%v ok", key, duration)
 
@@ -780,3 +931,36 @@ func (v *DvrM3u8Stream) finishM3u8(ctx context.Context) error {
 
 	return nil
 }
+
+// remuxS3Mp4 remux the ts files in S3 to a MP4 file, by presigned URLs, then upload it to S3.
+func (v *DvrM3u8Stream) remuxS3Mp4(ctx context.Context, s3Client *S3Client) error {
+	_, m3u8Body, _, err := buildVodM3u8(ctx, v.artifact, false, "", true, "")
+	if err != nil {
+		return errors.Wrapf(err, "build vod")
+	}
+
+	m3u8File := path.Join("dvr", fmt.Sprintf("%v.m3u8", v.UUID))
+	mp4File := path.Join("dvr", fmt.Sprintf("%v.mp4", v.UUID))
+	defer os.Remove(m3u8File)
+	defer os.Remove(mp4File)
+
+	if err := os.WriteFile(m3u8File, []byte(presignVodM3u8(m3u8Body, s3Client, 6*time.Hour)), 0644); err != nil {
+		return errors.Wrapf(err, "write %v", m3u8File)
+	}
+
+	args := []string{
+		"-protocol_whitelist", "file,http,https,tcp,tls,crypto", "-i", m3u8File,
+		"-c", "copy", "-bsf:a", "aac_adtstoasc", "-movflags", "+faststart", "-y", mp4File,
+	}
+	if err := exec.CommandContext(ctx, "ffmpeg", args...).Run(); err != nil {
+		return errors.Wrapf(err, "ffmpeg %v", strings.Join(args, " "))
+	}
+
+	key := s3Client.ObjectKey(fmt.Sprintf("%v/index.mp4", v.UUID))
+	if err := s3Client.UploadFile(ctx, key, "video/mp4", mp4File); err != nil {
+		return errors.Wrapf(err, "s3 upload %v", key)
+	}
+
+	logger.Tf(ctx, "dvr remux to %v ok", key)
+	return nil
+}
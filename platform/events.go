@@ -0,0 +1,417 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The type of platform events, the category is the prefix before dot, such as stream.
+const (
+	EventStreamPublish     = "stream.publish"
+	EventStreamUnpublish   = "stream.unpublish"
+	EventStreamPlay        = "stream.play"
+	EventRecordBegin       = "record.begin"
+	EventRecordEnd         = "record.end"
+	EventTaskState         = "task.state"
+	EventTranscriptSegment = "transcript.segment"
+	EventOCRResult         = "ocr.result"
+	EventCertRenew         = "cert.renew"
+	// The history is lost, for example, platform restarted or the cursor is too old, so the client should
+	// reload the state, instead of applying the events after cursor.
+	EventReset = "events.reset"
+)
+
+const (
+	// The max number of events to keep, for clients to resume by cursor.
+	platformEventsMaxHistory = 1024
+	// The max number of events pending for a subscriber, the subscriber is dropped if exceed, and the client
+	// should reconnect and resume by cursor.
+	platformEventsMaxPending = 256
+	// The interval to send the keepalive comment to SSE clients.
+	platformEventsKeepalive = 15 * time.Second
+	// The sequence ID is the boot epoch in high bits and the counter in low bits, so the ID keeps increasing
+	// after platform restarts.
+	platformEventsEpochBits = 32
+	// The ticket for EventSource, which does not support header, so the client exchanges the token for a
+	// short-lived ticket in query, instead of leaking the API key to logs and history.
+	platformEventsTicketTimeout = 60 * time.Second
+	// The scope of ticket, which is only allowed by the events API, not any other API.
+	platformEventsTicketScope = "events:ticket"
+)
+
+var platformEvents = NewPlatformEventHub()
+
+// PlatformEvent is an event of platform, such as stream published or OCR result.
+type PlatformEvent struct {
+	// The sequence ID of event, as the cursor to resume.
+	ID uint64 `json:"id"`
+	// The type of event, such as stream.publish.
+	Type string `json:"type"`
+	// The stream URL of event, such as live/livestream, empty if not about a stream.
+	Stream string `json:"stream,omitempty"`
+	// The time of event in RFC3339.
+	Time string `json:"time"`
+	// The data of event, depends on the type.
+	Data interface{} `json:"data,omitempty"`
+}
+
+func (v *PlatformEvent) String() string {
+	return fmt.Sprintf("id=%v, type=%v, stream=%v, time=%v", v.ID, v.Type, v.Stream, v.Time)
+}
+
+// PlatformEventFilter is the filter of events, match all if empty.
+type PlatformEventFilter struct {
+	// The types or categories of event, such as stream.publish or stream.
+	Types []string
+	// The stream URLs, such as live/livestream.
+	Streams []string
+}
+
+func (v *PlatformEventFilter) String() string {
+	return fmt.Sprintf("types=%v, streams=%v", v.Types, v.Streams)
+}
+
+// Match returns whether the event matches the filter.
+func (v *PlatformEventFilter) Match(event *PlatformEvent) bool {
+	if len(v.Types) > 0 {
+		var matched bool
+		for _, typ := range v.Types {
+			if typ == event.Type || strings.HasPrefix(event.Type, typ+".") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	// The event without stream, such as task.state or cert.renew, is not filtered by streams.
+	if len(v.Streams) > 0 && event.Stream != "" {
+		var matched bool
+		for _, stream := range v.Streams {
+			if stream == event.Stream {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// platformEventSubscriber is a subscriber of events, the events channel is closed when dropped.
+type platformEventSubscriber struct {
+	filter *PlatformEventFilter
+	events chan *PlatformEvent
+}
+
+// PlatformEventHub keeps the recent events, and dispatches the events to subscribers.
+type PlatformEventHub struct {
+	// The boot epoch, see platformEventsEpochBits.
+	epoch uint64
+	// The last sequence ID of event.
+	seq uint64
+	// The recent events, for clients to resume.
+	history []*PlatformEvent
+	// The subscribers of events.
+	subscribers map[*platformEventSubscriber]bool
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewPlatformEventHub() *PlatformEventHub {
+	return &PlatformEventHub{
+		subscribers: make(map[*platformEventSubscriber]bool),
+	}
+}
+
+// Start a new epoch by increasing the epoch in redis, so the sequence ID never goes back after restart.
+func (v *PlatformEventHub) Start(ctx context.Context) error {
+	epoch, err := rdb.Incr(ctx, SRS_EVENTS_EPOCH).Result()
+	if err != nil {
+		return errors.Wrapf(err, "incr %v", SRS_EVENTS_EPOCH)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.epoch = uint64(epoch)
+	v.seq = v.epoch << platformEventsEpochBits
+	logger.Tf(ctx, "events: start epoch=%v, seq=%v", v.epoch, v.seq)
+	return nil
+}
+
+// Publish an event of type, about the stream which is optional.
+func (v *PlatformEventHub) Publish(ctx context.Context, typ, stream string, data interface{}) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.seq++
+	event := &PlatformEvent{
+		ID: v.seq, Type: typ, Stream: stream, Time: time.Now().Format(time.RFC3339), Data: data,
+	}
+
+	v.history = append(v.history, event)
+	if len(v.history) > platformEventsMaxHistory {
+		v.history = v.history[len(v.history)-platformEventsMaxHistory:]
+	}
+
+	for subscriber := range v.subscribers {
+		if !subscriber.filter.Match(event) {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		default:
+			logger.Wf(ctx, "events: drop slow subscriber %v, pending=%v", subscriber.filter.String(), len(subscriber.events))
+			delete(v.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// Subscribe the events matched the filter, returns the subscriber and the missed events after the cursor.
+// If some events are lost, because the cursor is of previous epoch or too old, the missed events starts
+// with a reset event, then the available events in history.
+func (v *PlatformEventHub) Subscribe(filter *PlatformEventFilter, cursor uint64) (*platformEventSubscriber, []*PlatformEvent) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var missed []*PlatformEvent
+	if cursor > 0 {
+		oldest := v.seq + 1
+		if len(v.history) > 0 {
+			oldest = v.history[0].ID
+		}
+
+		if cursor>>platformEventsEpochBits != v.epoch || cursor+1 < oldest || cursor > v.seq {
+			missed = append(missed, &PlatformEvent{
+				ID: v.seq, Type: EventReset, Time: time.Now().Format(time.RFC3339),
+			})
+		}
+
+		for _, event := range v.history {
+			if event.ID > cursor && filter.Match(event) {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	subscriber := &platformEventSubscriber{
+		filter: filter, events: make(chan *PlatformEvent, platformEventsMaxPending),
+	}
+	v.subscribers[subscriber] = true
+	return subscriber, missed
+}
+
+// Unsubscribe the subscriber, ignore if already dropped.
+func (v *PlatformEventHub) Unsubscribe(subscriber *platformEventSubscriber) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, ok := v.subscribers[subscriber]; ok {
+		delete(v.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// eventStreamURL returns the stream URL of HLS message, such as live/livestream, empty if no message.
+func eventStreamURL(msg *SrsOnHlsMessage) string {
+	if msg == nil {
+		return ""
+	}
+	return (&SrsStream{Vhost: msg.Vhost, App: msg.App, Stream: msg.Stream}).StreamURL()
+}
+
+// parseEventList parse the list separated by comma, ignore the empty items.
+func parseEventList(s string) []string {
+	var r []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			r = append(r, item)
+		}
+	}
+	return r
+}
+
+// createPlatformEventsTicket create a short-lived ticket for EventSource, signed by the API secret.
+func createPlatformEventsTicket(apiSecret string, now time.Time) (string, error) {
+	claims := apiKeyClaims{
+		Version: "1.0",
+		Scope:   platformEventsTicketScope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(platformEventsTicketTimeout)),
+		},
+	}
+
+	ticket, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(apiSecret))
+	if err != nil {
+		return "", errors.Wrapf(err, "jwt sign")
+	}
+	return ticket, nil
+}
+
+// verifyPlatformEventsTicket verify the ticket is signed by the API secret, not expired and for events.
+func verifyPlatformEventsTicket(apiSecret, ticket string) error {
+	if apiSecret == "" {
+		return errors.New("no api secret")
+	}
+
+	claims, err := parseAPIKeyToken(apiSecret, ticket)
+	if err != nil {
+		return errors.Wrapf(err, "parse ticket")
+	}
+	if claims.Scope != platformEventsTicketScope || claims.ExpiresAt == nil {
+		return errors.Errorf("invalid ticket scope=%v", claims.Scope)
+	}
+	return nil
+}
+
+// writeServerSentEvent write the event in SSE format, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+func writeServerSentEvent(w http.ResponseWriter, event *PlatformEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", event.String())
+	}
+
+	if _, err := fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %v\n\n", event.ID, event.Type, string(b)); err != nil {
+		return errors.Wrapf(err, "write %v", event.String())
+	}
+	return nil
+}
+
+func handlePlatformEvents(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/events/ticket"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeEventsRead); err != nil {
+				return newHTTPStatusError(http.StatusUnauthorized, errors.Wrapf(err, "authenticate"))
+			}
+
+			ticket, err := createPlatformEventsTicket(apiSecret, time.Now())
+			if err != nil {
+				return errors.Wrapf(err, "create ticket")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Ticket string `json:"ticket"`
+				Expire int64  `json:"expire"`
+			}{
+				Ticket: ticket, Expire: int64(platformEventsTicketTimeout / time.Second),
+			})
+			logger.Tf(ctx, "events: create ticket ok, expire=%v", platformEventsTicketTimeout)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/events"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Note that EventSource of browser does not support header, so we use the short-lived ticket in
+			// query, never the token, which might be leaked to logs and history.
+			q := r.URL.Query()
+			apiSecret := envApiSecret()
+			if ticket := q.Get("ticket"); ticket != "" {
+				if err := verifyPlatformEventsTicket(apiSecret, ticket); err != nil {
+					return newHTTPStatusError(http.StatusUnauthorized, errors.Wrapf(err, "verify ticket"))
+				}
+			} else if err := AuthenticateWithScope(ctx, apiSecret, "", r.Header, ScopeEventsRead); err != nil {
+				return newHTTPStatusError(http.StatusUnauthorized, errors.Wrapf(err, "authenticate"))
+			}
+
+			filter := &PlatformEventFilter{
+				Types: parseEventList(q.Get("types")), Streams: parseEventList(q.Get("streams")),
+			}
+
+			// Resume by the Last-Event-ID header of EventSource, or the cursor in query.
+			var cursor uint64
+			if id := ChooseNotEmpty(r.Header.Get("Last-Event-ID"), q.Get("cursor")); id != "" {
+				if v, err := strconv.ParseUint(id, 10, 64); err != nil {
+					return errors.Wrapf(err, "parse cursor %v", id)
+				} else {
+					cursor = v
+				}
+			}
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				return errors.New("streaming not supported")
+			}
+
+			subscriber, missed := platformEvents.Subscribe(filter, cursor)
+			defer platformEvents.Unsubscribe(subscriber)
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+			logger.Tf(ctx, "events: subscribe %v, cursor=%v, missed=%v",
+				filter.String(), cursor, len(missed))
+
+			for _, event := range missed {
+				if err := writeServerSentEvent(w, event); err != nil {
+					return nil
+				}
+			}
+			flusher.Flush()
+
+			for {
+				select {
+				case <-r.Context().Done():
+					return nil
+				case <-time.After(platformEventsKeepalive):
+					if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
+						return nil
+					}
+				case event, ok := <-subscriber.events:
+					if !ok {
+						logger.Wf(ctx, "events: subscriber dropped %v", filter.String())
+						return nil
+					}
+					if err := writeServerSentEvent(w, event); err != nil {
+						return nil
+					}
+				}
+				flusher.Flush()
+			}
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
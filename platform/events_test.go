@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlatformEventFilter_Match(t *testing.T) {
+	event := &PlatformEvent{Type: EventStreamPublish, Stream: "live/livestream"}
+
+	for _, tc := range []struct {
+		filter *PlatformEventFilter
+		want   bool
+	}{
+		{&PlatformEventFilter{}, true},
+		{&PlatformEventFilter{Types: []string{EventStreamPublish}}, true},
+		{&PlatformEventFilter{Types: []string{"stream"}}, true},
+		{&PlatformEventFilter{Types: []string{"stream.pub"}}, false},
+		{&PlatformEventFilter{Types: []string{EventRecordBegin, "stream"}}, true},
+		{&PlatformEventFilter{Types: []string{"record"}}, false},
+		{&PlatformEventFilter{Streams: []string{"live/livestream"}}, true},
+		{&PlatformEventFilter{Streams: []string{"live/other"}}, false},
+		{&PlatformEventFilter{Types: []string{"stream"}, Streams: []string{"live/other"}}, false},
+	} {
+		if got := tc.filter.Match(event); got != tc.want {
+			t.Errorf("filter %v got %v, want %v", tc.filter.String(), got, tc.want)
+		}
+	}
+
+	// The event without stream is not filtered by streams.
+	event = &PlatformEvent{Type: EventCertRenew}
+	if filter := (&PlatformEventFilter{Streams: []string{"live/livestream"}}); !filter.Match(event) {
+		t.Errorf("filter %v should match %v", filter.String(), event.String())
+	}
+	if filter := (&PlatformEventFilter{Types: []string{"stream"}, Streams: []string{"live/livestream"}}); filter.Match(event) {
+		t.Errorf("filter %v should not match %v", filter.String(), event.String())
+	}
+}
+
+func TestPlatformEventsTicket(t *testing.T) {
+	ctx := context.Background()
+	apiSecret := "secret"
+
+	ticket, err := createPlatformEventsTicket(apiSecret, time.Now())
+	if err != nil {
+		t.Fatalf("create ticket err %+v", err)
+	}
+	if err := verifyPlatformEventsTicket(apiSecret, ticket); err != nil {
+		t.Errorf("verify ticket err %+v", err)
+	}
+	if err := verifyPlatformEventsTicket("other", ticket); err == nil {
+		t.Errorf("expect error for other secret")
+	}
+
+	// The ticket is only for events API, not allowed as a token of any scope.
+	if err := verifyAPIKeyToken(ctx, apiSecret, ticket, ScopeEventsRead); err == nil {
+		t.Errorf("expect ticket not allowed as token")
+	}
+
+	// The expired ticket.
+	expired, err := createPlatformEventsTicket(apiSecret, time.Now().Add(-2*platformEventsTicketTimeout))
+	if err != nil {
+		t.Fatalf("create ticket err %+v", err)
+	}
+	if err := verifyPlatformEventsTicket(apiSecret, expired); err == nil {
+		t.Errorf("expect error for expired ticket")
+	}
+
+	// The API key is not a ticket.
+	token, err := createAPIKeyToken(apiSecret, &APIKey{
+		ID: "key", Scopes: []string{ScopeEventsRead}, CreateAt: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("create token err %+v", err)
+	}
+	if err := verifyPlatformEventsTicket(apiSecret, token); err == nil {
+		t.Errorf("expect error for API key")
+	}
+}
+
+func TestPlatformEventHub_Subscribe(t *testing.T) {
+	ctx := context.Background()
+	hub := NewPlatformEventHub()
+
+	hub.Publish(ctx, EventStreamPublish, "live/a", nil)
+	hub.Publish(ctx, EventStreamPublish, "live/b", nil)
+	hub.Publish(ctx, EventRecordBegin, "live/a", nil)
+
+	// Without cursor, only the new events.
+	filter := &PlatformEventFilter{Streams: []string{"live/a"}}
+	subscriber, missed := hub.Subscribe(filter, 0)
+	if len(missed) != 0 {
+		t.Errorf("expect no missed events, got %v", len(missed))
+	}
+
+	hub.Publish(ctx, EventStreamUnpublish, "live/b", nil)
+	hub.Publish(ctx, EventStreamUnpublish, "live/a", nil)
+	if event := <-subscriber.events; event.ID != 5 || event.Type != EventStreamUnpublish {
+		t.Errorf("unexpected event %v", event.String())
+	}
+	hub.Unsubscribe(subscriber)
+	if _, ok := <-subscriber.events; ok {
+		t.Errorf("expect events closed")
+	}
+
+	// Resume by cursor, only the matched events after cursor.
+	subscriber, missed = hub.Subscribe(filter, 1)
+	defer hub.Unsubscribe(subscriber)
+	if len(missed) != 2 || missed[0].ID != 3 || missed[1].ID != 5 {
+		t.Errorf("unexpected missed events %v", missed)
+	}
+}
+
+func TestPlatformEventHub_Limits(t *testing.T) {
+	ctx := context.Background()
+	hub := NewPlatformEventHub()
+
+	subscriber, _ := hub.Subscribe(&PlatformEventFilter{}, 0)
+	for i := 0; i < platformEventsMaxHistory+10; i++ {
+		hub.Publish(ctx, EventTaskState, "", nil)
+	}
+
+	if len(hub.history) != platformEventsMaxHistory || hub.history[0].ID != 11 {
+		t.Errorf("unexpected history len=%v, first=%v", len(hub.history), hub.history[0].ID)
+	}
+
+	// The slow subscriber is dropped, and the events is closed after the pending events.
+	var n int
+	for range subscriber.events {
+		n++
+	}
+	if n != platformEventsMaxPending || len(hub.subscribers) != 0 {
+		t.Errorf("unexpected pending=%v, subscribers=%v", n, len(hub.subscribers))
+	}
+	hub.Unsubscribe(subscriber)
+
+	// Reset if the cursor is too old, some events are lost.
+	subscriber, missed := hub.Subscribe(&PlatformEventFilter{}, 1)
+	defer hub.Unsubscribe(subscriber)
+	if len(missed) != platformEventsMaxHistory+1 || missed[0].Type != EventReset || missed[1].ID != 11 {
+		t.Errorf("unexpected missed=%v, first=%v", len(missed), missed[0].String())
+	}
+}
+
+func TestPlatformEventHub_Epoch(t *testing.T) {
+	ctx := context.Background()
+	hub := NewPlatformEventHub()
+	hub.epoch, hub.seq = 2, 2<<platformEventsEpochBits
+
+	hub.Publish(ctx, EventStreamPublish, "live/a", nil)
+	hub.Publish(ctx, EventStreamUnpublish, "live/a", nil)
+
+	// Resume in the same epoch.
+	subscriber, missed := hub.Subscribe(&PlatformEventFilter{}, hub.seq-1)
+	hub.Unsubscribe(subscriber)
+	if len(missed) != 1 || missed[0].ID != 2<<platformEventsEpochBits+2 {
+		t.Errorf("unexpected missed %v", missed)
+	}
+
+	// Reset if the cursor is of previous epoch, even the counter is smaller.
+	for _, cursor := range []uint64{1<<platformEventsEpochBits + 1, 3 << platformEventsEpochBits} {
+		subscriber, missed := hub.Subscribe(&PlatformEventFilter{}, cursor)
+		hub.Unsubscribe(subscriber)
+		if len(missed) == 0 || missed[0].Type != EventReset || missed[0].ID != hub.seq {
+			t.Errorf("cursor=%v, expect reset, got %v", cursor, missed)
+		}
+	}
+}
+
+func TestWriteServerSentEvent(t *testing.T) {
+	w := httptest.NewRecorder()
+	event := &PlatformEvent{
+		ID: 7, Type: EventOCRResult, Stream: "live/livestream", Time: "2024-01-01T00:00:00Z",
+		Data: map[string]string{"text": "hello"},
+	}
+	if err := writeServerSentEvent(w, event); err != nil {
+		t.Fatalf("write err %+v", err)
+	}
+
+	want := fmt.Sprintf("id: 7\nevent: %v\ndata: %v\n\n", EventOCRResult,
+		`{"id":7,"type":"ocr.result","stream":"live/livestream","time":"2024-01-01T00:00:00Z","data":{"text":"hello"}}`)
+	if got := w.Body.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := parseEventList(" stream, ,record.begin,"); strings.Join(got, "|") != "stream|record.begin" {
+		t.Errorf("unexpected list %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The interval to probe whether the primary input is recovered.
+const failoverProbeInterval = 10 * time.Second
+
+// The min duration to stay on the fallback, to avoid switching back and forth.
+const failoverHoldDuration = 30 * time.Second
+
+// The timeout to probe the input.
+const failoverProbeTimeout = 8 * time.Second
+
+// The max number of fallback sources for a task.
+const failoverMaxSources = 8
+
+// ValidateFallbacks validate the fallback sources, which should be a stream URL, or a file of vLive uploads.
+func ValidateFallbacks(fallbacks []*FFprobeSource) error {
+	if len(fallbacks) > failoverMaxSources {
+		return errors.Errorf("too many fallbacks %v, max %v", len(fallbacks), failoverMaxSources)
+	}
+
+	for _, f := range fallbacks {
+		if f == nil || f.Target == "" {
+			return errors.New("no target")
+		}
+
+		if f.Type == FFprobeSourceTypeStream || strings.Contains(f.Target, "://") {
+			if err := ValidateServerURL(f.Target); err != nil {
+				return errors.Wrapf(err, "validate %v", f.Target)
+			}
+			continue
+		}
+
+		// Validate the path to prevent directory traversal.
+		cleaned := filepath.Clean(f.Target)
+		if !strings.HasPrefix(cleaned, dirVLivePath+string(filepath.Separator)) {
+			return errors.Errorf("invalid target %v", f.Target)
+		}
+		if _, err := os.Stat(cleaned); err != nil {
+			return errors.Wrapf(err, "no file %v", f.Target)
+		}
+	}
+
+	return nil
+}
+
+// probeFailoverSource check whether the source is available, by stat the file, or ffprobe the stream.
+func probeFailoverSource(ctx context.Context, source *FFprobeSource) bool {
+	if !strings.Contains(source.Target, "://") {
+		_, err := os.Stat(source.Target)
+		return err == nil
+	}
+
+	if err := ValidateServerURL(source.Target); err != nil {
+		return false
+	}
+
+	u, err := RebuildStreamURL(source.Target)
+	if err != nil {
+		return false
+	}
+
+	toCtx, toCancelFunc := context.WithTimeout(ctx, failoverProbeTimeout)
+	defer toCancelFunc()
+
+	args := []string{"-v", "quiet", "-show_format"}
+	// For RTSP stream source, always use TCP transport.
+	if strings.HasPrefix(source.Target, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, u.String())
+
+	return exec.CommandContext(toCtx, "ffprobe", args...).Run() == nil
+}
+
+// FailoverInputs select the input for a task, from the primary and the fallback sources. The index 0 is the
+// primary input, and others are the fallbacks. When the active input fails, for example, the heartbeat of
+// FFmpeg detects stall or abnormal speed, switch to the next input; when the primary is recovered, switch
+// back to the primary.
+type FailoverInputs struct {
+	// The index of active input, 0 is the primary.
+	active int
+	// The generation, changed when reset or recovered, to ignore the failure of canceled FFmpeg.
+	generation uint64
+	// The time when switched to the active input.
+	switchedAt time.Time
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func (v *FailoverInputs) String() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return fmt.Sprintf("active=%v, generation=%v, switched=%v", v.active, v.generation, v.switchedAt)
+}
+
+// Active returns the index of active input, and the generation.
+func (v *FailoverInputs) Active() (int, uint64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.active, v.generation
+}
+
+// Switch to the input, for example, the primary is not available.
+func (v *FailoverInputs) Switch(index int) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.active != index {
+		v.active, v.generation, v.switchedAt = index, v.generation+1, time.Now()
+	}
+}
+
+// OnFailed is called when the input of generation fails, with the number of inputs, including the primary.
+// Returns true if switched to the next input.
+func (v *FailoverInputs) OnFailed(generation uint64, inputs int) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Ignore if no fallbacks, or the input is already changed.
+	if inputs <= 1 || generation != v.generation {
+		return false
+	}
+
+	v.active, v.generation, v.switchedAt = (v.active+1)%inputs, v.generation+1, time.Now()
+	return true
+}
+
+// Reset to use the primary input, for example, the configure is changed. Note that the generation is always
+// changed, so the FFmpeg canceled by reset is not considered as failure.
+func (v *FailoverInputs) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.active, v.generation, v.switchedAt = 0, v.generation+1, time.Now()
+}
+
+// Recover switch back to primary, if the fallback is active. Returns true if switched.
+func (v *FailoverInputs) Recover() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.active == 0 || time.Since(v.switchedAt) < failoverHoldDuration {
+		return false
+	}
+
+	v.active, v.generation, v.switchedAt = 0, v.generation+1, time.Now()
+	return true
+}
+
+// Probe the primary input when fallback is active, by the probe function, and call the cancel function to
+// restart the FFmpeg with the primary input when recovered.
+func (v *FailoverInputs) Probe(ctx context.Context, probe func(ctx context.Context) bool, cancel func()) {
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(failoverProbeInterval):
+		}
+
+		if active, _ := v.Active(); active == 0 {
+			continue
+		}
+
+		if !probe(ctx) {
+			continue
+		}
+
+		if v.Recover() {
+			logger.Tf(ctx, "Failover: Primary recovered, switch back, %v", v.String())
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailoverInputs_Switch(t *testing.T) {
+	var v FailoverInputs
+
+	// No fallbacks, never switch.
+	_, generation := v.Active()
+	if v.OnFailed(generation, 1) {
+		t.Errorf("Expected no switch without fallbacks")
+	}
+
+	// Switch to the next input when failed, then wrap to primary.
+	for _, expect := range []int{1, 2, 0, 1} {
+		_, generation := v.Active()
+		if !v.OnFailed(generation, 3) {
+			t.Fatalf("Expected switch to %v", expect)
+		}
+		if active, _ := v.Active(); active != expect {
+			t.Errorf("Expected active %v, got %v", expect, active)
+		}
+	}
+
+	// Ignore the failure of a stale generation, for example, canceled by reset.
+	_, generation = v.Active()
+	v.Reset()
+	if v.OnFailed(generation, 3) {
+		t.Errorf("Expected no switch for stale generation")
+	}
+	if active, _ := v.Active(); active != 0 {
+		t.Errorf("Expected primary after reset, got %v", active)
+	}
+}
+
+func TestFailoverInputs_Recover(t *testing.T) {
+	var v FailoverInputs
+	if v.Recover() {
+		t.Errorf("Expected no recover for primary")
+	}
+
+	// Should hold the fallback for a while.
+	v.Switch(1)
+	if v.Recover() {
+		t.Errorf("Expected no recover during hold")
+	}
+
+	v.switchedAt = time.Now().Add(-failoverHoldDuration)
+	_, generation := v.Active()
+	if !v.Recover() {
+		t.Errorf("Expected recover after hold")
+	}
+	if active, _ := v.Active(); active != 0 {
+		t.Errorf("Expected primary, got %v", active)
+	}
+	if v.OnFailed(generation, 2) {
+		t.Errorf("Expected no switch for the FFmpeg canceled by recover")
+	}
+}
+
+func TestValidateFallbacks(t *testing.T) {
+	for _, c := range []struct {
+		source *FFprobeSource
+		valid  bool
+	}{
+		{&FFprobeSource{Type: FFprobeSourceTypeStream, Target: "rtmp://localhost/live/backup"}, true},
+		{&FFprobeSource{Type: FFprobeSourceTypeStream, Target: "rtsp://192.168.1.100/stream"}, true},
+		{&FFprobeSource{Type: FFprobeSourceTypeStream, Target: "http://localhost/live/backup.flv"}, false},
+		{&FFprobeSource{Type: FFprobeSourceTypeFile, Target: "/etc/passwd"}, false},
+		{&FFprobeSource{Type: FFprobeSourceTypeFile, Target: "vlive/../../etc/passwd"}, false},
+		{&FFprobeSource{Type: FFprobeSourceTypeFile, Target: "vlive/not-exists.mp4"}, false},
+		{&FFprobeSource{}, false},
+	} {
+		if err := ValidateFallbacks([]*FFprobeSource{c.source}); (err == nil) != c.valid {
+			t.Errorf("Expected valid=%v for %v, got %v", c.valid, c.source.Target, err)
+		}
+	}
+}
@@ -93,6 +93,9 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 				if userConf.Server == "" && userConf.Secret == "" {
 					return errors.New("no secret")
 				}
+				if err := ValidateFallbacks(userConf.Fallbacks); err != nil {
+					return errors.Wrapf(err, "validate fallbacks")
+				}
 			}
 
 			if action == "update" {
@@ -350,11 +353,13 @@ type ForwardConfigure struct {
 	Customed bool `json:"custom"`
 	// The label for this configure.
 	Label string `json:"label"`
+	// The fallback sources in order, used when the source stream is not available.
+	Fallbacks []*FFprobeSource `json:"fallbacks,omitempty"`
 }
 
 func (v *ForwardConfigure) String() string {
-	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v",
-		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label,
+	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, fallbacks=%v",
+		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, len(v.Fallbacks),
 	)
 }
 
@@ -366,6 +371,10 @@ func (v *ForwardConfigure) Update(u *ForwardConfigure) error {
 	v.Label = u.Label
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
+	// Keep the fallbacks if not specified, for the legacy clients.
+	if u.Fallbacks != nil {
+		v.Fallbacks = append([]*FFprobeSource{}, u.Fallbacks...)
+	}
 	return nil
 }
 
@@ -396,6 +405,8 @@ type ForwardTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// The failover of input.
+	failover FailoverInputs
 
 	// The configure for forwarding task.
 	config *ForwardConfigure
@@ -446,6 +457,9 @@ func (v *ForwardTask) Restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	// Use the primary input for new configure, before cancel the FFmpeg.
+	v.failover.Reset()
+
 	if v.cancel != nil {
 		v.cancel()
 	}
@@ -571,18 +585,60 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 			return errors.Wrapf(err, "select input")
 		}
 
-		if input == nil {
+		// Use the fallback if no active stream.
+		fallbacks := v.config.Fallbacks
+		if active, _ := v.failover.Active(); input == nil && active == 0 && len(fallbacks) > 0 {
+			v.failover.Switch(1)
+		}
+
+		var inputURL, inputStreamURL string
+		active, generation := v.failover.Active()
+		if active > 0 && active <= len(fallbacks) {
+			inputURL, inputStreamURL = fallbacks[active-1].Target, fallbacks[active-1].Target
+			logger.Tf(ctx, "forward use fallback=%v as input for platform=%v", inputURL, v.Platform)
+		} else if input != nil {
+			inputURL = fmt.Sprintf("rtmp://localhost/%v/%v", input.App, input.Stream)
+			inputStreamURL = input.StreamURL()
+		} else {
 			return nil
 		}
 
 		// Start forward task.
-		if err := v.doForward(ctx, input); err != nil {
+		err = v.doForward(ctx, inputURL, inputStreamURL)
+
+		// Switch to next input if failed, not caused by user or recovered. Note that the clean exit of FFmpeg,
+		// for example, the end of stream, is not a failure of input.
+		if err != nil && ctx.Err() == nil && v.failover.OnFailed(generation, len(fallbacks)+1) {
+			logger.Wf(ctx, "forward switch input for platform=%v, failover %v, err %v", v.Platform, v.failover.String(), err)
+			return nil
+		}
+
+		if err != nil {
 			return errors.Wrapf(err, "do forward")
 		}
 
 		return nil
 	}
 
+	// Probe the primary stream, switch back when it's recovered. Wait for the probe when the task quit.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v.failover.Probe(ctx, func(ctx context.Context) bool {
+			input, err := selectActiveStream()
+			return err == nil && input != nil
+		}, func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			if v.cancel != nil {
+				v.cancel()
+			}
+		})
+	}()
+
 	for ctx.Err() == nil {
 		if err := pfn(ctx); err != nil {
 			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
@@ -603,15 +659,17 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 	return nil
 }
 
-func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
+func (v *ForwardTask) doForward(ctx context.Context, inputURL, inputStreamURL string) error {
 	// Create context for current task.
 	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
+
+	v.lock.Lock()
 	v.cancel = cancel
+	v.lock.Unlock()
 
 	// Build input URL.
 	host := "localhost"
-	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
 
 	// Build output URL.
 	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
@@ -622,6 +680,7 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
+	heartbeat.MetricsTask, heartbeat.MetricsID = FFmpegTaskForward, v.Platform
 	v.starttime, v.firstReadyTime = &heartbeat.starttime, nil
 	defer func() {
 		v.starttime = nil
@@ -629,6 +688,10 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 
 	// Start FFmpeg process.
 	args := []string{}
+	// Loop the fallback file, for example, the slate file.
+	if !strings.Contains(inputURL, "://") {
+		args = append(args, "-stream_loop", "-1")
+	}
 	args = append(args, "-re")
 	// For RTSP stream source, always use TCP transport.
 	if strings.HasPrefix(inputURL, "rtsp://") {
@@ -666,7 +729,7 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 	}
 
 	v.PID = int32(cmd.Process.Pid)
-	v.Input, v.inputStreamURL, v.Output = inputURL, input.StreamURL(), outputURL
+	v.Input, v.inputStreamURL, v.Output = inputURL, inputStreamURL, outputURL
 	defer func() {
 		// If we got a PID, sleep for a while, to avoid too fast restart.
 		if v.PID > 0 {
@@ -680,7 +743,7 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 		v.cleanup(parentCtx)
 		v.saveTask(parentCtx)
 	}()
-	logger.Tf(ctx, "forward start, platform=%v, stream=%v, pid=%v", v.Platform, input.StreamURL(), v.PID)
+	logger.Tf(ctx, "forward start, platform=%v, stream=%v, pid=%v", v.Platform, inputStreamURL, v.PID)
 
 	if err := v.saveTask(ctx); err != nil {
 		return errors.Wrapf(err, "save task %v", v.String())
@@ -713,11 +776,11 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 	case <-heartbeat.PollingCtx.Done():
 	}
 	logger.Tf(ctx, "Forward: Cycle stopping, platform=%v, stream=%v, pid=%v",
-		v.Platform, input.StreamURL(), v.PID)
+		v.Platform, inputStreamURL, v.PID)
 
 	err = cmd.Wait()
 	logger.Tf(ctx, "forward done, platform=%v, stream=%v, pid=%v, err=%v",
-		v.Platform, input.StreamURL(), v.PID, err,
+		v.Platform, inputStreamURL, v.PID, err,
 	)
 
 	return err
@@ -15,6 +15,7 @@ require (
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.3.30
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vod v1.3.30
 	github.com/tencentyun/cos-go-sdk-v5 v0.7.72
+	golang.org/x/crypto v0.17.0
 )
 
 require (
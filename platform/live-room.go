@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -32,7 +33,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRoomsManage); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -76,7 +77,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRoomsManage); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -113,10 +114,14 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRoomsManage); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			if err := validateASRProvider(room.AIASRProvider); err != nil {
+				return errors.Wrapf(err, "validate asr")
+			}
+
 			// As room is a template config, to create active stage. So if we update the template, we
 			// need to update the active stage object.
 			if err := room.UpdateStage(ctx); err != nil {
@@ -164,7 +169,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRoomsManage); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -193,6 +198,112 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	ep = "/terraform/v1/live/room/play"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, roomUUID, app, clientIP string
+			var expire int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				RoomUUID *string `json:"uuid"`
+				App      *string `json:"app"`
+				Expire   *int    `json:"expire"`
+				ClientIP *string `json:"ip"`
+			}{
+				Token: &token, RoomUUID: &roomUUID, App: &app, Expire: &expire, ClientIP: &clientIP,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRoomsManage); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			var room SrsLiveRoom
+			if r0, err := rdb.HGet(ctx, SRS_LIVE_ROOM, roomUUID).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_LIVE_ROOM, roomUUID)
+			} else if r0 == "" {
+				return errors.Errorf("live room %v not exists", roomUUID)
+			} else if err = json.Unmarshal([]byte(r0), &room); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", roomUUID, r0)
+			}
+
+			// Default to live app, and expire in 1 hour.
+			if app == "" {
+				app = "live"
+			}
+			if expire <= 0 {
+				expire = 3600
+			}
+			if clientIP != "" && net.ParseIP(clientIP) == nil {
+				return errors.Errorf("invalid ip %v", clientIP)
+			}
+
+			key, err := loadPlayAuthKey(ctx, room.StreamName)
+			if err != nil {
+				return errors.Wrapf(err, "load key")
+			}
+
+			expireAt := time.Now().Add(time.Duration(expire) * time.Second)
+			streamURL := fmt.Sprintf("%v/%v", app, room.StreamName)
+			playToken := buildPlayToken(key, streamURL, expireAt, clientIP)
+
+			// Build the playback URLs, use the host of request.
+			hostname, httpHost := r.Host, r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				hostname = h
+			}
+			schema := "http"
+			if r.TLS != nil {
+				schema = "https"
+			}
+			rtmpHost := hostname
+			if port := envRtmpPort(); port != "" && port != "1935" {
+				rtmpHost = fmt.Sprintf("%v:%v", hostname, port)
+			}
+			srtPort := envSrtListen()
+			if srtPort == "" {
+				srtPort = "10080"
+			}
+			query := fmt.Sprintf("%v=%v", playAuthTokenParam, playToken)
+
+			type PlaybackURLs struct {
+				RTMP string `json:"rtmp"`
+				FLV  string `json:"flv"`
+				HLS  string `json:"hls"`
+				WHEP string `json:"whep"`
+				SRT  string `json:"srt"`
+			}
+			ohttp.WriteData(ctx, w, r, &struct {
+				// The stream URL, for example, live/livestream
+				Stream string `json:"stream"`
+				// The play token, to append to the playback URLs.
+				PlayToken string `json:"playToken"`
+				// The expire time of token.
+				Expire string `json:"expire"`
+				// The playback URLs with token.
+				URLs PlaybackURLs `json:"urls"`
+			}{
+				Stream: streamURL, PlayToken: playToken, Expire: expireAt.Format(time.RFC3339),
+				URLs: PlaybackURLs{
+					RTMP: fmt.Sprintf("rtmp://%v/%v?%v", rtmpHost, streamURL, query),
+					FLV:  fmt.Sprintf("%v://%v/%v.flv?%v", schema, httpHost, streamURL, query),
+					HLS:  fmt.Sprintf("%v://%v/%v.m3u8?%v", schema, httpHost, streamURL, query),
+					WHEP: fmt.Sprintf("%v://%v/rtc/v1/whep/?app=%v&stream=%v&%v", schema, httpHost, app, room.StreamName, query),
+					SRT: fmt.Sprintf("srt://%v:%v?streamid=#!::r=%v?%v,m=request",
+						hostname, srtPort, streamURL, query),
+				},
+			})
+			logger.Tf(ctx, "srs live room play ok, room=%v, stream=%v, expire=%v, ip=%v, token=%vB",
+				room.String(), streamURL, expireAt.Format(time.RFC3339), clientIP, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	ep = "/terraform/v1/live/room/remove"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
@@ -208,7 +319,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRoomsManage); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -319,11 +430,19 @@ type SrsAssistantASR struct {
 	AIASRLanguage string `json:"aiAsrLanguage"`
 	// The AI asr prompt type. user or user-ai.
 	AIASRPrompt string `json:"aiAsrPrompt"`
+	// The ASR provider, openai or whisper, which is independent of the AI provider for chat. Use
+	// openai if empty.
+	AIASRProvider string `json:"aiAsrProvider"`
+	// The ASR secret key, optional for whisper.
+	AIASRSecretKey string `json:"aiAsrSecretKey"`
+	// The ASR base URL, required for whisper.
+	AIASRBaseURL string `json:"aiAsrBaseURL"`
 }
 
 func (v *SrsAssistantASR) String() string {
-	return fmt.Sprintf("enabled=%v,language=%v,prompt=%v",
-		v.AIASREnabled, v.AIASRLanguage, v.AIASRPrompt)
+	return fmt.Sprintf("enabled=%v,language=%v,prompt=%v,provider=%v,secretKey=%vB,baseURL=%v",
+		v.AIASREnabled, v.AIASRLanguage, v.AIASRPrompt, v.AIASRProvider, len(v.AIASRSecretKey),
+		v.AIASRBaseURL)
 }
 
 type SrsAssistantChat struct {
@@ -388,6 +507,28 @@ type SrsAssistant struct {
 	SrsAssistantTTS
 }
 
+// ASRProvider returns the provider for ASR. For openai, the empty secret key and base URL fallback to
+// the AI provider, while whisper never uses them, to avoid leaking the OpenAI key to other server.
+func (v *SrsAssistant) ASRProvider() *SrsAssistantProvider {
+	if v.AIASRProvider == ASRProviderWhisper {
+		return &SrsAssistantProvider{
+			AIProvider: v.AIASRProvider, AISecretKey: v.AIASRSecretKey, AIBaseURL: v.AIASRBaseURL,
+		}
+	}
+
+	provider := &SrsAssistantProvider{
+		AIProvider: ASRProviderOpenAI, AISecretKey: v.AIASRSecretKey,
+		AIOrganization: v.AIOrganization, AIBaseURL: v.AIASRBaseURL,
+	}
+	if provider.AISecretKey == "" {
+		provider.AISecretKey = v.AISecretKey
+	}
+	if provider.AIBaseURL == "" {
+		provider.AIBaseURL = v.AIBaseURL
+	}
+	return provider
+}
+
 func NewAssistant(opts ...func(*SrsAssistant)) *SrsAssistant {
 	v := &SrsAssistant{}
 
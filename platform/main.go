@@ -187,6 +187,11 @@ func doMain(ctx context.Context) error {
 	}
 	logger.Tf(ctx, "initialize platform region=%v, registry=%v, version=%v", conf.Region, conf.Registry, version)
 
+	// Start a new epoch of platform events, before any worker publishes events.
+	if err := platformEvents.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start platform events")
+	}
+
 	// Create candidate worker for resolving domain to ip.
 	candidateWorker = NewCandidateWorker()
 	defer candidateWorker.Close()
@@ -194,6 +199,13 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start candidate worker")
 	}
 
+	// Create stream status worker to sample the status of streams.
+	streamStatusWorker = NewStreamStatusWorker()
+	defer streamStatusWorker.Close()
+	if err := streamStatusWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start stream status worker")
+	}
+
 	// Create callback worker.
 	callbackWorker = NewCallbackWorker()
 	defer callbackWorker.Close()
@@ -0,0 +1,426 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The FFmpeg task types for metrics.
+const (
+	FFmpegTaskForward   = "forward"
+	FFmpegTaskVLive     = "vlive"
+	FFmpegTaskCamera    = "camera"
+	FFmpegTaskTranscode = "transcode"
+)
+
+var platformMetrics = NewPlatformMetrics()
+
+// The FFmpeg process is restarted, if started again in this duration after stopped.
+const ffmpegRestartWindow = 5 * time.Minute
+
+// PlatformMetrics is the counters updated by the workers, and exported in Prometheus format. Other metrics,
+// such as the active streams and queues, are collected when scraping.
+type PlatformMetrics struct {
+	// The number of FFmpeg processes started and restarted, key is task type.
+	ffmpegStarts   map[string]uint64
+	ffmpegRestarts map[string]uint64
+	// The running FFmpeg processes, key is task type and ID, value is the speed.
+	ffmpegRunning map[ffmpegMetricKey]float64
+	// The FFmpeg processes recently stopped, value is the stop time, to detect the restart.
+	ffmpegStopped map[ffmpegMetricKey]time.Time
+	// The number of callback deliveries, key is status.
+	callbacks map[string]uint64
+	// The total cost and count of AI services, key is service such as asr or ocr.
+	costs map[string]*aiCostMetric
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+type ffmpegMetricKey struct {
+	task string
+	id   string
+}
+
+type aiCostMetric struct {
+	sum   time.Duration
+	count uint64
+}
+
+func NewPlatformMetrics() *PlatformMetrics {
+	return &PlatformMetrics{
+		ffmpegStarts:   make(map[string]uint64),
+		ffmpegRestarts: make(map[string]uint64),
+		ffmpegRunning:  make(map[ffmpegMetricKey]float64),
+		ffmpegStopped:  make(map[ffmpegMetricKey]time.Time),
+		callbacks:      make(map[string]uint64),
+		costs:          make(map[string]*aiCostMetric),
+	}
+}
+
+// OnFFmpegStart is called when FFmpeg process of task started, it's a restart if the task stopped recently.
+func (v *PlatformMetrics) OnFFmpegStart(task, id string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := ffmpegMetricKey{task: task, id: id}
+	v.ffmpegStarts[task]++
+	if stopped, ok := v.ffmpegStopped[key]; ok && time.Since(stopped) < ffmpegRestartWindow {
+		v.ffmpegRestarts[task]++
+	}
+	delete(v.ffmpegStopped, key)
+	v.ffmpegRunning[key] = 0
+}
+
+// OnFFmpegSpeed is called when FFmpeg reports the speed, such as 1.01x.
+func (v *PlatformMetrics) OnFFmpegSpeed(task, id, speed string) {
+	speedv, err := strconv.ParseFloat(strings.Trim(speed, "x"), 64)
+	if err != nil {
+		return
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := ffmpegMetricKey{task: task, id: id}
+	if _, ok := v.ffmpegRunning[key]; ok {
+		v.ffmpegRunning[key] = speedv
+	}
+}
+
+// OnFFmpegStop is called when FFmpeg process of task quit.
+func (v *PlatformMetrics) OnFFmpegStop(task, id string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := ffmpegMetricKey{task: task, id: id}
+	delete(v.ffmpegRunning, key)
+
+	// Remove the tasks stopped for a while, which are removed or never restarted.
+	now := time.Now()
+	for k, stopped := range v.ffmpegStopped {
+		if now.Sub(stopped) >= ffmpegRestartWindow {
+			delete(v.ffmpegStopped, k)
+		}
+	}
+	v.ffmpegStopped[key] = now
+}
+
+// OnCallback is called when callback is delivered or failed, the status is delivered, rejected, dead or retry.
+func (v *PlatformMetrics) OnCallback(status string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.callbacks[status]++
+}
+
+// OnAICost is called when AI service is done, the service is asr or ocr.
+func (v *PlatformMetrics) OnAICost(service string, cost time.Duration) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	metric, ok := v.costs[service]
+	if !ok {
+		metric = &aiCostMetric{}
+		v.costs[service] = metric
+	}
+	metric.sum += cost
+	metric.count++
+}
+
+// runningFFmpeg returns the number of running FFmpeg processes, key is task type.
+func (v *PlatformMetrics) runningFFmpeg() map[string]uint64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	running := make(map[string]uint64)
+	for key := range v.ffmpegRunning {
+		running[key.task]++
+	}
+	return running
+}
+
+// write the counters in Prometheus format.
+func (v *PlatformMetrics) write(w *metricsWriter) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	w.header("oryx_ffmpeg_starts_total", "counter", "The number of FFmpeg processes started.")
+	for _, task := range sortedKeys(v.ffmpegStarts) {
+		w.sample("oryx_ffmpeg_starts_total", []string{"task", task}, float64(v.ffmpegStarts[task]))
+	}
+
+	w.header("oryx_ffmpeg_restarts_total", "counter", "The number of FFmpeg processes restarted.")
+	for _, task := range sortedKeys(v.ffmpegRestarts) {
+		w.sample("oryx_ffmpeg_restarts_total", []string{"task", task}, float64(v.ffmpegRestarts[task]))
+	}
+
+	var keys []ffmpegMetricKey
+	running := make(map[string]uint64)
+	for key := range v.ffmpegRunning {
+		keys = append(keys, key)
+		running[key.task]++
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].task < keys[j].task || (keys[i].task == keys[j].task && keys[i].id < keys[j].id)
+	})
+
+	w.header("oryx_ffmpeg_running", "gauge", "The number of running FFmpeg processes.")
+	for _, task := range sortedKeys(running) {
+		w.sample("oryx_ffmpeg_running", []string{"task", task}, float64(running[task]))
+	}
+
+	w.header("oryx_ffmpeg_speed", "gauge", "The speed of running FFmpeg process, which should be about 1.")
+	for _, key := range keys {
+		w.sample("oryx_ffmpeg_speed", []string{"task", key.task, "id", key.id}, v.ffmpegRunning[key])
+	}
+
+	w.header("oryx_callback_deliveries_total", "counter", "The number of callback deliveries by status.")
+	for _, status := range sortedKeys(v.callbacks) {
+		w.sample("oryx_callback_deliveries_total", []string{"status", status}, float64(v.callbacks[status]))
+	}
+
+	var services []string
+	for service := range v.costs {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	w.header("oryx_ai_cost_seconds", "summary", "The cost of AI services, such as asr and ocr.")
+	for _, service := range services {
+		metric := v.costs[service]
+		w.sample("oryx_ai_cost_seconds_sum", []string{"service", service}, metric.sum.Seconds())
+		w.sample("oryx_ai_cost_seconds_count", []string{"service", service}, float64(metric.count))
+	}
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsWriter write the metrics in Prometheus text exposition format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (v *metricsWriter) header(name, typ, help string) {
+	v.buf.WriteString(fmt.Sprintf("# HELP %v %v\n", name, help))
+	v.buf.WriteString(fmt.Sprintf("# TYPE %v %v\n", name, typ))
+}
+
+// sample write a sample, the labels are pairs of name and value.
+func (v *metricsWriter) sample(name string, labels []string, value float64) {
+	v.buf.WriteString(name)
+	if len(labels) > 0 {
+		v.buf.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				v.buf.WriteString(",")
+			}
+			v.buf.WriteString(fmt.Sprintf("%v=\"%v\"", labels[i], escapeMetricsLabel(labels[i+1])))
+		}
+		v.buf.WriteString("}")
+	}
+	v.buf.WriteString(" ")
+	v.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	v.buf.WriteString("\n")
+}
+
+func (v *metricsWriter) String() string {
+	return v.buf.String()
+}
+
+// escapeMetricsLabel escape the backslash, double-quote and line feed of label value.
+func escapeMetricsLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writeStreamMetrics write the active streams by protocol, and the publish and play counters.
+func writeStreamMetrics(ctx context.Context, w *metricsWriter) error {
+	var counts []int64
+	for _, key := range []string{SRS_STREAM_ACTIVE, SRS_STREAM_SRT_ACTIVE, SRS_STREAM_RTC_ACTIVE} {
+		if n, err := rdb.HLen(ctx, key).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hlen %v", key)
+		} else {
+			counts = append(counts, n)
+		}
+	}
+
+	// The active streams include the SRT and WebRTC streams, which are converted to RTMP.
+	rtmp := counts[0] - counts[1] - counts[2]
+	if rtmp < 0 {
+		rtmp = 0
+	}
+
+	w.header("oryx_streams_active", "gauge", "The number of active streams by protocol.")
+	w.sample("oryx_streams_active", []string{"protocol", "rtmp"}, float64(rtmp))
+	w.sample("oryx_streams_active", []string{"protocol", "srt"}, float64(counts[1]))
+	w.sample("oryx_streams_active", []string{"protocol", "rtc"}, float64(counts[2]))
+
+	stats, err := rdb.HGetAll(ctx, SRS_STAT_COUNTER).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_STAT_COUNTER)
+	}
+
+	var events []string
+	for event := range stats {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	w.header("oryx_stream_events_total", "counter", "The number of stream events, such as publish and play.")
+	for _, event := range events {
+		if value, err := strconv.ParseFloat(stats[event], 64); err == nil {
+			w.sample("oryx_stream_events_total", []string{"event", event}, value)
+		}
+	}
+	return nil
+}
+
+// writeTaskMetrics write the number of FFmpeg tasks, and the queues of transcript and OCR tasks.
+func writeTaskMetrics(w *metricsWriter) {
+	countTasks := func(tasks *sync.Map) (n int) {
+		tasks.Range(func(key, value interface{}) bool {
+			n++
+			return true
+		})
+		return
+	}
+
+	tasks := map[string]uint64{
+		FFmpegTaskCamera:    uint64(countTasks(&cameraWorker.tasks)),
+		FFmpegTaskForward:   uint64(countTasks(&forwardWorker.tasks)),
+		FFmpegTaskTranscode: uint64(countTasks(&transcodeWorker.tasks)),
+		FFmpegTaskVLive:     uint64(countTasks(&vLiveWorker.tasks)),
+	}
+	writeFFmpegTaskMetrics(w, tasks, platformMetrics.runningFFmpeg())
+
+	transcripts := make(map[string]int)
+	transcriptWorker.tasks.Range(func(key, value interface{}) bool {
+		task := value.(*TranscriptTask)
+		transcripts["live"] += task.LiveQueue.count()
+		transcripts["asr"] += task.AsrQueue.count()
+		transcripts["fix"] += task.FixQueue.count()
+		transcripts["overlay"] += task.OverlayQueue.count()
+		return true
+	})
+
+	ocrs := make(map[string]int)
+	ocrWorker.tasks.Range(func(key, value interface{}) bool {
+		task := value.(*OCRTask)
+		ocrs["live"] += task.LiveQueue.count()
+		ocrs["ocr"] += task.OCRQueue.count()
+		ocrs["callback"] += task.CallbackQueue.count()
+		ocrs["cleanup"] += task.CleanupQueue.count()
+		return true
+	})
+
+	w.header("oryx_ai_tasks", "gauge", "The number of AI tasks by service.")
+	w.sample("oryx_ai_tasks", []string{"service", "ocr"}, float64(countTasks(&ocrWorker.tasks)))
+	w.sample("oryx_ai_tasks", []string{"service", "transcript"}, float64(countTasks(&transcriptWorker.tasks)))
+
+	w.header("oryx_ai_queue_segments", "gauge", "The number of segments in the queues of AI tasks.")
+	for _, queue := range []string{"live", "ocr", "callback", "cleanup"} {
+		w.sample("oryx_ai_queue_segments", []string{"service", "ocr", "queue", queue}, float64(ocrs[queue]))
+	}
+	for _, queue := range []string{"live", "asr", "fix", "overlay"} {
+		w.sample("oryx_ai_queue_segments", []string{"service", "transcript", "queue", queue}, float64(transcripts[queue]))
+	}
+}
+
+// writeFFmpegTaskMetrics write the number of FFmpeg tasks by type and state, the task is running if its
+// FFmpeg process is running, otherwise stopped, for example, waiting to restart.
+func writeFFmpegTaskMetrics(w *metricsWriter, tasks, running map[string]uint64) {
+	w.header("oryx_ffmpeg_tasks", "gauge", "The number of FFmpeg tasks by type.")
+	for _, task := range sortedKeys(tasks) {
+		w.sample("oryx_ffmpeg_tasks", []string{"task", task}, float64(tasks[task]))
+	}
+
+	w.header("oryx_ffmpeg_task_states", "gauge", "The number of FFmpeg tasks by type and state.")
+	for _, task := range sortedKeys(tasks) {
+		// The FFmpeg of removed task might be quitting, so never exceed the number of tasks.
+		n := running[task]
+		if n > tasks[task] {
+			n = tasks[task]
+		}
+		w.sample("oryx_ffmpeg_task_states", []string{"task", task, "state", "running"}, float64(n))
+		w.sample("oryx_ffmpeg_task_states", []string{"task", task, "state", "stopped"}, float64(tasks[task]-n))
+	}
+}
+
+// writeSystemMetrics write the disk usage of records, the expiry of certificates and goroutines.
+func writeSystemMetrics(w *metricsWriter) {
+	if total, free, err := recordDiskStat(); err == nil {
+		w.header("oryx_record_disk_total_bytes", "gauge", "The total bytes of disk for records.")
+		w.sample("oryx_record_disk_total_bytes", nil, float64(total))
+		w.header("oryx_record_disk_free_bytes", "gauge", "The free bytes of disk for records.")
+		w.sample("oryx_record_disk_free_bytes", nil, float64(free))
+	}
+
+	certs := certManager.queryDomainCertificates()
+	if cert := certManager.queryDefaultCertificate(); cert != nil {
+		certs = append([]*CertInfo{cert}, certs...)
+	}
+
+	w.header("oryx_cert_expiry_timestamp_seconds", "gauge", "The expiry time of certificates in unix seconds.")
+	for _, cert := range certs {
+		domain := cert.Domain
+		if domain == "" {
+			domain = "default"
+		}
+		w.sample("oryx_cert_expiry_timestamp_seconds", []string{"domain", domain}, float64(cert.NotAfter.Unix()))
+	}
+
+	w.header("oryx_goroutines", "gauge", "The number of goroutines.")
+	w.sample("oryx_goroutines", nil, float64(runtime.NumGoroutine()))
+}
+
+func handleMetrics(ctx context.Context, handler *http.ServeMux) {
+	ep := "/metrics"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Prometheus use the bearer token in header, never in query which might be logged.
+			apiSecret := envApiSecret()
+			if err := AuthenticateWithScope(ctx, apiSecret, "", r.Header, ScopeMetricsRead); err != nil {
+				return newHTTPStatusError(http.StatusUnauthorized, errors.Wrapf(err, "authenticate"))
+			}
+
+			mw := &metricsWriter{}
+			if err := writeStreamMetrics(ctx, mw); err != nil {
+				return errors.Wrapf(err, "stream metrics")
+			}
+			writeTaskMetrics(mw)
+			writeSystemMetrics(mw)
+			platformMetrics.write(mw)
+
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			w.Write([]byte(mw.String()))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
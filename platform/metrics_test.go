@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	w := &metricsWriter{}
+	w.header("oryx_test", "gauge", "The test metric.")
+	w.sample("oryx_test", nil, 1)
+	w.sample("oryx_test", []string{"task", "forward", "id", "a\"b\\c\nd"}, 0.5)
+
+	want := strings.Join([]string{
+		"# HELP oryx_test The test metric.",
+		"# TYPE oryx_test gauge",
+		"oryx_test 1",
+		`oryx_test{task="forward",id="a\"b\\c\nd"} 0.5`,
+		"",
+	}, "\n")
+	if got := w.String(); got != want {
+		t.Errorf("metrics writer got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestPlatformMetrics(t *testing.T) {
+	m := NewPlatformMetrics()
+
+	m.OnFFmpegStart(FFmpegTaskForward, "a")
+	m.OnFFmpegSpeed(FFmpegTaskForward, "a", "1.01x")
+	m.OnFFmpegStop(FFmpegTaskForward, "a")
+	m.OnFFmpegStart(FFmpegTaskForward, "a")
+	m.OnFFmpegSpeed(FFmpegTaskForward, "a", "0.98x")
+	m.OnFFmpegStart(FFmpegTaskVLive, "b")
+	m.OnFFmpegSpeed(FFmpegTaskVLive, "b", "N/A")
+	// Ignore the speed of stopped FFmpeg.
+	m.OnFFmpegSpeed(FFmpegTaskCamera, "c", "1x")
+
+	m.OnCallback("delivered")
+	m.OnCallback("delivered")
+	m.OnCallback("retry")
+
+	m.OnAICost("asr", 1500*time.Millisecond)
+	m.OnAICost("asr", 500*time.Millisecond)
+
+	w := &metricsWriter{}
+	m.write(w)
+	got := w.String()
+
+	for _, line := range []string{
+		`oryx_ffmpeg_starts_total{task="forward"} 2`,
+		`oryx_ffmpeg_starts_total{task="vlive"} 1`,
+		`oryx_ffmpeg_restarts_total{task="forward"} 1`,
+		`oryx_ffmpeg_running{task="forward"} 1`,
+		`oryx_ffmpeg_running{task="vlive"} 1`,
+		`oryx_ffmpeg_speed{task="forward",id="a"} 0.98`,
+		`oryx_ffmpeg_speed{task="vlive",id="b"} 0`,
+		`oryx_callback_deliveries_total{status="delivered"} 2`,
+		`oryx_callback_deliveries_total{status="retry"} 1`,
+		`oryx_ai_cost_seconds_sum{service="asr"} 2`,
+		`oryx_ai_cost_seconds_count{service="asr"} 2`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expect line %v in\n%v", line, got)
+		}
+	}
+
+	if strings.Contains(got, `oryx_ffmpeg_restarts_total{task="vlive"}`) || strings.Contains(got, `task="camera"`) {
+		t.Errorf("unexpected metrics\n%v", got)
+	}
+}
+
+func TestPlatformMetrics_Restart(t *testing.T) {
+	m := NewPlatformMetrics()
+
+	// The task stopped long ago is removed, and never counted as restart.
+	m.OnFFmpegStart(FFmpegTaskTranscode, "live/old#720p")
+	m.OnFFmpegStop(FFmpegTaskTranscode, "live/old#720p")
+	m.ffmpegStopped[ffmpegMetricKey{task: FFmpegTaskTranscode, id: "live/old#720p"}] = time.Now().Add(-ffmpegRestartWindow)
+
+	m.OnFFmpegStart(FFmpegTaskTranscode, "live/livestream#720p")
+	m.OnFFmpegStop(FFmpegTaskTranscode, "live/livestream#720p")
+	if n := len(m.ffmpegStopped); n != 1 {
+		t.Errorf("expect 1 stopped task, got %v", n)
+	}
+
+	m.OnFFmpegStart(FFmpegTaskTranscode, "live/livestream#720p")
+	m.OnFFmpegStart(FFmpegTaskTranscode, "live/old#720p")
+	if n := m.ffmpegRestarts[FFmpegTaskTranscode]; n != 1 {
+		t.Errorf("expect 1 restart, got %v", n)
+	}
+	if n := len(m.ffmpegStopped); n != 0 {
+		t.Errorf("expect no stopped task, got %v", n)
+	}
+}
+
+func TestWriteFFmpegTaskMetrics(t *testing.T) {
+	w := &metricsWriter{}
+	writeFFmpegTaskMetrics(w, map[string]uint64{FFmpegTaskForward: 3, FFmpegTaskVLive: 1},
+		map[string]uint64{FFmpegTaskForward: 2, FFmpegTaskVLive: 2, FFmpegTaskCamera: 1},
+	)
+	got := w.String()
+
+	for _, line := range []string{
+		`oryx_ffmpeg_tasks{task="forward"} 3`,
+		`oryx_ffmpeg_task_states{task="forward",state="running"} 2`,
+		`oryx_ffmpeg_task_states{task="forward",state="stopped"} 1`,
+		`oryx_ffmpeg_task_states{task="vlive",state="running"} 1`,
+		`oryx_ffmpeg_task_states{task="vlive",state="stopped"} 0`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expect line %v in\n%v", line, got)
+		}
+	}
+
+	if strings.Contains(got, `task="camera"`) {
+		t.Errorf("unexpected metrics\n%v", got)
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OCRRegion is the region of interest in image, the values are ratios of the width and height of image,
+// for example, {x:0, y:0.8, w:1, h:0.2} is the bottom 20% of image.
+type OCRRegion struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"w"`
+	Height float64 `json:"h"`
+}
+
+func (v *OCRRegion) String() string {
+	return fmt.Sprintf("x=%v, y=%v, w=%v, h=%v", v.X, v.Y, v.Width, v.Height)
+}
+
+func (v *OCRRegion) Validate() error {
+	if v.X < 0 || v.Y < 0 || v.Width <= 0 || v.Height <= 0 {
+		return errors.Errorf("invalid region %v", v.String())
+	}
+	if v.X+v.Width > 1 || v.Y+v.Height > 1 {
+		return errors.Errorf("region %v out of image", v.String())
+	}
+	return nil
+}
+
+// cropFilter returns the FFmpeg crop filter for the region.
+func (v *OCRRegion) cropFilter() string {
+	return fmt.Sprintf("crop=iw*%v:ih*%v:iw*%v:ih*%v", v.Width, v.Height, v.X, v.Y)
+}
+
+// OCRRule is a rule to recognize the image, with optional region of interest and schema for structured
+// JSON extraction, for example, the scoreboard of a match.
+type OCRRule struct {
+	// The rule ID, generated if empty.
+	ID string `json:"id"`
+	// The name of rule, such as scoreboard.
+	Name string `json:"name,omitempty"`
+	// The prompt for AI, use the prompt of OCR config if empty.
+	Prompt string `json:"prompt,omitempty"`
+	// The region of interest to crop, use the whole image if nil.
+	Region *OCRRegion `json:"region,omitempty"`
+	// The schema to extract JSON, the key is the field name, the value is the description of field, for
+	// example, {"home": "The score of home team", "away": "The score of away team"}.
+	Schema map[string]string `json:"schema,omitempty"`
+}
+
+func (v *OCRRule) String() string {
+	return fmt.Sprintf("id=%v, name=%v, prompt=%vB, region=%v, schema=%v",
+		v.ID, v.Name, len(v.Prompt), v.Region != nil, len(v.Schema))
+}
+
+// The rule ID is only letters, digits, underscore and hyphen, to never be a path.
+var ocrRuleIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func (v *OCRRule) Validate() error {
+	if v.ID == "" {
+		v.ID = uuid.NewString()
+	}
+	if !ocrRuleIDRegex.MatchString(v.ID) {
+		return errors.Errorf("invalid id %v", v.ID)
+	}
+	if v.Region != nil {
+		if err := v.Region.Validate(); err != nil {
+			return errors.Wrapf(err, "region")
+		}
+	}
+	for field := range v.Schema {
+		if strings.TrimSpace(field) == "" {
+			return errors.New("empty field of schema")
+		}
+	}
+	return nil
+}
+
+// systemPrompt returns the system prompt for AI, to respond JSON object if schema is set.
+func (v *OCRRule) systemPrompt(maxWords int) string {
+	if len(v.Schema) == 0 {
+		return fmt.Sprintf("Keep your reply neat, limiting the reply to %v words.", maxWords)
+	}
+
+	var fields []string
+	for field := range v.Schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var sb strings.Builder
+	sb.WriteString("Only respond a JSON object with the following fields, use null if not visible in image:\n")
+	for _, field := range fields {
+		sb.WriteString(fmt.Sprintf("- %v: %v\n", field, v.Schema[field]))
+	}
+	return sb.String()
+}
+
+// OCREventConfig is the config to generate OCR events, to sample segments, recognize by rules and only
+// callback when content changes.
+type OCREventConfig struct {
+	// The sampling interval in seconds, recognize all segments if zero.
+	Interval float64 `json:"interval,omitempty"`
+	// Whether only callback when the recognized content changes.
+	Dedup bool `json:"dedup"`
+	// The rules to recognize the image, use the whole image and prompt of config if empty.
+	Rules []*OCRRule `json:"rules,omitempty"`
+}
+
+func (v *OCREventConfig) String() string {
+	return fmt.Sprintf("interval=%v, dedup=%v, rules=%v", v.Interval, v.Dedup, len(v.Rules))
+}
+
+func (v *OCREventConfig) Validate() error {
+	if v.Interval < 0 {
+		return errors.Errorf("invalid interval %v", v.Interval)
+	}
+
+	ids := make(map[string]bool)
+	for _, rule := range v.Rules {
+		if rule == nil {
+			return errors.New("empty rule")
+		}
+		if err := rule.Validate(); err != nil {
+			return errors.Wrapf(err, "validate %v", rule.String())
+		}
+		if ids[rule.ID] {
+			return errors.Errorf("duplicated id %v", rule.ID)
+		}
+		ids[rule.ID] = true
+	}
+	return nil
+}
+
+// OCRResult is the recognized result of a rule.
+type OCRResult struct {
+	// The rule ID and name, empty for the default rule.
+	Rule string `json:"rule,omitempty"`
+	Name string `json:"name,omitempty"`
+	// The prompt used to recognize the image.
+	Prompt string `json:"prompt,omitempty"`
+	// The recognized text, or the JSON of data if schema is set.
+	Text string `json:"text"`
+	// The extracted data by schema.
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Whether the content changed, only callback when changed.
+	Changed bool `json:"changed"`
+}
+
+func (v *OCRResult) String() string {
+	return fmt.Sprintf("rule=%v, name=%v, text=%v, data=%v, changed=%v",
+		v.Rule, v.Name, v.Text, len(v.Data), v.Changed)
+}
+
+// sampleOCRSegment returns whether to recognize the segment, and the elapsed duration since the last sampled
+// segment. The since is zero for the first segment.
+func sampleOCRSegment(since, duration, interval float64) (bool, float64) {
+	if interval <= 0 || since <= 0 || since >= interval {
+		return true, duration
+	}
+	return false, since + duration
+}
+
+// parseOCRData parse the JSON object responded by AI, which might be wrapped in markdown code block, and
+// returns the data and the canonical JSON text.
+func parseOCRData(content string) (map[string]interface{}, string, error) {
+	if start, end := strings.Index(content, "{"), strings.LastIndex(content, "}"); start >= 0 && end > start {
+		content = content[start : end+1]
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return nil, "", errors.Wrapf(err, "unmarshal %v", content)
+	}
+
+	// Note that the keys of map are sorted when marshal.
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "marshal %v", data)
+	}
+	return data, string(b), nil
+}
+
+// normalizeOCRText normalize the text to compare, ignore the case and spaces.
+func normalizeOCRText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// chatOCRImage request AI to recognize the image by prompt.
+func chatOCRImage(
+	ctx context.Context, config *OCRConfig, system, prompt, imageData string, histories []openai.ChatCompletionMessage,
+) (string, error) {
+	aiConfig := openai.DefaultConfig(config.AISecretKey)
+	aiConfig.BaseURL = config.AIBaseURL
+	aiConfig.OrgID = config.AIOrganization
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: system},
+	}
+
+	messages = append(messages, histories...)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser, Content: prompt,
+	})
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{
+				Detail: openai.ImageURLDetailLow, URL: fmt.Sprintf("data:image/jpeg;base64,%v", imageData),
+			}},
+		},
+	})
+
+	client := openai.NewClientWithConfig(aiConfig)
+	resp, err := client.CreateChatCompletion(
+		ctx, openai.ChatCompletionRequest{
+			Model: config.AIChatModel, Messages: messages,
+		},
+	)
+	if err != nil {
+		return "", errors.Wrapf(err, "AI process, model=%v, messages=%v, system=<%v>, prompt=<%v>",
+			config.AIChatModel, len(messages), system, prompt,
+		)
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no choices")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// recognizeRule recognize the image of segment by rule, crop the region of interest if set.
+func (v *OCRTask) recognizeRule(ctx context.Context, segment *OCRSegment, rule *OCRRule) (*OCRResult, error) {
+	imageFile := segment.ImageFile.File
+	if rule.Region != nil {
+		// Never build the file name from rule, which is specified by user.
+		f, err := os.CreateTemp("ocr", "crop-*.jpg")
+		if err != nil {
+			return nil, errors.Wrapf(err, "create temp file")
+		}
+		croppedFile := f.Name()
+		f.Close()
+		defer os.Remove(croppedFile)
+
+		args := []string{
+			"-i", imageFile, "-vf", rule.Region.cropFilter(), "-q:v", "10", "-y", croppedFile,
+		}
+		if err := exec.CommandContext(ctx, "ffmpeg", args...).Run(); err != nil {
+			return nil, errors.Wrapf(err, "crop %v", args)
+		}
+		imageFile = croppedFile
+	}
+
+	data, err := os.ReadFile(imageFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read image from %v", imageFile)
+	}
+
+	prompt := rule.Prompt
+	if prompt == "" {
+		prompt = v.config.AIChatPrompt
+	}
+
+	content, err := chatOCRImage(
+		ctx, &v.config, rule.systemPrompt(v.config.AIChatMaxWords), prompt,
+		base64.StdEncoding.EncodeToString(data), nil,
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "recognize %v", imageFile)
+	}
+
+	result := &OCRResult{Rule: rule.ID, Name: rule.Name, Prompt: prompt, Text: content}
+	if len(rule.Schema) > 0 {
+		if result.Data, result.Text, err = parseOCRData(content); err != nil {
+			return nil, errors.Wrapf(err, "parse data")
+		}
+	}
+	return result, nil
+}
+
+// recognizeRules recognize the image of segment by all rules, ignore the failed rules.
+func (v *OCRTask) recognizeRules(ctx context.Context, segment *OCRSegment, rules []*OCRRule) []*OCRResult {
+	var results []*OCRResult
+	for _, rule := range rules {
+		starttime := time.Now()
+		result, err := v.recognizeRule(ctx, segment, rule)
+		if err != nil {
+			logger.Wf(ctx, "ocr: ignore rule %v of %v err %+v", rule.String(), segment.String(), err)
+			continue
+		}
+
+		results = append(results, result)
+		logger.Tf(ctx, "ocr: recognize rule %v, text=%v, cost=%v", rule.String(), result.Text, time.Since(starttime))
+	}
+	return results
+}
+
+// updateChanged mark whether the result changed since the last result of the same rule. All results are
+// changed if dedup is disabled.
+func (v *OCRTask) updateChanged(results []*OCRResult, dedup bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.lastResults == nil {
+		v.lastResults = make(map[string]string)
+	}
+
+	for _, result := range results {
+		text := normalizeOCRText(result.Text)
+		last, ok := v.lastResults[result.Rule]
+		result.Changed = !dedup || !ok || last != text
+		v.lastResults[result.Rule] = text
+	}
+}
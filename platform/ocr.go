@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -36,8 +37,8 @@ type OCRWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The global OCR task, only support one OCR task.
-	task *OCRTask
+	// The OCR tasks, key is stream URL in string such as /live/livestream, value is *OCRTask.
+	tasks sync.Map
 
 	// Use async goroutine to process on_hls messages.
 	msgs chan *SrsOnHlsMessage
@@ -53,11 +54,35 @@ func NewOCRWorker() *OCRWorker {
 		// TS files.
 		tsfiles: make(chan *SrsOnHlsObject, 1024),
 	}
-	v.task = NewOCRTask()
-	v.task.ocrWorker = v
 	return v
 }
 
+// queryTask returns the task by uuid or stream URL, or the default task if both are empty, which is the
+// task of the latest updated stream, to be compatible with the clients for only one task.
+func (v *OCRWorker) queryTask(uuid, stream string) (*OCRTask, error) {
+	var target *OCRTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		task := value.(*OCRTask)
+		if uuid != "" || stream != "" {
+			if (uuid != "" && task.UUID == uuid) || (uuid == "" && task.Stream == stream) {
+				target = task
+				return false
+			}
+			return true
+		}
+
+		if target == nil || target.updated().Before(task.updated()) {
+			target = task
+		}
+		return true
+	})
+
+	if target == nil {
+		return nil, errors.Errorf("no task for uuid=%v, stream=%v", uuid, stream)
+	}
+	return target, nil
+}
+
 func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ai/ocr/query"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -82,21 +107,45 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "load config")
 			}
 
+			type QueryTask struct {
+				UUID   string `json:"uuid"`
+				Stream string `json:"stream"`
+				Update string `json:"update"`
+				// The count of segments in each queue.
+				Live     int `json:"live"`
+				OCR      int `json:"ocr"`
+				Callback int `json:"callback"`
+				Cleanup  int `json:"cleanup"`
+			}
 			type QueryResponse struct {
 				Config *OCRConfig `json:"config"`
-				Task   struct {
+				// The default task, which is the latest updated one.
+				Task struct {
 					UUID string `json:"uuid"`
 				} `json:"task"`
+				// All tasks, one task for each stream.
+				Tasks []*QueryTask `json:"tasks"`
 			}
 
 			resp := &QueryResponse{
-				Config: config,
-			}
-			resp.Task.UUID = v.task.UUID
+				Config: config, Tasks: []*QueryTask{},
+			}
+			if task, err := v.queryTask("", ""); err == nil {
+				resp.Task.UUID = task.UUID
+			}
+			v.tasks.Range(func(key, value interface{}) bool {
+				task := value.(*OCRTask)
+				resp.Tasks = append(resp.Tasks, &QueryTask{
+					UUID: task.UUID, Stream: task.Stream, Update: task.Update,
+					Live: task.LiveQueue.count(), OCR: task.OCRQueue.count(),
+					Callback: task.CallbackQueue.count(), Cleanup: task.CleanupQueue.count(),
+				})
+				return true
+			})
 
 			ohttp.WriteData(ctx, w, r, resp)
-			logger.Tf(ctx, "ocr query ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+			logger.Tf(ctx, "ocr query ok, config=<%v>, uuid=%v, tasks=%v, token=%vB",
+				config, resp.Task.UUID, len(resp.Tasks), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -126,27 +175,49 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			// Not required yet.
-			if uuid != v.task.UUID {
-				logger.Wf(ctx, "ocr ignore uuid mismatch, query=%v, task=%v", uuid, v.task.UUID)
+			// Keep the event rules, streams and globs if not specified, for compatibility with the old client.
+			previous := NewOCRConfig()
+			if err := previous.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load previous config")
+			}
+			if config.Events == nil {
+				config.Events = previous.Events
+			}
+			if config.Streams == nil {
+				config.Streams = previous.Streams
+			}
+			if config.Globs == nil {
+				config.Globs = previous.Globs
+			}
+
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate config %v", config.String())
 			}
 
 			if err := config.Save(ctx); err != nil {
 				return errors.Wrapf(err, "save config")
 			}
 
-			if err := v.task.restart(ctx); err != nil {
-				return errors.Wrapf(err, "restart task %v", config.String())
-			}
+			// Restart all tasks to apply the config, and the worker will start or stop tasks for streams.
+			v.tasks.Range(func(key, value interface{}) bool {
+				task := value.(*OCRTask)
+				if err := task.restart(ctx); err != nil {
+					logger.Wf(ctx, "ocr ignore restart task %v err %+v", task.UUID, err)
+				}
+				return true
+			})
 
+			// Not required yet, response the default task for compatibility.
 			type ApplyResponse struct {
 				UUID string `json:"uuid"`
 			}
-			ohttp.WriteData(ctx, w, r, &ApplyResponse{
-				UUID: v.task.UUID,
-			})
-			logger.Tf(ctx, "ocr apply ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+			res := &ApplyResponse{}
+			if task, err := v.queryTask("", ""); err == nil {
+				res.UUID = task.UUID
+			}
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "ocr apply ok, config=<%v>, query=%v, uuid=%v, token=%vB",
+				config, uuid, res.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -235,11 +306,12 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if uuid != v.task.UUID {
-				return errors.Errorf("invalid uuid %v", uuid)
+			task, err := v.queryTask(uuid, "")
+			if err != nil {
+				return errors.Wrapf(err, "invalid uuid %v", uuid)
 			}
 
-			if err := v.task.reset(ctx); err != nil {
+			if err := task.reset(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", uuid)
 			}
 
@@ -247,9 +319,9 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				UUID string `json:"uuid"`
 			}
 			ohttp.WriteData(ctx, w, r, &ResetResponse{
-				UUID: v.task.UUID,
+				UUID: task.UUID,
 			})
-			logger.Tf(ctx, "ocr reset ok, uuid=%v, new=%v, token=%vB", uuid, v.task.UUID, len(token))
+			logger.Tf(ctx, "ocr reset ok, uuid=%v, new=%v, token=%vB", uuid, task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -260,11 +332,13 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -274,6 +348,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -287,7 +366,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &LiveQueueResponse{}
 
-			segments := v.task.liveSegments()
+			segments := task.liveSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.TsFile.TsID,
@@ -301,7 +380,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "ocr query live ok, token=%vB", len(token))
+			logger.Tf(ctx, "ocr query live ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -312,11 +391,13 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -326,6 +407,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -343,7 +429,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &OCRQueueResponse{}
 
-			segments := v.task.ocrSegments()
+			segments := task.ocrSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.ImageFile.TsID,
@@ -361,7 +447,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "ocr query ocr ok, token=%vB", len(token))
+			logger.Tf(ctx, "ocr query ocr ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -372,11 +458,13 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -386,6 +474,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -407,7 +500,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &OCRQueueResponse{}
 
-			segments := v.task.callbackSegments()
+			segments := task.callbackSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.ImageFile.TsID,
@@ -429,7 +522,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "ocr query callback ok, token=%vB", len(token))
+			logger.Tf(ctx, "ocr query callback ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -440,11 +533,13 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -454,6 +549,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -477,7 +577,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &OCRQueueResponse{}
 
-			segments := v.task.cleanupSegments()
+			segments := task.cleanupSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.ImageFile.TsID,
@@ -501,7 +601,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "ocr query cleanup ok, token=%vB", len(token))
+			logger.Tf(ctx, "ocr query cleanup ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -545,7 +645,12 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 }
 
 func (v *OCRWorker) Enabled() bool {
-	return v.task.enabled()
+	var enabled bool
+	v.tasks.Range(func(key, value interface{}) bool {
+		enabled = value.(*OCRTask).enabled()
+		return !enabled
+	})
+	return enabled
 }
 
 func (v *OCRWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
@@ -558,8 +663,9 @@ func (v *OCRWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) er
 }
 
 func (v *OCRWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage) error {
-	// Ignore if not natch the task config.
-	if !v.task.match(msg) {
+	// Ignore if no task for the stream, or not natch the task config.
+	value, ok := v.tasks.Load(fmt.Sprintf("/%v/%v", msg.App, msg.Stream))
+	if !ok || !value.(*OCRTask).match(msg) {
 		return nil
 	}
 
@@ -606,6 +712,11 @@ func (v *OCRWorker) Close() error {
 	}
 
 	v.wg.Wait()
+
+	v.tasks.Range(func(key, value interface{}) bool {
+		value.(*OCRTask).Close()
+		return true
+	})
 	return nil
 }
 
@@ -618,47 +729,36 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "ocr start a worker")
 
-	// Load task from redis and continue to run the task.
+	// Load tasks from redis and continue to run the tasks.
 	if objs, err := rdb.HGetAll(ctx, SRS_OCR_TASK).Result(); err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "hgetall %v", SRS_OCR_TASK)
-	} else if len(objs) != 1 {
-		// Only support one task right now.
-		if err = rdb.Del(ctx, SRS_OCR_TASK).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "del %v", SRS_OCR_TASK)
-		}
 	} else {
 		for uuid, obj := range objs {
 			logger.Tf(ctx, "Load task %v object %v", uuid, obj)
 
-			if err = json.Unmarshal([]byte(obj), v.task); err != nil {
+			task := NewOCRTask()
+			if err = json.Unmarshal([]byte(obj), task); err != nil {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			break
-		}
-	}
-
-	// Start global ocr task.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.Run(ctx); err != nil {
-				logger.Wf(ctx, "ocr: run task %v err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 3 * time.Second
+			// The task of previous version has no stream, so parse it from the input url.
+			if task.Stream == "" && task.Input != "" {
+				if u, err := url.Parse(task.Input); err == nil {
+					task.Stream = u.Path
+				}
 			}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
+			// Remove the invalid task, or the duplicated task of a stream.
+			if _, loaded := v.tasks.Load(task.Stream); task.Stream == "" || loaded {
+				if err = rdb.HDel(ctx, SRS_OCR_TASK, uuid).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_OCR_TASK, uuid)
+				}
+				continue
 			}
+
+			v.startTask(ctx, task)
 		}
-	}()
+	}
 
 	// Consume all on_hls messages.
 	wg.Add(1)
@@ -676,16 +776,22 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Consume all ts files by task.
+	// Consume all ts files by task of stream.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
 			select {
 			case <-ctx.Done():
 			case msg := <-v.tsfiles:
+				value, ok := v.tasks.Load(fmt.Sprintf("/%v/%v", msg.Msg.App, msg.Msg.Stream))
+				if !ok {
+					os.Remove(msg.TsFile.File)
+					continue
+				}
+
+				task := value.(*OCRTask)
 				if err := task.OnTsSegment(ctx, msg); err != nil {
 					logger.Wf(ctx, "ocr: task %v on hls ts message %v err %+v", task.String(), msg.String(), err)
 				}
@@ -693,19 +799,18 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Watch for new stream.
+	// Watch for streams, start or stop tasks by config.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
 			var duration time.Duration
-			if err := task.WatchNewStream(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v watch new stream err %+v", task.String(), err)
+			if err := v.updateTasks(ctx); err != nil {
+				logger.Wf(ctx, "ocr: update tasks err %+v", err)
 				duration = 10 * time.Second
 			} else {
-				duration = 200 * time.Millisecond
+				duration = 1 * time.Second
 			}
 
 			select {
@@ -715,93 +820,84 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Drive the live queue to OCR.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	return nil
+}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveLiveQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive live queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+// startTask start a task for the stream of task.
+func (v *OCRWorker) startTask(ctx context.Context, task *OCRTask) {
+	task.ocrWorker = v
+	task.Input = fmt.Sprintf("rtmp://localhost%v", task.Stream)
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	v.tasks.Store(task.Stream, task)
+	task.Start(ctx)
+	logger.Tf(ctx, "ocr: start task %v", task.String())
+}
 
-	// Drive the OCR queue to correct queue.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+// stopTask stop the task, and remove all files and the task from redis.
+func (v *OCRWorker) stopTask(ctx context.Context, task *OCRTask) error {
+	v.tasks.Delete(task.Stream)
+	task.Close()
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveOCRQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive ocr queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+	if err := task.dispose(ctx); err != nil {
+		return errors.Wrapf(err, "dispose task %v", task.UUID)
+	}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	logger.Tf(ctx, "ocr: stop task %v", task.String())
+	return nil
+}
 
-	// Drive the callback queue, notify user's service.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+// updateTasks start tasks for the streams selected by config, and stop the tasks not selected any more.
+func (v *OCRWorker) updateTasks(ctx context.Context) error {
+	config := NewOCRConfig()
+	if err := config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load config")
+	}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveCallbackQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive callback queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+	// Ignore if not enabled, keep the tasks for user to reset.
+	if !config.All {
+		return nil
+	}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+	}
 
-	// Drive the cleanup queue, remove old files.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	var actives []*SrsStream
+	for _, value := range streams {
+		var stream SrsStream
+		if err := json.Unmarshal([]byte(value), &stream); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", value)
+		}
+		actives = append(actives, &stream)
+	}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveCleanupQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive cleanup queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+	// Stop the tasks which is not selected by config.
+	selected := config.selectStreams(actives)
+	var stopped []*OCRTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*OCRTask); !config.keepTask(task.Stream, selected) {
+			stopped = append(stopped, task)
+		}
+		return true
+	})
+	for _, task := range stopped {
+		if err := v.stopTask(ctx, task); err != nil {
+			return errors.Wrapf(err, "stop task %v", task.String())
+		}
+	}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
+	// Start tasks for the new streams.
+	for _, stream := range selected {
+		if _, ok := v.tasks.Load(stream); ok {
+			continue
 		}
-	}()
+
+		logger.Tf(ctx, "ocr: Got new stream %v", stream)
+		task := NewOCRTask()
+		task.Stream = stream
+		v.startTask(ctx, task)
+	}
 
 	return nil
 }
@@ -813,6 +909,23 @@ type OCRConfig struct {
 	SrsAssistantProvider
 	// The AI chat configuration.
 	SrsAssistantChat
+	// The event rules, to sample, recognize by rules and detect changes.
+	Events *OCREventConfig `json:"events,omitempty"`
+	// The streams to OCR, with the chat and event rules of each stream. If both streams and globs are empty,
+	// OCR the latest active stream only.
+	Streams []*OCRStreamConfig `json:"streams,omitempty"`
+	// The glob filters of stream URL to OCR, such as /live/*
+	Globs []string `json:"globs,omitempty"`
+}
+
+// OCRStreamConfig is the config to OCR a stream.
+type OCRStreamConfig struct {
+	// The stream URL, such as /live/livestream
+	Stream string `json:"stream"`
+	// The AI chat configuration of stream, such as model and prompt, use the global chat if nil.
+	Chat *SrsAssistantChat `json:"chat,omitempty"`
+	// The event rules of stream, use the global event rules if nil.
+	Events *OCREventConfig `json:"events,omitempty"`
 }
 
 func NewOCRConfig() *OCRConfig {
@@ -823,9 +936,113 @@ func NewOCRConfig() *OCRConfig {
 }
 
 func (v OCRConfig) String() string {
-	return fmt.Sprintf("all=%v, provider=<%v>, chat=<%v>",
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("all=%v, provider=<%v>, chat=<%v>",
 		v.All, v.SrsAssistantProvider.String(), v.SrsAssistantChat.String(),
-	)
+	))
+	if v.Events != nil {
+		sb.WriteString(fmt.Sprintf(", events=<%v>", v.Events.String()))
+	}
+	if len(v.Streams) > 0 || len(v.Globs) > 0 {
+		sb.WriteString(fmt.Sprintf(", streams=%v, globs=%v", len(v.Streams), v.Globs))
+	}
+	return sb.String()
+}
+
+func (v *OCRConfig) Validate() error {
+	if v.Events != nil {
+		if err := v.Events.Validate(); err != nil {
+			return errors.Wrapf(err, "validate events %v", v.Events.String())
+		}
+	}
+	for _, s := range v.Streams {
+		if s == nil {
+			return errors.New("empty stream")
+		}
+		if parts := strings.Split(s.Stream, "/"); len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+			return errors.Errorf("invalid stream %v, should be /app/stream", s.Stream)
+		}
+		if s.Events != nil {
+			if err := s.Events.Validate(); err != nil {
+				return errors.Wrapf(err, "validate events %v of %v", s.Events.String(), s.Stream)
+			}
+		}
+	}
+	for _, glob := range v.Globs {
+		if !strings.HasPrefix(glob, "/") {
+			return errors.Errorf("invalid glob %v", glob)
+		}
+		if _, err := path.Match(glob, "/"); err != nil {
+			return errors.Wrapf(err, "invalid glob %v", glob)
+		}
+	}
+	return nil
+}
+
+// applyStream use the chat and event rules of stream, if configured.
+func (v *OCRConfig) applyStream(stream string) {
+	for _, s := range v.Streams {
+		if s.Stream != stream {
+			continue
+		}
+		if s.Chat != nil {
+			v.SrsAssistantChat = *s.Chat
+		}
+		if s.Events != nil {
+			v.Events = s.Events
+		}
+		return
+	}
+}
+
+// selects returns whether the stream is selected by streams or globs.
+func (v *OCRConfig) selects(stream string) bool {
+	for _, s := range v.Streams {
+		if s.Stream == stream {
+			return true
+		}
+	}
+	for _, glob := range v.Globs {
+		if ok, err := path.Match(glob, stream); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectStreams returns the stream URLs to OCR, from the active streams.
+func (v *OCRConfig) selectStreams(actives []*SrsStream) []string {
+	var selected []string
+
+	// Select the latest active stream if no streams and globs.
+	if len(v.Streams) == 0 && len(v.Globs) == 0 {
+		var best *SrsStream
+		for _, stream := range actives {
+			if best == nil || best.Update < stream.Update {
+				best = stream
+			}
+		}
+		if best != nil {
+			selected = append(selected, fmt.Sprintf("/%v/%v", best.App, best.Stream))
+		}
+		return selected
+	}
+
+	for _, stream := range actives {
+		if streamURL := fmt.Sprintf("/%v/%v", stream.App, stream.Stream); v.selects(streamURL) {
+			selected = append(selected, streamURL)
+		}
+	}
+	return selected
+}
+
+// keepTask returns whether to keep the task of stream. If no streams and globs, only keep the task of the
+// latest active stream, or keep it if no active stream. Otherwise, keep the task if selected by config.
+func (v *OCRConfig) keepTask(stream string, selected []string) bool {
+	if len(v.Streams) == 0 && len(v.Globs) == 0 {
+		return len(selected) == 0 || selected[0] == stream
+	}
+	return v.selects(stream)
 }
 
 func (v *OCRConfig) Load(ctx context.Context) error {
@@ -857,6 +1074,8 @@ type OCRSegment struct {
 	ImageFile *TsFile `json:"image,omitempty"`
 	// The ocr result, by AI service.
 	OCRText string `json:"ocr,omitempty"`
+	// The ocr results of rules, only callback the changed results.
+	Results []*OCRResult `json:"results,omitempty"`
 	// The callback video file.
 	CallbackFile *TsFile `json:"callback,omitempty"`
 
@@ -993,11 +1212,17 @@ type OCRTask struct {
 
 	// The input url.
 	Input string `json:"input,omitempty"`
-	// The input stream object, select the active stream.
-	inputStream *SrsStream
+	// The stream URL of task, such as /live/livestream
+	Stream string `json:"stream,omitempty"`
+	// The last time the task got a segment.
+	Update string `json:"update,omitempty"`
 
 	// The chat history, to use as prompt for next chat.
 	histories []openai.ChatCompletionMessage
+	// The elapsed duration in seconds since the last sampled segment.
+	sampleElapsed float64
+	// The last normalized result of each rule, to detect changes.
+	lastResults map[string]string
 
 	// The live queue for the current task. HLS TS segments are copied to the ocr
 	// directory, then a segment is created and added to the live queue for the ocr
@@ -1015,8 +1240,6 @@ type OCRTask struct {
 
 	// The signal to persistence task.
 	signalPersistence chan bool
-	// The signal to change the active stream for task.
-	signalNewStream chan *SrsStream
 
 	// The configure for ocr task.
 	config OCRConfig
@@ -1025,6 +1248,9 @@ type OCRTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// To stop the goroutines of task.
+	stop context.CancelFunc
+	wg   sync.WaitGroup
 
 	// To protect the common fields.
 	lock sync.Mutex
@@ -1044,27 +1270,76 @@ func NewOCRTask() *OCRTask {
 		CleanupQueue: NewOCRQueue(),
 		// Create persistence signal.
 		signalPersistence: make(chan bool, 1),
-		// Create new stream signal.
-		signalNewStream: make(chan *SrsStream, 1),
 	}
 }
 
 func (v *OCRTask) String() string {
-	return fmt.Sprintf("uuid=%v, live=%v, ocr=%v, callback=%v, cleanup=%v, config is %v",
-		v.UUID, v.LiveQueue.String(), v.OCRQueue.String(), v.CallbackQueue.String(), v.CleanupQueue.String(),
-		v.config.String(),
+	return fmt.Sprintf("uuid=%v, stream=%v, live=%v, ocr=%v, callback=%v, cleanup=%v, config is %v",
+		v.UUID, v.Stream, v.LiveQueue.String(), v.OCRQueue.String(), v.CallbackQueue.String(),
+		v.CleanupQueue.String(), v.config.String(),
 	)
 }
 
+// Start the goroutines to run and drive the queues of task, which are stopped by Close.
+func (v *OCRTask) Start(ctx context.Context) {
+	ctx, v.stop = context.WithCancel(ctx)
+
+	drive := func(name string, interval time.Duration, fn func(ctx context.Context) error) {
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+
+			for ctx.Err() == nil {
+				var duration time.Duration
+				if err := fn(ctx); err != nil {
+					logger.Wf(ctx, "ocr: task %v %v err %+v", v.String(), name, err)
+					duration = 10 * time.Second
+				} else {
+					duration = interval
+				}
+
+				select {
+				case <-ctx.Done():
+				case <-time.After(duration):
+				}
+			}
+		}()
+	}
+
+	// Run the ocr task.
+	drive("run", 3*time.Second, v.Run)
+	// Drive the live queue to OCR.
+	drive("drive live queue", 200*time.Millisecond, v.DriveLiveQueue)
+	// Drive the OCR queue to callback queue.
+	drive("drive ocr queue", 200*time.Millisecond, v.DriveOCRQueue)
+	// Drive the callback queue, notify user's service.
+	drive("drive callback queue", 200*time.Millisecond, v.DriveCallbackQueue)
+	// Drive the cleanup queue, remove old files.
+	drive("drive cleanup queue", 200*time.Millisecond, v.DriveCleanupQueue)
+}
+
+// Close stop the goroutines of task.
+func (v *OCRTask) Close() error {
+	if v.stop != nil {
+		v.stop()
+	}
+	v.wg.Wait()
+	return nil
+}
+
 func (v *OCRTask) Run(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "ocr run task %v", v.String())
 
 	pfn := func(ctx context.Context) error {
-		// Load config from redis.
-		if err := v.config.Load(ctx); err != nil {
+		// Load config from redis into a new object, because the overrides of stream might be removed, then
+		// use the chat and event rules of stream.
+		config := NewOCRConfig()
+		if err := config.Load(ctx); err != nil {
 			return errors.Wrapf(err, "load config")
 		}
+		config.applyStream(v.Stream)
+		v.config = *config
 
 		// Ignore if not enabled.
 		if !v.config.All {
@@ -1113,10 +1388,6 @@ func (v *OCRTask) doOCR(ctx context.Context) error {
 			if err := v.saveTask(ctx); err != nil {
 				return errors.Wrapf(err, "save task %v", v.String())
 			}
-		case input := <-v.signalNewStream:
-			host := "localhost"
-			inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
-			v.Input, v.inputStream = inputURL, input
 		}
 	}
 
@@ -1138,6 +1409,7 @@ func (v *OCRTask) OnTsSegment(ctx context.Context, msg *SrsOnHlsObject) error {
 			Msg:    msg.Msg,
 			TsFile: msg.TsFile,
 		})
+		v.Update = time.Now().Format(time.RFC3339)
 	}()
 
 	// Notify the main loop to persistent current task.
@@ -1145,89 +1417,6 @@ func (v *OCRTask) OnTsSegment(ctx context.Context, msg *SrsOnHlsObject) error {
 	return nil
 }
 
-// TODO: FIXME: Should reset the stream when republish.
-func (v *OCRTask) WatchNewStream(ctx context.Context) error {
-	// If not enabled, wait.
-	if !v.config.All {
-		return nil
-	}
-
-	selectActiveStream := func() (*SrsStream, error) {
-		streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
-		if err != nil {
-			return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
-		}
-
-		var best *SrsStream
-		for _, value := range streams {
-			var stream SrsStream
-			if err := json.Unmarshal([]byte(value), &stream); err != nil {
-				return nil, errors.Wrapf(err, "unmarshal %v", value)
-			}
-
-			if best == nil {
-				best = &stream
-				continue
-			}
-
-			bestUpdate, err := time.Parse(time.RFC3339, best.Update)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse %v", best.Update)
-			}
-
-			streamUpdate, err := time.Parse(time.RFC3339, stream.Update)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse %v", stream.Update)
-			}
-
-			if bestUpdate.Before(streamUpdate) {
-				best = &stream
-			}
-		}
-
-		// Ignore if no active stream.
-		if best == nil {
-			return nil, nil
-		}
-
-		if v.inputStream != nil && v.inputStream.StreamURL() != best.StreamURL() {
-			logger.Tf(ctx, "ocr use best=%v as input", best.StreamURL())
-		}
-		return best, nil
-	}
-
-	// Use an active stream as input.
-	input, err := selectActiveStream()
-	if err != nil {
-		return errors.Wrapf(err, "select input")
-	}
-
-	// Whether stream exists and changed.
-	var streamNotChanged bool
-	if v.inputStream != nil && input != nil && v.inputStream.StreamURL() == input.StreamURL() {
-		streamNotChanged = true
-	}
-
-	// No stream, or stream not changed, wait.
-	if input == nil || streamNotChanged {
-		select {
-		case <-ctx.Done():
-		case <-time.After(1 * time.Second):
-		}
-		return nil
-	}
-	logger.Tf(ctx, "ocr: Got new stream %v", input.String())
-
-	// Notify the main loop to change the active stream.
-	select {
-	case <-ctx.Done():
-	case v.signalNewStream <- input:
-		logger.Tf(ctx, "ocr: Notify new stream %v", input.String())
-	}
-
-	return nil
-}
-
 func (v *OCRTask) DriveLiveQueue(ctx context.Context) error {
 	// Ignore if not enabled.
 	if !v.config.All {
@@ -1255,6 +1444,24 @@ func (v *OCRTask) DriveLiveQueue(ctx context.Context) error {
 		return nil
 	}
 
+	// Skip the segment if not reach the sampling interval.
+	if events := v.config.Events; events != nil {
+		var sampled bool
+		sampled, v.sampleElapsed = sampleOCRSegment(v.sampleElapsed, segment.TsFile.Duration, events.Interval)
+		if !sampled {
+			func() {
+				v.lock.Lock()
+				defer v.lock.Unlock()
+				v.LiveQueue.dequeue(segment)
+			}()
+
+			segment.Dispose()
+			logger.Tf(ctx, "ocr: skip segment %v, elapsed=%v, interval=%v",
+				segment.String(), v.sampleElapsed, events.Interval)
+			return nil
+		}
+	}
+
 	// Wait if OCR queue is full.
 	if v.OCRQueue.count() >= maxCallbackSegments+1 {
 		return nil
@@ -1336,68 +1543,58 @@ func (v *OCRTask) DriveOCRQueue(ctx context.Context) error {
 		return nil
 	}
 
-	// Read the image file and convert to base64.
-	var imageData string
-	if data, err := os.ReadFile(segment.ImageFile.File); err != nil {
-		return errors.Wrapf(err, "read image from %v", segment.ImageFile.File)
-	} else {
-		imageData = base64.StdEncoding.EncodeToString(data)
-	}
+	// Recognize the image by rules, or by the prompt of config if no rules.
+	prompt := v.config.AIChatPrompt
+	if events := v.config.Events; events != nil && len(events.Rules) > 0 {
+		segment.Results = v.recognizeRules(ctx, segment, events.Rules)
 
-	// Convert the image file to text by AI.
-	var config openai.ClientConfig
-	config = openai.DefaultConfig(v.config.AISecretKey)
-	config.BaseURL = v.config.AIBaseURL
-	config.OrgID = v.config.AIOrganization
+		var texts []string
+		for _, result := range segment.Results {
+			texts = append(texts, result.Text)
+		}
+		segment.OCRText = strings.Join(texts, "\n")
+	} else {
+		// Read the image file and convert to base64.
+		var imageData string
+		if data, err := os.ReadFile(segment.ImageFile.File); err != nil {
+			return errors.Wrapf(err, "read image from %v", segment.ImageFile.File)
+		} else {
+			imageData = base64.StdEncoding.EncodeToString(data)
+		}
 
-	prompt := v.config.AIChatPrompt
-	system := fmt.Sprintf("Keep your reply neat, limiting the reply to %v words.", v.config.AIChatMaxWords)
+		// Convert the image file to text by AI.
+		system := fmt.Sprintf("Keep your reply neat, limiting the reply to %v words.", v.config.AIChatMaxWords)
+		if content, err := chatOCRImage(ctx, &v.config, system, prompt, imageData, v.histories); err != nil {
+			return errors.Wrapf(err, "recognize image=%v", segment.ImageFile.File)
+		} else {
+			segment.OCRText = content
+		}
 
-	messages := []openai.ChatCompletionMessage{
-		{Role: openai.ChatMessageRoleSystem, Content: system},
-	}
+		// Build the historical messages.
+		if segment.OCRText != "" {
+			v.histories = append(v.histories, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
+			}, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: segment.OCRText,
+			})
 
-	messages = append(messages, v.histories...)
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser, Content: prompt,
-	})
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{
-			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{
-				Detail: openai.ImageURLDetailLow, URL: fmt.Sprintf("data:image/jpeg;base64,%v", imageData),
-			}},
-		},
-	})
+			for len(v.histories) > v.config.AIChatMaxWindow*2 {
+				v.histories = v.histories[1:]
+			}
+		}
 
-	client := openai.NewClientWithConfig(config)
-	resp, err := client.CreateChatCompletion(
-		ctx, openai.ChatCompletionRequest{
-			Model: v.config.AIChatModel, Messages: messages,
-		},
-	)
-	if err != nil {
-		return errors.Wrapf(err, "AI process, model=%v, image=%v, messages=%v, system=<%v>, prompt=<%v>",
-			v.config.AIChatModel, segment.ImageFile.File, len(messages), system, prompt,
-		)
+		segment.Results = []*OCRResult{{Prompt: prompt, Text: segment.OCRText}}
 	}
-
-	segment.OCRText = resp.Choices[0].Message.Content
 	segment.CostOCR = time.Since(starttime)
+	platformMetrics.OnAICost("ocr", segment.CostOCR)
 
-	// Build the historical messages.
-	if segment.OCRText != "" {
-		v.histories = append(v.histories, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: prompt,
-		}, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleAssistant,
-			Content: segment.OCRText,
-		})
-
-		for len(v.histories) > v.config.AIChatMaxWindow*2 {
-			v.histories = v.histories[1:]
-		}
-	}
+	// Detect the changes of results, to only callback the changed content.
+	v.updateChanged(segment.Results, v.config.Events != nil && v.config.Events.Dedup)
+	platformEvents.Publish(ctx, EventOCRResult, eventStreamURL(segment.Msg), map[string]interface{}{
+		"id": segment.ImageFile.TsID, "text": segment.OCRText, "results": segment.Results,
+	})
 
 	// Dequeue the segment from OCR queue and attach to correct queue.
 	func() {
@@ -1437,9 +1634,18 @@ func (v *OCRTask) DriveCallbackQueue(ctx context.Context) error {
 	segment := v.CallbackQueue.first()
 	starttime := time.Now()
 
-	// Do callback to notify user's service.
-	if err := callbackWorker.OnOCR(ctx, SrsActionOnOcr, v.UUID, segment.Msg, v.config.AIChatPrompt, segment.OCRText); err != nil {
-		logger.Wf(ctx, "ocr: ignore callback %v err %+v", segment.String(), err)
+	// Do callback to notify user's service, only for the changed results.
+	results := segment.Results
+	if len(results) == 0 && segment.OCRText != "" {
+		results = []*OCRResult{{Prompt: v.config.AIChatPrompt, Text: segment.OCRText, Changed: true}}
+	}
+	for _, result := range results {
+		if !result.Changed {
+			continue
+		}
+		if err := callbackWorker.OnOCR(ctx, SrsActionOnOcr, v.UUID, segment.Msg, result.Prompt, result); err != nil {
+			logger.Wf(ctx, "ocr: ignore callback %v of %v err %+v", result.String(), segment.String(), err)
+		}
 	}
 
 	segment.CostCallback = time.Since(starttime)
@@ -1498,6 +1704,9 @@ func (v *OCRTask) restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	// Detect changes from scratch, because the rules might be changed.
+	v.lastResults = nil
+
 	if v.cancel != nil {
 		v.cancel()
 	}
@@ -1519,23 +1728,13 @@ func (v *OCRTask) reset(ctx context.Context) error {
 	}
 
 	if err := func() error {
+		if err := v.dispose(ctx); err != nil {
+			return errors.Wrapf(err, "dispose")
+		}
+
 		v.lock.Lock()
 		defer v.lock.Unlock()
 
-		// Reset all queues.
-		v.LiveQueue.reset(ctx)
-		v.OCRQueue.reset(ctx)
-		v.CallbackQueue.reset(ctx)
-		v.CleanupQueue.reset(ctx)
-
-		// Reset all states.
-		v.Input = ""
-
-		// Remove previous task from redis.
-		if err := rdb.HDel(ctx, SRS_OCR_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hdel %v %v", SRS_OCR_TASK, v.UUID)
-		}
-
 		// Regenerate new UUID.
 		v.UUID = uuid.NewString()
 
@@ -1555,6 +1754,29 @@ func (v *OCRTask) reset(ctx context.Context) error {
 	return nil
 }
 
+// dispose remove all segments and files, and remove the task from redis.
+func (v *OCRTask) dispose(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Reset all queues.
+	v.LiveQueue.reset(ctx)
+	v.OCRQueue.reset(ctx)
+	v.CallbackQueue.reset(ctx)
+	v.CleanupQueue.reset(ctx)
+
+	// Reset all states.
+	v.histories = nil
+	v.lastResults = nil
+
+	// Remove previous task from redis.
+	if err := rdb.HDel(ctx, SRS_OCR_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_OCR_TASK, v.UUID)
+	}
+
+	return nil
+}
+
 func (v *OCRTask) enabled() bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -1566,15 +1788,20 @@ func (v *OCRTask) match(msg *SrsOnHlsMessage) bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
-	if v.inputStream == nil || !v.config.All {
+	if v.Stream == "" || !v.config.All {
 		return false
 	}
 
-	if v.inputStream.App != msg.App || v.inputStream.Stream != msg.Stream {
-		return false
-	}
+	return v.Stream == fmt.Sprintf("/%v/%v", msg.App, msg.Stream)
+}
+
+// updated returns the last time the task got a segment.
+func (v *OCRTask) updated() time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
 
-	return true
+	update, _ := time.Parse(time.RFC3339, v.Update)
+	return update
 }
 
 func (v *OCRTask) liveSegments() []*OCRSegment {
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOCREventConfig_Validate(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		config   *OCREventConfig
+		hasError bool
+	}{
+		{"empty", &OCREventConfig{}, false},
+		{"rules", &OCREventConfig{Interval: 10, Dedup: true, Rules: []*OCRRule{
+			{Name: "scoreboard", Region: &OCRRegion{X: 0, Y: 0.8, Width: 1, Height: 0.2},
+				Schema: map[string]string{"home": "The score of home team"}},
+		}}, false},
+		{"negative-interval", &OCREventConfig{Interval: -1}, true},
+		{"nil-rule", &OCREventConfig{Rules: []*OCRRule{nil}}, true},
+		{"invalid-region", &OCREventConfig{Rules: []*OCRRule{{Region: &OCRRegion{Width: 0, Height: 1}}}}, true},
+		{"out-of-image", &OCREventConfig{Rules: []*OCRRule{{Region: &OCRRegion{X: 0.5, Width: 0.6, Height: 1}}}}, true},
+		{"empty-field", &OCREventConfig{Rules: []*OCRRule{{Schema: map[string]string{" ": "x"}}}}, true},
+		{"path-id", &OCREventConfig{Rules: []*OCRRule{{ID: "../../data/x"}}}, true},
+		{"space-id", &OCREventConfig{Rules: []*OCRRule{{ID: "r 0"}}}, true},
+		{"duplicated-id", &OCREventConfig{Rules: []*OCRRule{{ID: "r0"}, {ID: "r0"}}}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.config.Validate(); (err != nil) != c.hasError {
+				t.Errorf("Expected error %v, got %v", c.hasError, err)
+			}
+			for _, rule := range c.config.Rules {
+				if !c.hasError && rule.ID == "" {
+					t.Errorf("Expected generated id")
+				}
+			}
+		})
+	}
+}
+
+func TestOCRRegion_CropFilter(t *testing.T) {
+	region := &OCRRegion{X: 0, Y: 0.8, Width: 1, Height: 0.2}
+	if r := region.cropFilter(); r != "crop=iw*1:ih*0.2:iw*0:ih*0.8" {
+		t.Errorf("Unexpected filter %v", r)
+	}
+}
+
+func TestSampleOCRSegment(t *testing.T) {
+	// Sample all segments if no interval.
+	if sampled, _ := sampleOCRSegment(2, 2, 0); !sampled {
+		t.Errorf("Expected sampled without interval")
+	}
+
+	// Sample the first segment, then every 10s for 4s segments.
+	var since float64
+	var samples []bool
+	for i := 0; i < 7; i++ {
+		var sampled bool
+		sampled, since = sampleOCRSegment(since, 4, 10)
+		samples = append(samples, sampled)
+	}
+	expect := []bool{true, false, false, true, false, false, true}
+	for i := range expect {
+		if samples[i] != expect[i] {
+			t.Errorf("Expected %v, got %v", expect, samples)
+			break
+		}
+	}
+}
+
+func TestParseOCRData(t *testing.T) {
+	data, text, err := parseOCRData("```json\n{\"home\": 2, \"away\": 1}\n```")
+	if err != nil {
+		t.Fatalf("parse err %+v", err)
+	}
+	if text != `{"away":1,"home":2}` || data["home"] != float64(2) {
+		t.Errorf("Unexpected data %v %v", data, text)
+	}
+
+	if _, _, err := parseOCRData("No scoreboard"); err == nil {
+		t.Errorf("Expected error for no JSON")
+	}
+}
+
+func TestOCRTask_UpdateChanged(t *testing.T) {
+	task := NewOCRTask()
+
+	results := []*OCRResult{{Rule: "r0", Text: "Score 1:0"}}
+	task.updateChanged(results, true)
+	if !results[0].Changed {
+		t.Errorf("Expected changed for first result")
+	}
+
+	// Ignore the changes of case and spaces.
+	results = []*OCRResult{{Rule: "r0", Text: " score  1:0 "}, {Rule: "r1", Text: "Score 1:0"}}
+	task.updateChanged(results, true)
+	if results[0].Changed || !results[1].Changed {
+		t.Errorf("Expected r0 unchanged and r1 changed, got %v %v", results[0].Changed, results[1].Changed)
+	}
+
+	results = []*OCRResult{{Rule: "r0", Text: "Score 2:0"}}
+	task.updateChanged(results, true)
+	if !results[0].Changed {
+		t.Errorf("Expected changed for new score")
+	}
+
+	// Always changed if dedup disabled.
+	results = []*OCRResult{{Rule: "r0", Text: "Score 2:0"}}
+	task.updateChanged(results, false)
+	if !results[0].Changed {
+		t.Errorf("Expected changed without dedup")
+	}
+}
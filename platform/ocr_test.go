@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOCRConfig_SelectStreams(t *testing.T) {
+	actives := []*SrsStream{
+		{App: "live", Stream: "a", Update: "2024-01-01T10:00:00Z"},
+		{App: "live", Stream: "b", Update: "2024-01-01T10:00:02Z"},
+		{App: "show", Stream: "c", Update: "2024-01-01T10:00:01Z"},
+	}
+
+	for _, c := range []struct {
+		name   string
+		config *OCRConfig
+		expect string
+	}{
+		{"latest", &OCRConfig{}, "/live/b"},
+		{"streams", &OCRConfig{Streams: []*OCRStreamConfig{{Stream: "/live/a"}, {Stream: "/show/c"}}}, "/live/a,/show/c"},
+		{"globs", &OCRConfig{Globs: []string{"/live/*"}}, "/live/a,/live/b"},
+		{"none", &OCRConfig{Globs: []string{"/other/*"}}, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if r := strings.Join(c.config.selectStreams(actives), ","); r != c.expect {
+				t.Errorf("Expected %v, got %v", c.expect, r)
+			}
+		})
+	}
+
+	latest := &OCRConfig{}
+	if !latest.keepTask("/live/a", nil) || latest.keepTask("/live/a", []string{"/live/b"}) {
+		t.Errorf("Expected only keep task of latest stream")
+	}
+}
+
+func TestOCRConfig_ApplyStream(t *testing.T) {
+	events := &OCREventConfig{Dedup: true}
+	config := &OCRConfig{
+		SrsAssistantChat: SrsAssistantChat{AIChatModel: "gpt-4o", AIChatPrompt: "Describe the image"},
+		Streams: []*OCRStreamConfig{
+			{Stream: "/live/a", Chat: &SrsAssistantChat{AIChatModel: "gpt-4o-mini", AIChatPrompt: "Read the score"}},
+			{Stream: "/live/b", Events: events},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected valid, got %v", err)
+	}
+
+	a := *config
+	a.applyStream("/live/a")
+	if a.AIChatModel != "gpt-4o-mini" || a.AIChatPrompt != "Read the score" || a.Events != nil {
+		t.Errorf("Expected chat of stream, got %v", a.String())
+	}
+
+	b := *config
+	b.applyStream("/live/b")
+	if b.AIChatModel != "gpt-4o" || b.Events != events {
+		t.Errorf("Expected global chat and events of stream, got %v", b.String())
+	}
+
+	for _, c := range []*OCRConfig{
+		{Streams: []*OCRStreamConfig{{Stream: "live/a"}}},
+		{Streams: []*OCRStreamConfig{nil}},
+		{Streams: []*OCRStreamConfig{{Stream: "/live/a", Events: &OCREventConfig{Interval: -1}}}},
+		{Globs: []string{"/live/["}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected invalid for %v", c.String())
+		}
+	}
+}
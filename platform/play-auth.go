@@ -0,0 +1,284 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	// From ossrs.
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The query parameter of play token, for example, rtmp://ip/live/livestream?token=xxx
+const playAuthTokenParam = "token"
+
+// buildPlayToken sign the stream with key, the token is {expire}-{signature}. The stream URL is app/stream
+// without vhost, for example, live/livestream. The ip is optional, to bind the token to a client.
+func buildPlayToken(key, streamURL string, expire time.Time, ip string) string {
+	return fmt.Sprintf("%v-%v", expire.Unix(), signPlayToken(key, streamURL, expire.Unix(), ip))
+}
+
+// derivePlayToken sign the token of target stream by targetKey, with the same expire and ip binding of the
+// verified token of stream, for example, the renditions of transcode master m3u8, because the token is bound
+// to the stream.
+func derivePlayToken(key, streamURL, token, ip, targetKey, target string) (string, error) {
+	index := strings.Index(token, "-")
+	if index <= 0 {
+		return "", errors.Errorf("invalid token %v", token)
+	}
+
+	expire, err := strconv.ParseInt(token[:index], 10, 64)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse expire of %v", token)
+	}
+
+	// The token is not bound to ip, if matches the signature of empty ip.
+	if hmac.Equal([]byte(token[index+1:]), []byte(signPlayToken(key, streamURL, expire, ""))) {
+		ip = ""
+	}
+	return buildPlayToken(targetKey, target, time.Unix(expire, 0), ip), nil
+}
+
+// signPlayToken generate the HMAC-SHA256 signature for stream, expire and ip.
+func signPlayToken(key, streamURL string, expire int64, ip string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(fmt.Sprintf("play\n%v\n%v\n%v", streamURL, expire, ip)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyPlayToken verify the token of stream, which should not be expired, and should match the ip of client
+// if bound to the ip.
+func verifyPlayToken(key, streamURL, token, ip string, now time.Time) error {
+	index := strings.Index(token, "-")
+	if index <= 0 {
+		return errors.Errorf("invalid token %v", token)
+	}
+
+	expire, err := strconv.ParseInt(token[:index], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "parse expire of %v", token)
+	}
+	if now.Unix() > expire {
+		return errors.Errorf("token expired at %v", time.Unix(expire, 0).Format(time.RFC3339))
+	}
+
+	// The token might not bind to ip, so we try the empty ip first.
+	signature := []byte(token[index+1:])
+	for _, candidate := range []string{"", ip} {
+		if hmac.Equal(signature, []byte(signPlayToken(key, streamURL, expire, candidate))) {
+			return nil
+		}
+		if ip == "" {
+			break
+		}
+	}
+	return errors.Errorf("invalid signature of stream %v, ip=%v", streamURL, ip)
+}
+
+// loadPlayAuthKey load the key to sign the play token of stream. Use the secret of live room if the stream
+// is a room stream, or use the api secret.
+func loadPlayAuthKey(ctx context.Context, stream string) (string, error) {
+	roomPublishAuthKey := GenerateRoomPublishKey(stream)
+	if secret, err := rdb.HGet(ctx, SRS_AUTH_SECRET, roomPublishAuthKey).Result(); err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v %v", SRS_AUTH_SECRET, roomPublishAuthKey)
+	} else if secret != "" {
+		return secret, nil
+	}
+
+	apiSecret := envApiSecret()
+	if apiSecret == "" {
+		return "", errors.New("no api secret")
+	}
+	return apiSecret, nil
+}
+
+// isPlayAuthEnabled whether the play authentication is enabled.
+func isPlayAuthEnabled(ctx context.Context) (bool, error) {
+	if playAuth, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "playAuth").Result(); err != nil && err != redis.Nil {
+		return false, errors.Wrapf(err, "hget %v playAuth", SRS_AUTH_SECRET)
+	} else {
+		return playAuth == "true", nil
+	}
+}
+
+// verifyPlayAuth verify the play token in query of stream, if play authentication is enabled. Note that the
+// loopback clients are always allowed, because the FFmpeg tasks play streams from localhost, while the HTTP
+// and WebRTC players are verified by the proxy without the exemption, see verifyPlayAuthRequest.
+func verifyPlayAuth(ctx context.Context, app, stream, query, ip string) error {
+	if enabled, err := isPlayAuthEnabled(ctx); err != nil {
+		return errors.Wrapf(err, "query play auth")
+	} else if !enabled {
+		return nil
+	}
+
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+		return nil
+	}
+
+	if _, err := verifyPlayAuthToken(ctx, app, stream, query, ip); err != nil {
+		return err
+	}
+	return nil
+}
+
+// verifyPlayAuthToken verify the play token in query of stream, and returns the verified token.
+func verifyPlayAuthToken(ctx context.Context, app, stream, query, ip string) (string, error) {
+	q, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil {
+		return "", errors.Wrapf(err, "parse query %v", query)
+	}
+
+	token := q.Get(playAuthTokenParam)
+	if token == "" {
+		return "", errors.Errorf("no token for stream %v/%v", app, stream)
+	}
+
+	key, err := loadPlayAuthKey(ctx, stream)
+	if err != nil {
+		return "", errors.Wrapf(err, "load key")
+	}
+
+	streamURL := fmt.Sprintf("%v/%v", app, stream)
+	if err := verifyPlayToken(key, streamURL, token, ip, time.Now()); err != nil {
+		return "", errors.Wrapf(err, "verify token")
+	}
+
+	logger.Tf(ctx, "play auth ok, stream=%v, ip=%v", streamURL, ip)
+	return token, nil
+}
+
+// parsePlayAuthRequest parse the app and stream of HTTP-FLV, HLS m3u8 and ts, or WHEP request. The ts file is
+// named as [app]/[stream]-[seq]-[timestamp].ts, see srsGenerateConfig. Returns empty if not a play request.
+func parsePlayAuthRequest(r *http.Request) (app, stream string) {
+	if strings.HasPrefix(r.URL.Path, "/rtc/v1/whep/") || strings.HasPrefix(r.URL.Path, "/rtc/v1/play/") {
+		return r.URL.Query().Get("app"), r.URL.Query().Get("stream")
+	}
+
+	ext := path.Ext(r.URL.Path)
+	if ext != ".flv" && ext != ".m3u8" && ext != ".ts" {
+		return "", ""
+	}
+
+	dir, name := path.Split(strings.TrimSuffix(path.Clean(r.URL.Path), ext))
+	if ext == ".ts" {
+		for i := 0; i < 2; i++ {
+			index := strings.LastIndex(name, "-")
+			if index <= 0 {
+				break
+			}
+			if _, err := strconv.ParseInt(name[index+1:], 10, 64); err != nil {
+				break
+			}
+			name = name[:index]
+		}
+	}
+	return path.Base(dir), name
+}
+
+// playAuthClientIP returns the IP of client. The X-Real-IP is only trusted when the request is from the local
+// nginx, which always overwrites the header by proxy_set_header.
+func playAuthClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			ip = realIP
+		}
+	}
+	return ip
+}
+
+// verifyPlayAuthRequest verify the play token of HTTP-FLV, HLS or WHEP request, which is proxied to SRS from
+// localhost, so SRS hooks never know the client ip. Note that all requests are from the local nginx, so the
+// loopback clients are never allowed here. Returns the verified token, empty if not verified.
+func verifyPlayAuthRequest(ctx context.Context, r *http.Request) (string, error) {
+	app, stream := parsePlayAuthRequest(r)
+	if app == "" || stream == "" {
+		return "", nil
+	}
+
+	if enabled, err := isPlayAuthEnabled(ctx); err != nil {
+		return "", errors.Wrapf(err, "query play auth")
+	} else if !enabled {
+		return "", nil
+	}
+
+	token, err := verifyPlayAuthToken(ctx, app, stream, r.URL.RawQuery, playAuthClientIP(r))
+	if err != nil {
+		return "", newHTTPStatusError(http.StatusUnauthorized, errors.Wrapf(err, "play auth %v", r.URL.Path))
+	}
+	return token, nil
+}
+
+// appendPlayAuthToken append the play token to the ts URLs of m3u8, because the players never carry the query
+// of m3u8 to the ts files, for example, livestream-1-123.ts to livestream-1-123.ts?token=xxx.
+func appendPlayAuthToken(m3u8, token string) string {
+	lines := strings.Split(m3u8, "\n")
+	for i, line := range lines {
+		if line = strings.TrimRight(line, "\r"); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		separator := "?"
+		if strings.Contains(line, "?") {
+			separator = "&"
+		}
+		lines[i] = fmt.Sprintf("%v%v%v=%v", line, separator, playAuthTokenParam, url.QueryEscape(token))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// playAuthM3u8Writer buffers the m3u8 response, to append the play token to the ts URLs, see
+// appendPlayAuthToken. Note that only the response of 200 is rewritten.
+type playAuthM3u8Writer struct {
+	http.ResponseWriter
+	token  string
+	status int
+	body   bytes.Buffer
+}
+
+func newPlayAuthM3u8Writer(w http.ResponseWriter, token string) *playAuthM3u8Writer {
+	return &playAuthM3u8Writer{ResponseWriter: w, token: token, status: http.StatusOK}
+}
+
+func (v *playAuthM3u8Writer) WriteHeader(status int) {
+	v.status = status
+}
+
+func (v *playAuthM3u8Writer) Write(b []byte) (int, error) {
+	return v.body.Write(b)
+}
+
+// Close write the rewritten response to client.
+func (v *playAuthM3u8Writer) Close() error {
+	body := v.body.String()
+	if v.status == http.StatusOK {
+		body = appendPlayAuthToken(body, v.token)
+		v.ResponseWriter.Header().Del("Content-Length")
+		v.ResponseWriter.Header().Del("Last-Modified")
+		v.ResponseWriter.Header().Del("ETag")
+	}
+
+	v.ResponseWriter.WriteHeader(v.status)
+	_, err := v.ResponseWriter.Write([]byte(body))
+	return err
+}
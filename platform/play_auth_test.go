@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPlayToken_Verify(t *testing.T) {
+	key, now := "test-secret", time.Now()
+	expire := now.Add(time.Hour)
+
+	// The token without ip binding, allow any client.
+	token := buildPlayToken(key, "live/livestream", expire, "")
+	if err := verifyPlayToken(key, "live/livestream", token, "192.168.1.2", now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+	if err := verifyPlayToken(key, "live/livestream", token, "", now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+
+	// The token is bound to the stream and key.
+	if err := verifyPlayToken(key, "live/other", token, "", now); err == nil {
+		t.Errorf("Expected error for other stream")
+	}
+	if err := verifyPlayToken("other-secret", "live/livestream", token, "", now); err == nil {
+		t.Errorf("Expected error for other key")
+	}
+
+	// The token is expired.
+	if err := verifyPlayToken(key, "live/livestream", token, "", expire.Add(time.Second)); err == nil {
+		t.Errorf("Expected error for expired token")
+	}
+
+	// Should not change the expire of token.
+	tampered := buildPlayToken(key, "live/livestream", now.Add(-time.Hour), "")
+	tampered = token[:len(token)-64] + tampered[len(tampered)-64:]
+	if err := verifyPlayToken(key, "live/livestream", tampered, "", now); err == nil {
+		t.Errorf("Expected error for tampered token")
+	}
+
+	// The token with ip binding, only allow the client.
+	token = buildPlayToken(key, "live/livestream", expire, "192.168.1.2")
+	if err := verifyPlayToken(key, "live/livestream", token, "192.168.1.2", now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+	if err := verifyPlayToken(key, "live/livestream", token, "192.168.1.3", now); err == nil {
+		t.Errorf("Expected error for other ip")
+	}
+	if err := verifyPlayToken(key, "live/livestream", token, "", now); err == nil {
+		t.Errorf("Expected error for no ip")
+	}
+
+	// The invalid tokens.
+	for _, token := range []string{"", "-", "abc-def", "123"} {
+		if err := verifyPlayToken(key, "live/livestream", token, "", now); err == nil {
+			t.Errorf("Expected error for %v", token)
+		}
+	}
+}
+
+func TestPlayToken_Derive(t *testing.T) {
+	key, targetKey, now := "test-secret", "room-secret", time.Now()
+	expire := now.Add(time.Hour)
+
+	// The derived token keeps the expire, and is only valid for the target stream.
+	token := buildPlayToken(key, "live/livestream", expire, "")
+	derived, err := derivePlayToken(key, "live/livestream", token, "192.168.1.2", targetKey, "live/livestream_720p")
+	if err != nil {
+		t.Fatalf("Derive token err %+v", err)
+	}
+	if err := verifyPlayToken(targetKey, "live/livestream_720p", derived, "10.0.0.1", now); err != nil {
+		t.Errorf("Expected valid token without ip, got %v", err)
+	}
+	if err := verifyPlayToken(targetKey, "live/livestream_720p", derived, "", now.Add(2*time.Hour)); err == nil {
+		t.Errorf("Expected error for expired token")
+	}
+
+	// The derived token is bound to the ip, if the token is bound.
+	token = buildPlayToken(key, "live/livestream", expire, "192.168.1.2")
+	derived, err = derivePlayToken(key, "live/livestream", token, "192.168.1.2", targetKey, "live/livestream_720p")
+	if err != nil {
+		t.Fatalf("Derive token err %+v", err)
+	}
+	if err := verifyPlayToken(targetKey, "live/livestream_720p", derived, "192.168.1.2", now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+	if err := verifyPlayToken(targetKey, "live/livestream_720p", derived, "10.0.0.1", now); err == nil {
+		t.Errorf("Expected error for other ip")
+	}
+
+	if _, err := derivePlayToken(key, "live/livestream", "invalid", "", targetKey, "live/livestream_720p"); err == nil {
+		t.Errorf("Expected error for invalid token")
+	}
+}
+
+func TestPlayAuth_ParseRequest(t *testing.T) {
+	for u, want := range map[string]string{
+		"/live/livestream.flv":                         "live/livestream",
+		"/live/livestream.m3u8?token=xxx":              "live/livestream",
+		"/live/livestream-12-1700000000000.ts?token=x": "live/livestream",
+		"/live/my-stream-12-1700000000000.ts":          "live/my-stream",
+		"/live/my-stream.ts":                           "live/my-stream",
+		"/rtc/v1/whep/?app=live&stream=livestream":     "live/livestream",
+		"/live/livestream.mp4":                         "/",
+		"/console/index.html":                          "/",
+	} {
+		r := httptest.NewRequest("GET", u, nil)
+		if app, stream := parsePlayAuthRequest(r); app+"/"+stream != want {
+			t.Errorf("parse %v got %v/%v, want %v", u, app, stream, want)
+		}
+	}
+}
+
+func TestPlayAuth_ClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/live/livestream.flv", nil)
+	r.RemoteAddr, r.Header["X-Real-Ip"] = "127.0.0.1:1234", []string{"10.0.0.1"}
+	if ip := playAuthClientIP(r); ip != "10.0.0.1" {
+		t.Errorf("expect X-Real-IP from local nginx, got %v", ip)
+	}
+
+	// Never trust the header from remote client.
+	r.RemoteAddr = "192.168.1.2:1234"
+	if ip := playAuthClientIP(r); ip != "192.168.1.2" {
+		t.Errorf("expect remote addr, got %v", ip)
+	}
+}
+
+func TestPlayAuth_M3u8Writer(t *testing.T) {
+	m3u8 := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\nlivestream-1-100.ts\n" +
+		"#EXTINF:10.000,\nlivestream-2-200.ts?hls_ctx=abc\n"
+	want := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\nlivestream-1-100.ts?token=1-a%2Bb\n" +
+		"#EXTINF:10.000,\nlivestream-2-200.ts?hls_ctx=abc&token=1-a%2Bb\n"
+
+	w := httptest.NewRecorder()
+	mw := newPlayAuthM3u8Writer(w, "1-a+b")
+	mw.Header().Set("Content-Length", "100")
+	mw.Write([]byte(m3u8))
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close err %v", err)
+	}
+	if got := w.Body.String(); got != want || w.Header().Get("Content-Length") != "" {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Never rewrite the error response.
+	w = httptest.NewRecorder()
+	mw = newPlayAuthM3u8Writer(w, "1-a+b")
+	mw.WriteHeader(404)
+	mw.Write([]byte("not found\n"))
+	mw.Close()
+	if w.Code != 404 || w.Body.String() != "not found\n" {
+		t.Errorf("unexpected code=%v, body=%q", w.Code, w.Body.String())
+	}
+}
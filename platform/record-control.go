@@ -0,0 +1,449 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// RecordControlAction is the manual action to control the record of a stream.
+type RecordControlAction string
+
+const (
+	// Start to record the stream immediately, ignore the schedules and patterns.
+	RecordControlStart RecordControlAction = "start"
+	// Stop to record the stream immediately, ignore the schedules and patterns.
+	RecordControlStop RecordControlAction = "stop"
+	// Remove the manual control, and use the schedules and patterns.
+	RecordControlAuto RecordControlAction = "auto"
+)
+
+// RecordControl is the manual control of a stream, which overwrites the schedules and patterns.
+type RecordControl struct {
+	// The stream URL, such as /live/livestream
+	Stream string `json:"stream"`
+	// The manual action, start or stop.
+	Action RecordControlAction `json:"action"`
+	// The update time.
+	Update string `json:"update"`
+}
+
+func (v *RecordControl) String() string {
+	return fmt.Sprintf("stream=%v, action=%v, update=%v", v.Stream, v.Action, v.Update)
+}
+
+// RecordSchedule is a time window to record the streams, for example, record /live/* from 20:00 to 22:00 on
+// weekdays. The window crosses midnight if end is not after start, for example, from 23:00 to 01:00.
+type RecordSchedule struct {
+	// The id of schedule, generated if empty.
+	ID string `json:"id"`
+	// The name of schedule, for display.
+	Name string `json:"name"`
+	// Whether schedule is enabled.
+	Enabled bool `json:"enabled"`
+	// The glob filter of stream URL, such as /live/*
+	Stream string `json:"stream"`
+	// The local time of day to start and end, for example, 20:00 and 22:00.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// The days of week to start, 0 is Sunday, empty for daily.
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+}
+
+func (v *RecordSchedule) String() string {
+	return fmt.Sprintf("id=%v, name=%v, enabled=%v, stream=%v, start=%v, end=%v, weekdays=%v",
+		v.ID, v.Name, v.Enabled, v.Stream, v.Start, v.End, v.Weekdays)
+}
+
+func (v *RecordSchedule) Validate() error {
+	if v.ID == "" {
+		v.ID = uuid.NewString()
+	}
+	if !strings.HasPrefix(v.Stream, "/") {
+		return errors.Errorf("invalid stream %v", v.Stream)
+	}
+	if _, err := path.Match(v.Stream, "/"); err != nil {
+		return errors.Wrapf(err, "invalid stream %v", v.Stream)
+	}
+
+	start, err := time.Parse("15:04", v.Start)
+	if err != nil {
+		return errors.Wrapf(err, "parse start %v", v.Start)
+	}
+	end, err := time.Parse("15:04", v.End)
+	if err != nil {
+		return errors.Wrapf(err, "parse end %v", v.End)
+	}
+	if start.Equal(end) {
+		return errors.Errorf("empty window %v to %v", v.Start, v.End)
+	}
+
+	for _, weekday := range v.Weekdays {
+		if weekday < time.Sunday || weekday > time.Saturday {
+			return errors.Errorf("invalid weekday %v", weekday)
+		}
+	}
+	return nil
+}
+
+// scheduled returns whether the window is scheduled to start at the day of t.
+func (v *RecordSchedule) scheduled(t time.Time) bool {
+	if len(v.Weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range v.Weekdays {
+		if weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns whether now is in the window of schedule.
+func (v *RecordSchedule) Active(now time.Time) bool {
+	start, err := time.Parse("15:04", v.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", v.End)
+	if err != nil {
+		return false
+	}
+
+	at := func(t time.Time) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	}
+	startAt, endAt := at(start), at(end)
+
+	// The window in the same day.
+	if startAt.Before(endAt) {
+		return v.scheduled(now) && !now.Before(startAt) && now.Before(endAt)
+	}
+
+	// The window crosses midnight, which is started today, or started yesterday.
+	if !now.Before(startAt) {
+		return v.scheduled(now)
+	}
+	return now.Before(endAt) && v.scheduled(now.AddDate(0, 0, -1))
+}
+
+// decideRecord returns whether to record the stream, and the reason. The manual control has the highest
+// priority, then the schedules, and the patterns of all and globs at last.
+func decideRecord(
+	streamURL string, control *RecordControl, schedules []*RecordSchedule, all bool, globs []string,
+	now time.Time,
+) (bool, string) {
+	if control != nil {
+		switch control.Action {
+		case RecordControlStart:
+			return true, "manual start"
+		case RecordControlStop:
+			return false, "manual stop"
+		}
+	}
+
+	for _, schedule := range schedules {
+		if !schedule.Enabled {
+			continue
+		}
+		if ok, err := path.Match(schedule.Stream, streamURL); err != nil || !ok {
+			continue
+		}
+		if schedule.Active(now) {
+			return true, fmt.Sprintf("schedule %v", schedule.ID)
+		}
+	}
+
+	if !all {
+		return false, "disabled"
+	}
+
+	// If glob filters are empty, ignore it, and record all streams.
+	if len(globs) == 0 {
+		return true, "all"
+	}
+	for _, glob := range globs {
+		if ok, err := path.Match(glob, streamURL); err == nil && ok {
+			return true, fmt.Sprintf("glob %v", glob)
+		}
+	}
+	return false, "no glob matched"
+}
+
+// loadRecordSchedules load all schedules from redis.
+func loadRecordSchedules(ctx context.Context) ([]*RecordSchedule, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_RECORD_SCHEDULES).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_RECORD_SCHEDULES)
+	}
+
+	schedules := []*RecordSchedule{}
+	for id, value := range objs {
+		var schedule RecordSchedule
+		if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, value)
+		}
+		schedules = append(schedules, &schedule)
+	}
+	return schedules, nil
+}
+
+// loadRecordControls load all manual controls from redis.
+func loadRecordControls(ctx context.Context) ([]*RecordControl, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_RECORD_CONTROL).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_RECORD_CONTROL)
+	}
+
+	controls := []*RecordControl{}
+	for stream, value := range objs {
+		var control RecordControl
+		if err := json.Unmarshal([]byte(value), &control); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", stream, value)
+		}
+		controls = append(controls, &control)
+	}
+	return controls, nil
+}
+
+// recordRules is the manual controls, schedules and patterns to decide whether to record a stream, which is
+// cached by RecordWorker and reset when changed.
+type recordRules struct {
+	// The manual controls, key is stream URL, such as /live/livestream
+	controls  map[string]*RecordControl
+	schedules []*RecordSchedule
+	// Whether record all streams, filtered by globs.
+	all   bool
+	globs []string
+}
+
+// loadRecordRules load the manual controls, schedules and patterns from redis.
+func loadRecordRules(ctx context.Context) (*recordRules, error) {
+	rules := &recordRules{controls: make(map[string]*RecordControl)}
+
+	controls, err := loadRecordControls(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load controls")
+	}
+	for _, control := range controls {
+		rules.controls[control.Stream] = control
+	}
+
+	if rules.schedules, err = loadRecordSchedules(ctx); err != nil {
+		return nil, errors.Wrapf(err, "load schedules")
+	}
+
+	all, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v all", SRS_RECORD_PATTERNS)
+	}
+	rules.all = all == "true"
+
+	if value, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "globs").Result(); err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v globs", SRS_RECORD_PATTERNS)
+	} else if value != "" {
+		if err := json.Unmarshal([]byte(value), &rules.globs); err != nil {
+			return nil, errors.Wrapf(err, "parse %v", value)
+		}
+	}
+	return rules, nil
+}
+
+// decide returns whether to record the stream at now, and the reason.
+func (v *recordRules) decide(app, stream string, now time.Time) (bool, string) {
+	streamURL := fmt.Sprintf("/%v/%v", app, stream)
+	return decideRecord(streamURL, v.controls[streamURL], v.schedules, v.all, v.globs, now)
+}
+
+// queryRules returns the cached record rules, load from redis if not cached. Note that the lock is not held
+// when loading, and the loaded rules are not cached if reset while loading, because they might be stale.
+func (v *RecordWorker) queryRules(ctx context.Context) (*recordRules, error) {
+	v.rulesLock.Lock()
+	rules, version := v.rules, v.rulesVersion
+	v.rulesLock.Unlock()
+
+	if rules != nil {
+		return rules, nil
+	}
+
+	rules, err := loadRecordRules(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load rules")
+	}
+
+	v.rulesLock.Lock()
+	defer v.rulesLock.Unlock()
+	if v.rulesVersion == version {
+		v.rules = rules
+	}
+	return rules, nil
+}
+
+// resetRules drop the cached record rules, for example, updated by user.
+func (v *RecordWorker) resetRules() {
+	v.rulesLock.Lock()
+	defer v.rulesLock.Unlock()
+
+	v.rules = nil
+	v.rulesVersion++
+}
+
+// shouldRecord returns whether to record the stream now, by manual control, schedules and patterns.
+func (v *RecordWorker) shouldRecord(ctx context.Context, app, stream string) (bool, string, error) {
+	rules, err := v.queryRules(ctx)
+	if err != nil {
+		return false, "", errors.Wrapf(err, "query rules")
+	}
+
+	ok, reason := rules.decide(app, stream, time.Now())
+	return ok, reason, nil
+}
+
+// handleControl handle the API for manual control and schedules of record.
+func (v *RecordWorker) handleControl(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/hooks/record/control"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, stream string
+			var action RecordControlAction
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string              `json:"token"`
+				Stream *string              `json:"stream"`
+				Action *RecordControlAction `json:"action"`
+			}{
+				Token: &token, Stream: &stream, Action: &action,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeRecordWrite); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if parts := strings.Split(stream, "/"); len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+				return errors.Errorf("invalid stream %v, should be /app/stream", stream)
+			}
+
+			control := &RecordControl{Stream: stream, Action: action, Update: time.Now().Format(time.RFC3339)}
+			switch action {
+			case RecordControlStart, RecordControlStop:
+				if b, err := json.Marshal(control); err != nil {
+					return errors.Wrapf(err, "marshal %v", control.String())
+				} else if err := rdb.HSet(ctx, SRS_RECORD_CONTROL, stream, string(b)).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v %v %v", SRS_RECORD_CONTROL, stream, string(b))
+				}
+			case RecordControlAuto:
+				if err := rdb.HDel(ctx, SRS_RECORD_CONTROL, stream).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_RECORD_CONTROL, stream)
+				}
+			default:
+				return errors.Errorf("invalid action %v", action)
+			}
+
+			v.resetRules()
+
+			// Note that the recording task is ended by expired check, if the stream is no longer to record.
+			ohttp.WriteData(ctx, w, r, control)
+			logger.Tf(ctx, "record control ok, %v, token=%vB", control.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/record/schedules"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var schedules *[]*RecordSchedule
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string             `json:"token"`
+				Schedules **[]*RecordSchedule `json:"schedules"`
+			}{
+				Token: &token, Schedules: &schedules,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			// Only query the schedules requires the read scope.
+			scope := ScopeRecordRead
+			if schedules != nil {
+				scope = ScopeRecordWrite
+			}
+
+			apiSecret := envApiSecret()
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, scope); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Replace all schedules if specified.
+			if schedules != nil {
+				ids := make(map[string]bool)
+				for _, schedule := range *schedules {
+					if schedule == nil {
+						return errors.New("empty schedule")
+					}
+					if err := schedule.Validate(); err != nil {
+						return errors.Wrapf(err, "validate %v", schedule.String())
+					}
+					if ids[schedule.ID] {
+						return errors.Errorf("duplicated id %v", schedule.ID)
+					}
+					ids[schedule.ID] = true
+				}
+
+				if err := rdb.Del(ctx, SRS_RECORD_SCHEDULES).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "del %v", SRS_RECORD_SCHEDULES)
+				}
+				for _, schedule := range *schedules {
+					if b, err := json.Marshal(schedule); err != nil {
+						return errors.Wrapf(err, "marshal %v", schedule.String())
+					} else if err := rdb.HSet(ctx, SRS_RECORD_SCHEDULES, schedule.ID, string(b)).Err(); err != nil && err != redis.Nil {
+						return errors.Wrapf(err, "hset %v %v %v", SRS_RECORD_SCHEDULES, schedule.ID, string(b))
+					}
+				}
+				v.resetRules()
+			}
+
+			loaded, err := loadRecordSchedules(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load schedules")
+			}
+
+			controls, err := loadRecordControls(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load controls")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Schedules []*RecordSchedule `json:"schedules"`
+				Controls  []*RecordControl  `json:"controls"`
+			}{
+				Schedules: loaded, Controls: controls,
+			})
+			logger.Tf(ctx, "record schedules ok, update=%v, schedules=%v, controls=%v, token=%vB",
+				schedules != nil, len(loaded), len(controls), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
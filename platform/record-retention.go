@@ -0,0 +1,373 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The interval to sweep the records by retention policy.
+const recordRetentionInterval = 60 * time.Second
+
+// RecordLowDiskAction is the action when the free disk is lower than the watermark.
+type RecordLowDiskAction string
+
+const (
+	// Evict the oldest records, until the free disk is enough.
+	RecordLowDiskEvict RecordLowDiskAction = "evict"
+	// Reject the new recordings, but keep the existing records.
+	RecordLowDiskReject RecordLowDiskAction = "reject"
+)
+
+// RecordRetention is the retention policy for local records, all limits are disabled when zero.
+type RecordRetention struct {
+	// The max age in seconds of record.
+	MaxAge int64 `json:"maxAge"`
+	// The max total bytes of all records.
+	MaxBytes uint64 `json:"maxBytes"`
+	// The max number of records per stream.
+	MaxCount int `json:"maxCount"`
+	// The low watermark of free disk in bytes.
+	MinFree uint64 `json:"minFree"`
+	// The action when the free disk is lower than the watermark, evict or reject.
+	LowDisk RecordLowDiskAction `json:"lowDisk"`
+}
+
+func (v *RecordRetention) String() string {
+	return fmt.Sprintf("maxAge=%v, maxBytes=%v, maxCount=%v, minFree=%v, lowDisk=%v",
+		v.MaxAge, v.MaxBytes, v.MaxCount, v.MinFree, v.LowDisk)
+}
+
+func (v *RecordRetention) Validate() error {
+	if v.MaxAge < 0 || v.MaxCount < 0 {
+		return errors.Errorf("invalid maxAge=%v, maxCount=%v", v.MaxAge, v.MaxCount)
+	}
+	if v.LowDisk != "" && v.LowDisk != RecordLowDiskEvict && v.LowDisk != RecordLowDiskReject {
+		return errors.Errorf("invalid lowDisk %v", v.LowDisk)
+	}
+	return nil
+}
+
+func (v *RecordRetention) Load(ctx context.Context) error {
+	if config, err := rdb.HGet(ctx, SRS_RECORD_RETENTION, "config").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v config", SRS_RECORD_RETENTION)
+	} else if config != "" {
+		if err = json.Unmarshal([]byte(config), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", config)
+		}
+	}
+	return nil
+}
+
+func (v *RecordRetention) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_RECORD_RETENTION, "config", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v config %v", SRS_RECORD_RETENTION, string(b))
+	}
+	return nil
+}
+
+// lowDisk returns whether the free disk is lower than the watermark.
+func (v *RecordRetention) lowDisk(free uint64) bool {
+	return v.MinFree > 0 && free < v.MinFree
+}
+
+// RecordEviction is a record to remove by retention policy.
+type RecordEviction struct {
+	// The record artifact.
+	Artifact *M3u8VoDArtifact
+	// The size in bytes of record.
+	Size uint64
+	// The reason to evict, age, count, quota or disk.
+	Reason string
+}
+
+// planRecordRetention returns the records to evict by the policy, the size function returns the bytes of a
+// record, and free is the free bytes of disk. Note that the processing records are never evicted.
+func planRecordRetention(
+	policy *RecordRetention, artifacts []*M3u8VoDArtifact, sizeOf func(*M3u8VoDArtifact) uint64,
+	free uint64, now time.Time,
+) []*RecordEviction {
+	type record struct {
+		artifact *M3u8VoDArtifact
+		update   time.Time
+		size     uint64
+	}
+
+	// Sort the finished records by update time, the oldest first.
+	var records []*record
+	for _, artifact := range artifacts {
+		if artifact.Processing {
+			continue
+		}
+		update, _ := time.Parse(time.RFC3339, artifact.Update)
+		records = append(records, &record{artifact: artifact, update: update, size: sizeOf(artifact)})
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].update.Before(records[j].update)
+	})
+
+	var evictions []*RecordEviction
+	evicted := make(map[*record]bool)
+	evict := func(r *record, reason string) {
+		if !evicted[r] {
+			evicted[r] = true
+			evictions = append(evictions, &RecordEviction{Artifact: r.artifact, Size: r.size, Reason: reason})
+		}
+	}
+
+	// Evict the records which are too old.
+	if policy.MaxAge > 0 {
+		for _, r := range records {
+			if now.Sub(r.update) > time.Duration(policy.MaxAge)*time.Second {
+				evict(r, "age")
+			}
+		}
+	}
+
+	// Evict the oldest records of each stream, keep the newest ones.
+	if policy.MaxCount > 0 {
+		streams := make(map[string][]*record)
+		for _, r := range records {
+			if !evicted[r] {
+				streamURL := fmt.Sprintf("%v/%v/%v", r.artifact.Vhost, r.artifact.App, r.artifact.Stream)
+				streams[streamURL] = append(streams[streamURL], r)
+			}
+		}
+		for _, rs := range streams {
+			for i := 0; i < len(rs)-policy.MaxCount; i++ {
+				evict(rs[i], "count")
+			}
+		}
+	}
+
+	// Evict the oldest records, until the total size is under quota.
+	if policy.MaxBytes > 0 {
+		var total uint64
+		for _, r := range records {
+			if !evicted[r] {
+				total += r.size
+			}
+		}
+		for _, r := range records {
+			if total <= policy.MaxBytes {
+				break
+			}
+			if !evicted[r] {
+				total -= r.size
+				evict(r, "quota")
+			}
+		}
+	}
+
+	// Evict the oldest records, until the free disk is enough.
+	if policy.LowDisk == RecordLowDiskEvict && policy.lowDisk(free) {
+		for _, e := range evictions {
+			free += e.Size
+		}
+		for _, r := range records {
+			if !policy.lowDisk(free) {
+				break
+			}
+			if !evicted[r] {
+				free += r.size
+				evict(r, "disk")
+			}
+		}
+	}
+
+	return evictions
+}
+
+// RecordRetentionReport is the report of sweeper.
+type RecordRetentionReport struct {
+	// The last sweep time.
+	Update string `json:"update"`
+	// The number of records and bytes removed by last sweep.
+	Removed   int    `json:"removed"`
+	Reclaimed uint64 `json:"reclaimed"`
+	// The total number of records and bytes removed.
+	TotalRemoved   int    `json:"totalRemoved"`
+	TotalReclaimed uint64 `json:"totalReclaimed"`
+	// The free bytes of disk.
+	Free uint64 `json:"free"`
+	// Whether reject new recordings for low disk.
+	Rejecting bool `json:"rejecting"`
+	// The reasons of evictions by last sweep, the key is the reason, the value is the number of records.
+	Reasons map[string]int `json:"reasons,omitempty"`
+}
+
+func (v *RecordRetentionReport) Load(ctx context.Context) error {
+	if report, err := rdb.HGet(ctx, SRS_RECORD_RETENTION, "report").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v report", SRS_RECORD_RETENTION)
+	} else if report != "" {
+		if err = json.Unmarshal([]byte(report), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", report)
+		}
+	}
+	return nil
+}
+
+func (v *RecordRetentionReport) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal report")
+	} else if err = rdb.HSet(ctx, SRS_RECORD_RETENTION, "report", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v report %v", SRS_RECORD_RETENTION, string(b))
+	}
+	return nil
+}
+
+// recordDiskStat returns the total and free bytes of disk for records.
+func recordDiskStat() (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs("record", &stat); err != nil {
+		return 0, 0, errors.Wrapf(err, "statfs record")
+	}
+	return stat.Blocks * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// recordArtifactSize returns the bytes of record on disk, including ts, m3u8 and mp4 files.
+func recordArtifactSize(artifact *M3u8VoDArtifact) uint64 {
+	var size uint64
+	filepath.WalkDir(path.Join("record", artifact.UUID), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+
+	// For the ts files not in the directory of record.
+	for _, file := range artifact.Files {
+		if file.Key != "" && filepath.Dir(file.Key) != path.Join("record", artifact.UUID) {
+			if info, err := os.Stat(file.Key); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+	}
+	return size
+}
+
+// removeRecordArtifact remove the files and the metadata of record.
+func removeRecordArtifact(ctx context.Context, metadata *M3u8VoDArtifact) error {
+	uuid := metadata.UUID
+
+	// Remove all ts files.
+	for _, file := range metadata.Files {
+		if _, err := os.Stat(file.Key); err == nil {
+			os.Remove(file.Key)
+		}
+	}
+
+	// Remove m3u8 file.
+	m3u8File := path.Join("record", uuid, "index.m3u8")
+	if _, err := os.Stat(m3u8File); err == nil {
+		os.Remove(m3u8File)
+	}
+
+	// Remove mp4 file.
+	mp4File := path.Join("record", uuid, "index.mp4")
+	if _, err := os.Stat(mp4File); err == nil {
+		os.Remove(mp4File)
+	}
+
+	// Remove ts directory.
+	m3u8Directory := path.Join("record", uuid)
+	if _, err := os.Stat(m3u8Directory); err == nil {
+		os.RemoveAll(m3u8Directory)
+	}
+
+	// Remove HLS from list.
+	if err := rdb.HDel(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+	}
+
+	return nil
+}
+
+// sweep the records by retention policy, and update the report.
+func (v *RecordWorker) sweep(ctx context.Context) error {
+	var policy RecordRetention
+	if err := policy.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load retention")
+	}
+
+	_, free, err := recordDiskStat()
+	if err != nil {
+		return errors.Wrapf(err, "disk free")
+	}
+
+	// Update the state to reject new recordings.
+	rejecting := policy.LowDisk == RecordLowDiskReject && policy.lowDisk(free)
+	if rejecting {
+		atomic.StoreUint32(&v.rejecting, 1)
+	} else {
+		atomic.StoreUint32(&v.rejecting, 0)
+	}
+
+	var artifacts []*M3u8VoDArtifact
+	if objs, err := rdb.HGetAll(ctx, SRS_RECORD_M3U8_ARTIFACT).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_RECORD_M3U8_ARTIFACT)
+	} else {
+		for uuid, value := range objs {
+			var artifact M3u8VoDArtifact
+			if err := json.Unmarshal([]byte(value), &artifact); err != nil {
+				logger.Wf(ctx, "ignore record %v err %+v", uuid, err)
+				continue
+			}
+			artifacts = append(artifacts, &artifact)
+		}
+	}
+
+	evictions := planRecordRetention(&policy, artifacts, recordArtifactSize, free, time.Now())
+
+	var report RecordRetentionReport
+	if err := report.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load report")
+	}
+	report.Removed, report.Reclaimed, report.Reasons = 0, 0, make(map[string]int)
+
+	for _, e := range evictions {
+		if err := removeRecordArtifact(ctx, e.Artifact); err != nil {
+			logger.Wf(ctx, "ignore remove record %v err %+v", e.Artifact.String(), err)
+			continue
+		}
+
+		report.Removed, report.Reclaimed = report.Removed+1, report.Reclaimed+e.Size
+		report.Reasons[e.Reason]++
+		logger.Tf(ctx, "record retention remove uuid=%v, size=%v, reason=%v", e.Artifact.UUID, e.Size, e.Reason)
+	}
+
+	report.TotalRemoved += report.Removed
+	report.TotalReclaimed += report.Reclaimed
+	report.Free, report.Rejecting = free+report.Reclaimed, rejecting
+	report.Update = time.Now().Format(time.RFC3339)
+	if err := report.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save report")
+	}
+
+	if report.Removed > 0 {
+		logger.Tf(ctx, "record retention ok, removed=%v, reclaimed=%v, policy is %v",
+			report.Removed, report.Reclaimed, policy.String())
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecordSchedule_Active(t *testing.T) {
+	// 2024-01-01 is Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	daily := &RecordSchedule{Start: "20:00", End: "22:00"}
+	for _, c := range []struct {
+		now    time.Time
+		active bool
+	}{
+		{at(1, 19, 59), false}, {at(1, 20, 0), true}, {at(1, 21, 59), true}, {at(1, 22, 0), false},
+	} {
+		if v := daily.Active(c.now); v != c.active {
+			t.Errorf("Expected %v at %v, got %v", c.active, c.now, v)
+		}
+	}
+
+	// Cross midnight, only start on Monday.
+	overnight := &RecordSchedule{Start: "23:00", End: "01:00", Weekdays: []time.Weekday{time.Monday}}
+	for _, c := range []struct {
+		now    time.Time
+		active bool
+	}{
+		{at(1, 0, 30), false}, {at(1, 23, 30), true}, {at(2, 0, 30), true}, {at(2, 1, 0), false},
+		{at(2, 23, 30), false}, {at(3, 0, 30), false},
+	} {
+		if v := overnight.Active(c.now); v != c.active {
+			t.Errorf("Expected %v at %v, got %v", c.active, c.now, v)
+		}
+	}
+}
+
+func TestRecordSchedule_Validate(t *testing.T) {
+	for _, c := range []struct {
+		schedule RecordSchedule
+		valid    bool
+	}{
+		{RecordSchedule{Stream: "/live/*", Start: "20:00", End: "22:00"}, true},
+		{RecordSchedule{Stream: "/live/*", Start: "23:00", End: "01:00", Weekdays: []time.Weekday{1, 5}}, true},
+		{RecordSchedule{Stream: "live/*", Start: "20:00", End: "22:00"}, false},
+		{RecordSchedule{Stream: "/live/[", Start: "20:00", End: "22:00"}, false},
+		{RecordSchedule{Stream: "/live/*", Start: "20:00", End: "20:00"}, false},
+		{RecordSchedule{Stream: "/live/*", Start: "25:00", End: "22:00"}, false},
+		{RecordSchedule{Stream: "/live/*", Start: "20:00", End: "22:00", Weekdays: []time.Weekday{7}}, false},
+	} {
+		if err := c.schedule.Validate(); (err == nil) != c.valid {
+			t.Errorf("Expected valid=%v for %v, got %v", c.valid, c.schedule.String(), err)
+		} else if err == nil && c.schedule.ID == "" {
+			t.Errorf("Expected generated id for %v", c.schedule.String())
+		}
+	}
+}
+
+func TestDecideRecord(t *testing.T) {
+	now := time.Date(2024, 1, 1, 21, 0, 0, 0, time.UTC)
+	schedules := []*RecordSchedule{
+		{ID: "off", Enabled: false, Stream: "/*/*", Start: "00:00", End: "23:59"},
+		{ID: "show", Enabled: true, Stream: "/live/show*", Start: "20:00", End: "22:00"},
+	}
+	start := &RecordControl{Stream: "/live/a", Action: RecordControlStart}
+	stop := &RecordControl{Stream: "/live/show1", Action: RecordControlStop}
+
+	for _, c := range []struct {
+		name    string
+		stream  string
+		control *RecordControl
+		all     bool
+		globs   []string
+		record  bool
+	}{
+		{"disabled", "/live/a", nil, false, nil, false},
+		{"all", "/live/a", nil, true, nil, true},
+		{"glob-matched", "/live/a", nil, true, []string{"/live/*"}, true},
+		{"glob-not-matched", "/live/a", nil, true, []string{"/show/*"}, false},
+		{"schedule", "/live/show1", nil, false, nil, true},
+		{"manual-start", "/live/a", start, false, nil, true},
+		{"manual-stop-overwrite-schedule", "/live/show1", stop, true, nil, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if ok, reason := decideRecord(c.stream, c.control, schedules, c.all, c.globs, now); ok != c.record {
+				t.Errorf("Expected %v, got %v by %v", c.record, ok, reason)
+			}
+		})
+	}
+}
+
+func TestRecordWorker_Rules(t *testing.T) {
+	ctx := context.Background()
+	v := NewRecordWorker()
+	v.rules = &recordRules{
+		controls: map[string]*RecordControl{"/live/show": {Stream: "/live/show", Action: RecordControlStop}},
+		all:      true,
+	}
+
+	// Use the cached rules, never load from redis.
+	if ok, _, err := v.shouldRecord(ctx, "live", "livestream"); err != nil || !ok {
+		t.Errorf("Expected record livestream, got %v, err %v", ok, err)
+	}
+	if ok, _, err := v.shouldRecord(ctx, "live", "show"); err != nil || ok {
+		t.Errorf("Expected not record show, got %v, err %v", ok, err)
+	}
+
+	// Drop the cached rules and bump the version, so a load in flight is not cached.
+	version := v.rulesVersion
+	v.resetRules()
+	if v.rules != nil || v.rulesVersion != version+1 {
+		t.Errorf("Expected reset rules, got %v, version %v", v.rules, v.rulesVersion)
+	}
+}
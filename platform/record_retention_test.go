@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanRecordRetention(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	artifact := func(uuid, stream string, age time.Duration, processing bool) *M3u8VoDArtifact {
+		return &M3u8VoDArtifact{
+			UUID: uuid, Vhost: "__defaultVhost__", App: "live", Stream: stream, Processing: processing,
+			Update: now.Add(-age).Format(time.RFC3339),
+		}
+	}
+	artifacts := []*M3u8VoDArtifact{
+		artifact("a1", "a", 72*time.Hour, false),
+		artifact("a2", "a", 48*time.Hour, false),
+		artifact("a3", "a", 1*time.Hour, false),
+		artifact("b1", "b", 96*time.Hour, true),
+		artifact("b2", "b", 2*time.Hour, false),
+	}
+	sizeOf := func(*M3u8VoDArtifact) uint64 {
+		return 100
+	}
+	evicted := func(evictions []*RecordEviction) (r string) {
+		for _, e := range evictions {
+			r += e.Artifact.UUID + ":" + e.Reason + " "
+		}
+		return
+	}
+
+	for _, c := range []struct {
+		name   string
+		policy RecordRetention
+		free   uint64
+		expect string
+	}{
+		{"none", RecordRetention{}, 0, ""},
+		{"age", RecordRetention{MaxAge: 24 * 3600}, 0, "a1:age a2:age "},
+		{"count", RecordRetention{MaxCount: 1}, 0, "a1:count a2:count "},
+		{"quota", RecordRetention{MaxBytes: 250}, 0, "a1:quota a2:quota "},
+		{"age-and-quota", RecordRetention{MaxAge: 60 * 3600, MaxBytes: 150}, 0, "a1:age a2:quota b2:quota "},
+		{"disk-evict", RecordRetention{MinFree: 1000, LowDisk: RecordLowDiskEvict}, 850, "a1:disk a2:disk "},
+		{"disk-reject", RecordRetention{MinFree: 1000, LowDisk: RecordLowDiskReject}, 850, ""},
+		{"disk-enough", RecordRetention{MinFree: 1000, LowDisk: RecordLowDiskEvict}, 1000, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if r := evicted(planRecordRetention(&c.policy, artifacts, sizeOf, c.free, now)); r != c.expect {
+				t.Errorf("Expected %q, got %q", c.expect, r)
+			}
+		})
+	}
+}
+
+func TestRecordRetention_Validate(t *testing.T) {
+	for _, c := range []struct {
+		policy RecordRetention
+		valid  bool
+	}{
+		{RecordRetention{}, true},
+		{RecordRetention{MaxAge: 3600, MaxCount: 10, LowDisk: RecordLowDiskReject}, true},
+		{RecordRetention{MaxAge: -1}, false},
+		{RecordRetention{MaxCount: -1}, false},
+		{RecordRetention{LowDisk: "drop"}, false},
+	} {
+		if err := c.policy.Validate(); (err == nil) != c.valid {
+			t.Errorf("Expected valid=%v for %v, got %v", c.valid, c.policy.String(), err)
+		}
+	}
+}
@@ -5,10 +5,10 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"github.com/joho/godotenv"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -178,9 +178,7 @@ func (v *httpService) Run(ctx context.Context) error {
 			Addr:    addr,
 			Handler: handler,
 			TLSConfig: &tls.Config{
-				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
-					return certManager.httpsCertificate, nil
-				},
+				GetCertificate: certManager.GetCertificate,
 			},
 		}
 		v.servers = append(v.servers, server)
@@ -270,6 +268,8 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 	handleMgmtEnvs(ctx, handler)
 	handleMgmtToken(ctx, handler)
 	handleMgmtLogin(ctx, handler)
+	handleMgmtAPIKeys(ctx, handler)
+	handleMgmtUsers(ctx, handler)
 	handleMgmtStatus(ctx, handler)
 	handleMgmtBilibili(ctx, handler)
 	handleMgmtLimitsQuery(ctx, handler)
@@ -287,8 +287,11 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 	handleMgmtSsl(ctx, handler)
 	handleMgmtLetsEncrypt(ctx, handler)
 	handleMgmtCertQuery(ctx, handler)
+	handleMgmtCertDomains(ctx, handler)
 	handleMgmtStreamsQuery(ctx, handler)
 	handleMgmtStreamsKickoff(ctx, handler)
+	handleMetrics(ctx, handler)
+	handlePlatformEvents(ctx, handler)
 	handleMgmtUI(ctx, handler)
 
 	proxy2023, err := httpCreateProxy("http://127.0.0.1:2023")
@@ -342,6 +345,17 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 			return
 		}
 
+		// Verify the play token for HTTP-FLV, HLS and WHEP players, because SRS only see the proxy.
+		if token, err := verifyPlayAuthRequest(ctx, r); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+			return
+		} else if token != "" && strings.HasSuffix(r.URL.Path, ".m3u8") {
+			// Append the token to the ts URLs in m3u8, so that the ts files are also verified.
+			m3u8Writer := newPlayAuthM3u8Writer(w, token)
+			defer m3u8Writer.Close()
+			w = m3u8Writer
+		}
+
 		// Proxy to SRS RTC API, by /rtc/ prefix.
 		if strings.HasPrefix(r.URL.Path, "/rtc/") {
 			q := r.URL.Query()
@@ -495,42 +509,40 @@ func handleMgmtInit(ctx context.Context, handler *http.ServeMux) {
 				}
 			}
 
+			// The system is initialized by users, or by MGMT_PASSWORD for the old version.
+			initialized, err := hasUsers(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query users")
+			}
+			initialized = initialized || envMgmtPassword() != ""
+
 			// If no password, query the system init status.
 			if password == "" {
 				ohttp.WriteData(ctx, w, r, &struct {
 					Init bool `json:"init"`
 				}{
-					Init: envMgmtPassword() != "",
+					Init: initialized,
 				})
 				return nil
 			}
 
 			// If already initialized, never set it again.
-			if envMgmtPassword() != "" {
+			if initialized {
 				return errors.New("already initialized")
 			}
 
-			// Initialize the system password, save to env.
-			envFile := path.Join(conf.Pwd, "containers/data/config/.env")
-			if envs, err := godotenv.Read(envFile); err != nil {
-				return errors.Wrapf(err, "load envs from %v", envFile)
-			} else {
-				envs["MGMT_PASSWORD"] = password
-				if err := godotenv.Write(envs, envFile); err != nil {
-					return errors.Wrapf(err, "write %v", envFile)
-				}
-			}
-			logger.Tf(ctx, "init mgmt password %vB ok, file=%v", len(password), envFile)
-
-			// Refresh the local token.
-			if err := godotenv.Overload(envFile); err != nil {
-				return errors.Wrapf(err, "load %v", envFile)
+			// Initialize the system by creating the admin user, the password is hashed in redis. Note that the
+			// users are checked again when creating, because of concurrent requests.
+			user, err := initAdminUser(ctx, password)
+			if err != nil {
+				return errors.Wrapf(err, "create admin")
 			}
+			logger.Tf(ctx, "init admin %v ok, password=%vB", user.String(), len(password))
 
 			apiSecret := envApiSecret()
-			expireAt, createAt, token, err := createToken(ctx, envApiSecret())
+			session, token, err := createUserSession(ctx, apiSecret, user, r)
 			if err != nil {
-				return errors.Wrapf(err, "build token")
+				return errors.Wrapf(err, "create session")
 			}
 
 			ohttp.WriteData(ctx, w, r, &struct {
@@ -539,11 +551,13 @@ func handleMgmtInit(ctx context.Context, handler *http.ServeMux) {
 				ExpireAt string `json:"expireAt"`
 				// Allow user to directly use Bearer token.
 				Bearer string `json:"bearer"`
+				// The user of session.
+				User *User `json:"user"`
 			}{
-				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
-				Bearer: apiSecret,
+				Token: token, CreateAt: session.CreateAt, ExpireAt: session.ExpireAt,
+				Bearer: apiSecret, User: user.Public(),
 			})
-			logger.Tf(ctx, "init password ok, create=%v, expire=%v, password=%vB", createAt, expireAt, len(password))
+			logger.Tf(ctx, "init password ok, %v, password=%vB", session.String(), len(password))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -702,7 +716,29 @@ func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "parse body")
 			}
 
+			// For user session, respond the same token, to never escalate the role of user.
 			apiSecret := envApiSecret()
+			if r.Header.Get("Authorization") == "" && token != "" {
+				if claims, err := parseAPIKeyToken(apiSecret, token); err == nil && claims.Subject != "" {
+					user, err := verifyUserSession(ctx, claims)
+					if err != nil {
+						return errors.Wrapf(err, "verify session")
+					}
+
+					ohttp.WriteData(ctx, w, r, &struct {
+						Token    string `json:"token"`
+						CreateAt string `json:"createAt"`
+						ExpireAt string `json:"expireAt"`
+						User     *User  `json:"user"`
+					}{
+						Token: token, CreateAt: claims.IssuedAt.Format(time.RFC3339),
+						ExpireAt: claims.ExpiresAt.Format(time.RFC3339), User: user.Public(),
+					})
+					logger.Tf(ctx, "login by session ok, %v, session=%v, token=%vB", user.String(), claims.ID, len(token))
+					return nil
+				}
+			}
+
 			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
@@ -738,7 +774,11 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 			}
 			defer loginLock.Unlock()
 
-			if envMgmtPassword() == "" {
+			initialized, err := hasUsers(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query users")
+			}
+			if !initialized && envMgmtPassword() == "" {
 				return errors.New("not init")
 			}
 
@@ -747,11 +787,13 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "read body")
 			}
 
-			var password string
+			var name, password, code string
 			if err := json.Unmarshal(b, &struct {
+				Name     *string `json:"name"`
 				Password *string `json:"password"`
+				Code     *string `json:"code"`
 			}{
-				Password: &password,
+				Name: &name, Password: &password, Code: &code,
 			}); err != nil {
 				return errors.Wrapf(err, "json unmarshal %v", string(b))
 			}
@@ -759,23 +801,75 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 			if password == "" {
 				return errors.New("no password")
 			}
+			// For the old version, only password is required, which is the admin.
+			if name = strings.TrimSpace(name); name == "" {
+				name = userDefaultAdmin
+			}
 
-			if password != envMgmtPassword() {
+			// Wait for a while when failed, to slow down the brute force attack.
+			loginFailed := func(err error) error {
 				wait := time.Duration(10) * time.Second
-				logger.Wf(ctx, "Invalid password, wait for %v", wait)
+				logger.Wf(ctx, "Invalid login of %v, wait for %v, err %+v", name, wait, err)
 
 				select {
 				case <-time.After(wait):
 				case <-ctx.Done():
 				}
 
-				return errors.Errorf("invalid password, wait %v", wait)
+				return errors.Wrapf(err, "login failed, wait %v", wait)
+			}
+
+			var user *User
+			if !initialized {
+				// Migrate the MGMT_PASSWORD of old version to the admin user, once the password is verified.
+				if name != userDefaultAdmin || subtle.ConstantTimeCompare([]byte(password), []byte(envMgmtPassword())) != 1 {
+					return loginFailed(errors.New("invalid name or password"))
+				}
+
+				if user, err = createUser(ctx, userDefaultAdmin, password, RoleAdmin); err != nil {
+					return errors.Wrapf(err, "migrate admin")
+				}
+				logger.Tf(ctx, "login migrate MGMT_PASSWORD to %v", user.String())
+
+				if err := clearMgmtPassword(ctx); err != nil {
+					return errors.Wrapf(err, "clear MGMT_PASSWORD")
+				}
+			} else {
+				if user, err = loadUserByName(ctx, name); err != nil {
+					return errors.Wrapf(err, "load user %v", name)
+				}
+				if user == nil || !verifyUserPassword(user.Password, password) {
+					return loginFailed(errors.New("invalid name or password"))
+				}
+			}
+
+			// Verify the TOTP code if two-factor is enabled.
+			if user.TOTPEnabled {
+				if code == "" {
+					return errors.New("totp code required")
+				}
+
+				step, err := verifyTOTPCode(user.TOTPSecret, code, user.TOTPStep, time.Now())
+				if err != nil {
+					return loginFailed(errors.Wrapf(err, "invalid totp code"))
+				}
+
+				user.TOTPStep = step
+				if err := saveUser(ctx, user); err != nil {
+					return errors.Wrapf(err, "save %v", user.String())
+				}
 			}
 
 			apiSecret := envApiSecret()
-			expireAt, createAt, token, err := createToken(ctx, apiSecret)
+			session, token, err := createUserSession(ctx, apiSecret, user, r)
 			if err != nil {
-				return errors.Wrapf(err, "build token")
+				return errors.Wrapf(err, "create session")
+			}
+
+			// Only the admin is allowed to use the API secret as Bearer token.
+			var bearer string
+			if user.Role == RoleAdmin {
+				bearer = apiSecret
 			}
 
 			ohttp.WriteData(ctx, w, r, &struct {
@@ -783,12 +877,14 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 				CreateAt string `json:"createAt"`
 				ExpireAt string `json:"expireAt"`
 				// Allow user to directly use Bearer token.
-				Bearer string `json:"bearer"`
+				Bearer string `json:"bearer,omitempty"`
+				// The user of session.
+				User *User `json:"user"`
 			}{
-				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
-				Bearer: apiSecret,
+				Token: token, CreateAt: session.CreateAt, ExpireAt: session.ExpireAt,
+				Bearer: bearer, User: user.Public(),
 			})
-			logger.Tf(ctx, "login by password ok, create=%v, expire=%v, token=%vB", createAt, expireAt, len(token))
+			logger.Tf(ctx, "login by password ok, %v, role=%v, token=%vB", session.String(), user.Role, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -1469,11 +1565,15 @@ func handleMgmtLetsEncrypt(ctx context.Context, handler *http.ServeMux) {
 		if err := func() error {
 			var token string
 			var domain string
+			var domains []string
+			var acme *CertACMEConfig
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token  *string `json:"token"`
-				Domain *string `json:"domain"`
+				Token   *string          `json:"token"`
+				Domain  *string          `json:"domain"`
+				Domains *[]string        `json:"domains"`
+				ACME    **CertACMEConfig `json:"acme"`
 			}{
-				Token: &token, Domain: &domain,
+				Token: &token, Domain: &domain, Domains: &domains, ACME: &acme,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -1483,27 +1583,56 @@ func handleMgmtLetsEncrypt(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if domain = strings.TrimSpace(domain); domain == "" {
-				return errors.New("empty domain")
+			// The domain might be multiple domains separated by comma, such as "a.com,*.b.com".
+			domains = parseCertDomains(strings.Join(append([]string{domain}, domains...), ","))
+
+			// Keep the previous ACME config if not specified, for compatibility with the old client.
+			if acme == nil {
+				acme = NewCertACMEConfig()
+				if err := acme.Load(ctx); err != nil {
+					return errors.Wrapf(err, "load acme config")
+				}
+			} else if rfc2136 := acme.rfc2136(); rfc2136 != nil && rfc2136.TSIGKey != "" && rfc2136.TSIGSecret == "" {
+				// Keep the TSIG secret if not specified, because query never response it.
+				previous := NewCertACMEConfig()
+				if err := previous.Load(ctx); err != nil {
+					return errors.Wrapf(err, "load acme config")
+				}
+				if prev := previous.rfc2136(); prev != nil && prev.TSIGKey == rfc2136.TSIGKey {
+					rfc2136.TSIGSecret = prev.TSIGSecret
+				}
+			}
+			if err := acme.Validate(); err != nil {
+				return errors.Wrapf(err, "validate acme %v", acme.String())
+			}
+			if err := validateCertDomains(domains, acme.Challenge); err != nil {
+				return errors.Wrapf(err, "validate domains %v", domains)
 			}
 
-			if err := certManager.updateLetsEncrypt(ctx, domain); err != nil {
-				return errors.Wrapf(err, "updateSslFiles domain=%v", domain)
+			if err := certManager.updateLetsEncrypt(ctx, domains, acme); err != nil {
+				return errors.Wrapf(err, "updateSslFiles domains=%v, acme=<%v>", domains, acme.String())
 			}
+			platformEvents.Publish(ctx, EventCertRenew, "", map[string]interface{}{
+				"action": "issue", "domains": domains, "provider": "lets",
+			})
 
+			domain = strings.Join(domains, ",")
 			if err := rdb.Set(ctx, SRS_HTTPS, "lets", 0).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "set %v %v", SRS_HTTPS, "lets")
 			}
 			if err := rdb.Set(ctx, SRS_HTTPS_DOMAIN, domain, 0).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "set %v %v", SRS_HTTPS_DOMAIN, domain)
 			}
+			if err := acme.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save acme config")
+			}
 
 			if err := nginxGenerateConfig(ctx); err != nil {
 				return errors.Wrapf(err, "nginx config and reload")
 			}
 
 			ohttp.WriteData(ctx, w, r, nil)
-			logger.Tf(ctx, "nginx letsencrypt ok, domain=%v, token=%vB", domain, len(token))
+			logger.Tf(ctx, "nginx letsencrypt ok, domain=%v, acme=<%v>, token=%vB", domain, acme.String(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -1540,6 +1669,15 @@ func handleMgmtCertQuery(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "get %v", SRS_HTTPS_DOMAIN)
 			}
 
+			acme := NewCertACMEConfig()
+			if err := acme.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load acme config")
+			}
+			// Never response the TSIG secret.
+			if rfc2136 := acme.rfc2136(); rfc2136 != nil {
+				rfc2136.TSIGSecret = ""
+			}
+
 			var key, crt string
 			if provider != "" {
 				key, crt, err = certManager.QueryCertificate()
@@ -1549,12 +1687,20 @@ func handleMgmtCertQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			ohttp.WriteData(ctx, w, r, &struct {
-				Provider string `json:"provider"`
-				Domain   string `json:"domain"`
-				Key      string `json:"key"`
-				Crt      string `json:"crt"`
+				Provider string          `json:"provider"`
+				Domain   string          `json:"domain"`
+				Domains  []string        `json:"domains"`
+				ACME     *CertACMEConfig `json:"acme"`
+				Key      string          `json:"key"`
+				Crt      string          `json:"crt"`
+				// The expiry of the default certificate.
+				Info *CertInfo `json:"info,omitempty"`
+				// The certificates of domains, selected by SNI.
+				Certs []*CertInfo `json:"certs"`
 			}{
-				Provider: provider, Domain: domain, Key: key, Crt: crt,
+				Provider: provider, Domain: domain, Domains: parseCertDomains(domain), ACME: acme,
+				Key: key, Crt: crt,
+				Info: certManager.queryDefaultCertificate(), Certs: certManager.queryDomainCertificates(),
 			})
 			logger.Tf(ctx, "query cert ok, provider=%v, domain=%v, key=%vB, crt=%vB, token=%vB",
 				provider, domain, len(key), len(crt), len(token),
@@ -1581,29 +1727,41 @@ func handleMgmtStreamsQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeStreamsRead); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+			streamObjects, err := queryActiveStreams(ctx)
 			if err != nil {
-				return errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+				return errors.Wrapf(err, "query active streams")
 			}
 
-			var streamObjects []*SrsStream
-			for _, value := range streams {
-				var stream SrsStream
-				if err := json.Unmarshal([]byte(value), &stream); err != nil {
-					return errors.Wrapf(err, "unmarshal %v", value)
+			// Enrich the status by SRS HTTP API, ignore if SRS is not available.
+			var apiStreams []*SrsAPIStream
+			var clients []*SrsAPIClient
+			if len(streamObjects) > 0 {
+				if apiStreams, clients, err = querySrsStreamsAndClients(ctx); err != nil {
+					logger.Wf(ctx, "query streams ignore srs err %+v", err)
 				}
+			}
+
+			now := time.Now()
+			statuses := []*StreamStatus{}
+			for _, stream := range streamObjects {
+				apiStream := findSrsAPIStream(apiStreams, stream.Vhost, stream.App, stream.Stream)
+				status := buildStreamStatus(stream, apiStream, clients, now)
 
-				streamObjects = append(streamObjects, &stream)
+				status.History = streamStatusWorker.History(stream.StreamURL())
+				if len(status.History) > 0 {
+					status.FPS = status.History[len(status.History)-1].FPS
+				}
+				statuses = append(statuses, status)
 			}
 
 			ohttp.WriteData(ctx, w, r, &struct {
-				Streams []*SrsStream `json:"streams"`
+				Streams []*StreamStatus `json:"streams"`
 			}{
-				streamObjects,
+				statuses,
 			})
 			logger.Tf(ctx, "query streams ok, streams=%v, token=%vB", len(streamObjects), len(token))
 			return nil
@@ -1616,6 +1774,83 @@ func handleMgmtStreamsQuery(ctx context.Context, handler *http.ServeMux) {
 // See SRS error code ERROR_RTMP_CLIENT_NOT_FOUND
 const ErrorRtmpClientNotFound = 2049
 
+// kickoffStream kickoff the publisher of stream, by deleting the client of SRS, and remove the stream from
+// active streams. The code is ErrorRtmpClientNotFound if the client is already gone.
+func kickoffStream(ctx context.Context, vhost, app, stream string) (int, error) {
+	streamObject := &SrsStream{Vhost: vhost, App: app, Stream: stream}
+	streamURL := streamObject.StreamURL()
+	if target, err := rdb.HGet(ctx, SRS_STREAM_ACTIVE, streamURL).Result(); err != nil && err != redis.Nil {
+		return 0, errors.Wrapf(err, "hget %v %v", SRS_STREAM_ACTIVE, streamURL)
+	} else if target == "" {
+		return 0, errors.Errorf("stream not found %v", streamURL)
+	} else if err := json.Unmarshal([]byte(target), &streamObject); err != nil {
+		return 0, errors.Wrapf(err, "unmarshal %v", target)
+	}
+
+	if streamObject.Client == "" {
+		return 0, errors.Errorf("no client_id for %v", streamURL)
+	}
+
+	// Start request and parse the code.
+	requestClient := func(ctx context.Context, clientURL, method string) (int, string, error) {
+		req, err := http.NewRequest(method, clientURL, nil)
+		if err != nil {
+			return 0, "", errors.Wrapf(err, "new request")
+		}
+
+		client := &http.Client{Timeout: 10 * time.Second}
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, "", errors.Wrapf(err, "do request")
+		}
+		defer res.Body.Close()
+
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			return 0, "", errors.Wrapf(err, "http read body")
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return 0, "", errors.Errorf("status %v", res.StatusCode)
+		}
+
+		var code int
+		if err := json.Unmarshal(b, &struct {
+			Code *int `json:"code"`
+		}{
+			Code: &code,
+		}); err != nil {
+			return 0, "", errors.Wrapf(err, "unmarshal %v", string(b))
+		}
+		return code, string(b), nil
+	}
+
+	// Whether client exists in SRS server.
+	var code int
+	clientURL := fmt.Sprintf("http://127.0.0.1:1985/api/v1/clients/%v", streamObject.Client)
+	if r0, body, err := requestClient(ctx, clientURL, http.MethodGet); err != nil {
+		return 0, errors.Wrapf(err, "http query client %v", clientURL)
+	} else if r0 != 0 && r0 != ErrorRtmpClientNotFound {
+		return 0, errors.Errorf("invalid code=%v, body=%v", r0, body)
+	} else {
+		code = r0
+	}
+
+	// Kickoff if exists, ignore if not.
+	if code == 0 {
+		if r0, body, err := requestClient(ctx, clientURL, http.MethodDelete); err != nil {
+			return 0, errors.Wrapf(err, "kickoff %v, body %v", clientURL, body)
+		} else if r0 != 0 && r0 != ErrorRtmpClientNotFound {
+			return 0, errors.Errorf("invalid code=%v, body=%v", r0, body)
+		}
+	}
+
+	if err := rdb.HDel(ctx, SRS_STREAM_ACTIVE, streamURL).Err(); err != nil && err != redis.Nil {
+		return 0, errors.Wrapf(err, "hdel %v %v", SRS_STREAM_ACTIVE, streamURL)
+	}
+	return code, nil
+}
+
 func handleMgmtStreamsKickoff(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/streams/kickoff"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -1635,7 +1870,7 @@ func handleMgmtStreamsKickoff(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := AuthenticateWithScope(ctx, apiSecret, token, r.Header, ScopeStreamsWrite); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1649,76 +1884,9 @@ func handleMgmtStreamsKickoff(ctx context.Context, handler *http.ServeMux) {
 				return errors.New("no stream")
 			}
 
-			streamObject := &SrsStream{Vhost: vhost, App: app, Stream: stream}
-			streamURL := streamObject.StreamURL()
-			if target, err := rdb.HGet(ctx, SRS_STREAM_ACTIVE, streamURL).Result(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hget %v %v", SRS_STREAM_ACTIVE, streamURL)
-			} else if target == "" {
-				return errors.Errorf("stream not found %v", streamURL)
-			} else if err := json.Unmarshal([]byte(target), &streamObject); err != nil {
-				return errors.Wrapf(err, "unmarshal %v", target)
-			}
-
-			if streamObject.Client == "" {
-				return errors.Errorf("no client_id for %v", streamURL)
-			}
-
-			// Start request and parse the code.
-			requestClient := func(ctx context.Context, clientURL, method string) (int, string, error) {
-				req, err := http.NewRequest(method, clientURL, nil)
-				if err != nil {
-					return 0, "", errors.Wrapf(err, "new request")
-				}
-
-				client := &http.Client{Timeout: 10 * time.Second}
-				res, err := client.Do(req.WithContext(ctx))
-				if err != nil {
-					return 0, "", errors.Wrapf(err, "do request")
-				}
-				defer res.Body.Close()
-
-				b, err := io.ReadAll(res.Body)
-				if err != nil {
-					return 0, "", errors.Wrapf(err, "http read body")
-				}
-
-				if res.StatusCode != http.StatusOK {
-					return 0, "", errors.Errorf("status %v", res.StatusCode)
-				}
-
-				var code int
-				if err := json.Unmarshal(b, &struct {
-					Code *int `json:"code"`
-				}{
-					Code: &code,
-				}); err != nil {
-					return 0, "", errors.Wrapf(err, "unmarshal %v", string(b))
-				}
-				return code, string(b), nil
-			}
-
-			// Whether client exists in SRS server.
-			var code int
-			clientURL := fmt.Sprintf("http://127.0.0.1:1985/api/v1/clients/%v", streamObject.Client)
-			if r0, body, err := requestClient(ctx, clientURL, http.MethodGet); err != nil {
-				return errors.Wrapf(err, "http query client %v", clientURL)
-			} else if r0 != 0 && r0 != ErrorRtmpClientNotFound {
-				return errors.Errorf("invalid code=%v, body=%v", r0, body)
-			} else {
-				code = r0
-			}
-
-			// Kickoff if exists, ignore if not.
-			if code == 0 {
-				if r0, body, err := requestClient(ctx, clientURL, http.MethodDelete); err != nil {
-					return errors.Wrapf(err, "kickoff %v, body %v", clientURL, body)
-				} else if r0 != 0 && r0 != ErrorRtmpClientNotFound {
-					return errors.Errorf("invalid code=%v, body=%v", r0, body)
-				}
-			}
-
-			if err := rdb.HDel(ctx, SRS_STREAM_ACTIVE, streamURL).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hdel %v %v", SRS_STREAM_ACTIVE, streamURL)
+			code, err := kickoffStream(ctx, vhost, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "kickoff")
 			}
 
 			ohttp.WriteData(ctx, w, r, nil)
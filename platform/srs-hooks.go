@@ -48,6 +48,8 @@ const (
 
 	// The on_ocr action.
 	SrsActionOnOcr = "on_ocr"
+	// The on_transcript_match action, when transcript matches the alert rules.
+	SrsActionOnTranscriptMatch = "on_transcript_match"
 )
 
 func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
@@ -88,11 +90,13 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 
 			var action SrsAction
 			var streamObj SrsStream
+			var clientIP string
 			if err := json.Unmarshal(b, &struct {
-				Action *SrsAction `json:"action"`
+				Action   *SrsAction `json:"action"`
+				ClientIP *string    `json:"ip"`
 				*SrsStream
 			}{
-				Action: &action, SrsStream: &streamObj,
+				Action: &action, ClientIP: &clientIP, SrsStream: &streamObj,
 			}); err != nil {
 				return errors.Wrapf(err, "json unmarshal %v", string(b))
 			}
@@ -111,20 +115,30 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 					return publish == "" || strings.Contains(param, publish) || strings.Contains(stream, publish)
 				}
 
-				// Use live room secret to verify if stream name matches.
-				roomPublishAuthKey := GenerateRoomPublishKey(streamObj.Stream)
-				publish, err := rdb.HGet(ctx, SRS_AUTH_SECRET, roomPublishAuthKey).Result()
-				verifiedBy = "room"
-				if publish == "" {
-					// Use global publish secret to verify
-					publish, err = rdb.HGet(ctx, SRS_AUTH_SECRET, "pubSecret").Result()
-					verifiedBy = "global"
-				}
-				if err != nil && err != redis.Nil {
-					return errors.Wrapf(err, "hget %v pubSecret", SRS_AUTH_SECRET)
+				// Use the keys bound to stream to verify, which should be exactly matched.
+				verifiedByKey, err := verifyStreamKey(ctx, &streamObj)
+				if err != nil {
+					return errors.Wrapf(err, "invalid stream key, stream=%v, action=%v", streamObj.StreamURL(), action)
 				}
-				if !isSecretOK(publish, streamObj.Stream, streamObj.Param) {
-					return errors.Errorf("invalid normal stream=%v, param=%v, action=%v", streamObj.Stream, streamObj.Param, action)
+
+				if verifiedByKey {
+					verifiedBy = "key"
+				} else {
+					// Use live room secret to verify if stream name matches.
+					roomPublishAuthKey := GenerateRoomPublishKey(streamObj.Stream)
+					publish, err := rdb.HGet(ctx, SRS_AUTH_SECRET, roomPublishAuthKey).Result()
+					verifiedBy = "room"
+					if publish == "" {
+						// Use global publish secret to verify
+						publish, err = rdb.HGet(ctx, SRS_AUTH_SECRET, "pubSecret").Result()
+						verifiedBy = "global"
+					}
+					if err != nil && err != redis.Nil {
+						return errors.Wrapf(err, "hget %v pubSecret", SRS_AUTH_SECRET)
+					}
+					if !isSecretOK(publish, streamObj.Stream, streamObj.Param) {
+						return errors.Errorf("invalid normal stream=%v, param=%v, action=%v", streamObj.Stream, streamObj.Param, action)
+					}
 				}
 			}
 
@@ -165,6 +179,9 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 				if err := rdb.HDel(ctx, SRS_STREAM_ACTIVE, streamURL).Err(); err != nil && err != redis.Nil {
 					return errors.Wrapf(err, "hset %v %v", SRS_STREAM_ACTIVE, streamURL)
 				}
+				if err := rdb.HDel(ctx, SRS_STREAM_KEY_PUBLISHERS, streamURL).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_STREAM_KEY_PUBLISHERS, streamURL)
+				}
 				if streamObj.IsSRT() {
 					if err := rdb.HDel(ctx, SRS_STREAM_SRT_ACTIVE, streamURL).Err(); err != nil && err != redis.Nil {
 						return errors.Wrapf(err, "hset %v %v", SRS_STREAM_SRT_ACTIVE, streamURL)
@@ -176,11 +193,26 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 					}
 				}
 			} else if action == "on_play" {
+				if err := verifyPlayAuth(ctx, streamObj.App, streamObj.Stream, streamObj.Param, clientIP); err != nil {
+					return errors.Wrapf(err, "play auth, stream=%v, ip=%v", streamURL, clientIP)
+				}
+
 				if err := rdb.HIncrBy(ctx, SRS_STAT_COUNTER, "play", 1).Err(); err != nil && err != redis.Nil {
 					return errors.Wrapf(err, "hincrby %v play 1", SRS_STAT_COUNTER)
 				}
 			}
 
+			// Start or stop the transcode tasks, after the active streams are updated.
+			if err := transcodeWorker.OnStreamMessage(ctx, action, &streamObj); err != nil {
+				return errors.Wrapf(err, "transcode action=%v", action)
+			}
+
+			// Close the transcript session of stream, when it's unpublished or published again. Note that
+			// we never reject the stream if failed, because the session is not critical.
+			if err := transcriptWorker.OnStreamMessage(ctx, action, &streamObj); err != nil {
+				logger.Wf(ctx, "ignore transcript action=%v, stream=%v, err %+v", action, streamURL, err)
+			}
+
 			// For some events, hook after all other hooks are done.
 			if !preAllHook {
 				if err := callbackWorker.OnStreamMessage(ctx, action, &streamObj); err != nil {
@@ -188,6 +220,16 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 				}
 			}
 
+			// Notify the subscribers of events, note that the param is ignored because it might contain secret.
+			if event := map[SrsAction]string{
+				SrsActionOnPublish: EventStreamPublish, SrsActionOnUnpublish: EventStreamUnpublish, "on_play": EventStreamPlay,
+			}[action]; event != "" {
+				platformEvents.Publish(ctx, event, streamURL, map[string]string{
+					"vhost": streamObj.Vhost, "app": streamObj.App, "stream": streamObj.Stream,
+					"client": streamObj.Client, "ip": clientIP, "verifiedBy": verifiedBy,
+				})
+			}
+
 			ohttp.WriteData(ctx, w, r, nil)
 			logger.Tf(ctx, "srs hooks ok, action=%v, verifiedBy=%v, %v, %v",
 				action, verifiedBy, streamObj.String(), requestBody)
@@ -311,6 +353,75 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	ep = "/terraform/v1/hooks/srs/play/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			playAuth, err := isPlayAuthEnabled(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query play auth")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				PlayAuth bool `json:"playAuth"`
+			}{
+				PlayAuth: playAuth,
+			})
+			logger.Tf(ctx, "hooks query play auth ok, playAuth=%v, token=%vB", playAuth, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/play/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var playAuth bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				PlayAuth *bool   `json:"playAuth"`
+			}{
+				Token: &token, PlayAuth: &playAuth,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := rdb.HSet(ctx, SRS_AUTH_SECRET, "playAuth", fmt.Sprintf("%v", playAuth)).Err(); err != nil {
+				return errors.Wrapf(err, "hset %v playAuth %v", SRS_AUTH_SECRET, playAuth)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "hooks update play auth ok, playAuth=%v, token=%vB", playAuth, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	handleStreamKeysService(ctx, handler)
+
 	// See https://console.cloud.tencent.com/cam
 	ep = "/terraform/v1/tencent/cam/secret"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -742,14 +853,9 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 			}
 			logger.Tf(ctx, "on_hls ok, %v", string(b))
 
-			// Handle TS file by Record task if enabled.
-			if recordAll, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hget %v all", SRS_RECORD_PATTERNS)
-			} else if recordAll == "true" {
-				if err = recordWorker.OnHlsTsMessage(ctx, &msg); err != nil {
-					return errors.Wrapf(err, "feed %v", msg.String())
-				}
-				logger.Tf(ctx, "record %v", msg.String())
+			// Handle TS file by Record task, which decides whether to record by control, schedules and patterns.
+			if err := recordWorker.OnHlsTsMessage(ctx, &msg); err != nil {
+				return errors.Wrapf(err, "feed %v", msg.String())
 			}
 
 			// Handle TS file by DVR task if enabled.
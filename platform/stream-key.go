@@ -0,0 +1,450 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The query parameter of stream key, for example, rtmp://ip/live/livestream?secret=xxx
+const streamKeyParam = "secret"
+
+// StreamKey is the publish key bound to a stream, there might be multiple active keys for a stream, to
+// rotate the key without interrupting the publisher.
+type StreamKey struct {
+	// The key ID.
+	ID string `json:"id"`
+	// The name of key, such as obs or backup.
+	Name string `json:"name,omitempty"`
+	// The app and stream name bound to, for example, live and livestream.
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The secret to publish, which should be exactly matched.
+	Key string `json:"key"`
+	// The create time in RFC3339.
+	CreateAt string `json:"createAt"`
+	// The valid time range in RFC3339, optional.
+	NotBefore string `json:"notBefore,omitempty"`
+	ExpireAt  string `json:"expireAt,omitempty"`
+	// The revoke time in RFC3339. Note that the revoked key is kept, so the stream still only accepts its keys,
+	// never fallback to the room or global secret.
+	RevokeAt string `json:"revokeAt,omitempty"`
+}
+
+func (v *StreamKey) String() string {
+	return fmt.Sprintf("id=%v, name=%v, stream=%v, key=%vB, create=%v, notBefore=%v, expire=%v, revoke=%v",
+		v.ID, v.Name, v.StreamURL(), len(v.Key), v.CreateAt, v.NotBefore, v.ExpireAt, v.RevokeAt)
+}
+
+// StreamURL returns the app/stream bound to.
+func (v *StreamKey) StreamURL() string {
+	return fmt.Sprintf("%v/%v", v.App, v.Stream)
+}
+
+func (v *StreamKey) Validate() error {
+	if v.ID == "" {
+		v.ID = uuid.NewString()
+	}
+	if v.Key == "" {
+		v.Key = strings.ReplaceAll(uuid.NewString(), "-", "")
+	}
+	if v.CreateAt == "" {
+		v.CreateAt = time.Now().Format(time.RFC3339)
+	}
+
+	if v.App == "" || v.Stream == "" {
+		return errors.Errorf("no app or stream of %v", v.StreamURL())
+	}
+	if strings.ContainsAny(v.App, "/?&") || strings.ContainsAny(v.Stream, "/?&") {
+		return errors.Errorf("invalid stream %v", v.StreamURL())
+	}
+	if strings.ContainsAny(v.Key, "?&=# ") {
+		return errors.Errorf("invalid key %v", v.Key)
+	}
+
+	var notBefore, expireAt time.Time
+	if v.NotBefore != "" {
+		if t, err := time.Parse(time.RFC3339, v.NotBefore); err != nil {
+			return errors.Wrapf(err, "parse notBefore %v", v.NotBefore)
+		} else {
+			notBefore = t
+		}
+	}
+	if v.ExpireAt != "" {
+		if t, err := time.Parse(time.RFC3339, v.ExpireAt); err != nil {
+			return errors.Wrapf(err, "parse expireAt %v", v.ExpireAt)
+		} else {
+			expireAt = t
+		}
+	}
+	if !notBefore.IsZero() && !expireAt.IsZero() && !expireAt.After(notBefore) {
+		return errors.Errorf("expireAt %v not after notBefore %v", v.ExpireAt, v.NotBefore)
+	}
+	return nil
+}
+
+// Active returns whether the key is valid at the time of now.
+func (v *StreamKey) Active(now time.Time) bool {
+	if v.RevokeAt != "" {
+		return false
+	}
+	if v.NotBefore != "" {
+		if t, err := time.Parse(time.RFC3339, v.NotBefore); err != nil || now.Before(t) {
+			return false
+		}
+	}
+	if v.ExpireAt != "" {
+		if t, err := time.Parse(time.RFC3339, v.ExpireAt); err != nil || now.After(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStreamKeyParam parse the stream key from the param of stream, such as ?secret=xxx
+func parseStreamKeyParam(param string) string {
+	q, err := url.ParseQuery(strings.TrimPrefix(param, "?"))
+	if err != nil {
+		return ""
+	}
+	return q.Get(streamKeyParam)
+}
+
+// matchStreamKey find the active key which exactly matches the secret, for the app and stream. Returns nil
+// if no key ever bound to the stream, or an error if the stream has keys but none matches, even if all keys
+// are expired or revoked.
+func matchStreamKey(keys []*StreamKey, app, stream, secret string, now time.Time) (*StreamKey, error) {
+	var bound int
+	for _, key := range keys {
+		if key.App != app || key.Stream != stream {
+			continue
+		}
+		bound++
+
+		if secret == "" || subtle.ConstantTimeCompare([]byte(key.Key), []byte(secret)) != 1 {
+			continue
+		}
+		if !key.Active(now) {
+			return nil, errors.Errorf("key %v of %v/%v not active", key.ID, app, stream)
+		}
+		return key, nil
+	}
+
+	if bound > 0 {
+		return nil, errors.Errorf("no matched key for %v/%v, keys=%v", app, stream, bound)
+	}
+	return nil, nil
+}
+
+// loadStreamKeys load all stream keys, sorted by create time.
+func loadStreamKeys(ctx context.Context) ([]*StreamKey, error) {
+	values, err := rdb.HGetAll(ctx, SRS_STREAM_KEYS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_KEYS)
+	}
+
+	keys := []*StreamKey{}
+	for id, value := range values {
+		var key StreamKey
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, value)
+		}
+		keys = append(keys, &key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreateAt < keys[j].CreateAt
+	})
+	return keys, nil
+}
+
+// streamKeyPublisher is the publisher of stream, which is verified by the key.
+type streamKeyPublisher struct {
+	// The key ID used to publish.
+	KeyID string `json:"keyId"`
+	// The stream published.
+	SrsStream
+}
+
+// verifyStreamKey verify the publish request by the keys bound to stream. Returns false if no key ever bound
+// to stream, so the caller should fallback to the room or global secret.
+func verifyStreamKey(ctx context.Context, streamObj *SrsStream) (bool, error) {
+	keys, err := loadStreamKeys(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "load keys")
+	}
+
+	secret := parseStreamKeyParam(streamObj.Param)
+	key, err := matchStreamKey(keys, streamObj.App, streamObj.Stream, secret, time.Now())
+	if err != nil {
+		return false, errors.Wrapf(err, "match key")
+	}
+	if key == nil {
+		return false, nil
+	}
+
+	// Save the key of publisher, to kickoff the publisher when key is revoked.
+	streamURL := streamObj.StreamURL()
+	publisher := &streamKeyPublisher{KeyID: key.ID, SrsStream: *streamObj}
+	if b, err := json.Marshal(publisher); err != nil {
+		return false, errors.Wrapf(err, "marshal publisher")
+	} else if err := rdb.HSet(ctx, SRS_STREAM_KEY_PUBLISHERS, streamURL, string(b)).Err(); err != nil && err != redis.Nil {
+		return false, errors.Wrapf(err, "hset %v %v %v", SRS_STREAM_KEY_PUBLISHERS, streamURL, string(b))
+	}
+
+	logger.Tf(ctx, "stream key ok, stream=%v, key=%v", streamURL, key.ID)
+	return true, nil
+}
+
+// kickoffStreamKeyPublishers kickoff the publishers which use the key, ignore if stream is not active.
+func kickoffStreamKeyPublishers(ctx context.Context, keyID string) error {
+	values, err := rdb.HGetAll(ctx, SRS_STREAM_KEY_PUBLISHERS).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_STREAM_KEY_PUBLISHERS)
+	}
+
+	for streamURL, value := range values {
+		var publisher streamKeyPublisher
+		if err := json.Unmarshal([]byte(value), &publisher); err != nil {
+			return errors.Wrapf(err, "unmarshal %v %v", streamURL, value)
+		}
+		if publisher.KeyID != keyID {
+			continue
+		}
+
+		if active, err := rdb.HGet(ctx, SRS_STREAM_ACTIVE, streamURL).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v %v", SRS_STREAM_ACTIVE, streamURL)
+		} else if active != "" {
+			code, err := kickoffStream(ctx, publisher.Vhost, publisher.App, publisher.Stream)
+			if err != nil {
+				return errors.Wrapf(err, "kickoff %v", streamURL)
+			}
+			logger.Tf(ctx, "stream key kickoff stream=%v, key=%v, code=%v", streamURL, keyID, code)
+		}
+
+		if err := rdb.HDel(ctx, SRS_STREAM_KEY_PUBLISHERS, streamURL).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hdel %v %v", SRS_STREAM_KEY_PUBLISHERS, streamURL)
+		}
+	}
+	return nil
+}
+
+// kickoffInactiveStreamKeyPublishers kickoff the publishers whose key is no longer active, for example, the
+// key is expired or removed, which is checked by crontab.
+func kickoffInactiveStreamKeyPublishers(ctx context.Context) error {
+	keys, err := loadStreamKeys(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load keys")
+	}
+
+	values, err := rdb.HGetAll(ctx, SRS_STREAM_KEY_PUBLISHERS).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_STREAM_KEY_PUBLISHERS)
+	}
+
+	keyIDs := inactiveStreamKeyPublishers(keys, values, time.Now())
+	for _, keyID := range keyIDs {
+		logger.Tf(ctx, "stream key inactive, key=%v, kickoff publishers", keyID)
+		if err := kickoffStreamKeyPublishers(ctx, keyID); err != nil {
+			return errors.Wrapf(err, "kickoff publishers of %v", keyID)
+		}
+	}
+	return nil
+}
+
+// inactiveStreamKeyPublishers returns the key IDs used by publishers, which are no longer active at now.
+func inactiveStreamKeyPublishers(keys []*StreamKey, publishers map[string]string, now time.Time) []string {
+	active := make(map[string]bool)
+	for _, key := range keys {
+		active[key.ID] = key.Active(now)
+	}
+
+	var keyIDs []string
+	for _, value := range publishers {
+		var publisher streamKeyPublisher
+		if err := json.Unmarshal([]byte(value), &publisher); err != nil {
+			continue
+		}
+		if active[publisher.KeyID] {
+			continue
+		}
+
+		active[publisher.KeyID] = true // Only kickoff once for each key.
+		keyIDs = append(keyIDs, publisher.KeyID)
+	}
+	sort.Strings(keyIDs)
+	return keyIDs
+}
+
+func handleStreamKeysService(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/hooks/srs/keys/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var key StreamKey
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string `json:"token"`
+				Name      *string `json:"name"`
+				App       *string `json:"app"`
+				Stream    *string `json:"stream"`
+				Key       *string `json:"key"`
+				NotBefore *string `json:"notBefore"`
+				ExpireAt  *string `json:"expireAt"`
+			}{
+				Token: &token, Name: &key.Name, App: &key.App, Stream: &key.Stream, Key: &key.Key,
+				NotBefore: &key.NotBefore, ExpireAt: &key.ExpireAt,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := key.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", key.String())
+			}
+
+			if b, err := json.Marshal(&key); err != nil {
+				return errors.Wrapf(err, "marshal %v", key.String())
+			} else if err := rdb.HSet(ctx, SRS_STREAM_KEYS, key.ID, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v", SRS_STREAM_KEYS, key.ID)
+			}
+
+			ohttp.WriteData(ctx, w, r, &key)
+			logger.Tf(ctx, "stream keys create ok, %v, token=%vB", key.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/keys/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+			}{
+				Token: &token, App: &app, Stream: &stream,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			keys, err := loadStreamKeys(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load keys")
+			}
+
+			// Filter by app and stream, if specified.
+			filtered := []*StreamKey{}
+			for _, key := range keys {
+				if (app == "" || key.App == app) && (stream == "" || key.Stream == stream) {
+					filtered = append(filtered, key)
+				}
+			}
+
+			now := time.Now()
+			type StreamKeyStatus struct {
+				*StreamKey
+				// Whether the key is active now.
+				Active bool `json:"active"`
+			}
+			statuses := []*StreamKeyStatus{}
+			for _, key := range filtered {
+				statuses = append(statuses, &StreamKeyStatus{StreamKey: key, Active: key.Active(now)})
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Keys []*StreamKeyStatus `json:"keys"`
+			}{
+				Keys: statuses,
+			})
+			logger.Tf(ctx, "stream keys query ok, app=%v, stream=%v, keys=%v, token=%vB",
+				app, stream, len(statuses), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/keys/revoke"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if id == "" {
+				return errors.New("no id")
+			}
+
+			// Keep the revoked key, so the stream never fallback to the room or global secret.
+			var key StreamKey
+			if value, err := rdb.HGet(ctx, SRS_STREAM_KEYS, id).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_STREAM_KEYS, id)
+			} else if value == "" {
+				return errors.Errorf("no key %v", id)
+			} else if err := json.Unmarshal([]byte(value), &key); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", id, value)
+			}
+
+			if key.RevokeAt == "" {
+				key.RevokeAt = time.Now().Format(time.RFC3339)
+				if b, err := json.Marshal(&key); err != nil {
+					return errors.Wrapf(err, "marshal %v", key.String())
+				} else if err := rdb.HSet(ctx, SRS_STREAM_KEYS, key.ID, string(b)).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v %v", SRS_STREAM_KEYS, key.ID)
+				}
+			}
+
+			// Kickoff the publisher immediately, which uses the revoked key.
+			if err := kickoffStreamKeyPublishers(ctx, id); err != nil {
+				return errors.Wrapf(err, "kickoff publishers of %v", id)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "stream keys revoke ok, id=%v, token=%vB", id, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,454 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+var streamStatusWorker *StreamStatusWorker
+
+const (
+	// The interval to sample the status of streams.
+	streamStatusInterval = 10 * time.Second
+	// The max number of samples for each stream, about 10 minutes.
+	streamStatusMaxHistory = 60
+)
+
+// SrsAPIStream is the stream object of SRS HTTP API, see /api/v1/streams/
+type SrsAPIStream struct {
+	// The stream ID, referenced by the clients.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// The vhost ID, see SrsAPIVhost, and the vhost name which is resolved by the ID.
+	Vhost     string `json:"vhost"`
+	VhostName string `json:"-"`
+	App       string `json:"app"`
+	Clients   int    `json:"clients"`
+	// The total number of video frames.
+	Frames    uint64 `json:"frames"`
+	SendBytes uint64 `json:"send_bytes"`
+	RecvBytes uint64 `json:"recv_bytes"`
+	Kbps      struct {
+		Recv30s int `json:"recv_30s"`
+		Send30s int `json:"send_30s"`
+	} `json:"kbps"`
+	Publish struct {
+		Active bool   `json:"active"`
+		Cid    string `json:"cid"`
+	} `json:"publish"`
+	Video *struct {
+		Codec   string `json:"codec"`
+		Profile string `json:"profile"`
+		Level   string `json:"level"`
+		Width   int    `json:"width"`
+		Height  int    `json:"height"`
+	} `json:"video"`
+	Audio *struct {
+		Codec      string `json:"codec"`
+		SampleRate int    `json:"sample_rate"`
+		Channel    int    `json:"channel"`
+		Profile    string `json:"profile"`
+	} `json:"audio"`
+}
+
+// SrsAPIVhost is the vhost object of SRS HTTP API, see /api/v1/vhosts/
+type SrsAPIVhost struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SrsAPIClient is the client object of SRS HTTP API, see /api/v1/clients/
+type SrsAPIClient struct {
+	ID string `json:"id"`
+	// The stream ID of client.
+	Stream string `json:"stream"`
+	IP     string `json:"ip"`
+	// The type of client, such as fmle-publish, rtmp-play, flv-play, rtc-publish or srt-publish.
+	Type    string  `json:"type"`
+	Publish bool    `json:"publish"`
+	Alive   float64 `json:"alive"`
+}
+
+// Protocol returns the protocol of client, such as rtmp, flv, hls, rtc or srt.
+func (v *SrsAPIClient) Protocol() string {
+	protocol := v.Type
+	if index := strings.Index(protocol, "-"); index > 0 {
+		protocol = protocol[:index]
+	}
+
+	switch protocol {
+	case "fmle", "flash", "haivision":
+		return "rtmp"
+	case "":
+		return "unknown"
+	}
+	return strings.ToLower(protocol)
+}
+
+// StreamPublisher is the publisher of stream.
+type StreamPublisher struct {
+	// The client ID of SRS.
+	Client string `json:"client"`
+	// The IP of publisher.
+	IP string `json:"ip"`
+	// The protocol to publish, such as rtmp, rtc or srt.
+	Protocol string `json:"protocol"`
+}
+
+// StreamVideo is the video codec information of stream.
+type StreamVideo struct {
+	Codec   string `json:"codec"`
+	Profile string `json:"profile,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// StreamAudio is the audio codec information of stream.
+type StreamAudio struct {
+	Codec      string `json:"codec"`
+	SampleRate int    `json:"sampleRate"`
+	Channels   int    `json:"channels"`
+	Profile    string `json:"profile,omitempty"`
+}
+
+// StreamSample is a sample of stream status, to show the spikes and drops.
+type StreamSample struct {
+	// The sample time in RFC3339.
+	Time string `json:"time"`
+	// The ingest and egress bitrate in kbps.
+	RecvKbps int `json:"recvKbps"`
+	SendKbps int `json:"sendKbps"`
+	// The video frame rate, calculated by the frames between samples.
+	FPS float64 `json:"fps"`
+	// The total number of viewers.
+	Viewers int `json:"viewers"`
+
+	// The sample time and the total number of frames, to calculate the fps.
+	at     time.Time
+	frames uint64
+}
+
+func (v *StreamSample) String() string {
+	return fmt.Sprintf("time=%v, recv=%vkbps, send=%vkbps, fps=%.1f, viewers=%v",
+		v.Time, v.RecvKbps, v.SendKbps, v.FPS, v.Viewers)
+}
+
+// StreamStatus is the live status of stream, by joining the active stream and the SRS HTTP API.
+type StreamStatus struct {
+	// The active stream, saved when publishing.
+	*SrsStream
+	// The publisher of stream.
+	Publisher *StreamPublisher `json:"publisher,omitempty"`
+	// The uptime in seconds.
+	Uptime float64 `json:"uptime"`
+	// The ingest and egress bitrate in kbps.
+	RecvKbps int `json:"recvKbps"`
+	SendKbps int `json:"sendKbps"`
+	// The video frame rate, from the latest sample.
+	FPS float64 `json:"fps"`
+	// The codec information.
+	Video *StreamVideo `json:"video,omitempty"`
+	Audio *StreamAudio `json:"audio,omitempty"`
+	// The number of viewers, the key is protocol such as rtmp, flv, hls, rtc or srt.
+	Viewers      int            `json:"viewers"`
+	ViewersProto map[string]int `json:"viewersProto"`
+	// The rolling history of samples.
+	History []*StreamSample `json:"history"`
+}
+
+// findSrsAPIStream find the stream of SRS HTTP API by vhost, app and stream name.
+func findSrsAPIStream(streams []*SrsAPIStream, vhost, app, stream string) *SrsAPIStream {
+	for _, s := range streams {
+		if s.VhostName == vhost && s.App == app && s.Name == stream {
+			return s
+		}
+	}
+	return nil
+}
+
+// buildStreamStatus build the status of stream, by joining the stream and clients of SRS HTTP API. The
+// apiStream might be nil if SRS HTTP API is not available.
+func buildStreamStatus(
+	stream *SrsStream, apiStream *SrsAPIStream, clients []*SrsAPIClient, now time.Time,
+) *StreamStatus {
+	status := &StreamStatus{SrsStream: stream, ViewersProto: make(map[string]int)}
+	if update, err := time.Parse(time.RFC3339, stream.Update); err == nil {
+		status.Uptime = now.Sub(update).Seconds()
+	}
+
+	if apiStream == nil {
+		return status
+	}
+
+	status.RecvKbps, status.SendKbps = apiStream.Kbps.Recv30s, apiStream.Kbps.Send30s
+	if video := apiStream.Video; video != nil {
+		status.Video = &StreamVideo{
+			Codec: video.Codec, Profile: video.Profile, Level: video.Level, Width: video.Width, Height: video.Height,
+		}
+	}
+	if audio := apiStream.Audio; audio != nil {
+		status.Audio = &StreamAudio{
+			Codec: audio.Codec, SampleRate: audio.SampleRate, Channels: audio.Channel, Profile: audio.Profile,
+		}
+	}
+
+	for _, client := range clients {
+		if client.Stream != apiStream.ID {
+			continue
+		}
+
+		if client.Publish || client.ID == apiStream.Publish.Cid {
+			status.Publisher = &StreamPublisher{Client: client.ID, IP: client.IP, Protocol: client.Protocol()}
+			status.Uptime = client.Alive
+			continue
+		}
+
+		status.Viewers++
+		status.ViewersProto[client.Protocol()]++
+	}
+	return status
+}
+
+// buildStreamSample build the sample of stream, calculate the fps by the previous sample.
+func buildStreamSample(status *StreamStatus, apiStream *SrsAPIStream, prev *StreamSample, now time.Time) *StreamSample {
+	sample := &StreamSample{
+		Time: now.Format(time.RFC3339), RecvKbps: status.RecvKbps, SendKbps: status.SendKbps,
+		Viewers: status.Viewers, at: now, frames: apiStream.Frames,
+	}
+	if prev != nil && apiStream.Frames >= prev.frames {
+		if duration := now.Sub(prev.at).Seconds(); duration > 0 {
+			sample.FPS = float64(apiStream.Frames-prev.frames) / duration
+		}
+	}
+	return sample
+}
+
+// requestSrsAPI request the SRS HTTP API, and parse the response to v.
+func requestSrsAPI(ctx context.Context, api string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:1985%v", api), nil)
+	if err != nil {
+		return errors.Wrapf(err, "new request")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "do request %v", api)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("status %v, body %v", res.StatusCode, string(b))
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	return nil
+}
+
+// querySrsStreamsAndClients query the streams and clients of SRS HTTP API.
+func querySrsStreamsAndClients(ctx context.Context) ([]*SrsAPIStream, []*SrsAPIClient, error) {
+	var streams []*SrsAPIStream
+	if err := requestSrsAPI(ctx, "/api/v1/streams/?count=1000", &struct {
+		Streams *[]*SrsAPIStream `json:"streams"`
+	}{
+		Streams: &streams,
+	}); err != nil {
+		return nil, nil, errors.Wrapf(err, "query streams")
+	}
+
+	var clients []*SrsAPIClient
+	if err := requestSrsAPI(ctx, "/api/v1/clients/?count=1000", &struct {
+		Clients *[]*SrsAPIClient `json:"clients"`
+	}{
+		Clients: &clients,
+	}); err != nil {
+		return nil, nil, errors.Wrapf(err, "query clients")
+	}
+
+	var vhosts []*SrsAPIVhost
+	if err := requestSrsAPI(ctx, "/api/v1/vhosts/", &struct {
+		Vhosts *[]*SrsAPIVhost `json:"vhosts"`
+	}{
+		Vhosts: &vhosts,
+	}); err != nil {
+		return nil, nil, errors.Wrapf(err, "query vhosts")
+	}
+
+	resolveSrsAPIStreamVhosts(streams, vhosts)
+	return streams, clients, nil
+}
+
+// resolveSrsAPIStreamVhosts resolve the vhost name of streams, because the vhost of stream is the vhost ID.
+func resolveSrsAPIStreamVhosts(streams []*SrsAPIStream, vhosts []*SrsAPIVhost) {
+	names := make(map[string]string)
+	for _, vhost := range vhosts {
+		names[vhost.ID] = vhost.Name
+	}
+	for _, stream := range streams {
+		stream.VhostName = names[stream.Vhost]
+	}
+}
+
+// StreamStatusWorker sample the status of active streams periodically, to keep a rolling history.
+type StreamStatusWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// The history of streams, key is stream URL.
+	history map[string][]*StreamSample
+	lock    sync.Mutex
+}
+
+func NewStreamStatusWorker() *StreamStatusWorker {
+	return &StreamStatusWorker{history: make(map[string][]*StreamSample)}
+}
+
+func (v *StreamStatusWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *StreamStatusWorker) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "stream status start a worker, interval=%v, history=%v",
+		streamStatusInterval, streamStatusMaxHistory)
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(streamStatusInterval):
+			}
+
+			if err := v.sample(ctx); err != nil {
+				logger.Wf(ctx, "stream status ignore sample err %+v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// sample query the status of active streams, and append to the history.
+func (v *StreamStatusWorker) sample(ctx context.Context) error {
+	streams, err := queryActiveStreams(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query active streams")
+	}
+
+	// Cleanup the history if no active streams, and ignore the request to SRS.
+	if len(streams) == 0 {
+		v.update(nil)
+		return nil
+	}
+
+	apiStreams, clients, err := querySrsStreamsAndClients(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query srs")
+	}
+
+	now := time.Now()
+	samples := make(map[string]*StreamSample)
+	for _, stream := range streams {
+		apiStream := findSrsAPIStream(apiStreams, stream.Vhost, stream.App, stream.Stream)
+		if apiStream == nil {
+			continue
+		}
+
+		streamURL := stream.StreamURL()
+		status := buildStreamStatus(stream, apiStream, clients, now)
+		samples[streamURL] = buildStreamSample(status, apiStream, v.last(streamURL), now)
+	}
+
+	v.update(samples)
+	return nil
+}
+
+// last returns the latest sample of stream, or nil if no history.
+func (v *StreamStatusWorker) last(streamURL string) *StreamSample {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if history := v.history[streamURL]; len(history) > 0 {
+		return history[len(history)-1]
+	}
+	return nil
+}
+
+// update append the samples to history, and remove the history of streams which are not sampled.
+func (v *StreamStatusWorker) update(samples map[string]*StreamSample) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for streamURL := range v.history {
+		if _, ok := samples[streamURL]; !ok {
+			delete(v.history, streamURL)
+		}
+	}
+
+	for streamURL, sample := range samples {
+		history := append(v.history[streamURL], sample)
+		if len(history) > streamStatusMaxHistory {
+			history = history[len(history)-streamStatusMaxHistory:]
+		}
+		v.history[streamURL] = history
+	}
+}
+
+// History returns a copy of the history of stream.
+func (v *StreamStatusWorker) History(streamURL string) []*StreamSample {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	history := make([]*StreamSample, len(v.history[streamURL]))
+	copy(history, v.history[streamURL])
+	return history
+}
+
+// queryActiveStreams query the active streams, which are saved when publishing.
+func queryActiveStreams(ctx context.Context) ([]*SrsStream, error) {
+	streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+	}
+
+	var streamObjects []*SrsStream
+	for _, value := range streams {
+		var stream SrsStream
+		if err := json.Unmarshal([]byte(value), &stream); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", value)
+		}
+
+		streamObjects = append(streamObjects, &stream)
+	}
+	return streamObjects, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamKey_Validate(t *testing.T) {
+	key := &StreamKey{App: "live", Stream: "livestream"}
+	if err := key.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if key.ID == "" || len(key.Key) != 32 || key.CreateAt == "" {
+		t.Errorf("expect generated id, key and create, got %v", key.String())
+	}
+
+	tests := []struct {
+		name string
+		key  *StreamKey
+	}{
+		{name: "NoStream", key: &StreamKey{App: "live"}},
+		{name: "InvalidStream", key: &StreamKey{App: "live", Stream: "a/b"}},
+		{name: "InvalidKey", key: &StreamKey{App: "live", Stream: "livestream", Key: "a&b"}},
+		{name: "InvalidTime", key: &StreamKey{App: "live", Stream: "livestream", ExpireAt: "tomorrow"}},
+		{name: "ExpireBeforeNotBefore", key: &StreamKey{
+			App: "live", Stream: "livestream", NotBefore: "2024-01-02T00:00:00Z", ExpireAt: "2024-01-01T00:00:00Z",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.key.Validate(); err == nil {
+				t.Errorf("expect error for %v", tt.key.String())
+			}
+		})
+	}
+}
+
+func TestStreamKey_Active(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		key  *StreamKey
+		want bool
+	}{
+		{name: "NoLimit", key: &StreamKey{}, want: true},
+		{name: "InRange", key: &StreamKey{NotBefore: "2024-01-01T00:00:00Z", ExpireAt: "2024-01-03T00:00:00Z"}, want: true},
+		{name: "NotYet", key: &StreamKey{NotBefore: "2024-01-03T00:00:00Z"}, want: false},
+		{name: "Expired", key: &StreamKey{ExpireAt: "2024-01-01T00:00:00Z"}, want: false},
+		{name: "Revoked", key: &StreamKey{RevokeAt: "2024-01-01T00:00:00Z"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.Active(now); got != tt.want {
+				t.Errorf("Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStreamKeyParam(t *testing.T) {
+	if got := parseStreamKeyParam("?secret=abc&upstream=srt"); got != "abc" {
+		t.Errorf("parseStreamKeyParam() = %v, want abc", got)
+	}
+	if got := parseStreamKeyParam(""); got != "" {
+		t.Errorf("parseStreamKeyParam() = %v, want empty", got)
+	}
+}
+
+func TestMatchStreamKey(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	keys := []*StreamKey{
+		{ID: "old", App: "live", Stream: "livestream", Key: "oldkey"},
+		{ID: "new", App: "live", Stream: "livestream", Key: "newkey"},
+		{ID: "expired", App: "live", Stream: "livestream", Key: "expiredkey", ExpireAt: "2024-01-01T00:00:00Z"},
+		{ID: "other", App: "live", Stream: "other", Key: "otherkey"},
+		{ID: "revoked", App: "live", Stream: "revoked", Key: "revokedkey", RevokeAt: "2024-01-01T00:00:00Z"},
+	}
+
+	tests := []struct {
+		name    string
+		app     string
+		stream  string
+		secret  string
+		want    string
+		wantErr bool
+	}{
+		{name: "OldKey", app: "live", stream: "livestream", secret: "oldkey", want: "old"},
+		{name: "NewKey", app: "live", stream: "livestream", secret: "newkey", want: "new"},
+		{name: "Substring", app: "live", stream: "livestream", secret: "newkeyx", wantErr: true},
+		{name: "Prefix", app: "live", stream: "livestream", secret: "new", wantErr: true},
+		{name: "OtherStreamKey", app: "live", stream: "livestream", secret: "otherkey", wantErr: true},
+		{name: "Expired", app: "live", stream: "livestream", secret: "expiredkey", wantErr: true},
+		{name: "NoSecret", app: "live", stream: "livestream", secret: "", wantErr: true},
+		{name: "OtherApp", app: "show", stream: "livestream", secret: "oldkey"},
+		{name: "NoKeys", app: "live", stream: "nokeys", secret: "any"},
+		{name: "Revoked", app: "live", stream: "revoked", secret: "revokedkey", wantErr: true},
+		{name: "RevokedOnly", app: "live", stream: "revoked", secret: "any", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := matchStreamKey(keys, tt.app, tt.stream, tt.secret, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchStreamKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got string
+			if key != nil {
+				got = key.ID
+			}
+			if got != tt.want {
+				t.Errorf("matchStreamKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInactiveStreamKeyPublishers(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	keys := []*StreamKey{
+		{ID: "active", App: "live", Stream: "livestream", Key: "activekey"},
+		{ID: "expired", App: "live", Stream: "livestream", Key: "expiredkey", ExpireAt: "2024-01-01T00:00:00Z"},
+		{ID: "revoked", App: "live", Stream: "show", Key: "revokedkey", RevokeAt: "2024-01-01T00:00:00Z"},
+	}
+	publishers := map[string]string{
+		"live/livestream": `{"keyId":"active"}`,
+		"live/show":       `{"keyId":"revoked"}`,
+		"live/show2":      `{"keyId":"expired"}`,
+		"live/show3":      `{"keyId":"expired"}`,
+		"live/removed":    `{"keyId":"removed"}`,
+	}
+
+	if got, want := strings.Join(inactiveStreamKeyPublishers(keys, publishers, now), ","), "expired,removed,revoked"; got != want {
+		t.Errorf("inactiveStreamKeyPublishers() = %v, want %v", got, want)
+	}
+}
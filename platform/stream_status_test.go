@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSrsAPIClient_Protocol(t *testing.T) {
+	tests := map[string]string{
+		"fmle-publish":      "rtmp",
+		"flash-publish":     "rtmp",
+		"haivision-publish": "rtmp",
+		"rtmp-play":         "rtmp",
+		"flv-play":          "flv",
+		"hls-play":          "hls",
+		"rtc-publish":       "rtc",
+		"srt-play":          "srt",
+		"":                  "unknown",
+	}
+	for typ, want := range tests {
+		if got := (&SrsAPIClient{Type: typ}).Protocol(); got != want {
+			t.Errorf("Protocol(%v) = %v, want %v", typ, got, want)
+		}
+	}
+}
+
+func TestBuildStreamStatus(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+	stream := &SrsStream{
+		Vhost: "__defaultVhost__", App: "live", Stream: "livestream", Update: "2024-01-01T00:00:00Z",
+	}
+
+	// Without SRS HTTP API, use the publish time as uptime.
+	status := buildStreamStatus(stream, nil, nil, now)
+	if status.Uptime != 600 || status.Publisher != nil || status.Viewers != 0 {
+		t.Errorf("unexpected status uptime=%v, publisher=%v, viewers=%v", status.Uptime, status.Publisher, status.Viewers)
+	}
+
+	var apiStreams []*SrsAPIStream
+	if err := json.Unmarshal([]byte(`[{
+		"id": "vid-0", "name": "livestream", "vhost": "vhost-2", "app": "live"
+	}, {
+		"id": "vid-1", "name": "livestream", "vhost": "vhost-1", "app": "live", "frames": 3000,
+		"kbps": {"recv_30s": 2500, "send_30s": 5000}, "publish": {"active": true, "cid": "c-pub"},
+		"video": {"codec": "H264", "profile": "High", "level": "3.1", "width": 1920, "height": 1080},
+		"audio": {"codec": "AAC", "sample_rate": 44100, "channel": 2, "profile": "LC"}
+	}, {"id": "vid-2", "name": "other", "vhost": "vhost-1", "app": "live"}]`), &apiStreams); err != nil {
+		t.Fatalf("unmarshal streams err %v", err)
+	}
+	resolveSrsAPIStreamVhosts(apiStreams, []*SrsAPIVhost{
+		{ID: "vhost-1", Name: "__defaultVhost__"}, {ID: "vhost-2", Name: "example.com"},
+	})
+
+	var clients []*SrsAPIClient
+	if err := json.Unmarshal([]byte(`[
+		{"id": "c-pub", "stream": "vid-1", "ip": "10.0.0.1", "type": "fmle-publish", "publish": true, "alive": 300.5},
+		{"id": "c-1", "stream": "vid-1", "ip": "10.0.0.2", "type": "flv-play", "publish": false},
+		{"id": "c-2", "stream": "vid-1", "ip": "10.0.0.3", "type": "flv-play", "publish": false},
+		{"id": "c-3", "stream": "vid-1", "ip": "10.0.0.4", "type": "rtc-play", "publish": false},
+		{"id": "c-4", "stream": "vid-2", "ip": "10.0.0.5", "type": "rtmp-play", "publish": false}
+	]`), &clients); err != nil {
+		t.Fatalf("unmarshal clients err %v", err)
+	}
+
+	if apiStream := findSrsAPIStream(apiStreams, "example.com", "live", "livestream"); apiStream == nil || apiStream.ID != "vid-0" {
+		t.Errorf("expect stream vid-0, got %v", apiStream)
+	}
+	if apiStream := findSrsAPIStream(apiStreams, "other.com", "live", "livestream"); apiStream != nil {
+		t.Errorf("expect no stream, got %v", apiStream)
+	}
+
+	apiStream := findSrsAPIStream(apiStreams, stream.Vhost, stream.App, stream.Stream)
+	if apiStream == nil || apiStream.ID != "vid-1" {
+		t.Fatalf("expect stream vid-1, got %v", apiStream)
+	}
+
+	status = buildStreamStatus(stream, apiStream, clients, now)
+	if status.Publisher == nil || status.Publisher.IP != "10.0.0.1" || status.Publisher.Protocol != "rtmp" {
+		t.Errorf("unexpected publisher %v", status.Publisher)
+	}
+	if status.Uptime != 300.5 {
+		t.Errorf("unexpected uptime %v", status.Uptime)
+	}
+	if status.RecvKbps != 2500 || status.SendKbps != 5000 {
+		t.Errorf("unexpected kbps recv=%v, send=%v", status.RecvKbps, status.SendKbps)
+	}
+	if status.Video == nil || status.Video.Codec != "H264" || status.Video.Width != 1920 || status.Video.Height != 1080 {
+		t.Errorf("unexpected video %v", status.Video)
+	}
+	if status.Audio == nil || status.Audio.Codec != "AAC" || status.Audio.SampleRate != 44100 || status.Audio.Channels != 2 {
+		t.Errorf("unexpected audio %v", status.Audio)
+	}
+	if status.Viewers != 3 || status.ViewersProto["flv"] != 2 || status.ViewersProto["rtc"] != 1 {
+		t.Errorf("unexpected viewers %v, %v", status.Viewers, status.ViewersProto)
+	}
+
+	// The stream fields should be kept at top level, for compatibility.
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("marshal err %v", err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		t.Fatalf("unmarshal err %v", err)
+	}
+	if obj["stream"] != "livestream" || obj["app"] != "live" {
+		t.Errorf("expect stream fields at top level, got %v", string(b))
+	}
+}
+
+func TestBuildStreamSample(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	status := &StreamStatus{RecvKbps: 1000, Viewers: 2}
+
+	first := buildStreamSample(status, &SrsAPIStream{Frames: 100}, nil, now)
+	if first.FPS != 0 || first.RecvKbps != 1000 || first.Viewers != 2 {
+		t.Errorf("unexpected first sample %v", first.String())
+	}
+
+	second := buildStreamSample(status, &SrsAPIStream{Frames: 400}, first, now.Add(10*time.Second))
+	if second.FPS != 30 {
+		t.Errorf("unexpected fps %v", second.FPS)
+	}
+
+	// The frames might be reset when republish.
+	third := buildStreamSample(status, &SrsAPIStream{Frames: 10}, second, now.Add(20*time.Second))
+	if third.FPS != 0 {
+		t.Errorf("unexpected fps %v", third.FPS)
+	}
+}
+
+func TestStreamStatusWorker_History(t *testing.T) {
+	worker := NewStreamStatusWorker()
+	for i := 0; i < streamStatusMaxHistory+5; i++ {
+		worker.update(map[string]*StreamSample{
+			"live/a": {Time: fmt.Sprintf("%v", i)},
+			"live/b": {Time: fmt.Sprintf("%v", i)},
+		})
+	}
+
+	history := worker.History("live/a")
+	if len(history) != streamStatusMaxHistory || history[0].Time != "5" {
+		t.Errorf("unexpected history len=%v, first=%v", len(history), history[0].Time)
+	}
+	if last := worker.last("live/a"); last == nil || last.Time != fmt.Sprintf("%v", streamStatusMaxHistory+4) {
+		t.Errorf("unexpected last %v", last)
+	}
+
+	// Remove the history of streams not sampled.
+	worker.update(map[string]*StreamSample{"live/a": {Time: "new"}})
+	if len(worker.History("live/b")) != 0 {
+		t.Errorf("expect no history of live/b")
+	}
+	worker.update(nil)
+	if len(worker.History("live/a")) != 0 {
+		t.Errorf("expect no history of live/a")
+	}
+}
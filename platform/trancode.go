@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"os/exec"
 	"path"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -31,15 +33,101 @@ type TranscodeWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The global transcode task, only support one transcode task.
-	task *TranscodeTask
+	// The transcode tasks, key is stream URL and profile name, see transcodeTaskKey, value is *TranscodeTask.
+	tasks sync.Map
+	// The output streams of tasks, key is stream URL such as live/livestream_720p, value is the last time
+	// in time.Time the task is running. Keep it for a while after task stopped, because the output stream
+	// might be still active, which should never be transcoded.
+	outputs sync.Map
+	// Notify the worker to update tasks immediately, when config changed or stream published.
+	notify chan bool
 }
 
 func NewTranscodeWorker() *TranscodeWorker {
-	v := &TranscodeWorker{}
-	v.task = NewTranscodeTask()
-	v.task.transcodeWorker = v
-	return v
+	return &TranscodeWorker{
+		notify: make(chan bool, 1),
+	}
+}
+
+// transcodeTaskKey build the key for task of stream and profile.
+func transcodeTaskKey(streamURL, profile string) string {
+	return fmt.Sprintf("%v#%v", streamURL, profile)
+}
+
+// queryTasks returns all the transcode tasks, sorted by stream URL and profile name.
+func (v *TranscodeWorker) queryTasks() []*TranscodeTask {
+	var tasks []*TranscodeTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		tasks = append(tasks, value.(*TranscodeTask))
+		return true
+	})
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Stream != tasks[j].Stream {
+			return tasks[i].Stream < tasks[j].Stream
+		}
+		return tasks[i].Profile < tasks[j].Profile
+	})
+	return tasks
+}
+
+// The duration to keep the output stream after task stopped, see TranscodeWorker.outputs.
+const transcodeOutputExpire = 30 * time.Second
+
+// updateOutputs refresh the output streams of running tasks, and remove the expired ones. Returns the
+// output streams, key is stream URL.
+func (v *TranscodeWorker) updateOutputs() map[string]bool {
+	now := time.Now()
+	for _, task := range v.queryTasks() {
+		if _, _, output, _, _ := task.queryFrame(); output != "" {
+			v.outputs.Store(transcodeOutputStream(output), now)
+		}
+	}
+
+	outputs := make(map[string]bool)
+	v.outputs.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(time.Time)) > transcodeOutputExpire {
+			v.outputs.Delete(key)
+		} else {
+			outputs[key.(string)] = true
+		}
+		return true
+	})
+	return outputs
+}
+
+// notifyUpdate notify the worker to update the tasks, never block.
+func (v *TranscodeWorker) notifyUpdate() {
+	select {
+	case v.notify <- true:
+	default:
+	}
+}
+
+// OnStreamMessage is called by the SRS hooks, to start the tasks when stream published, and stop the tasks
+// when stream unpublished. Note that the SRS_STREAM_ACTIVE should be updated before this callback.
+func (v *TranscodeWorker) OnStreamMessage(ctx context.Context, action SrsAction, streamObj *SrsStream) error {
+	if action != SrsActionOnPublish && action != SrsActionOnUnpublish {
+		return nil
+	}
+
+	// Stop the tasks of stream immediately, to release the FFmpeg processes.
+	if action == SrsActionOnUnpublish {
+		streamURL := streamObj.StreamURL()
+		v.tasks.Range(func(key, value interface{}) bool {
+			if task := value.(*TranscodeTask); task.Stream == streamURL {
+				logger.Tf(ctx, "transcode stop task %v by %v", task.String(), action)
+				task.Stop()
+				v.tasks.Delete(key)
+			}
+			return true
+		})
+	}
+
+	// Start tasks for new stream, or the stream which is waiting for another stream to unpublish.
+	v.notifyUpdate()
+	logger.Tf(ctx, "transcode on stream message ok, action=%v, stream=%v", action, streamObj.StreamURL())
+	return nil
 }
 
 func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
@@ -62,12 +150,8 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}
 
 			var config TranscodeConfig
-			if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
-			} else if len(b) > 0 {
-				if err := json.Unmarshal([]byte(b), &config); err != nil {
-					return errors.Wrapf(err, "unmarshal %v", b)
-				}
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
 			}
 
 			ohttp.WriteData(ctx, w, r, &config)
@@ -103,16 +187,26 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				return err
 			}
 
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
 			if b, err := json.Marshal(config); err != nil {
 				return errors.Wrapf(err, "marshal conf %v", config)
 			} else if err := rdb.HSet(ctx, SRS_TRANSCODE_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hset %v global %v", SRS_TRANSCODE_CONFIG, string(b))
 			}
 
-			if err := v.task.Restart(ctx); err != nil {
-				return errors.Wrapf(err, "restart task %v", config.String())
+			// Restart all tasks to apply the new config, and notify the worker to update the tasks, because
+			// the profiles or streams might be changed.
+			for _, task := range v.queryTasks() {
+				if err := task.Restart(ctx); err != nil {
+					return errors.Wrapf(err, "restart task %v", task.String())
+				}
 			}
 
+			v.notifyUpdate()
+
 			ohttp.WriteData(ctx, w, r, nil)
 			logger.Tf(ctx, "transcode apply ok, %v, token=%vB", config, len(token))
 			return nil
@@ -140,15 +234,30 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}
 
 			var config TranscodeConfig
-			if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
-			} else if len(b) > 0 {
-				if err := json.Unmarshal([]byte(b), &config); err != nil {
-					return errors.Wrapf(err, "unmarshal %v", b)
-				}
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
 			}
 
-			pid, input, output, frame, update := v.task.queryFrame()
+			type TranscodeTaskFrame struct {
+				// The FFmpeg log lines.
+				Log string `json:"log"`
+				// The last update time.
+				Update string `json:"update"`
+			}
+			type TranscodeTaskResult struct {
+				// The task uuid.
+				UUID string `json:"uuid"`
+				// The source stream URL, such as live/livestream
+				Stream string `json:"stream"`
+				// The profile name, empty for the default profile.
+				Profile string `json:"profile"`
+				// The input stream URL.
+				InputStream string `json:"input"`
+				// The output stream URL.
+				OutputStream string `json:"output"`
+				// The FFmpeg log.
+				Frame TranscodeTaskFrame `json:"frame"`
+			}
 
 			res := struct {
 				// The task uuid.
@@ -160,25 +269,120 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				// The output stream URL.
 				OutputStream string `json:"output"`
 				// The FFmpeg log.
-				Frame struct {
-					// The FFmpeg log lines.
-					Log string `json:"log"`
-					// The last update time.
-					Update string `json:"update"`
-				} `json:"frame"`
-			}{}
-			res.Enabled = config.All
-			res.UUID = v.task.UUID
-			if pid > 0 {
-				res.InputStream = input
-				res.OutputStream = output
-				res.Frame.Log = frame
-				res.Frame.Update = update
+				Frame TranscodeTaskFrame `json:"frame"`
+				// All transcode tasks, one for each stream and profile.
+				Tasks []*TranscodeTaskResult `json:"tasks"`
+				// The profile set of each stream, key is stream URL, value is the profile names.
+				Streams map[string][]string `json:"streams"`
+			}{
+				Enabled: config.All,
+				Tasks:   []*TranscodeTaskResult{},
+				Streams: map[string][]string{},
+			}
+
+			for _, task := range v.queryTasks() {
+				pid, input, output, frame, update := task.queryFrame()
+
+				elem := &TranscodeTaskResult{
+					UUID: task.UUID, Stream: task.Stream, Profile: task.Profile,
+				}
+				if pid > 0 {
+					elem.InputStream, elem.OutputStream = input, output
+					elem.Frame.Log, elem.Frame.Update = frame, update
+				}
+
+				res.Tasks = append(res.Tasks, elem)
+				res.Streams[task.Stream] = append(res.Streams[task.Stream], task.Profile)
+
+				// For compatibility, the first running task is also the task of response.
+				if res.UUID == "" && pid > 0 {
+					res.UUID, res.InputStream, res.OutputStream = elem.UUID, elem.InputStream, elem.OutputStream
+					res.Frame = elem.Frame
+				}
 			}
 
 			ohttp.WriteData(ctx, w, r, &res)
-			logger.Tf(ctx, "transcode task ok, %v, pid=%v, input=%v, output=%v, frame=%v, update=%v, token=%vB",
-				config, pid, input, output, frame, update, len(token))
+			logger.Tf(ctx, "transcode task ok, %v, tasks=%v, streams=%v, token=%vB",
+				config, len(res.Tasks), len(res.Streams), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/transcode/hls/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is :app/:stream.m3u8
+			filename := r.URL.Path[len("/terraform/v1/ffmpeg/transcode/hls/"):]
+			if !strings.HasSuffix(filename, ".m3u8") {
+				return errors.Errorf("invalid m3u8 %v of %v", filename, r.URL.Path)
+			}
+
+			// Note that we must use path.Clean to avoid path traversal.
+			streamURL := strings.TrimPrefix(path.Clean("/"+strings.TrimSuffix(filename, ".m3u8")), "/")
+			if strings.Count(streamURL, "/") != 1 {
+				return errors.Errorf("invalid stream %v of %v", streamURL, r.URL.Path)
+			}
+
+			var config TranscodeConfig
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+
+			// Use the output stream of task, because the app of output might differ from the input.
+			var renditions []*transcodeRendition
+			for _, task := range v.queryTasks() {
+				if task.Stream != streamURL || task.Profile == "" {
+					continue
+				}
+
+				_, _, output, _, _ := task.queryFrame()
+				for _, profile := range config.Profiles {
+					if profile.Name == task.Profile && output != "" {
+						renditions = append(renditions, &transcodeRendition{
+							profile: profile, stream: transcodeOutputStream(output),
+						})
+					}
+				}
+			}
+			if len(renditions) == 0 {
+				return errors.Errorf("no transcode profiles for %v", streamURL)
+			}
+
+			// Verify the play token, and sign the token of each rendition, because the token is bound to stream.
+			// Note that the error is status error of 401, so never wrap it.
+			token, err := verifyPlayAuthRequest(ctx, r)
+			if err != nil {
+				return err
+			} else if token != "" {
+				stream := streamURL[strings.Index(streamURL, "/")+1:]
+				key, err := loadPlayAuthKey(ctx, stream)
+				if err != nil {
+					return errors.Wrapf(err, "load key of %v", streamURL)
+				}
+
+				for _, rendition := range renditions {
+					target := rendition.stream[strings.Index(rendition.stream, "/")+1:]
+					targetKey, err := loadPlayAuthKey(ctx, target)
+					if err != nil {
+						return errors.Wrapf(err, "load key of %v", rendition.stream)
+					}
+
+					rendition.token, err = derivePlayToken(key, streamURL, token, playAuthClientIP(r), targetKey, rendition.stream)
+					if err != nil {
+						return errors.Wrapf(err, "derive token for %v", rendition.stream)
+					}
+				}
+			}
+
+			contentType, m3u8Body := buildTranscodeMasterM3u8(renditions)
+
+			w.Header().Set("Cache-Control", "no-cache, max-age=0")
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(m3u8Body))
+			logger.Tf(ctx, "transcode generate master m3u8 ok, stream=%v, renditions=%v", streamURL, len(renditions))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -227,24 +431,24 @@ func (v *TranscodeWorker) Start(ctx context.Context) error {
 		}
 	}
 
-	// Start global transcode task.
+	// Start or stop tasks for each stream and profile, from the active streams. Note that the hooks of stream
+	// notify to update the tasks immediately, so the polling is a fallback, for example, for the streams which
+	// are published before the worker started.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.Run(ctx); err != nil {
-				logger.Wf(ctx, "run task %v err %+v", task.String(), err)
+			duration := 3 * time.Second
+			if err := v.updateTasks(ctx); err != nil {
+				logger.Wf(ctx, "transcode update tasks err %+v", err)
 				duration = 10 * time.Second
-			} else {
-				duration = 3 * time.Second
 			}
 
 			select {
 			case <-ctx.Done():
 			case <-time.After(duration):
+			case <-v.notify:
 			}
 		}
 	}()
@@ -252,6 +456,139 @@ func (v *TranscodeWorker) Start(ctx context.Context) error {
 	return nil
 }
 
+// updateTasks loads the config and active streams, then starts a task for each pair of stream and profile
+// which is not running, and stops the tasks which are not expected.
+func (v *TranscodeWorker) updateTasks(ctx context.Context) error {
+	var config TranscodeConfig
+	if err := config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load config")
+	}
+
+	streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+	}
+
+	var activeStreams []*SrsStream
+	for _, value := range streams {
+		var stream SrsStream
+		if err := json.Unmarshal([]byte(value), &stream); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", value)
+		}
+		activeStreams = append(activeStreams, &stream)
+	}
+
+	// Build the expected tasks, key is the task key.
+	expected := make(map[string]*transcodeBinding)
+	if config.All {
+		bindings, err := config.Bind(activeStreams, v.updateOutputs())
+		if err != nil {
+			return errors.Wrapf(err, "bind streams")
+		}
+		for _, binding := range bindings {
+			expected[transcodeTaskKey(binding.stream.StreamURL(), binding.profile.Name)] = binding
+		}
+	}
+
+	// Stop the tasks which are not expected.
+	v.tasks.Range(func(key, value interface{}) bool {
+		if _, ok := expected[key.(string)]; !ok {
+			task := value.(*TranscodeTask)
+			logger.Tf(ctx, "transcode stop task %v", task.String())
+			task.Stop()
+			v.tasks.Delete(key)
+		}
+		return true
+	})
+
+	// Start the tasks which are expected but not running.
+	for key, binding := range expected {
+		if _, loaded := v.tasks.Load(key); loaded {
+			continue
+		}
+
+		task := NewTranscodeTask(binding.stream, binding.profile)
+		task.Output = config.OutputURL(binding.profile, binding.stream, "localhost")
+		task.transcodeWorker = v
+		v.tasks.Store(key, task)
+		logger.Tf(ctx, "transcode start task %v", task.String())
+
+		taskCtx, taskCancel := context.WithCancel(ctx)
+		task.stop = taskCancel
+
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+			defer taskCancel()
+
+			if err := task.Run(taskCtx); err != nil {
+				logger.Wf(ctx, "run task %v err %+v", task.String(), err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// The profile name, which is used as the suffix of the output stream, so only allow some safe characters.
+var transcodeProfileNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// TranscodeProfile is a rendition of the ABR ladder, for example, 720p. Each profile is transcoded by an
+// FFmpeg process for each stream, and output to a stream named {stream}_{profile}.
+type TranscodeProfile struct {
+	// The profile name, such as 720p, used as the suffix of the output stream.
+	Name string `json:"name"`
+	// The stream name patterns to bind this profile, for example, live*. If empty, bind to all streams.
+	Streams []string `json:"streams"`
+	// The video codec name.
+	VideoCodec string `json:"vcodec"`
+	// The audio codec name.
+	AudioCodec string `json:"acodec"`
+	// The video bitrate in kbps.
+	VideoBitrate int `json:"vbitrate"`
+	// The audio bitrate in kbps.
+	AudioBitrate int `json:"abitrate"`
+	// The video profile, for example, baseline.
+	VideoProfile string `json:"vprofile"`
+	// The video preset, for example, veryfast.
+	VideoPreset string `json:"vpreset"`
+	// The audio channels.
+	AudioChannels int `json:"achannels"`
+	// The video width in pixels, zero to keep the aspect ratio by height.
+	Width int `json:"width"`
+	// The video height in pixels, zero to keep the aspect ratio by width.
+	Height int `json:"height"`
+}
+
+func (v *TranscodeProfile) String() string {
+	return fmt.Sprintf("name=%v, streams=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, "+
+		"vprofile=%v, vpreset=%v, width=%v, height=%v",
+		v.Name, v.Streams, v.VideoCodec, v.AudioCodec, v.VideoBitrate, v.AudioBitrate, v.AudioChannels,
+		v.VideoProfile, v.VideoPreset, v.Width, v.Height,
+	)
+}
+
+// Match whether the stream name matches the patterns of profile.
+func (v *TranscodeProfile) Match(stream string) (bool, error) {
+	if len(v.Streams) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range v.Streams {
+		if ok, err := path.Match(pattern, stream); err != nil {
+			return false, errors.Wrapf(err, "match %v", pattern)
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Bandwidth is the peak bitrate in bps of the rendition, for HLS master playlist.
+func (v *TranscodeProfile) Bandwidth() int {
+	return (v.VideoBitrate + v.AudioBitrate) * 1000
+}
+
 type TranscodeConfig struct {
 	// Whether transcode all streams.
 	All bool `json:"all"`
@@ -271,20 +608,284 @@ type TranscodeConfig struct {
 	AudioChannels int `json:"achannels"`
 	// The RTMP server url, for example, rtmp://localhost/live
 	Server string `json:"server"`
-	// The RTMP stream and secret, for example, livestream
+	// The RTMP stream and secret, for example, livestream, the variables [app] and [stream] are replaced by
+	// the input stream, for example, [stream]_hd to transcode each stream to a different output.
 	Secret string `json:"secret"`
+	// The glob pattern of vhost to match the input streams, empty to match all, for example, __defaultVhost__
+	Vhost string `json:"vhost,omitempty"`
+	// The glob pattern of app to match the input streams, empty to match all, for example, live
+	App string `json:"app,omitempty"`
+	// The glob pattern of stream to match the input streams, empty to match all, for example, livestream*
+	Stream string `json:"stream,omitempty"`
+	// The named profiles for ABR ladder, for example, 1080p, 720p and 480p. If empty, use the codec fields
+	// above as the default profile, which outputs to the server and secret.
+	Profiles []*TranscodeProfile `json:"profiles,omitempty"`
 }
 
 func (v TranscodeConfig) String() string {
-	return fmt.Sprintf("all=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, vprofile=%v, vpreset=%v, server=%v, secret=%v",
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("all=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, vprofile=%v, vpreset=%v, server=%v, secret=%v",
 		v.All, v.VideoCodec, v.AudioCodec, v.VideoBitrate, v.AudioBitrate, v.AudioChannels, v.VideoProfile,
 		v.VideoPreset, v.Server, v.Secret,
-	)
+	))
+	if v.Vhost != "" || v.App != "" || v.Stream != "" {
+		sb.WriteString(fmt.Sprintf(", vhost=%v, app=%v, stream=%v", v.Vhost, v.App, v.Stream))
+	}
+	for _, profile := range v.Profiles {
+		sb.WriteString(fmt.Sprintf(", profile=(%v)", profile.String()))
+	}
+	return sb.String()
+}
+
+// Load the config from redis.
+func (v *TranscodeConfig) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+// Validate the stream patterns and profiles, the name of profile should be unique and safe, and the
+// patterns should be valid.
+func (v *TranscodeConfig) Validate() error {
+	for _, pattern := range []string{v.Vhost, v.App, v.Stream} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern %v", pattern)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, profile := range v.Profiles {
+		if profile == nil {
+			return errors.New("empty profile")
+		}
+		if !transcodeProfileNameRegex.MatchString(profile.Name) {
+			return errors.Errorf("invalid profile name %v", profile.Name)
+		}
+		if names[profile.Name] {
+			return errors.Errorf("duplicated profile %v", profile.Name)
+		}
+		names[profile.Name] = true
+
+		for _, pattern := range profile.Streams {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Wrapf(err, "invalid pattern %v of profile %v", pattern, profile.Name)
+			}
+		}
+		if profile.Width < 0 || profile.Height < 0 {
+			return errors.Errorf("invalid size %vx%v of profile %v", profile.Width, profile.Height, profile.Name)
+		}
+		if profile.VideoCodec == "" || profile.AudioCodec == "" {
+			return errors.Errorf("no vcodec or acodec of profile %v", profile.Name)
+		}
+		if profile.VideoProfile == "" || profile.VideoPreset == "" {
+			return errors.Errorf("no vprofile or vpreset of profile %v", profile.Name)
+		}
+		if profile.VideoBitrate < 0 || profile.AudioBitrate < 0 {
+			return errors.Errorf("invalid bitrate %v/%v of profile %v", profile.VideoBitrate, profile.AudioBitrate, profile.Name)
+		}
+	}
+	return nil
+}
+
+// defaultProfile build the default profile from the codec fields, which has no name.
+func (v *TranscodeConfig) defaultProfile() *TranscodeProfile {
+	return &TranscodeProfile{
+		VideoCodec: v.VideoCodec, AudioCodec: v.AudioCodec,
+		VideoBitrate: v.VideoBitrate, AudioBitrate: v.AudioBitrate,
+		VideoProfile: v.VideoProfile, VideoPreset: v.VideoPreset,
+		AudioChannels: v.AudioChannels,
+	}
+}
+
+// Match whether the input stream matches the vhost, app and stream patterns, empty pattern matches all.
+func (v *TranscodeConfig) Match(stream *SrsStream) (bool, error) {
+	for _, filter := range []struct {
+		pattern, value string
+	}{
+		{v.Vhost, stream.Vhost}, {v.App, stream.App}, {v.Stream, stream.Stream},
+	} {
+		if filter.pattern == "" {
+			continue
+		}
+
+		if ok, err := path.Match(filter.pattern, filter.value); err != nil {
+			return false, errors.Wrapf(err, "match %v", filter.pattern)
+		} else if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isStreamTemplate whether the output contains the [stream] variable, so each stream has its own output.
+func (v *TranscodeConfig) isStreamTemplate() bool {
+	return strings.Contains(v.Server, "[stream]") || strings.Contains(v.Secret, "[stream]")
+}
+
+// OutputURL build the output URL of profile for the input stream. For the default profile, output to the
+// server and secret; for a named profile, output to {stream}_{profile} with the query string of secret, for
+// example, ?secret=xxx to pass the publish authentication. The localhost is replaced by host.
+func (v *TranscodeConfig) OutputURL(profile *TranscodeProfile, input *SrsStream, host string) string {
+	replacer := strings.NewReplacer("[app]", input.App, "[stream]", input.Stream)
+	outputServer := replacer.Replace(strings.ReplaceAll(v.Server, "localhost", host))
+	secret := replacer.Replace(v.Secret)
+
+	if profile.Name != "" {
+		var query string
+		if index := strings.Index(secret, "?"); index >= 0 {
+			query = secret[index:]
+		}
+		return fmt.Sprintf("%v/%v_%v%v", strings.TrimSuffix(outputServer, "/"), input.Stream, profile.Name, query)
+	}
+
+	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(secret, "/") && secret != "" {
+		outputServer += "/"
+	}
+	return fmt.Sprintf("%v%v", outputServer, secret)
+}
+
+// transcodeOutputStream returns the stream URL of output, such as live/livestream_720p, ignore the
+// host and query string.
+func transcodeOutputStream(outputURL string) string {
+	if u, err := url.Parse(outputURL); err == nil {
+		outputURL = u.Path
+	}
+	return strings.TrimPrefix(path.Clean("/"+outputURL), "/")
+}
+
+// IsTranscodeOutput whether the stream is the output of transcoding any active stream, which should never be
+// transcoded again. The outputs is the output streams of tasks, key is stream URL.
+func (v *TranscodeConfig) IsTranscodeOutput(stream *SrsStream, streams []*SrsStream, outputs map[string]bool) bool {
+	if outputs[stream.StreamURL()] {
+		return true
+	}
+
+	// Ignore the output of default profile and renditions, note that it might be the stream itself.
+	profiles := append([]*TranscodeProfile{v.defaultProfile()}, v.Profiles...)
+	for _, input := range append([]*SrsStream{stream}, streams...) {
+		for _, profile := range profiles {
+			if transcodeOutputStream(v.OutputURL(profile, input, "localhost")) == stream.StreamURL() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transcodeBinding is a pair of stream and profile, which is a transcode task.
+type transcodeBinding struct {
+	stream  *SrsStream
+	profile *TranscodeProfile
+}
+
+// Bind the active streams which match the patterns to profiles, each pair is a transcode task. The outputs
+// is the output streams of tasks, which are never transcoded.
+func (v *TranscodeConfig) Bind(streams []*SrsStream, outputs map[string]bool) ([]*transcodeBinding, error) {
+	var candidates []*SrsStream
+	for _, stream := range streams {
+		if v.IsTranscodeOutput(stream, streams, outputs) {
+			continue
+		}
+
+		if ok, err := v.Match(stream); err != nil {
+			return nil, errors.Wrapf(err, "match %v", stream.StreamURL())
+		} else if ok {
+			candidates = append(candidates, stream)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].StreamURL() < candidates[j].StreamURL()
+	})
+
+	var bindings []*transcodeBinding
+	if len(v.Profiles) == 0 {
+		profile := v.defaultProfile()
+		if v.isStreamTemplate() {
+			for _, stream := range candidates {
+				bindings = append(bindings, &transcodeBinding{stream: stream, profile: profile})
+			}
+			return bindings, nil
+		}
+
+		// All streams output to the same stream, so only bind the first published stream, which keeps
+		// transcoding until unpublished, to avoid streams fighting for the output.
+		var first *SrsStream
+		for _, stream := range candidates {
+			if first == nil || stream.Update < first.Update {
+				first = stream
+			}
+		}
+
+		if first != nil {
+			bindings = append(bindings, &transcodeBinding{stream: first, profile: profile})
+		}
+		return bindings, nil
+	}
+
+	for _, stream := range candidates {
+		for _, profile := range v.Profiles {
+			if ok, err := profile.Match(stream.Stream); err != nil {
+				return nil, errors.Wrapf(err, "match %v", profile.String())
+			} else if ok {
+				bindings = append(bindings, &transcodeBinding{stream: stream, profile: profile})
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// transcodeRendition is the output stream of profile, for HLS master playlist.
+type transcodeRendition struct {
+	profile *TranscodeProfile
+	// The output stream URL, such as live/livestream_720p
+	stream string
+	// The play token of output stream, empty if play auth is disabled.
+	token string
+}
+
+// buildTranscodeMasterM3u8 build the HLS master playlist for stream, which groups the renditions of profiles,
+// ordered by bandwidth from high to low.
+func buildTranscodeMasterM3u8(renditions []*transcodeRendition) (contentType, m3u8Body string) {
+	renditions = append([]*transcodeRendition{}, renditions...)
+	sort.SliceStable(renditions, func(i, j int) bool {
+		return renditions[i].profile.Bandwidth() > renditions[j].profile.Bandwidth()
+	})
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:3\n")
+	for _, rendition := range renditions {
+		profile := rendition.profile
+		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%v", profile.Bandwidth()))
+		if profile.Width > 0 && profile.Height > 0 {
+			sb.WriteString(fmt.Sprintf(",RESOLUTION=%vx%v", profile.Width, profile.Height))
+		}
+		sb.WriteString(fmt.Sprintf(",NAME=\"%v\"\n", profile.Name))
+		uri := fmt.Sprintf("/%v.m3u8", rendition.stream)
+		if rendition.token != "" {
+			uri = appendPlayAuthToken(uri, rendition.token)
+		}
+		sb.WriteString(uri + "\n")
+	}
+
+	contentType = "application/vnd.apple.mpegurl"
+	m3u8Body = sb.String()
+	return
 }
 
 type TranscodeTask struct {
 	// The ID for task.
 	UUID string `json:"uuid"`
+	// The source stream URL, such as live/livestream
+	Stream string `json:"stream"`
+	// The profile name, empty for the default profile.
+	Profile string `json:"profile"`
 
 	// The input url.
 	Input string `json:"input"`
@@ -300,9 +901,15 @@ type TranscodeTask struct {
 	// The last update time.
 	update time.Time
 
-	// The context for current task.
+	// The context for current FFmpeg process.
 	cancel context.CancelFunc
+	// To stop the task, when stream unpublished or profile removed.
+	stop context.CancelFunc
 
+	// The source stream to transcode.
+	input *SrsStream
+	// The profile for transcode task.
+	profile *TranscodeProfile
 	// The configure for transcode task.
 	config TranscodeConfig
 	// The transcode worker.
@@ -312,16 +919,20 @@ type TranscodeTask struct {
 	lock sync.Mutex
 }
 
-func NewTranscodeTask() *TranscodeTask {
-	return &TranscodeTask{UUID: uuid.NewString()}
+func NewTranscodeTask(input *SrsStream, profile *TranscodeProfile) *TranscodeTask {
+	return &TranscodeTask{
+		UUID: uuid.NewString(), Stream: input.StreamURL(), Profile: profile.Name,
+		input: input, profile: profile,
+	}
 }
 
 func (v *TranscodeTask) String() string {
-	return fmt.Sprintf("uuid=%v, pid=%v, config is %v",
-		v.UUID, v.PID, v.config.String(),
+	return fmt.Sprintf("uuid=%v, stream=%v, profile=%v, pid=%v, config is %v",
+		v.UUID, v.Stream, v.Profile, v.PID, v.config.String(),
 	)
 }
 
+// Restart the FFmpeg process, to apply the new config.
 func (v *TranscodeTask) Restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -333,107 +944,59 @@ func (v *TranscodeTask) Restart(ctx context.Context) error {
 	return nil
 }
 
-func (v *TranscodeTask) Run(ctx context.Context) error {
-	ctx = logger.WithContext(ctx)
-	logger.Tf(ctx, "transcode run task %v", v.String())
-
-	isSameStream := func(a, b string) bool {
-		ua, err := url.Parse(a)
-		if err != nil {
-			return false
-		}
-
-		ub, err := url.Parse(b)
-		if err != nil {
-			return false
-		}
-
-		if path.Clean(ua.Path) == path.Clean(ub.Path) {
-			return true
-		}
+// Stop the task and FFmpeg process.
+func (v *TranscodeTask) Stop() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
 
-		return false
+	if v.stop != nil {
+		v.stop()
 	}
+}
 
-	// TODO: FIXME: Should select stream again when stream republished.
-	selectActiveStream := func() (*SrsStream, error) {
-		streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
-		if err != nil {
-			return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
-		}
-
-		var best *SrsStream
-		for _, value := range streams {
-			var stream SrsStream
-			if err := json.Unmarshal([]byte(value), &stream); err != nil {
-				return nil, errors.Wrapf(err, "unmarshal %v", value)
-			}
-
-			// Ignore the transcode stream itself.
-			if isSameStream(fmt.Sprintf("%v/%v", v.config.Server, v.config.Secret),
-				fmt.Sprintf("rtmp://%v/%v/%v", stream.Vhost, stream.App, stream.Stream)) {
-				continue
-			}
-
-			if best == nil {
-				best = &stream
-				continue
-			}
-
-			bestUpdate, err := time.Parse(time.RFC3339, best.Update)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse %v", best.Update)
-			}
-
-			streamUpdate, err := time.Parse(time.RFC3339, stream.Update)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse %v", stream.Update)
-			}
-
-			if bestUpdate.Before(streamUpdate) {
-				best = &stream
-			}
-		}
+func (v *TranscodeTask) Run(ctx context.Context) error {
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "transcode run task %v", v.String())
 
-		// Ignore if no active stream.
-		if best == nil {
-			return nil, nil
+	// Remove the task from redis when stopped, note that the ctx is cancelled.
+	defer func() {
+		ctx := logger.WithContext(context.Background())
+		if err := rdb.HDel(ctx, SRS_TRANSCODE_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+			logger.Wf(ctx, "hdel %v %v err %+v", SRS_TRANSCODE_TASK, v.UUID, err)
 		}
-
-		logger.Tf(ctx, "transcode use best=%v as input", best.StreamURL())
-		return best, nil
-	}
+	}()
 
 	pfn := func(ctx context.Context) error {
-		if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
-		} else if len(b) > 0 {
-			if err := json.Unmarshal([]byte(b), &v.config); err != nil {
-				return errors.Wrapf(err, "unmarshal %v", b)
-			}
+		var config TranscodeConfig
+		if err := config.Load(ctx); err != nil {
+			return errors.Wrapf(err, "load config")
 		}
 
 		// Ignore if not enabled.
-		if !v.config.All {
+		if !config.All {
 			return nil
 		}
 
-		if err := v.saveTask(ctx); err != nil {
-			return errors.Wrapf(err, "save task")
-		}
-
-		// Use a active stream as input.
-		input, err := selectActiveStream()
-		if err != nil {
-			return errors.Wrapf(err, "select input")
+		// Use the latest profile from config, because it might be changed.
+		v.lock.Lock()
+		v.config = config
+		if v.Profile == "" {
+			v.profile = config.defaultProfile()
+		} else {
+			for _, profile := range config.Profiles {
+				if profile.Name == v.Profile {
+					v.profile = profile
+				}
+			}
 		}
+		v.lock.Unlock()
 
-		if input == nil {
-			return nil
+		if err := v.saveTask(ctx); err != nil {
+			return errors.Wrapf(err, "save task")
 		}
 
 		// Start transcode task.
-		if err := v.doTranscode(ctx, input); err != nil {
+		if err := v.doTranscode(ctx, v.input); err != nil {
 			return errors.Wrapf(err, "do transcode")
 		}
 
@@ -464,21 +1027,22 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 	// Create context for current task.
 	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
+
+	v.lock.Lock()
 	v.cancel = cancel
+	config, profile := v.config, v.profile
+	v.lock.Unlock()
 
 	// Build input URL.
 	host := "localhost"
 	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
 
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	outputURL := config.OutputURL(profile, input, host)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
+	heartbeat.MetricsTask, heartbeat.MetricsID = FFmpegTaskTranscode, transcodeTaskKey(v.Stream, v.Profile)
 
 	// Start FFmpeg process.
 	args := []string{}
@@ -499,18 +1063,34 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 		args = append(args, "-i", inputURL)
 	}
 	args = append(args,
-		"-vcodec", v.config.VideoCodec,
-		"-profile:v", v.config.VideoProfile,
-		"-preset:v", v.config.VideoPreset,
+		"-vcodec", profile.VideoCodec,
+		"-profile:v", profile.VideoProfile,
+		"-preset:v", profile.VideoPreset,
 		"-tune", "zerolatency", // Low latency mode.
-		"-b:v", fmt.Sprintf("%vk", v.config.VideoBitrate),
 		"-r", "25", "-g", "50", // Set gop to 2s.
 		"-bf", "0", // Disable B frame for WebRTC.
-		"-acodec", v.config.AudioCodec,
-		"-b:a", fmt.Sprintf("%vk", v.config.AudioBitrate),
 	)
-	if v.config.AudioChannels > 0 {
-		args = append(args, "-ac", fmt.Sprintf("%v", v.config.AudioChannels))
+	// Use the default bitrate of codec, if not specified.
+	if profile.VideoBitrate > 0 {
+		args = append(args, "-b:v", fmt.Sprintf("%vk", profile.VideoBitrate))
+	}
+	// Scale the video for rendition, use -2 to keep the aspect ratio and even size.
+	if profile.Width > 0 || profile.Height > 0 {
+		width, height := "-2", "-2"
+		if profile.Width > 0 {
+			width = fmt.Sprintf("%v", profile.Width)
+		}
+		if profile.Height > 0 {
+			height = fmt.Sprintf("%v", profile.Height)
+		}
+		args = append(args, "-vf", fmt.Sprintf("scale=%v:%v", width, height))
+	}
+	args = append(args, "-acodec", profile.AudioCodec)
+	if profile.AudioBitrate > 0 {
+		args = append(args, "-b:a", fmt.Sprintf("%vk", profile.AudioBitrate))
+	}
+	if profile.AudioChannels > 0 {
+		args = append(args, "-ac", fmt.Sprintf("%v", profile.AudioChannels))
 	}
 	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
 	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
@@ -531,8 +1111,10 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
 	}
 
+	v.lock.Lock()
 	v.PID = int32(cmd.Process.Pid)
 	v.Input, v.inputStreamURL, v.Output = inputURL, input.StreamURL(), outputURL
+	v.lock.Unlock()
 	defer func() {
 		// If we got a PID, sleep for a while, to avoid too fast restart.
 		if v.PID > 0 {
@@ -546,7 +1128,7 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 		v.cleanup(parentCtx)
 		v.saveTask(parentCtx)
 	}()
-	logger.Tf(ctx, "transcode start, stream=%v, pid=%v", input.StreamURL(), v.PID)
+	logger.Tf(ctx, "transcode start, stream=%v, profile=%v, pid=%v", input.StreamURL(), profile.Name, v.PID)
 
 	if err := v.saveTask(ctx); err != nil {
 		return errors.Wrapf(err, "save task %v", v.String())
@@ -571,11 +1153,11 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 	case <-ctx.Done():
 	case <-heartbeat.PollingCtx.Done():
 	}
-	logger.Tf(ctx, "Transcode: Cycle stopping, stream=%v, pid=%v", input.StreamURL(), v.PID)
+	logger.Tf(ctx, "Transcode: Cycle stopping, stream=%v, profile=%v, pid=%v", input.StreamURL(), profile.Name, v.PID)
 
 	err = cmd.Wait()
-	logger.Tf(ctx, "transcode done, stream=%v, pid=%v, err=%v",
-		input.StreamURL(), v.PID, err,
+	logger.Tf(ctx, "transcode done, stream=%v, profile=%v, pid=%v, err=%v",
+		input.StreamURL(), profile.Name, v.PID, err,
 	)
 	return err
 }
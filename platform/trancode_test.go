@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscodeConfig_Validate(t *testing.T) {
+	newProfile := func(name string, opts ...func(*TranscodeProfile)) *TranscodeProfile {
+		profile := &TranscodeProfile{
+			Name: name, VideoCodec: "libx264", AudioCodec: "aac", VideoProfile: "baseline", VideoPreset: "veryfast",
+		}
+		for _, opt := range opts {
+			opt(profile)
+		}
+		return profile
+	}
+
+	config := TranscodeConfig{Profiles: []*TranscodeProfile{
+		newProfile("720p", func(p *TranscodeProfile) { p.Streams, p.Width, p.Height = []string{"live*"}, 1280, 720 }),
+		newProfile("480p", func(p *TranscodeProfile) { p.Height = 480 }),
+	}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	if err := (&TranscodeConfig{Stream: "[live"}).Validate(); err == nil {
+		t.Errorf("Expected error for invalid stream pattern")
+	}
+
+	for _, profiles := range [][]*TranscodeProfile{
+		{newProfile("")},
+		{newProfile("../720p")},
+		{newProfile("720p"), newProfile("720p")},
+		{newProfile("720p", func(p *TranscodeProfile) { p.Streams = []string{"[live"} })},
+		{newProfile("720p", func(p *TranscodeProfile) { p.Width = -1 })},
+		{newProfile("720p", func(p *TranscodeProfile) { p.VideoCodec = "" })},
+		{newProfile("720p", func(p *TranscodeProfile) { p.AudioCodec = "" })},
+		{newProfile("720p", func(p *TranscodeProfile) { p.VideoProfile = "" })},
+		{newProfile("720p", func(p *TranscodeProfile) { p.VideoPreset = "" })},
+		{newProfile("720p", func(p *TranscodeProfile) { p.VideoBitrate = -1 })},
+	} {
+		config := TranscodeConfig{Profiles: profiles}
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected error for %v", config.String())
+		}
+	}
+}
+
+func TestTranscodeConfig_Bind(t *testing.T) {
+	streams := []*SrsStream{
+		{Vhost: "__defaultVhost__", App: "live", Stream: "livestream", Update: "2024-01-01T00:00:01Z"},
+		{Vhost: "__defaultVhost__", App: "live", Stream: "show", Update: "2024-01-01T00:00:02Z"},
+		{Vhost: "__defaultVhost__", App: "live", Stream: "livestream_720p", Update: "2024-01-01T00:00:03Z"},
+		{Vhost: "__defaultVhost__", App: "live", Stream: "output", Update: "2024-01-01T00:00:04Z"},
+	}
+
+	// The default profile only transcodes the first published stream, ignore the output itself.
+	config := TranscodeConfig{Server: "rtmp://localhost/live", Secret: "output"}
+	if bindings, err := config.Bind(streams, nil); err != nil {
+		t.Fatal(err)
+	} else if len(bindings) != 1 {
+		t.Fatalf("Expected 1 binding, got %v", len(bindings))
+	} else if stream := bindings[0].stream.Stream; stream != "livestream" {
+		t.Errorf("Expected livestream, got %v", stream)
+	} else if name := bindings[0].profile.Name; name != "" {
+		t.Errorf("Expected default profile, got %v", name)
+	}
+
+	// The named profiles transcode each matched stream, ignore the renditions.
+	config.Profiles = []*TranscodeProfile{
+		{Name: "720p", Streams: []string{"live*"}},
+		{Name: "480p"},
+	}
+	bindings, err := config.Bind(streams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, binding := range bindings {
+		keys = append(keys, transcodeTaskKey(binding.stream.StreamURL(), binding.profile.Name))
+	}
+	if r0, expect := strings.Join(keys, ","), "live/livestream#720p,live/livestream#480p,live/show#480p"; r0 != expect {
+		t.Errorf("Expected %v, got %v", expect, r0)
+	}
+
+	// The stream which ends with profile name is not a rendition, if not the output of any stream.
+	streams = append(streams, &SrsStream{Vhost: "__defaultVhost__", App: "live", Stream: "other_480p"})
+	bindings, err = config.Bind(streams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys = nil
+	for _, binding := range bindings {
+		keys = append(keys, transcodeTaskKey(binding.stream.StreamURL(), binding.profile.Name))
+	}
+	expect := "live/livestream#720p,live/livestream#480p,live/other_480p#480p,live/show#480p"
+	if r0 := strings.Join(keys, ","); r0 != expect {
+		t.Errorf("Expected %v, got %v", expect, r0)
+	}
+
+	// The output of task is never transcoded, even the input stream is unpublished.
+	outputs := map[string]bool{"live/livestream_720p": true, "live/other_480p": true}
+	bindings, err = config.Bind(streams[1:], outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys = nil
+	for _, binding := range bindings {
+		keys = append(keys, transcodeTaskKey(binding.stream.StreamURL(), binding.profile.Name))
+	}
+	if r0, expect := strings.Join(keys, ","), "live/show#480p"; r0 != expect {
+		t.Errorf("Expected %v, got %v", expect, r0)
+	}
+}
+
+func TestTranscodeConfig_BindByPatterns(t *testing.T) {
+	streams := []*SrsStream{
+		{Vhost: "__defaultVhost__", App: "live", Stream: "show"},
+		{Vhost: "__defaultVhost__", App: "live", Stream: "livestream"},
+		{Vhost: "__defaultVhost__", App: "live", Stream: "livestream_hd"},
+		{Vhost: "__defaultVhost__", App: "game", Stream: "livestream2"},
+	}
+
+	// Each matched stream has its own output, ignore the outputs.
+	config := TranscodeConfig{Server: "rtmp://localhost/live", Secret: "[stream]_hd?secret=xxx", Stream: "live*"}
+	bindings, err := config.Bind(streams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs []string
+	for _, binding := range bindings {
+		outputs = append(outputs, config.OutputURL(binding.profile, binding.stream, "127.0.0.1"))
+	}
+	expect := "rtmp://127.0.0.1/live/livestream2_hd?secret=xxx,rtmp://127.0.0.1/live/livestream_hd?secret=xxx"
+	if r0 := strings.Join(outputs, ","); r0 != expect {
+		t.Errorf("Expected %v, got %v", expect, r0)
+	}
+
+	// Filter by app.
+	config.App = "game"
+	if bindings, err := config.Bind(streams, nil); err != nil {
+		t.Fatal(err)
+	} else if len(bindings) != 1 || bindings[0].stream.StreamURL() != "game/livestream2" {
+		t.Errorf("Expected game/livestream2, got %v", bindings)
+	}
+}
+
+func TestBuildTranscodeMasterM3u8(t *testing.T) {
+	contentType, body := buildTranscodeMasterM3u8([]*transcodeRendition{
+		{&TranscodeProfile{Name: "480p", VideoBitrate: 800, AudioBitrate: 64, Width: 854, Height: 480}, "live/livestream_480p", ""},
+		{&TranscodeProfile{Name: "720p", VideoBitrate: 2000, AudioBitrate: 128, Width: 1280, Height: 720}, "abr/livestream_720p", ""},
+		{&TranscodeProfile{Name: "audio", AudioBitrate: 64}, "live/livestream_audio", ""},
+	})
+
+	if contentType != "application/vnd.apple.mpegurl" {
+		t.Errorf("Unexpected content type %v", contentType)
+	}
+
+	expect := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		"#EXT-X-STREAM-INF:BANDWIDTH=2128000,RESOLUTION=1280x720,NAME=\"720p\"",
+		"/abr/livestream_720p.m3u8",
+		"#EXT-X-STREAM-INF:BANDWIDTH=864000,RESOLUTION=854x480,NAME=\"480p\"",
+		"/live/livestream_480p.m3u8",
+		"#EXT-X-STREAM-INF:BANDWIDTH=64000,NAME=\"audio\"",
+		"/live/livestream_audio.m3u8",
+	}, "\n") + "\n"
+	if body != expect {
+		t.Errorf("Expected %v, got %v", expect, body)
+	}
+
+	// Append the play token to the rendition, which is signed for the output stream.
+	_, body = buildTranscodeMasterM3u8([]*transcodeRendition{
+		{&TranscodeProfile{Name: "720p"}, "live/livestream_720p", "1700000000-xxx"},
+	})
+	if !strings.Contains(body, "\n/live/livestream_720p.m3u8?token=1700000000-xxx\n") {
+		t.Errorf("Expected token in %v", body)
+	}
+
+	if r0 := transcodeOutputStream("rtmp://localhost/abr/livestream_720p?secret=xxx"); r0 != "abr/livestream_720p" {
+		t.Errorf("Expected abr/livestream_720p, got %v", r0)
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The max number of segments to evaluate the alert rules, drop the segment if exceed.
+const transcriptAlertQueueSize = 64
+
+// The timeout to classify the text by AI, for each rule.
+const transcriptAlertTimeout = 15 * time.Second
+
+// TranscriptAlertRule is a rule to match the ASR text of live streams. The rule matches if any keyword or
+// the regexp matches the text. If prompt is set, AI classifies the text by the prompt, to confirm the
+// matched text, or to match the text only by AI if no keywords and regexp.
+type TranscriptAlertRule struct {
+	// The rule ID, generated if empty.
+	ID string `json:"id"`
+	// The name of rule.
+	Name string `json:"name,omitempty"`
+	// Whether the rule is enabled.
+	Enabled bool `json:"enabled"`
+	// The glob filter of stream URL, such as /live/*, match all streams if empty.
+	Stream string `json:"stream,omitempty"`
+	// The keywords or phrases to match, case insensitive.
+	Keywords []string `json:"keywords,omitempty"`
+	// The regular expression to match.
+	Regexp string `json:"regexp,omitempty"`
+	// The prompt to classify the text by AI.
+	Prompt string `json:"prompt,omitempty"`
+	// Whether kickoff the stream when matched.
+	Kickoff bool `json:"kickoff,omitempty"`
+
+	// The compiled regular expression.
+	re *regexp.Regexp
+	// The compiled regular expressions of keywords, to match the whole words.
+	keywords []*regexp.Regexp
+}
+
+func (v *TranscriptAlertRule) String() string {
+	return fmt.Sprintf("id=%v, name=%v, enabled=%v, stream=%v, keywords=%v, regexp=%v, prompt=%vB, kickoff=%v",
+		v.ID, v.Name, v.Enabled, v.Stream, len(v.Keywords), v.Regexp, len(v.Prompt), v.Kickoff)
+}
+
+func (v *TranscriptAlertRule) Validate() error {
+	if v.ID == "" {
+		v.ID = uuid.NewString()
+	}
+	if v.Stream != "" {
+		if !strings.HasPrefix(v.Stream, "/") {
+			return errors.Errorf("invalid stream %v", v.Stream)
+		}
+		if _, err := path.Match(v.Stream, "/"); err != nil {
+			return errors.Wrapf(err, "invalid stream %v", v.Stream)
+		}
+	}
+	v.keywords = nil
+	for _, keyword := range v.Keywords {
+		if strings.TrimSpace(keyword) == "" {
+			return errors.New("empty keyword")
+		}
+		v.keywords = append(v.keywords, compileAlertKeyword(keyword))
+	}
+	if v.Regexp != "" {
+		re, err := regexp.Compile(v.Regexp)
+		if err != nil {
+			return errors.Wrapf(err, "invalid regexp %v", v.Regexp)
+		}
+		v.re = re
+	}
+	if len(v.Keywords) == 0 && v.Regexp == "" && v.Prompt == "" {
+		return errors.New("no keywords, regexp or prompt")
+	}
+	return nil
+}
+
+// selects returns whether the rule is enabled for the stream URL, such as /live/livestream
+func (v *TranscriptAlertRule) selects(stream string) bool {
+	if !v.Enabled {
+		return false
+	}
+	if v.Stream == "" {
+		return true
+	}
+	matched, err := path.Match(v.Stream, stream)
+	return err == nil && matched
+}
+
+// compileAlertKeyword returns the regexp to match the keyword as whole words, case insensitive. Note that the
+// word boundary is only required for the edge of ASCII word, because there is no space between CJK words.
+func compileAlertKeyword(keyword string) *regexp.Regexp {
+	keyword = strings.TrimSpace(keyword)
+	isWord := func(c byte) bool {
+		return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+
+	expr := regexp.QuoteMeta(keyword)
+	if isWord(keyword[0]) {
+		expr = `\b` + expr
+	}
+	if isWord(keyword[len(keyword)-1]) {
+		expr = expr + `\b`
+	}
+	return regexp.MustCompile("(?i)" + expr)
+}
+
+// matchText returns the matched keyword or text of regexp, or empty if not matched.
+func (v *TranscriptAlertRule) matchText(text string) string {
+	for i, re := range v.keywords {
+		if re.MatchString(text) {
+			return strings.TrimSpace(v.Keywords[i])
+		}
+	}
+	if v.re != nil {
+		return v.re.FindString(text)
+	}
+	return ""
+}
+
+// locate returns the matched text and the wall clock time of the ASR segment which contains it. The begin is
+// the start time of TS segment. Use the whole text if the phrase crosses ASR segments.
+func (v *TranscriptAlertRule) locate(asr *TranscriptAsrResult, begin time.Time) (string, time.Time) {
+	for _, s := range asr.Segments {
+		if matched := v.matchText(s.Text); matched != "" {
+			return matched, begin.Add(time.Duration(s.Start * float64(time.Second)))
+		}
+	}
+	return v.matchText(asr.Text), begin
+}
+
+// TranscriptAlertConfig is the alert rules for live transcript.
+type TranscriptAlertConfig struct {
+	// The alert rules.
+	Rules []*TranscriptAlertRule `json:"rules"`
+	// The AI chat settings to classify text by prompt, use the AI provider of transcript if not set.
+	Classifier *SrsAssistant `json:"classifier,omitempty"`
+}
+
+func NewTranscriptAlertConfig() *TranscriptAlertConfig {
+	return &TranscriptAlertConfig{Rules: []*TranscriptAlertRule{}}
+}
+
+func (v *TranscriptAlertConfig) String() string {
+	return fmt.Sprintf("rules=%v, classifier=%v", len(v.Rules), v.Classifier != nil)
+}
+
+func (v *TranscriptAlertConfig) Validate() error {
+	ids := make(map[string]bool)
+	for _, rule := range v.Rules {
+		if rule == nil {
+			return errors.New("empty rule")
+		}
+		if err := rule.Validate(); err != nil {
+			return errors.Wrapf(err, "validate %v", rule.String())
+		}
+		if ids[rule.ID] {
+			return errors.Errorf("duplicated id %v", rule.ID)
+		}
+		ids[rule.ID] = true
+	}
+	return nil
+}
+
+func (v *TranscriptAlertConfig) Load(ctx context.Context) error {
+	if value, err := rdb.HGet(ctx, SRS_TRANSCRIPT_CONFIG, "alerts").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v alerts", SRS_TRANSCRIPT_CONFIG)
+	} else if value != "" {
+		if err = json.Unmarshal([]byte(value), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", value)
+		}
+	}
+
+	// Compile the regexp of rules.
+	return v.Validate()
+}
+
+func (v *TranscriptAlertConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal conf %v", v)
+	} else if err := rdb.HSet(ctx, SRS_TRANSCRIPT_CONFIG, "alerts", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v alerts %v", SRS_TRANSCRIPT_CONFIG, string(b))
+	}
+	return nil
+}
+
+// TranscriptAlertMatch is the matched alert of a segment, which is sent by on_transcript_match callback.
+type TranscriptAlertMatch struct {
+	// The rule ID and name.
+	Rule string `json:"rule"`
+	Name string `json:"name,omitempty"`
+	// The seqno of TS segment.
+	SeqNo uint64 `json:"seqno"`
+	// The wall clock time of the matched text, in RFC3339.
+	Timestamp string `json:"timestamp"`
+	// The matched text.
+	Matched string `json:"matched"`
+	// The ASR text of segment.
+	Text string `json:"text"`
+	// Whether kickoff the stream.
+	Kickoff bool `json:"kickoff"`
+}
+
+func (v *TranscriptAlertMatch) String() string {
+	return fmt.Sprintf("rule=%v, name=%v, seqno=%v, timestamp=%v, matched=%v, kickoff=%v",
+		v.Rule, v.Name, v.SeqNo, v.Timestamp, v.Matched, v.Kickoff)
+}
+
+// parseClassifiedText parse the response of AI classifier, which is the matched text or NO.
+func parseClassifiedText(content string) string {
+	content = strings.Trim(strings.TrimSpace(content), "\"'.")
+	if strings.EqualFold(content, "NO") {
+		return ""
+	}
+	return content
+}
+
+// classifyTranscript ask AI whether the text matches the prompt, returns the matched text or empty.
+func classifyTranscript(ctx context.Context, chat *SrsAssistant, prompt, text string) (string, error) {
+	systemPrompt := fmt.Sprintf("%v. Check whether the live transcript matches the rule: %v. If matched, "+
+		"only respond the matched words or phrase in the transcript, otherwise only respond NO.",
+		chat.AIChatPrompt, prompt)
+
+	aiConfig := openai.DefaultConfig(chat.AISecretKey)
+	aiConfig.OrgID = chat.AIOrganization
+	aiConfig.BaseURL = chat.AIBaseURL
+
+	client := openai.NewClientWithConfig(aiConfig)
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chat.AIChatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: text},
+		},
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "classify")
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no classification")
+	}
+	return parseClassifiedText(resp.Choices[0].Message.Content), nil
+}
+
+// queryAlerts returns the cached alert rules, load from redis if not cached.
+func (v *TranscriptWorker) queryAlerts(ctx context.Context) (*TranscriptAlertConfig, error) {
+	v.alertsLock.Lock()
+	defer v.alertsLock.Unlock()
+
+	if v.alerts == nil {
+		alerts := NewTranscriptAlertConfig()
+		if err := alerts.Load(ctx); err != nil {
+			return nil, errors.Wrapf(err, "load alerts")
+		}
+		v.alerts = alerts
+	}
+	return v.alerts, nil
+}
+
+// resetAlerts drop the cached alert rules, for example, updated by user.
+func (v *TranscriptWorker) resetAlerts() {
+	v.alertsLock.Lock()
+	defer v.alertsLock.Unlock()
+	v.alerts = nil
+}
+
+// enqueueAlerts evaluate the alert rules for the segment asynchronously, because the AI classifier might be
+// slow, which should not block the ASR queue. Drop the segment if the queue is full.
+func (v *TranscriptTask) enqueueAlerts(ctx context.Context, segment *TranscriptSegment) {
+	if segment.Msg == nil || segment.TsFile == nil || segment.AsrText == nil || segment.AsrText.Text == "" {
+		return
+	}
+
+	// Copy the ASR text, because it might be fixed by user.
+	asr := *segment.AsrText
+	asr.Segments = append([]TranscriptAsrSegment{}, segment.AsrText.Segments...)
+	alert := &TranscriptSegment{Msg: segment.Msg, TsFile: segment.TsFile, AsrText: &asr, Received: segment.Received}
+
+	select {
+	case v.alertSegments <- alert:
+	default:
+		logger.Wf(ctx, "transcript: drop alert of %v, queue=%v", segment.String(), len(v.alertSegments))
+	}
+}
+
+// evaluateAlerts match the ASR text of segment by the alert rules, send on_transcript_match callback for
+// each matched rule, and kickoff the stream if required.
+func (v *TranscriptTask) evaluateAlerts(ctx context.Context, segment *TranscriptSegment) error {
+	alerts, err := v.transcriptWorker.queryAlerts(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query alerts")
+	}
+
+	begin := segmentBegin(segment)
+	var kickoff bool
+	for _, rule := range alerts.Rules {
+		if !rule.selects(v.Stream) {
+			continue
+		}
+
+		var matched string
+		at := begin
+		if len(rule.Keywords) > 0 || rule.re != nil {
+			if matched, at = rule.locate(segment.AsrText, begin); matched == "" {
+				continue
+			}
+		}
+
+		// Classify the text by AI, to confirm the matched text, or match it only by AI.
+		if rule.Prompt != "" {
+			chat := v.config.chatOptions(alerts.Classifier, "You are a compliance reviewer for live streams")
+			classifyCtx, classifyCancel := context.WithTimeout(ctx, transcriptAlertTimeout)
+			classified, err := classifyTranscript(classifyCtx, chat, rule.Prompt, segment.AsrText.Text)
+			classifyCancel()
+			if err != nil {
+				logger.Wf(ctx, "transcript: ignore classify %v by %v err %+v", segment.String(), rule.String(), err)
+				continue
+			} else if classified == "" {
+				continue
+			} else if matched == "" {
+				matched = classified
+			}
+		}
+
+		match := &TranscriptAlertMatch{
+			Rule: rule.ID, Name: rule.Name, SeqNo: segment.TsFile.SeqNo, Timestamp: at.Format(time.RFC3339),
+			Matched: matched, Text: segment.AsrText.Text, Kickoff: rule.Kickoff,
+		}
+		if err := callbackWorker.OnTranscriptMatch(ctx, SrsActionOnTranscriptMatch, v.UUID, segment.Msg, match); err != nil {
+			logger.Wf(ctx, "transcript: ignore callback %v err %+v", match.String(), err)
+		}
+		logger.Tf(ctx, "transcript: alert stream=%v, %v", v.Stream, match.String())
+
+		kickoff = kickoff || rule.Kickoff
+	}
+
+	if kickoff {
+		if code, err := kickoffStream(ctx, segment.Msg.Vhost, segment.Msg.App, segment.Msg.Stream); err != nil {
+			return errors.Wrapf(err, "kickoff %v", v.Stream)
+		} else {
+			logger.Tf(ctx, "transcript: kickoff stream=%v by alert, code=%v", v.Stream, code)
+		}
+	}
+	return nil
+}
+
+func (v *TranscriptWorker) handleAlerts(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai/transcript/alerts"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var alerts *TranscriptAlertConfig
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string                 `json:"token"`
+				Alerts **TranscriptAlertConfig `json:"alerts"`
+			}{
+				Token: &token, Alerts: &alerts,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Replace the alert rules if specified.
+			if alerts != nil {
+				if alerts.Rules == nil {
+					alerts.Rules = []*TranscriptAlertRule{}
+				}
+				if err := alerts.Validate(); err != nil {
+					return errors.Wrapf(err, "validate %v", alerts.String())
+				}
+				if err := alerts.Save(ctx); err != nil {
+					return errors.Wrapf(err, "save %v", alerts.String())
+				}
+				v.resetAlerts()
+			}
+
+			loaded := NewTranscriptAlertConfig()
+			if err := loaded.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load alerts")
+			}
+
+			ohttp.WriteData(ctx, w, r, loaded)
+			logger.Tf(ctx, "transcript alerts ok, update=%v, %v, token=%vB", alerts != nil, loaded.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,662 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	uuidpkg "github.com/google/uuid"
+)
+
+// The session is removed with its cues, if it's done for a long time.
+const transcriptSessionRetention = 30 * 24 * time.Hour
+
+// The interval to cleanup the expired sessions.
+const transcriptSessionCleanupInterval = 1 * time.Hour
+
+// TranscriptCue is a line of subtitle in the archived session, in wall clock time.
+type TranscriptCue struct {
+	// The TS id of segment, to update the cues when user fix the segment.
+	TsID string `json:"tsid"`
+	// The start and end time in wall clock.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The text of subtitle.
+	Text string `json:"text"`
+}
+
+// TranscriptSession is the archived transcript for a publish session of stream.
+type TranscriptSession struct {
+	// The uuid of session.
+	UUID string `json:"uuid"`
+	// The uuid of transcript task.
+	Task string `json:"task"`
+	// The stream URL, such as /live/livestream
+	Stream string `json:"stream"`
+	// The language of stream.
+	Language string `json:"lang,omitempty"`
+	// The start time of session, in wall clock.
+	Start time.Time `json:"start"`
+	// The time of the last segment.
+	Update string `json:"update"`
+	// The done time, empty if the session is still publishing.
+	Done string `json:"done,omitempty"`
+	// The uuid of RecordM3u8Stream, if the stream is also recorded.
+	Record string `json:"record,omitempty"`
+	// The number of cues.
+	NN int `json:"nn"`
+}
+
+func (v *TranscriptSession) String() string {
+	return fmt.Sprintf("uuid=%v, task=%v, stream=%v, lang=%v, start=%v, update=%v, done=%v, record=%v, nn=%v",
+		v.UUID, v.Task, v.Stream, v.Language, v.Start.Format(time.RFC3339), v.Update, v.Done, v.Record, v.NN)
+}
+
+// active returns whether the session is still publishing, that is, the stream is not unpublished.
+func (v *TranscriptSession) active() bool {
+	return v.Done == ""
+}
+
+// expired returns whether the session is done for longer than the retention.
+func (v *TranscriptSession) expired(now time.Time) bool {
+	done, err := time.Parse(time.RFC3339, v.Done)
+	return err == nil && now.Sub(done) > transcriptSessionRetention
+}
+
+func (v *TranscriptSession) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_TRANSCRIPT_SESSION, v.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_TRANSCRIPT_SESSION, v.UUID, string(b))
+	}
+	return nil
+}
+
+// loadTranscriptSessions returns all archived sessions, sorted by start time.
+func loadTranscriptSessions(ctx context.Context) ([]*TranscriptSession, error) {
+	values, err := rdb.HGetAll(ctx, SRS_TRANSCRIPT_SESSION).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_TRANSCRIPT_SESSION)
+	}
+
+	var sessions []*TranscriptSession
+	for _, value := range values {
+		var session TranscriptSession
+		if err := json.Unmarshal([]byte(value), &session); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", value)
+		}
+		sessions = append(sessions, &session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions, nil
+}
+
+// queryTranscriptSession returns the session, or nil if not exists, for example, removed by user.
+func queryTranscriptSession(ctx context.Context, uuid string) (*TranscriptSession, error) {
+	value, err := rdb.HGet(ctx, SRS_TRANSCRIPT_SESSION, uuid).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_TRANSCRIPT_SESSION, uuid)
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	var session TranscriptSession
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", value)
+	}
+	return &session, nil
+}
+
+func loadTranscriptSession(ctx context.Context, uuid string) (*TranscriptSession, error) {
+	session, err := queryTranscriptSession(ctx, uuid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query session %v", uuid)
+	}
+	if session == nil {
+		return nil, errors.Errorf("no session %v", uuid)
+	}
+	return session, nil
+}
+
+// removeTranscriptSession remove the session and its cues.
+func removeTranscriptSession(ctx context.Context, uuid string) error {
+	key := transcriptCuesKey(uuid)
+	if err := rdb.Del(ctx, key).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "del %v", key)
+	}
+	if err := rdb.HDel(ctx, SRS_TRANSCRIPT_SESSION, uuid).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_SESSION, uuid)
+	}
+	return nil
+}
+
+// cleanupTranscriptSessions remove the sessions which are expired.
+func cleanupTranscriptSessions(ctx context.Context, now time.Time) error {
+	sessions, err := loadTranscriptSessions(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load sessions")
+	}
+
+	for _, session := range sessions {
+		if !session.expired(now) {
+			continue
+		}
+
+		if err := removeTranscriptSession(ctx, session.UUID); err != nil {
+			return errors.Wrapf(err, "remove session %v", session.UUID)
+		}
+		logger.Tf(ctx, "transcript: cleanup expired session %v", session.String())
+	}
+	return nil
+}
+
+// transcriptCuesKey returns the key of cues of session, which is a hash with the TS id as field, and the cues
+// of segment as value, so that a segment is saved without rewriting the whole session.
+func transcriptCuesKey(uuid string) string {
+	return fmt.Sprintf("%v:%v", SRS_TRANSCRIPT_CUES, uuid)
+}
+
+// loadTranscriptCues returns the cues of all segments of session, sorted by start time.
+func loadTranscriptCues(ctx context.Context, uuid string) ([]*TranscriptCue, error) {
+	key := transcriptCuesKey(uuid)
+	values, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", key)
+	}
+
+	var cues []*TranscriptCue
+	for tsid, value := range values {
+		var segmentCues []*TranscriptCue
+		if err := json.Unmarshal([]byte(value), &segmentCues); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v of %v", value, tsid)
+		}
+		cues = append(cues, segmentCues...)
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].Start.Before(cues[j].Start)
+	})
+	return cues, nil
+}
+
+// saveTranscriptCues replace the cues of segment in session, remove it if no cues. Returns the change of the
+// number of cues, which is negative if decreased.
+func saveTranscriptCues(ctx context.Context, uuid, tsid string, cues []*TranscriptCue) (int, error) {
+	key := transcriptCuesKey(uuid)
+	previous, err := rdb.HGet(ctx, key, tsid).Result()
+	if err != nil && err != redis.Nil {
+		return 0, errors.Wrapf(err, "hget %v %v", key, tsid)
+	}
+
+	var previousCues []*TranscriptCue
+	if previous != "" {
+		if err := json.Unmarshal([]byte(previous), &previousCues); err != nil {
+			return 0, errors.Wrapf(err, "unmarshal %v", previous)
+		}
+	}
+
+	if len(cues) == 0 {
+		if err := rdb.HDel(ctx, key, tsid).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "hdel %v %v", key, tsid)
+		}
+	} else if b, err := json.Marshal(cues); err != nil {
+		return 0, errors.Wrapf(err, "marshal cues")
+	} else if err = rdb.HSet(ctx, key, tsid, string(b)).Err(); err != nil && err != redis.Nil {
+		return 0, errors.Wrapf(err, "hset %v %v", key, tsid)
+	}
+	return len(cues) - len(previousCues), nil
+}
+
+// segmentBegin returns the start time of segment in wall clock, because we got the TS file when it's done.
+func segmentBegin(segment *TranscriptSegment) time.Time {
+	received := segment.Received
+	if received.IsZero() {
+		received = time.Now()
+	}
+	return received.Add(-time.Duration(segment.TsFile.Duration * float64(time.Second)))
+}
+
+// buildTranscriptCues convert the ASR segments to cues in wall clock, and ignore the text cleared by user.
+func buildTranscriptCues(segment *TranscriptSegment) []*TranscriptCue {
+	if segment.UserClearASR || segment.AsrText == nil {
+		return nil
+	}
+
+	begin := segmentBegin(segment)
+	var cues []*TranscriptCue
+	for _, s := range segment.AsrText.Segments {
+		if text := strings.TrimSpace(s.Text); text != "" {
+			cues = append(cues, &TranscriptCue{
+				TsID:  segment.TsFile.TsID,
+				Start: begin.Add(time.Duration(s.Start * float64(time.Second))),
+				End:   begin.Add(time.Duration(s.End * float64(time.Second))),
+				Text:  text,
+			})
+		}
+	}
+
+	// Use the whole text as one cue, if no segments.
+	if text := strings.TrimSpace(segment.AsrText.Text); len(cues) == 0 && text != "" {
+		cues = append(cues, &TranscriptCue{
+			TsID:  segment.TsFile.TsID,
+			Start: begin,
+			End:   begin.Add(time.Duration(segment.TsFile.Duration * float64(time.Second))),
+			Text:  text,
+		})
+	}
+	return cues
+}
+
+// queryRecordOfStream returns the uuid of RecordM3u8Stream which is recording the stream.
+func queryRecordOfStream(ctx context.Context, m3u8URL string) string {
+	value, err := rdb.HGet(ctx, SRS_RECORD_M3U8_WORKING, m3u8URL).Result()
+	if err != nil || value == "" {
+		return ""
+	}
+
+	var obj struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal([]byte(value), &obj); err != nil {
+		return ""
+	}
+	return obj.UUID
+}
+
+// OnStreamMessage close the session when the stream is unpublished, or published again, so that a session
+// is exactly a publish of stream.
+func (v *TranscriptWorker) OnStreamMessage(ctx context.Context, action SrsAction, streamObj *SrsStream) error {
+	if action != SrsActionOnPublish && action != SrsActionOnUnpublish {
+		return nil
+	}
+
+	value, ok := v.tasks.Load(fmt.Sprintf("/%v/%v", streamObj.App, streamObj.Stream))
+	if !ok {
+		return nil
+	}
+
+	task := value.(*TranscriptTask)
+	if action == SrsActionOnPublish {
+		if err := task.onPublish(ctx, time.Now()); err != nil {
+			return errors.Wrapf(err, "publish %v", task.String())
+		}
+	} else {
+		if err := task.closeSession(ctx); err != nil {
+			return errors.Wrapf(err, "unpublish %v", task.String())
+		}
+	}
+	return nil
+}
+
+// onPublish close the session of previous publish, and the next segment starts a new session.
+func (v *TranscriptTask) onPublish(ctx context.Context, now time.Time) error {
+	v.sessionLock.Lock()
+	defer v.sessionLock.Unlock()
+
+	if err := v.resumeSessionImpl(ctx); err != nil {
+		return errors.Wrapf(err, "resume session")
+	}
+	if err := v.closeSessionImpl(ctx); err != nil {
+		return errors.Wrapf(err, "close session")
+	}
+
+	v.previous, v.session, v.publishAt = v.session, nil, now
+	return nil
+}
+
+// resumeSessionImpl resume the session of stream which is not done, for example, when server restarted.
+func (v *TranscriptTask) resumeSessionImpl(ctx context.Context) error {
+	if v.session != nil || v.resumed {
+		return nil
+	}
+
+	sessions, err := loadTranscriptSessions(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load sessions")
+	}
+	for _, session := range sessions {
+		if session.Stream == v.Stream && session.active() {
+			v.session = session
+		}
+	}
+
+	v.resumed = true
+	return nil
+}
+
+// sessionOfSegment returns the session to archive the segment, or nil to ignore it.
+func (v *TranscriptTask) sessionOfSegment(ctx context.Context, segment *TranscriptSegment) (*TranscriptSession, error) {
+	// The segment is already archived, for example, fixed by user, update the same session.
+	if segment.Session != "" {
+		for _, session := range []*TranscriptSession{v.session, v.previous} {
+			if session != nil && session.UUID == segment.Session {
+				return session, nil
+			}
+		}
+		return queryTranscriptSession(ctx, segment.Session)
+	}
+
+	// The segment is received before the stream is published again, which belongs to the previous session.
+	received := segment.Received
+	if !received.IsZero() && received.Before(v.publishAt) {
+		return v.previous, nil
+	}
+
+	if err := v.resumeSessionImpl(ctx); err != nil {
+		return nil, errors.Wrapf(err, "resume session")
+	}
+
+	// The session is done, and the segment is received after unpublished, which should never happen unless
+	// the unpublish event is lost, so we start a new session.
+	if v.session != nil && !v.session.active() && !received.IsZero() {
+		if done, err := time.Parse(time.RFC3339, v.session.Done); err == nil && received.After(done) {
+			v.previous, v.session = v.session, nil
+		}
+	}
+
+	if v.session == nil {
+		v.session = &TranscriptSession{
+			UUID: uuidpkg.NewString(), Task: v.UUID, Stream: v.Stream, Language: v.config.Language,
+			Start: segmentBegin(segment),
+		}
+		logger.Tf(ctx, "transcript: start session %v", v.session.String())
+	}
+	return v.session, nil
+}
+
+// archiveSegment save the ASR text of segment to the session of publish. Note that the fix queue updates the
+// segment later, which is archived to the same session.
+func (v *TranscriptTask) archiveSegment(ctx context.Context, segment *TranscriptSegment) error {
+	if segment.TsFile == nil || segment.AsrText == nil {
+		return nil
+	}
+
+	v.sessionLock.Lock()
+	defer v.sessionLock.Unlock()
+
+	session, err := v.sessionOfSegment(ctx, segment)
+	if err != nil {
+		return errors.Wrapf(err, "session of segment")
+	}
+	if session == nil {
+		return nil
+	}
+	segment.Session = session.UUID
+
+	n, err := saveTranscriptCues(ctx, session.UUID, segment.TsFile.TsID, buildTranscriptCues(segment))
+	if err != nil {
+		return errors.Wrapf(err, "save cues")
+	}
+	session.NN += n
+
+	// Link to the record of stream.
+	if session.Record == "" && segment.Msg != nil {
+		session.Record = queryRecordOfStream(ctx, segment.Msg.M3u8URL)
+	}
+
+	if received := segment.Received; !received.IsZero() {
+		if update, err := time.Parse(time.RFC3339, session.Update); err != nil || received.After(update) {
+			session.Update = received.Format(time.RFC3339)
+		}
+	}
+	if session.Update == "" {
+		session.Update = time.Now().Format(time.RFC3339)
+	}
+	if err := session.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save session")
+	}
+	return nil
+}
+
+// closeSession mark the session of task as done, for example, the stream is unpublished or task is stopped.
+func (v *TranscriptTask) closeSession(ctx context.Context) error {
+	v.sessionLock.Lock()
+	defer v.sessionLock.Unlock()
+	return v.closeSessionImpl(ctx)
+}
+
+// closeSessionImpl mark the session as done, but keep it to archive the segments which are received before
+// done, because the ASR is later than the stream.
+func (v *TranscriptTask) closeSessionImpl(ctx context.Context) error {
+	if v.session == nil || !v.session.active() {
+		return nil
+	}
+
+	session := v.session
+	session.Done = time.Now().Format(time.RFC3339)
+	if err := session.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save session")
+	}
+
+	logger.Tf(ctx, "transcript: close session %v", session.String())
+	return nil
+}
+
+// formatSubtitleTime format the duration to subtitle time, such as 00:01:02,345 for SRT when sep is comma,
+// or 00:01:02.345 for WebVTT when sep is dot.
+func formatSubtitleTime(d time.Duration, sep string) string {
+	if d < 0 {
+		d = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%v%03d",
+		int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, sep, int(d.Milliseconds())%1000)
+}
+
+// exportTranscriptSession build the session in format srt, vtt, json or txt, the timestamps of SRT and WebVTT
+// are relative to the start of session, to match the record of stream.
+func exportTranscriptSession(session *TranscriptSession, cues []*TranscriptCue, format string) (string, string, error) {
+	var sb strings.Builder
+	switch format {
+	case "srt":
+		for index, cue := range cues {
+			sb.WriteString(fmt.Sprintf("%v\n", index+1))
+			sb.WriteString(fmt.Sprintf("%v --> %v\n",
+				formatSubtitleTime(cue.Start.Sub(session.Start), ","), formatSubtitleTime(cue.End.Sub(session.Start), ",")))
+			sb.WriteString(fmt.Sprintf("%v\n\n", cue.Text))
+		}
+		return "application/x-subrip", sb.String(), nil
+	case "vtt":
+		sb.WriteString("WEBVTT\n\n")
+		for _, cue := range cues {
+			sb.WriteString(fmt.Sprintf("%v --> %v\n",
+				formatSubtitleTime(cue.Start.Sub(session.Start), "."), formatSubtitleTime(cue.End.Sub(session.Start), ".")))
+			sb.WriteString(fmt.Sprintf("%v\n\n", cue.Text))
+		}
+		return "text/vtt", sb.String(), nil
+	case "txt":
+		for _, cue := range cues {
+			sb.WriteString(fmt.Sprintf("%v\n", cue.Text))
+		}
+		return "text/plain; charset=utf-8", sb.String(), nil
+	case "json":
+		b, err := json.Marshal(&struct {
+			Session *TranscriptSession `json:"session"`
+			Cues    []*TranscriptCue   `json:"cues"`
+		}{
+			Session: session, Cues: cues,
+		})
+		if err != nil {
+			return "", "", errors.Wrapf(err, "marshal")
+		}
+		return "application/json", string(b), nil
+	}
+	return "", "", errors.Errorf("invalid format %v", format)
+}
+
+// searchTranscriptCues returns the cues which contain the keyword, case insensitive.
+func searchTranscriptCues(cues []*TranscriptCue, keyword string) []*TranscriptCue {
+	keyword = strings.ToLower(keyword)
+
+	var matches []*TranscriptCue
+	for _, cue := range cues {
+		if strings.Contains(strings.ToLower(cue.Text), keyword) {
+			matches = append(matches, cue)
+		}
+	}
+	return matches
+}
+
+func (v *TranscriptWorker) handleSessions(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai/transcript/sessions/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, stream, keyword string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token   *string `json:"token"`
+				Stream  *string `json:"stream"`
+				Keyword *string `json:"keyword"`
+			}{
+				Token: &token, Stream: &stream, Keyword: &keyword,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			sessions, err := loadTranscriptSessions(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load sessions")
+			}
+
+			type SessionResult struct {
+				*TranscriptSession
+				// Whether the stream is still publishing.
+				Active bool `json:"active"`
+				// The cues match the keyword.
+				Matches []*TranscriptCue `json:"matches,omitempty"`
+			}
+			res := []*SessionResult{}
+			for _, session := range sessions {
+				if stream != "" && session.Stream != stream {
+					continue
+				}
+
+				result := &SessionResult{TranscriptSession: session, Active: session.active()}
+				if keyword != "" {
+					cues, err := loadTranscriptCues(ctx, session.UUID)
+					if err != nil {
+						return errors.Wrapf(err, "load cues of %v", session.UUID)
+					}
+					if result.Matches = searchTranscriptCues(cues, keyword); len(result.Matches) == 0 {
+						continue
+					}
+				}
+				res = append(res, result)
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "transcript query sessions ok, stream=%v, keyword=%v, sessions=%v, token=%vB",
+				stream, keyword, len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/sessions/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			session, err := loadTranscriptSession(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "load session %v", uuid)
+			}
+			if session.active() {
+				return errors.Errorf("session %v is active", uuid)
+			}
+
+			if err := removeTranscriptSession(ctx, uuid); err != nil {
+				return errors.Wrapf(err, "remove session %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "transcript remove session ok, session=%v, token=%vB", session.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/sessions/export/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is :uuid.srt, :uuid.vtt, :uuid.json or :uuid.txt
+			filename := r.URL.Path[len("/terraform/v1/ai/transcript/sessions/export/"):]
+			ext := path.Ext(filename)
+			uuid := filename[:len(filename)-len(ext)]
+			if len(uuid) == 0 || len(ext) == 0 {
+				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
+			}
+
+			// Note that we use query for token, because the file is downloaded by link.
+			token := r.URL.Query().Get("token")
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			session, err := loadTranscriptSession(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "load session %v", uuid)
+			}
+			cues, err := loadTranscriptCues(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "load cues %v", uuid)
+			}
+
+			contentType, body, err := exportTranscriptSession(session, cues, ext[1:])
+			if err != nil {
+				return errors.Wrapf(err, "export %v", filename)
+			}
+
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v", filename))
+			w.Write([]byte(body))
+			logger.Tf(ctx, "transcript export session ok, session=%v, format=%v, cues=%v, token=%vB",
+				session.String(), ext, len(cues), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
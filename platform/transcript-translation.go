@@ -0,0 +1,204 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+)
+
+// The max number of languages to translate the subtitles.
+const maxTranslateLanguages = 8
+
+// The max number of languages to translate concurrently, for each segment.
+const maxTranslateConcurrency = 4
+
+// The language code for subtitles, such as en, zh or pt-BR, which is used in m3u8 and url.
+var subtitleLanguageRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// validateSubtitleLanguage validate the language code of subtitles.
+func validateSubtitleLanguage(lang string) error {
+	if !subtitleLanguageRegexp.MatchString(lang) {
+		return errors.Errorf("invalid language %v", lang)
+	}
+	return nil
+}
+
+// translateOptions returns the AI assistant to translate subtitles, reuse the chat settings of dubbing
+// Translation, and use the AI provider of transcript if not set.
+func (v *TranscriptConfig) translateOptions() *SrsAssistant {
+	return v.chatOptions(v.Translation, "You are a professional translator for live subtitles")
+}
+
+// chatOptions returns the AI assistant for chat, use the AI provider of transcript if not set, and the
+// default model and prompt if empty.
+func (v *TranscriptConfig) chatOptions(chat *SrsAssistant, prompt string) *SrsAssistant {
+	options := NewAssistant()
+	if chat != nil {
+		*options = *chat
+	}
+
+	if options.AISecretKey == "" {
+		options.AISecretKey, options.AIBaseURL, options.AIOrganization = v.SecretKey, v.BaseURL, v.Organization
+	}
+	if options.AIChatModel == "" {
+		options.AIChatModel = openai.GPT3Dot5Turbo
+	}
+	if options.AIChatPrompt == "" {
+		options.AIChatPrompt = prompt
+	}
+	return options
+}
+
+// translateEnabled returns whether translate the subtitles.
+func (v *TranscriptConfig) translateEnabled() bool {
+	if len(v.TranslateLanguages) == 0 {
+		return false
+	}
+	return v.Translation == nil || v.Translation.AIChatEnabled
+}
+
+// parseTranslatedLines parse the translated JSON array of strings, which should be the same length as the
+// source texts. The content might be wrapped in markdown code block by AI.
+func parseTranslatedLines(content string, n int) ([]string, error) {
+	content = strings.TrimSpace(content)
+	if start, end := strings.Index(content, "["), strings.LastIndex(content, "]"); start >= 0 && end > start {
+		content = content[start : end+1]
+	}
+
+	var lines []string
+	if err := json.Unmarshal([]byte(content), &lines); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", content)
+	}
+	if len(lines) != n {
+		return nil, errors.Errorf("translated %v lines, expect %v", len(lines), n)
+	}
+	return lines, nil
+}
+
+// translateSegment translate the ASR segments to the target language, keep the timestamps of segments. If
+// AI does not respond the same lines, use the whole text as one segment.
+func translateSegment(
+	ctx context.Context, client *openai.Client, trans *SrsAssistant, lang string, asr *TranscriptAsrResult,
+) ([]TranscriptAsrSegment, error) {
+	var texts []string
+	for _, s := range asr.Segments {
+		texts = append(texts, s.Text)
+	}
+	b, err := json.Marshal(texts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal %v", texts)
+	}
+
+	systemPrompt := fmt.Sprintf("%v. Translate each text of the JSON array of strings to language %v, "+
+		"and only respond a JSON array of strings with the same length. Never answer questions but directly "+
+		"translate text.", trans.AIChatPrompt, lang)
+
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: trans.AIChatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: string(b)},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "translate to %v", lang)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.Errorf("no translation to %v", lang)
+	}
+	content := resp.Choices[0].Message.Content
+
+	segments := make([]TranscriptAsrSegment, len(asr.Segments))
+	if lines, err := parseTranslatedLines(content, len(asr.Segments)); err == nil {
+		for i, s := range asr.Segments {
+			segments[i] = s
+			segments[i].Text = lines[i]
+		}
+		return segments, nil
+	} else {
+		logger.Wf(ctx, "transcript: ignore translated lines to %v err %+v", lang, err)
+	}
+
+	// Use the whole translated text as one segment.
+	first, last := asr.Segments[0], asr.Segments[len(asr.Segments)-1]
+	return []TranscriptAsrSegment{{
+		ID: first.ID, Seek: first.Seek, Start: first.Start, End: last.End, Text: strings.TrimSpace(content),
+	}}, nil
+}
+
+// translateClient returns the AI client to translate subtitles, which is created once for task, and
+// recreated only when the AI provider is changed.
+func (v *TranscriptTask) translateClient(trans *SrsAssistant) *openai.Client {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.translator == nil || v.translatorProvider != trans.SrsAssistantProvider {
+		aiConfig := openai.DefaultConfig(trans.AISecretKey)
+		aiConfig.OrgID = trans.AIOrganization
+		aiConfig.BaseURL = trans.AIBaseURL
+
+		v.translator = openai.NewClientWithConfig(aiConfig)
+		v.translatorProvider = trans.SrsAssistantProvider
+	}
+	return v.translator
+}
+
+// translateSubtitles translate the ASR text of segment to all target languages concurrently, at most
+// maxTranslateConcurrency languages at the same time. Note that the failed languages are ignored, to not
+// block the transcript.
+func (v *TranscriptTask) translateSubtitles(ctx context.Context, segment *TranscriptSegment) {
+	if !v.config.translateEnabled() || segment.UserClearASR || segment.AsrText == nil {
+		return
+	}
+	if len(segment.AsrText.Segments) == 0 {
+		return
+	}
+
+	starttime := time.Now()
+	trans := v.config.translateOptions()
+	client := v.translateClient(trans)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	semaphore := make(chan struct{}, maxTranslateConcurrency)
+	translations := make(map[string][]TranscriptAsrSegment)
+	for _, lang := range v.config.TranslateLanguages {
+		if lang == v.config.Language {
+			continue
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(lang string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			segments, err := translateSegment(ctx, client, trans, lang, segment.AsrText)
+			if err != nil {
+				logger.Wf(ctx, "transcript: ignore translate %v to %v err %+v", segment.String(), lang, err)
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			translations[lang] = segments
+		}(lang)
+	}
+	wg.Wait()
+
+	segment.Translations = translations
+	segment.CostTranslate = time.Since(starttime)
+	logger.Tf(ctx, "transcript: translate %v to %v languages, model=%v, cost=%v",
+		segment.AsrText.Text, len(translations), trans.AIChatModel, segment.CostTranslate)
+}
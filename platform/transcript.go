@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -36,14 +37,19 @@ type TranscriptWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The global transcript task, only support one transcript task.
-	task *TranscriptTask
+	// The transcript tasks, key is stream URL in string such as /live/livestream, value is *TranscriptTask.
+	tasks sync.Map
 
 	// Use async goroutine to process on_hls messages.
 	msgs chan *SrsOnHlsMessage
 
 	// Got message from SRS, a new TS segment file is generated.
 	tsfiles chan *SrsOnHlsObject
+
+	// The cached alert rules, nil to load from redis.
+	alerts *TranscriptAlertConfig
+	// To protect the alert rules.
+	alertsLock sync.Mutex
 }
 
 func NewTranscriptWorker() *TranscriptWorker {
@@ -53,11 +59,50 @@ func NewTranscriptWorker() *TranscriptWorker {
 		// TS files.
 		tsfiles: make(chan *SrsOnHlsObject, 1024),
 	}
-	v.task = NewTranscriptTask()
-	v.task.transcriptWorker = v
 	return v
 }
 
+// queryTask returns the task by uuid or stream URL, or the default task if both are empty, which is the
+// task of the latest updated stream, to be compatible with the clients for only one task.
+func (v *TranscriptWorker) queryTask(uuid, stream string) (*TranscriptTask, error) {
+	var target *TranscriptTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		task := value.(*TranscriptTask)
+		if uuid != "" || stream != "" {
+			if (uuid != "" && task.UUID == uuid) || (uuid == "" && task.Stream == stream) {
+				target = task
+				return false
+			}
+			return true
+		}
+
+		if target == nil || target.updated().Before(task.updated()) {
+			target = task
+		}
+		return true
+	})
+
+	if target == nil {
+		return nil, errors.Errorf("no task for uuid=%v, stream=%v", uuid, stream)
+	}
+	return target, nil
+}
+
+// findOverlaySegment returns the segment by the overlay ts id, in all tasks.
+func (v *TranscriptWorker) findOverlaySegment(tsid string) *TranscriptSegment {
+	var target *TranscriptSegment
+	v.tasks.Range(func(key, value interface{}) bool {
+		for _, s := range value.(*TranscriptTask).overlaySegments() {
+			if s.OverlayFile != nil && s.OverlayFile.TsID == tsid {
+				target = s
+				return false
+			}
+		}
+		return true
+	})
+	return target
+}
+
 func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ai/transcript/query"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -82,21 +127,40 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "load config")
 			}
 
+			type QueryTask struct {
+				UUID     string `json:"uuid"`
+				Stream   string `json:"stream"`
+				Language string `json:"lang"`
+				Update   string `json:"update"`
+			}
 			type QueryResponse struct {
 				Config *TranscriptConfig `json:"config"`
-				Task   struct {
+				// The default task, which is the latest updated one.
+				Task struct {
 					UUID string `json:"uuid"`
 				} `json:"task"`
+				// All tasks, one task for each stream.
+				Tasks []*QueryTask `json:"tasks"`
 			}
 
 			resp := &QueryResponse{
-				Config: config,
-			}
-			resp.Task.UUID = v.task.UUID
+				Config: config, Tasks: []*QueryTask{},
+			}
+			if task, err := v.queryTask("", ""); err == nil {
+				resp.Task.UUID = task.UUID
+			}
+			v.tasks.Range(func(key, value interface{}) bool {
+				task := value.(*TranscriptTask)
+				resp.Tasks = append(resp.Tasks, &QueryTask{
+					UUID: task.UUID, Stream: task.Stream, Language: config.LanguageOf(task.Stream),
+					Update: task.Update,
+				})
+				return true
+			})
 
 			ohttp.WriteData(ctx, w, r, resp)
-			logger.Tf(ctx, "transcript query ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+			logger.Tf(ctx, "transcript query ok, config=<%v>, uuid=%v, tasks=%v, token=%vB",
+				config, resp.Task.UUID, len(resp.Tasks), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -126,27 +190,55 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			// Not required yet.
-			if taskUUID != v.task.UUID {
-				logger.Wf(ctx, "transcript ignore uuid mismatch, query=%v, task=%v", taskUUID, v.task.UUID)
+			// Keep the provider, streams and globs, if not specified by client.
+			previous := NewTranscriptConfig()
+			if err := previous.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+			if config.Provider == "" {
+				config.Provider = previous.Provider
+			}
+			if config.Streams == nil {
+				config.Streams = previous.Streams
+			}
+			if config.Globs == nil {
+				config.Globs = previous.Globs
+			}
+			if config.TranslateLanguages == nil {
+				config.TranslateLanguages = previous.TranslateLanguages
+			}
+			if config.Translation == nil {
+				config.Translation = previous.Translation
+			}
+
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate config %v", config.String())
 			}
 
 			if err := config.Save(ctx); err != nil {
 				return errors.Wrapf(err, "save config")
 			}
 
-			if err := v.task.restart(ctx); err != nil {
-				return errors.Wrapf(err, "restart task %v", config.String())
-			}
+			// Restart all tasks to apply the config, and the worker will start or stop tasks for streams.
+			v.tasks.Range(func(key, value interface{}) bool {
+				task := value.(*TranscriptTask)
+				if err := task.restart(ctx); err != nil {
+					logger.Wf(ctx, "transcript ignore restart task %v err %+v", task.UUID, err)
+				}
+				return true
+			})
 
+			// Not required yet, response the default task for compatibility.
 			type ApplyResponse struct {
 				UUID string `json:"uuid"`
 			}
-			ohttp.WriteData(ctx, w, r, &ApplyResponse{
-				UUID: v.task.UUID,
-			})
-			logger.Tf(ctx, "transcript apply ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+			res := &ApplyResponse{}
+			if task, err := v.queryTask("", ""); err == nil {
+				res.UUID = task.UUID
+			}
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "transcript apply ok, config=<%v>, query=%v, uuid=%v, token=%vB",
+				config, taskUUID, res.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -174,6 +266,17 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			// For self-hosted whisper server, only check whether the server is available.
+			if transcriptConfig.Provider == ASRProviderWhisper {
+				if err := checkWhisperServer(ctx, transcriptConfig.BaseURL); err != nil {
+					return errors.Wrapf(err, "check whisper server")
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "transcript check ok, config=<%v>, token=%vB", transcriptConfig, len(token))
+				return nil
+			}
+
 			// Query whisper-1 model detail.
 			var config openai.ClientConfig
 			config = openai.DefaultConfig(transcriptConfig.SecretKey)
@@ -236,11 +339,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if taskUUID != v.task.UUID {
-				return errors.Errorf("invalid uuid %v", taskUUID)
+			task, err := v.queryTask(taskUUID, "")
+			if err != nil {
+				return errors.Wrapf(err, "invalid uuid %v", taskUUID)
 			}
 
-			if err := v.task.clearSubtitle(ctx, tsid); err != nil {
+			if err := task.clearSubtitle(ctx, tsid); err != nil {
 				return errors.Wrapf(err, "clear subtitle task %v and tsid=%v", taskUUID, tsid)
 			}
 
@@ -277,11 +381,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if taskUUID != v.task.UUID {
-				return errors.Errorf("invalid uuid %v", taskUUID)
+			task, err := v.queryTask(taskUUID, "")
+			if err != nil {
+				return errors.Wrapf(err, "invalid uuid %v", taskUUID)
 			}
 
-			if err := v.task.reset(ctx); err != nil {
+			if err := task.reset(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", taskUUID)
 			}
 
@@ -289,9 +394,9 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				UUID string `json:"uuid"`
 			}
 			ohttp.WriteData(ctx, w, r, &ResetResponse{
-				UUID: v.task.UUID,
+				UUID: task.UUID,
 			})
-			logger.Tf(ctx, "transcript reset ok, uuid=%v, new=%v, token=%vB", taskUUID, v.task.UUID, len(token))
+			logger.Tf(ctx, "transcript reset ok, uuid=%v, new=%v, token=%vB", taskUUID, task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -302,11 +407,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -316,6 +423,11 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -329,7 +441,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &LiveQueueResponse{}
 
-			segments := v.task.liveSegments()
+			segments := task.liveSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.TsFile.TsID,
@@ -343,7 +455,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "transcript query live ok, token=%vB", len(token))
+			logger.Tf(ctx, "transcript query live ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -354,11 +466,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -368,6 +482,11 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -385,7 +504,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &AsrQueueResponse{}
 
-			segments := v.task.asrSegments()
+			segments := task.asrSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.AudioFile.TsID,
@@ -403,7 +522,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "transcript query asr ok, token=%vB", len(token))
+			logger.Tf(ctx, "transcript query asr ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -414,11 +533,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -428,6 +549,11 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type AsrSegment struct {
 				Start float64 `json:"start"`
 				End   float64 `json:"end"`
@@ -459,7 +585,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &FixQueueResponse{}
 
-			segments := v.task.fixSegments()
+			segments := task.fixSegments()
 			for _, segment := range segments {
 				asrSegments := []AsrSegment{}
 				for _, asrSegment := range segment.AsrText.Segments {
@@ -494,7 +620,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "transcript query fix ok, token=%vB", len(token))
+			logger.Tf(ctx, "transcript query fix ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -505,11 +631,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			var token, taskUUID, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Token *string `json:"token"`
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &taskUUID, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -519,6 +647,11 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(taskUUID, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type AsrSegment struct {
 				Start float64 `json:"start"`
 				End   float64 `json:"end"`
@@ -554,7 +687,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &OverlayQueueResonse{}
 
-			segments := v.task.overlaySegments()
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				asrSegments := []AsrSegment{}
 				for _, asrSegment := range segment.AsrText.Segments {
@@ -593,7 +726,7 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			res.Count = len(res.Segments)
 
 			ohttp.WriteData(ctx, w, r, res)
-			logger.Tf(ctx, "transcript query overlay ok, token=%vB", len(token))
+			logger.Tf(ctx, "transcript query overlay ok, uuid=%v, token=%vB", task.UUID, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -616,7 +749,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid uuid format %v from %v of %v: %v", uuid, filename, r.URL.Path, err)
 			}
 
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
+			segments := task.overlaySegments()
 			if len(segments) == 0 {
 				return errors.Errorf("no segments for %v", uuid)
 			}
@@ -633,10 +771,20 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid bitrate %v of %v %v", bitrate, uuid, firstSegment.OverlayFile.TsID)
 			}
 
+			// The subtitles of source language, and the translated languages.
+			renditions := []*HlsSubtitleRendition{{Language: task.config.Language, URI: "subtitles.m3u8"}}
+			if task.config.translateEnabled() {
+				for _, lang := range task.config.TranslateLanguages {
+					if lang != task.config.Language {
+						renditions = append(renditions, &HlsSubtitleRendition{
+							Language: lang, URI: fmt.Sprintf("subtitles-%v.m3u8", lang),
+						})
+					}
+				}
+			}
+
 			contentType, m3u8Body, err := buildLiveM3u8ForVariantCC(
-				ctx, bitrate, v.task.config.Language,
-				fmt.Sprintf("%v%v.m3u8", webvttPrefix, uuid),
-				"subtitles.m3u8",
+				ctx, bitrate, fmt.Sprintf("%v%v.m3u8", webvttPrefix, uuid), renditions,
 			)
 			if err != nil {
 				return errors.Wrapf(err, "build transcript webvtt m3u8 of %v", uuid)
@@ -657,8 +805,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 			}
 
+			task, err := v.queryTask(uuid, "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				tsFiles = append(tsFiles, segment.TsFile)
 			}
@@ -706,17 +859,33 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			// Format is webvtt/:uuid/subtitles.m3u8
 			webvttPrefix := "/terraform/v1/ai/transcript/hls/webvtt/"
 			filename := r.URL.Path[len(webvttPrefix):]
-			// Format is :uuid/index.m3u8
-			uuid := filename[:len(filename)-len("/subtitles.m3u8")]
-			if len(uuid) == 0 {
+			// Format is :uuid/subtitles.m3u8 or :uuid/subtitles-:lang.m3u8
+			uuid := path.Dir(filename)
+			if len(uuid) == 0 || uuid == "." {
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 			}
 
+			// The language of translated subtitles, empty for the source language.
+			lang := strings.TrimSuffix(strings.TrimPrefix(path.Base(filename), "subtitles"), ".m3u8")
+			if lang = strings.TrimPrefix(lang, "-"); lang != "" {
+				if err := validateSubtitleLanguage(lang); err != nil {
+					return errors.Wrapf(err, "invalid subtitles %v", filename)
+				}
+			}
+
+			task, err := v.queryTask(uuid, "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				vttFile := *segment.OverlayFile
 				vttFile.Key = fmt.Sprintf("%v.vtt", vttFile.TsID)
+				if lang != "" {
+					vttFile.Key = fmt.Sprintf("%v.%v.vtt", vttFile.TsID, lang)
+				}
 				tsFiles = append(tsFiles, &vttFile)
 			}
 
@@ -729,27 +898,25 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 
 			w.Header().Set("Content-Type", contentType)
 			w.Write([]byte(m3u8Body))
-			logger.Tf(ctx, "transcript generate m3u8 ok, uuid=%v", uuid)
+			logger.Tf(ctx, "transcript generate m3u8 ok, uuid=%v, lang=%v", uuid, lang)
 			return nil
 		}
 
 		hlsVttHandler := func(w http.ResponseWriter, r *http.Request) error {
-			// Format is :uuid.ts
+			// Format is :uuid.vtt or :uuid.:lang.vtt
 			filename := r.URL.Path[len("/terraform/v1/ai/transcript/hls/webvtt/"):]
 			fileBase := path.Base(filename)
 			uuid := fileBase[:len(fileBase)-len(path.Ext(fileBase))]
+			var lang string
+			if index := strings.LastIndex(uuid, "."); index > 0 {
+				uuid, lang = uuid[:index], uuid[index+1:]
+			}
 			if len(uuid) == 0 {
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, fileBase, r.URL.Path)
 			}
 
 			// Find out the segment by overlay vtt ID.
-			var segment *TranscriptSegment
-			for _, s := range v.task.overlaySegments() {
-				if s.OverlayFile != nil && s.OverlayFile.TsID == uuid {
-					segment = s
-					break
-				}
-			}
+			segment := v.findOverlaySegment(uuid)
 			if segment == nil {
 				return errors.Errorf("no segment for %v", uuid)
 			}
@@ -761,9 +928,15 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("no asr text segments for %v", uuid)
 			}
 
+			// Use the translated text, or an empty vtt if not translated.
+			asrSegments := segment.AsrText.Segments
+			if lang != "" {
+				asrSegments = segment.Translations[lang]
+			}
+
 			var vttBody strings.Builder
 			vttBody.WriteString(fmt.Sprintf("WEBVTT\n\n"))
-			for _, as := range segment.AsrText.Segments {
+			for _, as := range asrSegments {
 				s := segment.StreamStarttime + time.Duration(as.Start*float64(time.Second))
 				e := segment.StreamStarttime + time.Duration(as.End*float64(time.Second))
 				vttBody.WriteString(fmt.Sprintf("%02d:%02d:%02d.%03d --> ",
@@ -775,14 +948,14 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 
 			w.Header().Set("Content-Type", "text/vtt")
 			w.Write([]byte(vttBody.String()))
-			logger.Tf(ctx, "transcript server vtt file ok, uuid=%v", uuid)
+			logger.Tf(ctx, "transcript server vtt file ok, uuid=%v, lang=%v", uuid, lang)
 			return nil
 		}
 
 		if err := func() error {
 			if strings.HasSuffix(r.URL.Path, "/index.m3u8") {
 				return hlsM3u8VariantHandler(w, r)
-			} else if strings.HasSuffix(r.URL.Path, "/subtitles.m3u8") {
+			} else if strings.HasPrefix(path.Base(r.URL.Path), "subtitles") && strings.HasSuffix(r.URL.Path, ".m3u8") {
 				return hlsM3u8SubtitleHandler(w, r)
 			} else if strings.HasSuffix(r.URL.Path, ".m3u8") {
 				return hlsM3u8Handler(w, r)
@@ -810,8 +983,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 			}
 
+			task, err := v.queryTask(uuid, "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				tsFiles = append(tsFiles, segment.OverlayFile)
 			}
@@ -880,8 +1058,13 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 			}
 
+			task, err := v.queryTask(uuid, "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				tsFiles = append(tsFiles, segment.TsFile)
 			}
@@ -938,11 +1121,26 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 		}
 	})
 
+	// Handle the archived sessions of transcript.
+	if err := v.handleSessions(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle sessions")
+	}
+
+	// Handle the alert rules of transcript.
+	if err := v.handleAlerts(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle alerts")
+	}
+
 	return nil
 }
 
 func (v *TranscriptWorker) Enabled() bool {
-	return v.task.enabled()
+	var enabled bool
+	v.tasks.Range(func(key, value interface{}) bool {
+		enabled = value.(*TranscriptTask).enabled()
+		return !enabled
+	})
+	return enabled
 }
 
 func (v *TranscriptWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
@@ -955,8 +1153,9 @@ func (v *TranscriptWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMess
 }
 
 func (v *TranscriptWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage) error {
-	// Ignore if not natch the task config.
-	if !v.task.match(msg) {
+	// Ignore if no task for the stream, or not natch the task config.
+	value, ok := v.tasks.Load(fmt.Sprintf("/%v/%v", msg.App, msg.Stream))
+	if !ok || !value.(*TranscriptTask).match(msg) {
 		return nil
 	}
 
@@ -1003,6 +1202,11 @@ func (v *TranscriptWorker) Close() error {
 	}
 
 	v.wg.Wait()
+
+	v.tasks.Range(func(key, value interface{}) bool {
+		value.(*TranscriptTask).Close()
+		return true
+	})
 	return nil
 }
 
@@ -1015,47 +1219,36 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "transcript start a worker")
 
-	// Load task from redis and continue to run the task.
+	// Load tasks from redis and continue to run the tasks.
 	if objs, err := rdb.HGetAll(ctx, SRS_TRANSCRIPT_TASK).Result(); err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "hgetall %v", SRS_TRANSCRIPT_TASK)
-	} else if len(objs) != 1 {
-		// Only support one task right now.
-		if err = rdb.Del(ctx, SRS_TRANSCRIPT_TASK).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "del %v", SRS_TRANSCRIPT_TASK)
-		}
 	} else {
 		for uuid, obj := range objs {
 			logger.Tf(ctx, "Load task %v object %v", uuid, obj)
 
-			if err = json.Unmarshal([]byte(obj), v.task); err != nil {
+			task := NewTranscriptTask()
+			if err = json.Unmarshal([]byte(obj), task); err != nil {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			break
-		}
-	}
-
-	// Start global transcript task.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.Run(ctx); err != nil {
-				logger.Wf(ctx, "transcript: run task %v err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 3 * time.Second
+			// The task of previous version has no stream, so parse it from the input url.
+			if task.Stream == "" && task.Input != "" {
+				if u, err := url.Parse(task.Input); err == nil {
+					task.Stream = u.Path
+				}
 			}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
+			// Remove the invalid task, or the duplicated task of a stream.
+			if _, loaded := v.tasks.Load(task.Stream); task.Stream == "" || loaded {
+				if err = rdb.HDel(ctx, SRS_TRANSCRIPT_TASK, uuid).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_TASK, uuid)
+				}
+				continue
 			}
+
+			v.startTask(ctx, task)
 		}
-	}()
+	}
 
 	// Consume all on_hls messages.
 	wg.Add(1)
@@ -1073,16 +1266,22 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Consume all ts files by task.
+	// Consume all ts files by task of stream.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
 			select {
 			case <-ctx.Done():
 			case msg := <-v.tsfiles:
+				value, ok := v.tasks.Load(fmt.Sprintf("/%v/%v", msg.Msg.App, msg.Msg.Stream))
+				if !ok {
+					os.Remove(msg.TsFile.File)
+					continue
+				}
+
+				task := value.(*TranscriptTask)
 				if err := task.OnTsSegment(ctx, msg); err != nil {
 					logger.Wf(ctx, "transcript: task %v on hls ts message %v err %+v", task.String(), msg.String(), err)
 				}
@@ -1090,41 +1289,35 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Watch for new stream.
+	// Cleanup the expired sessions.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.WatchNewStream(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v watch new stream err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
+			if err := cleanupTranscriptSessions(ctx, time.Now()); err != nil {
+				logger.Wf(ctx, "transcript: cleanup sessions err %+v", err)
 			}
 
 			select {
 			case <-ctx.Done():
-			case <-time.After(duration):
+			case <-time.After(transcriptSessionCleanupInterval):
 			}
 		}
 	}()
 
-	// Drive the live queue to ASR.
+	// Watch for streams, start or stop tasks by config.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
 			var duration time.Duration
-			if err := task.DriveLiveQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive live queue err %+v", task.String(), err)
+			if err := v.updateTasks(ctx); err != nil {
+				logger.Wf(ctx, "transcript: update tasks err %+v", err)
 				duration = 10 * time.Second
 			} else {
-				duration = 200 * time.Millisecond
+				duration = 1 * time.Second
 			}
 
 			select {
@@ -1134,71 +1327,88 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Drive the asr queue to correct queue.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	return nil
+}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveAsrQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive asr queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+// startTask start a task for the stream of task.
+func (v *TranscriptWorker) startTask(ctx context.Context, task *TranscriptTask) {
+	task.transcriptWorker = v
+	task.Input = fmt.Sprintf("rtmp://localhost%v", task.Stream)
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	v.tasks.Store(task.Stream, task)
+	task.Start(ctx)
+	logger.Tf(ctx, "transcript: start task %v", task.String())
+}
 
-	// Drive the fix queue to overlay queue.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+// stopTask stop the task, and remove all files and the task from redis.
+func (v *TranscriptWorker) stopTask(ctx context.Context, task *TranscriptTask) error {
+	v.tasks.Delete(task.Stream)
+	task.Close()
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveFixQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive fix queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+	if err := task.closeSession(ctx); err != nil {
+		logger.Wf(ctx, "transcript: ignore close session of %v err %+v", task.UUID, err)
+	}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	if err := task.dispose(ctx); err != nil {
+		return errors.Wrapf(err, "dispose task %v", task.UUID)
+	}
 
-	// Drive the overlay queue, remove old files.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	logger.Tf(ctx, "transcript: stop task %v", task.String())
+	return nil
+}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveOverlayQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive overlay queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+// updateTasks start tasks for the streams selected by config, and stop the tasks not selected any more.
+func (v *TranscriptWorker) updateTasks(ctx context.Context) error {
+	config := NewTranscriptConfig()
+	if err := config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load config")
+	}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
+	// Ignore if not enabled, keep the tasks for user to reset.
+	if !config.All {
+		return nil
+	}
+
+	streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+	}
+
+	var actives []*SrsStream
+	for _, value := range streams {
+		var stream SrsStream
+		if err := json.Unmarshal([]byte(value), &stream); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", value)
 		}
-	}()
+		actives = append(actives, &stream)
+	}
+
+	// Stop the tasks which is not selected by config.
+	selected := config.selectStreams(actives)
+	var stopped []*TranscriptTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*TranscriptTask); !config.keepTask(task.Stream, selected) {
+			stopped = append(stopped, task)
+		}
+		return true
+	})
+	for _, task := range stopped {
+		if err := v.stopTask(ctx, task); err != nil {
+			return errors.Wrapf(err, "stop task %v", task.String())
+		}
+	}
+
+	// Start tasks for the new streams.
+	for _, stream := range selected {
+		if _, ok := v.tasks.Load(stream); ok {
+			continue
+		}
+
+		logger.Tf(ctx, "transcript: Got new stream %v", stream)
+		task := NewTranscriptTask()
+		task.Stream = stream
+		v.startTask(ctx, task)
+	}
 
 	return nil
 }
@@ -1207,6 +1417,8 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 type TranscriptConfig struct {
 	// Whether transcript all streams.
 	All bool `json:"all"`
+	// The ASR provider, openai or whisper, use openai if empty.
+	Provider string `json:"provider,omitempty"`
 	// The secret key for AI service.
 	SecretKey string `json:"secretKey"`
 	// The base URL for AI service.
@@ -1223,6 +1435,24 @@ type TranscriptConfig struct {
 	EnableOverlay bool `json:"overlayEnabled"`
 	// Whether enable WebVTT subtitle.
 	EnableWebVTT bool `json:"webvttEnabled"`
+	// The streams to transcript, with the language of each stream. If both streams and globs are empty,
+	// transcript the latest active stream only.
+	Streams []*TranscriptStreamConfig `json:"streams,omitempty"`
+	// The glob filters of stream URL to transcript, such as /live/*
+	Globs []string `json:"globs,omitempty"`
+	// The target languages to translate the subtitles to, such as zh and ja.
+	TranslateLanguages []string `json:"translateLanguages,omitempty"`
+	// The AI chat settings to translate, the same as Translation of dubbing, use the AI provider of
+	// transcript if not set.
+	Translation *SrsAssistant `json:"trans,omitempty"`
+}
+
+// TranscriptStreamConfig is the config to transcript a stream.
+type TranscriptStreamConfig struct {
+	// The stream URL, such as /live/livestream
+	Stream string `json:"stream"`
+	// The language of stream, use the global language if empty.
+	Language string `json:"lang,omitempty"`
 }
 
 func NewTranscriptConfig() *TranscriptConfig {
@@ -1232,9 +1462,108 @@ func NewTranscriptConfig() *TranscriptConfig {
 }
 
 func (v TranscriptConfig) String() string {
-	return fmt.Sprintf("all=%v, key=%vB, organization=%v, base=%v, lang=%v, overlay=%v, forceStyle=%v, videoCodecParams=%v, webvtt=%v",
-		v.All, len(v.SecretKey), v.Organization, v.BaseURL, v.Language, v.EnableOverlay, v.ForceStyle,
-		v.VideoCodecParams, v.EnableWebVTT)
+	return fmt.Sprintf("all=%v, provider=%v, key=%vB, organization=%v, base=%v, lang=%v, overlay=%v, forceStyle=%v, videoCodecParams=%v, webvtt=%v, streams=%v, globs=%v, translate=%v",
+		v.All, v.Provider, len(v.SecretKey), v.Organization, v.BaseURL, v.Language, v.EnableOverlay, v.ForceStyle,
+		v.VideoCodecParams, v.EnableWebVTT, len(v.Streams), v.Globs, v.TranslateLanguages)
+}
+
+func (v *TranscriptConfig) Validate() error {
+	if err := validateASRProvider(v.Provider); err != nil {
+		return errors.Wrapf(err, "provider")
+	}
+	for _, s := range v.Streams {
+		if s == nil {
+			return errors.New("empty stream")
+		}
+		if parts := strings.Split(s.Stream, "/"); len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+			return errors.Errorf("invalid stream %v, should be /app/stream", s.Stream)
+		}
+	}
+	if len(v.TranslateLanguages) > maxTranslateLanguages {
+		return errors.Errorf("too many languages %v, max %v", len(v.TranslateLanguages), maxTranslateLanguages)
+	}
+	for _, lang := range v.TranslateLanguages {
+		if err := validateSubtitleLanguage(lang); err != nil {
+			return errors.Wrapf(err, "translate language")
+		}
+	}
+	for _, glob := range v.Globs {
+		if !strings.HasPrefix(glob, "/") {
+			return errors.Errorf("invalid glob %v", glob)
+		}
+		if _, err := path.Match(glob, "/"); err != nil {
+			return errors.Wrapf(err, "invalid glob %v", glob)
+		}
+	}
+	return nil
+}
+
+// asrProvider returns the AI provider for ASR.
+func (v *TranscriptConfig) asrProvider() *SrsAssistantProvider {
+	return &SrsAssistantProvider{
+		AIProvider: v.Provider, AISecretKey: v.SecretKey, AIOrganization: v.Organization, AIBaseURL: v.BaseURL,
+	}
+}
+
+// LanguageOf returns the language of stream.
+func (v *TranscriptConfig) LanguageOf(stream string) string {
+	for _, s := range v.Streams {
+		if s.Stream == stream && s.Language != "" {
+			return s.Language
+		}
+	}
+	return v.Language
+}
+
+// selects returns whether the stream is selected by streams or globs.
+func (v *TranscriptConfig) selects(stream string) bool {
+	for _, s := range v.Streams {
+		if s.Stream == stream {
+			return true
+		}
+	}
+	for _, glob := range v.Globs {
+		if ok, err := path.Match(glob, stream); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectStreams returns the stream URLs to transcript, from the active streams.
+func (v *TranscriptConfig) selectStreams(actives []*SrsStream) []string {
+	var selected []string
+
+	// Select the latest active stream if no streams and globs.
+	if len(v.Streams) == 0 && len(v.Globs) == 0 {
+		var best *SrsStream
+		for _, stream := range actives {
+			if best == nil || best.Update < stream.Update {
+				best = stream
+			}
+		}
+		if best != nil {
+			selected = append(selected, fmt.Sprintf("/%v/%v", best.App, best.Stream))
+		}
+		return selected
+	}
+
+	for _, stream := range actives {
+		if streamURL := fmt.Sprintf("/%v/%v", stream.App, stream.Stream); v.selects(streamURL) {
+			selected = append(selected, streamURL)
+		}
+	}
+	return selected
+}
+
+// keepTask returns whether to keep the task of stream. If no streams and globs, only keep the task of the
+// latest active stream, or keep it if no active stream. Otherwise, keep the task if selected by config,
+// even the stream is not active, for user to review the subtitles.
+func (v *TranscriptConfig) keepTask(stream string, selected []string) bool {
+	if len(v.Streams) == 0 && len(v.Globs) == 0 {
+		return len(selected) == 0 || selected[0] == stream
+	}
+	return v.selects(stream)
 }
 
 func (v *TranscriptConfig) Load(ctx context.Context) error {
@@ -1263,6 +1592,8 @@ type TranscriptAsrSegment struct {
 	Start float64 `json:"start,omitempty"`
 	End   float64 `json:"end,omitempty"`
 	Text  string  `json:"text,omitempty"`
+	// The probability of no speech, used to detect silence.
+	NoSpeechProb float64 `json:"no_speech_prob,omitempty"`
 }
 
 type TranscriptAsrResult struct {
@@ -1297,6 +1628,12 @@ type TranscriptSegment struct {
 	SrtFile string `json:"srt,omitempty"`
 	// Whether user clear the ASR text of this segment.
 	UserClearASR bool `json:"uca,omitempty"`
+	// The translated ASR segments, the key is the language.
+	Translations map[string][]TranscriptAsrSegment `json:"trans,omitempty"`
+	// The time when got the TS file, which is the end of segment in wall clock.
+	Received time.Time `json:"recv,omitempty"`
+	// The uuid of archived session, to update the same session when fixed.
+	Session string `json:"session,omitempty"`
 
 	// The cost to transcode the TS file to audio file.
 	CostExtractAudio time.Duration `json:"eac,omitempty"`
@@ -1304,6 +1641,8 @@ type TranscriptSegment struct {
 	CostASR time.Duration `json:"asrc,omitempty"`
 	// The cost to overlay the ASR text onto the video.
 	CostOverlay time.Duration `json:"olc,omitempty"`
+	// The cost to translate the ASR text.
+	CostTranslate time.Duration `json:"trc,omitempty"`
 }
 
 func (v TranscriptSegment) String() string {
@@ -1455,8 +1794,10 @@ type TranscriptTask struct {
 
 	// The input url.
 	Input string `json:"input,omitempty"`
-	// The input stream object, select the active stream.
-	inputStream *SrsStream
+	// The stream URL of task, such as /live/livestream
+	Stream string `json:"stream,omitempty"`
+	// The last time the task got a segment.
+	Update string `json:"update,omitempty"`
 
 	// The live queue for the current task. HLS TS segments are copied to the transcript
 	// directory, then a segment is created and added to the live queue for the transcript
@@ -1478,10 +1819,24 @@ type TranscriptTask struct {
 	// produce more accurate and robust subsequent ASR text.
 	PreviousAsrText string `json:"pat,omitempty"`
 
+	// The archived session of current publish, all ASR text is saved to it.
+	session *TranscriptSession
+	// The session of previous publish, for the segments received before published again.
+	previous *TranscriptSession
+	// The time when the stream is published, the boundary of sessions.
+	publishAt time.Time
+	// Whether resumed the session from redis, for example, when server restarted.
+	resumed bool
+	// To protect the session, which is archived by both ASR and fix queue.
+	sessionLock sync.Mutex
+
 	// The signal to persistence task.
 	signalPersistence chan bool
-	// The signal to change the active stream for task.
-	signalNewStream chan *SrsStream
+	// The segments to evaluate the alert rules, by a dedicated goroutine.
+	alertSegments chan *TranscriptSegment
+	// The AI client to translate subtitles, and the provider it is created by.
+	translator         *openai.Client
+	translatorProvider SrsAssistantProvider
 
 	// The configure for transcript task.
 	config TranscriptConfig
@@ -1490,6 +1845,9 @@ type TranscriptTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// To stop the goroutines of task.
+	stop context.CancelFunc
+	wg   sync.WaitGroup
 
 	// To protect the common fields.
 	lock sync.Mutex
@@ -1509,27 +1867,91 @@ func NewTranscriptTask() *TranscriptTask {
 		OverlayQueue: NewTranscriptQueue(),
 		// Create persistence signal.
 		signalPersistence: make(chan bool, 1),
-		// Create new stream signal.
-		signalNewStream: make(chan *SrsStream, 1),
+		// The segments to evaluate the alert rules.
+		alertSegments: make(chan *TranscriptSegment, transcriptAlertQueueSize),
 	}
 }
 
 func (v *TranscriptTask) String() string {
-	return fmt.Sprintf("uuid=%v, live=%v, asr=%v, fix=%v, pat=%v, overlay=%v, config is %v",
-		v.UUID, v.LiveQueue.String(), v.AsrQueue.String(), v.FixQueue.String(), v.PreviousAsrText,
+	return fmt.Sprintf("uuid=%v, stream=%v, live=%v, asr=%v, fix=%v, pat=%v, overlay=%v, config is %v",
+		v.UUID, v.Stream, v.LiveQueue.String(), v.AsrQueue.String(), v.FixQueue.String(), v.PreviousAsrText,
 		v.OverlayQueue.String(), v.config.String(),
 	)
 }
 
+// Start the goroutines to run and drive the queues of task, which are stopped by Close.
+func (v *TranscriptTask) Start(ctx context.Context) {
+	ctx, v.stop = context.WithCancel(ctx)
+
+	drive := func(name string, interval time.Duration, fn func(ctx context.Context) error) {
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+
+			for ctx.Err() == nil {
+				var duration time.Duration
+				if err := fn(ctx); err != nil {
+					logger.Wf(ctx, "transcript: task %v %v err %+v", v.String(), name, err)
+					duration = 10 * time.Second
+				} else {
+					duration = interval
+				}
+
+				select {
+				case <-ctx.Done():
+				case <-time.After(duration):
+				}
+			}
+		}()
+	}
+
+	// Run the transcript task.
+	drive("run", 3*time.Second, v.Run)
+	// Drive the live queue to ASR.
+	drive("drive live queue", 200*time.Millisecond, v.DriveLiveQueue)
+	// Drive the asr queue to correct queue.
+	drive("drive asr queue", 200*time.Millisecond, v.DriveAsrQueue)
+	// Drive the fix queue to overlay queue.
+	drive("drive fix queue", 200*time.Millisecond, v.DriveFixQueue)
+	// Drive the overlay queue, remove old files.
+	drive("drive overlay queue", 200*time.Millisecond, v.DriveOverlayQueue)
+
+	// Evaluate the alert rules of segments, which might be slow because of AI classifier.
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case segment := <-v.alertSegments:
+				if err := v.evaluateAlerts(ctx, segment); err != nil {
+					logger.Wf(ctx, "transcript: ignore alert %v err %+v", segment.String(), err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stop the goroutines of task.
+func (v *TranscriptTask) Close() error {
+	if v.stop != nil {
+		v.stop()
+	}
+	v.wg.Wait()
+	return nil
+}
+
 func (v *TranscriptTask) Run(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "transcript run task %v", v.String())
 
 	pfn := func(ctx context.Context) error {
-		// Load config from redis.
+		// Load config from redis, and use the language of stream.
 		if err := v.config.Load(ctx); err != nil {
 			return errors.Wrapf(err, "load config")
 		}
+		v.config.Language = v.config.LanguageOf(v.Stream)
 
 		// Ignore if not enabled.
 		if !v.config.All {
@@ -1578,10 +2000,6 @@ func (v *TranscriptTask) doTranscript(ctx context.Context) error {
 			if err := v.saveTask(ctx); err != nil {
 				return errors.Wrapf(err, "save task %v", v.String())
 			}
-		case input := <-v.signalNewStream:
-			host := "localhost"
-			inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
-			v.Input, v.inputStream = inputURL, input
 		}
 	}
 
@@ -1600,9 +2018,11 @@ func (v *TranscriptTask) OnTsSegment(ctx context.Context, msg *SrsOnHlsObject) e
 		v.lock.Unlock()
 
 		v.LiveQueue.enqueue(&TranscriptSegment{
-			Msg:    msg.Msg,
-			TsFile: msg.TsFile,
+			Msg:      msg.Msg,
+			TsFile:   msg.TsFile,
+			Received: time.Now(),
 		})
+		v.Update = time.Now().Format(time.RFC3339)
 	}()
 
 	// Notify the main loop to persistent current task.
@@ -1610,89 +2030,6 @@ func (v *TranscriptTask) OnTsSegment(ctx context.Context, msg *SrsOnHlsObject) e
 	return nil
 }
 
-// TODO: FIXME: Should reset the stream when republish.
-func (v *TranscriptTask) WatchNewStream(ctx context.Context) error {
-	// If not enabled, wait.
-	if !v.config.All {
-		return nil
-	}
-
-	selectActiveStream := func() (*SrsStream, error) {
-		streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
-		if err != nil {
-			return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
-		}
-
-		var best *SrsStream
-		for _, value := range streams {
-			var stream SrsStream
-			if err := json.Unmarshal([]byte(value), &stream); err != nil {
-				return nil, errors.Wrapf(err, "unmarshal %v", value)
-			}
-
-			if best == nil {
-				best = &stream
-				continue
-			}
-
-			bestUpdate, err := time.Parse(time.RFC3339, best.Update)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse %v", best.Update)
-			}
-
-			streamUpdate, err := time.Parse(time.RFC3339, stream.Update)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse %v", stream.Update)
-			}
-
-			if bestUpdate.Before(streamUpdate) {
-				best = &stream
-			}
-		}
-
-		// Ignore if no active stream.
-		if best == nil {
-			return nil, nil
-		}
-
-		if v.inputStream != nil && v.inputStream.StreamURL() != best.StreamURL() {
-			logger.Tf(ctx, "transcript use best=%v as input", best.StreamURL())
-		}
-		return best, nil
-	}
-
-	// Use an active stream as input.
-	input, err := selectActiveStream()
-	if err != nil {
-		return errors.Wrapf(err, "select input")
-	}
-
-	// Whether stream exists and changed.
-	var streamNotChanged bool
-	if v.inputStream != nil && input != nil && v.inputStream.StreamURL() == input.StreamURL() {
-		streamNotChanged = true
-	}
-
-	// No stream, or stream not changed, wait.
-	if input == nil || streamNotChanged {
-		select {
-		case <-ctx.Done():
-		case <-time.After(1 * time.Second):
-		}
-		return nil
-	}
-	logger.Tf(ctx, "transcript: Got new stream %v", input.String())
-
-	// Notify the main loop to change the active stream.
-	select {
-	case <-ctx.Done():
-	case v.signalNewStream <- input:
-		logger.Tf(ctx, "transcript: Notify new stream %v", input.String())
-	}
-
-	return nil
-}
-
 func (v *TranscriptTask) DriveLiveQueue(ctx context.Context) error {
 	// Ignore if not enabled.
 	if !v.config.All {
@@ -1801,25 +2138,15 @@ func (v *TranscriptTask) DriveAsrQueue(ctx context.Context) error {
 	}
 
 	// Convert the audio file to text by AI.
-	var config openai.ClientConfig
-	config = openai.DefaultConfig(v.config.SecretKey)
-	config.BaseURL = v.config.BaseURL
-	config.OrgID = v.config.Organization
+	provider, err := NewASRProvider(v.config.asrProvider())
+	if err != nil {
+		return errors.Wrapf(err, "asr provider")
+	}
 
 	// TODO: FIXME: Fast retry when failed.
 	// TODO: FIXME: Use smaller timeout.
-	client := openai.NewClientWithConfig(config)
 	prompt := v.PreviousAsrText
-	resp, err := client.CreateTranscription(
-		ctx,
-		openai.AudioRequest{
-			Model:    openai.Whisper1,
-			FilePath: segment.AudioFile.File,
-			Format:   openai.AudioResponseFormatVerboseJSON,
-			Language: v.config.Language,
-			Prompt:   prompt,
-		},
-	)
+	resp, err := provider.Transcribe(ctx, segment.AudioFile.File, v.config.Language, prompt)
 	if err != nil {
 		// TODO: FIXME: Cleanup the failed file.
 		return errors.Wrapf(err, "transcription %v", segment.String())
@@ -1884,23 +2211,14 @@ func (v *TranscriptTask) DriveAsrQueue(ctx context.Context) error {
 		defer v.lock.Unlock()
 		v.AsrQueue.dequeue(segment)
 	}()
-	segment.AsrText = &TranscriptAsrResult{
-		Task:     resp.Task,
-		Language: resp.Language,
-		Duration: resp.Duration,
-		Text:     resp.Text,
-	}
-	for _, s := range resp.Segments {
-		segment.AsrText.Segments = append(segment.AsrText.Segments, TranscriptAsrSegment{
-			ID:    s.ID,
-			Seek:  s.Seek,
-			Start: s.Start,
-			End:   s.End,
-			Text:  s.Text,
-		})
-	}
+	segment.AsrText = resp
 	v.PreviousAsrText = resp.Text
 	segment.CostASR = time.Since(starttime)
+	platformMetrics.OnAICost("asr", segment.CostASR)
+	platformEvents.Publish(ctx, EventTranscriptSegment, eventStreamURL(segment.Msg), map[string]interface{}{
+		"id": segment.AudioFile.TsID, "text": resp.Text, "start": segment.StreamStarttime.Seconds(),
+		"duration": segment.AudioFile.Duration,
+	})
 	func() {
 		v.lock.Lock()
 		defer v.lock.Unlock()
@@ -1909,6 +2227,14 @@ func (v *TranscriptTask) DriveAsrQueue(ctx context.Context) error {
 	logger.Tf(ctx, "transcript: asr audio=%v, prompt=%v, text=%v, cost=%v",
 		segment.AudioFile.File, prompt, resp.Text, segment.CostASR)
 
+	// Archive the ASR text to session, note that the fixed text will be updated later.
+	if err := v.archiveSegment(ctx, segment); err != nil {
+		logger.Wf(ctx, "transcript: ignore archive %v err %+v", segment.String(), err)
+	}
+
+	// Match the ASR text by alert rules, asynchronously.
+	v.enqueueAlerts(ctx, segment)
+
 	// Notify the main loop to persistent current task.
 	v.notifyPersistence(ctx)
 	return nil
@@ -1954,6 +2280,14 @@ func (v *TranscriptTask) DriveFixQueue(ctx context.Context) error {
 	}
 	overlayFile.File = path.Join("transcript", fmt.Sprintf("%v.ts", overlayFile.TsID))
 
+	// Translate the fixed ASR text to other languages, for WebVTT subtitles.
+	v.translateSubtitles(ctx, segment)
+
+	// Update the archived session, because user might fix or clear the ASR text.
+	if err := v.archiveSegment(ctx, segment); err != nil {
+		logger.Wf(ctx, "transcript: ignore archive %v err %+v", segment.String(), err)
+	}
+
 	var processCmd string
 	if v.config.EnableOverlay {
 		args := []string{
@@ -2087,24 +2421,13 @@ func (v *TranscriptTask) reset(ctx context.Context) error {
 	}
 
 	if err := func() error {
+		if err := v.dispose(ctx); err != nil {
+			return errors.Wrapf(err, "dispose")
+		}
+
 		v.lock.Lock()
 		defer v.lock.Unlock()
 
-		// Reset all queues.
-		v.LiveQueue.reset(ctx)
-		v.AsrQueue.reset(ctx)
-		v.FixQueue.reset(ctx)
-		v.OverlayQueue.reset(ctx)
-
-		// Reset all states.
-		v.Input = ""
-		v.PreviousAsrText = ""
-
-		// Remove previous task from redis.
-		if err := rdb.HDel(ctx, SRS_TRANSCRIPT_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_TASK, v.UUID)
-		}
-
 		// Regenerate new UUID.
 		v.UUID = uuidpkg.NewString()
 
@@ -2124,6 +2447,28 @@ func (v *TranscriptTask) reset(ctx context.Context) error {
 	return nil
 }
 
+// dispose remove all segments and files, and remove the task from redis.
+func (v *TranscriptTask) dispose(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Reset all queues.
+	v.LiveQueue.reset(ctx)
+	v.AsrQueue.reset(ctx)
+	v.FixQueue.reset(ctx)
+	v.OverlayQueue.reset(ctx)
+
+	// Reset all states.
+	v.PreviousAsrText = ""
+
+	// Remove previous task from redis.
+	if err := rdb.HDel(ctx, SRS_TRANSCRIPT_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_TASK, v.UUID)
+	}
+
+	return nil
+}
+
 func (v *TranscriptTask) enabled() bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -2135,15 +2480,20 @@ func (v *TranscriptTask) match(msg *SrsOnHlsMessage) bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
-	if v.inputStream == nil || !v.config.All {
+	if v.Stream == "" || !v.config.All {
 		return false
 	}
 
-	if v.inputStream.App != msg.App || v.inputStream.Stream != msg.Stream {
-		return false
-	}
+	return v.Stream == fmt.Sprintf("/%v/%v", msg.App, msg.Stream)
+}
+
+// updated returns the last time the task got a segment.
+func (v *TranscriptTask) updated() time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
 
-	return true
+	update, _ := time.Parse(time.RFC3339, v.Update)
+	return update
 }
 
 func (v *TranscriptTask) liveSegments() []*TranscriptSegment {
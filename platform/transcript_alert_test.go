@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTranscriptAlertRule_Validate(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		rule     *TranscriptAlertRule
+		hasError bool
+	}{
+		{"keywords", &TranscriptAlertRule{Keywords: []string{"secret"}}, false},
+		{"regexp", &TranscriptAlertRule{Regexp: `\d{4}-\d{4}`}, false},
+		{"prompt", &TranscriptAlertRule{Prompt: "Talk about violence"}, false},
+		{"stream", &TranscriptAlertRule{Stream: "/live/*", Keywords: []string{"secret"}}, false},
+		{"empty", &TranscriptAlertRule{}, true},
+		{"empty-keyword", &TranscriptAlertRule{Keywords: []string{" "}}, true},
+		{"invalid-regexp", &TranscriptAlertRule{Regexp: `(`}, true},
+		{"invalid-stream", &TranscriptAlertRule{Stream: "live/*", Keywords: []string{"secret"}}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.rule.Validate(); (err != nil) != c.hasError {
+				t.Errorf("Expected error %v, got %v", c.hasError, err)
+			}
+			if !c.hasError && c.rule.ID == "" {
+				t.Errorf("Expected generated id")
+			}
+		})
+	}
+
+	config := &TranscriptAlertConfig{Rules: []*TranscriptAlertRule{
+		{ID: "r0", Keywords: []string{"a"}}, {ID: "r0", Keywords: []string{"b"}},
+	}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected error for duplicated id")
+	}
+}
+
+func TestTranscriptAlertRule_Match(t *testing.T) {
+	rule := &TranscriptAlertRule{Enabled: true, Stream: "/live/*", Keywords: []string{"Password"}, Regexp: `\d{4}-\d{4}`}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("validate err %+v", err)
+	}
+
+	if !rule.selects("/live/livestream") || rule.selects("/show/livestream") {
+		t.Errorf("Expected only select /live/*")
+	}
+	if r := rule.matchText("my password is"); r != "Password" {
+		t.Errorf("Expected keyword, got %v", r)
+	}
+	if r := rule.matchText("call 1234-5678 now"); r != "1234-5678" {
+		t.Errorf("Expected regexp, got %v", r)
+	}
+	if r := rule.matchText("hello world"); r != "" {
+		t.Errorf("Expected no match, got %v", r)
+	}
+	// Only match the whole words.
+	if r := rule.matchText("the passwords are mypassword"); r != "" {
+		t.Errorf("Expected no match for partial word, got %v", r)
+	}
+	if r := rule.matchText("PASSWORD: 123"); r != "Password" {
+		t.Errorf("Expected keyword with punctuation, got %v", r)
+	}
+
+	// There is no word boundary for CJK, and the keyword might contain special characters.
+	rule = &TranscriptAlertRule{Keywords: []string{"密码", "c++"}}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("validate err %+v", err)
+	}
+	if r := rule.matchText("我的密码是"); r != "密码" {
+		t.Errorf("Expected CJK keyword, got %v", r)
+	}
+	if r := rule.matchText("I love C++."); r != "c++" {
+		t.Errorf("Expected keyword with special characters, got %v", r)
+	}
+
+	rule.Enabled = false
+	if rule.selects("/live/livestream") {
+		t.Errorf("Expected not select disabled rule")
+	}
+}
+
+func TestTranscriptAlertRule_Locate(t *testing.T) {
+	rule := &TranscriptAlertRule{Keywords: []string{"secret plan"}}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("validate err %+v", err)
+	}
+	begin := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// Use the time of ASR segment which contains the keyword.
+	asr := &TranscriptAsrResult{Text: "Hello. The secret plan is here.", Segments: []TranscriptAsrSegment{
+		{Start: 0, End: 1, Text: "Hello."}, {Start: 1.5, End: 3, Text: "The secret plan is here."},
+	}}
+	if matched, at := rule.locate(asr, begin); matched != "secret plan" || !at.Equal(begin.Add(1500*time.Millisecond)) {
+		t.Errorf("Expected matched at 1.5s, got %v %v", matched, at)
+	}
+
+	// Use the begin time if the phrase crosses segments.
+	asr = &TranscriptAsrResult{Text: "The secret plan", Segments: []TranscriptAsrSegment{
+		{Start: 0, End: 1, Text: "The secret"}, {Start: 1, End: 2, Text: "plan"},
+	}}
+	if matched, at := rule.locate(asr, begin); matched != "secret plan" || !at.Equal(begin) {
+		t.Errorf("Expected matched at begin, got %v %v", matched, at)
+	}
+}
+
+func TestParseClassifiedText(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		expect  string
+	}{
+		{"NO", ""}, {"no.", ""}, {" \"No\" ", ""}, {"\"kill you\"", "kill you"}, {"kill you.", "kill you"},
+	} {
+		if r := parseClassifiedText(c.content); r != c.expect {
+			t.Errorf("Expected %v for %v, got %v", c.expect, c.content, r)
+		}
+	}
+}
+
+func TestClassifyTranscript(t *testing.T) {
+	var request openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(&openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "free money"}},
+		}})
+	}))
+	defer server.Close()
+
+	config := &TranscriptConfig{SecretKey: "key", BaseURL: server.URL}
+	chat := config.chatOptions(nil, "You are a reviewer")
+	matched, err := classifyTranscript(context.Background(), chat, "Talk about scams", "Get free money now")
+	if err != nil {
+		t.Fatalf("classify err %+v", err)
+	}
+	if matched != "free money" {
+		t.Errorf("Expected free money, got %v", matched)
+	}
+	if len(request.Messages) != 2 || !strings.Contains(request.Messages[0].Content, "Talk about scams") ||
+		request.Messages[1].Content != "Get free money now" {
+		t.Errorf("Invalid request %v", request)
+	}
+}
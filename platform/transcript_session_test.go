@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBuildTranscriptCues(t *testing.T) {
+	received := time.Date(2024, 1, 1, 10, 0, 10, 0, time.UTC)
+	segment := &TranscriptSegment{
+		TsFile:   &TsFile{TsID: "ts0", Duration: 10},
+		Received: received,
+		AsrText: &TranscriptAsrResult{Text: "Hello world", Segments: []TranscriptAsrSegment{
+			{Start: 0.5, End: 2, Text: " Hello"}, {Start: 2, End: 4.5, Text: " world"}, {Start: 4.5, End: 5, Text: " "},
+		}},
+	}
+
+	cues := buildTranscriptCues(segment)
+	if len(cues) != 2 {
+		t.Fatalf("Expected 2 cues, got %v", len(cues))
+	}
+	if expect := received.Add(-9500 * time.Millisecond); !cues[0].Start.Equal(expect) || cues[0].Text != "Hello" {
+		t.Errorf("Expected start %v, got %v %v", expect, cues[0].Start, cues[0].Text)
+	}
+	if expect := received.Add(-5500 * time.Millisecond); !cues[1].End.Equal(expect) || cues[1].TsID != "ts0" {
+		t.Errorf("Expected end %v, got %v", expect, cues[1].End)
+	}
+
+	// Use the whole text if no segments.
+	segment.AsrText.Segments = nil
+	if cues = buildTranscriptCues(segment); len(cues) != 1 || cues[0].Text != "Hello world" {
+		t.Errorf("Expected one cue of text, got %v", len(cues))
+	}
+
+	// Ignore the text cleared by user.
+	segment.UserClearASR = true
+	if cues = buildTranscriptCues(segment); len(cues) != 0 {
+		t.Errorf("Expected no cues, got %v", len(cues))
+	}
+}
+
+func TestTranscriptTask_SessionOfSegment(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	newSegment := func(received time.Time) *TranscriptSegment {
+		return &TranscriptSegment{TsFile: &TsFile{Duration: 10}, Received: received}
+	}
+
+	// Start a session for the first segment.
+	task := &TranscriptTask{UUID: "task", Stream: "/live/livestream", resumed: true}
+	first, err := task.sessionOfSegment(ctx, newSegment(base.Add(10*time.Second)))
+	if err != nil || first == nil || !first.Start.Equal(base) {
+		t.Fatalf("Expected new session, got %v, err %v", first, err)
+	}
+
+	// The stream is published again, the segment received before belongs to the previous session.
+	task.previous, task.session, task.publishAt = first, nil, base.Add(time.Minute)
+	if session, err := task.sessionOfSegment(ctx, newSegment(base.Add(50*time.Second))); err != nil || session != first {
+		t.Errorf("Expected previous session, got %v, err %v", session, err)
+	}
+
+	second, err := task.sessionOfSegment(ctx, newSegment(base.Add(70*time.Second)))
+	if err != nil || second == nil || second == first {
+		t.Fatalf("Expected another session, got %v, err %v", second, err)
+	}
+
+	// The fixed segment is always archived to its session.
+	segment := newSegment(base.Add(80 * time.Second))
+	segment.Session = first.UUID
+	if session, err := task.sessionOfSegment(ctx, segment); err != nil || session != first {
+		t.Errorf("Expected fixed to previous session, got %v, err %v", session, err)
+	}
+
+	// The segment received before unpublished belongs to the done session, otherwise start a new session.
+	second.Done = base.Add(90 * time.Second).Format(time.RFC3339)
+	if session, err := task.sessionOfSegment(ctx, newSegment(base.Add(85*time.Second))); err != nil || session != second {
+		t.Errorf("Expected done session, got %v, err %v", session, err)
+	}
+	if session, err := task.sessionOfSegment(ctx, newSegment(base.Add(100*time.Second))); err != nil ||
+		session == nil || session == second || task.previous != second {
+		t.Errorf("Expected new session after done, got %v, err %v", session, err)
+	}
+}
+
+func TestExportTranscriptSession(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	session := &TranscriptSession{UUID: "s0", Stream: "/live/livestream", Start: base}
+	cues := []*TranscriptCue{
+		{Start: base.Add(1500 * time.Millisecond), End: base.Add(3 * time.Second), Text: "Hello"},
+		{Start: base.Add(time.Hour + 62*time.Second), End: base.Add(time.Hour + 63*time.Second + 250*time.Millisecond), Text: "World"},
+	}
+
+	for _, c := range []struct {
+		format      string
+		contentType string
+		expect      string
+	}{
+		{"srt", "application/x-subrip", "1\n00:00:01,500 --> 00:00:03,000\nHello\n\n2\n01:01:02,000 --> 01:01:03,250\nWorld\n\n"},
+		{"vtt", "text/vtt", "WEBVTT\n\n00:00:01.500 --> 00:00:03.000\nHello\n\n01:01:02.000 --> 01:01:03.250\nWorld\n\n"},
+		{"txt", "text/plain; charset=utf-8", "Hello\nWorld\n"},
+	} {
+		t.Run(c.format, func(t *testing.T) {
+			contentType, body, err := exportTranscriptSession(session, cues, c.format)
+			if err != nil {
+				t.Fatalf("export err %+v", err)
+			}
+			if contentType != c.contentType || body != c.expect {
+				t.Errorf("Expected %v %q, got %v %q", c.contentType, c.expect, contentType, body)
+			}
+		})
+	}
+
+	_, body, err := exportTranscriptSession(session, cues, "json")
+	if err != nil {
+		t.Fatalf("export json err %+v", err)
+	}
+	var res struct {
+		Session *TranscriptSession `json:"session"`
+		Cues    []*TranscriptCue   `json:"cues"`
+	}
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatalf("unmarshal err %+v", err)
+	}
+	if res.Session.UUID != "s0" || len(res.Cues) != 2 || !res.Cues[1].Start.Equal(cues[1].Start) {
+		t.Errorf("Unexpected json %v", body)
+	}
+
+	if _, _, err := exportTranscriptSession(session, cues, "doc"); err == nil {
+		t.Errorf("Expected error for invalid format")
+	}
+}
+
+func TestSearchTranscriptCues(t *testing.T) {
+	cues := []*TranscriptCue{{Text: "Hello World"}, {Text: "Goodbye"}, {Text: "hello again"}}
+	if matches := searchTranscriptCues(cues, "HELLO"); len(matches) != 2 || matches[1].Text != "hello again" {
+		t.Errorf("Expected 2 matches, got %v", len(matches))
+	}
+}
+
+func TestTranscriptSession_Active(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	session := &TranscriptSession{Update: now.Add(-10 * time.Second).Format(time.RFC3339)}
+	if !session.active() || session.expired(now.Add(transcriptSessionRetention*2)) {
+		t.Errorf("Expected active and not expired")
+	}
+
+	session.Done = session.Update
+	if session.active() {
+		t.Errorf("Expected inactive when done")
+	}
+	if session.expired(now) || !session.expired(now.Add(transcriptSessionRetention)) {
+		t.Errorf("Expected expired after retention")
+	}
+}
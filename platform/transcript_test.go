@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTranscriptConfig_SelectStreams(t *testing.T) {
+	actives := []*SrsStream{
+		{App: "live", Stream: "a", Update: "2024-01-01T10:00:00Z"},
+		{App: "live", Stream: "b", Update: "2024-01-01T10:00:02Z"},
+		{App: "show", Stream: "c", Update: "2024-01-01T10:00:01Z"},
+	}
+
+	for _, c := range []struct {
+		name   string
+		config *TranscriptConfig
+		expect string
+	}{
+		{"latest", &TranscriptConfig{}, "/live/b"},
+		{"streams", &TranscriptConfig{Streams: []*TranscriptStreamConfig{{Stream: "/live/a"}, {Stream: "/show/c"}}}, "/live/a,/show/c"},
+		{"globs", &TranscriptConfig{Globs: []string{"/live/*"}}, "/live/a,/live/b"},
+		{"streams-and-globs", &TranscriptConfig{Streams: []*TranscriptStreamConfig{{Stream: "/show/c"}}, Globs: []string{"/live/a"}}, "/live/a,/show/c"},
+		{"none", &TranscriptConfig{Globs: []string{"/other/*"}}, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if r := strings.Join(c.config.selectStreams(actives), ","); r != c.expect {
+				t.Errorf("Expected %v, got %v", c.expect, r)
+			}
+		})
+	}
+}
+
+func TestTranscriptConfig_KeepTask(t *testing.T) {
+	// Only keep the task of the latest stream, or keep it if no active stream.
+	latest := &TranscriptConfig{}
+	if !latest.keepTask("/live/a", nil) || !latest.keepTask("/live/a", []string{"/live/a"}) {
+		t.Errorf("Expected keep task of latest stream")
+	}
+	if latest.keepTask("/live/a", []string{"/live/b"}) {
+		t.Errorf("Expected stop task of previous stream")
+	}
+
+	// Keep the task if selected by config, even not active.
+	globs := &TranscriptConfig{Globs: []string{"/live/*"}}
+	if !globs.keepTask("/live/a", nil) {
+		t.Errorf("Expected keep task selected by globs")
+	}
+	if globs.keepTask("/show/a", []string{"/live/b"}) {
+		t.Errorf("Expected stop task not selected by globs")
+	}
+}
+
+func TestTranscriptConfig_Validate(t *testing.T) {
+	config := &TranscriptConfig{Language: "en", Streams: []*TranscriptStreamConfig{
+		{Stream: "/live/a", Language: "zh"}, {Stream: "/live/b"},
+	}, Globs: []string{"/live/*"}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected valid, got %v", err)
+	}
+	if lang := config.LanguageOf("/live/a"); lang != "zh" {
+		t.Errorf("Expected zh, got %v", lang)
+	}
+	if lang := config.LanguageOf("/live/b"); lang != "en" {
+		t.Errorf("Expected en, got %v", lang)
+	}
+
+	for _, c := range []*TranscriptConfig{
+		{Streams: []*TranscriptStreamConfig{{Stream: "live/a"}}},
+		{Streams: []*TranscriptStreamConfig{{Stream: "/live/a/b"}}},
+		{Streams: []*TranscriptStreamConfig{nil}},
+		{Globs: []string{"live/*"}},
+		{Globs: []string{"/live/["}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected invalid for %v", c.String())
+		}
+	}
+}
+
+func TestParseTranslatedLines(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		n       int
+		expect  string
+		valid   bool
+	}{
+		{`["你好","世界"]`, 2, "你好|世界", true},
+		{"```json\n[\"你好\", \"世界\"]\n```", 2, "你好|世界", true},
+		{`["你好世界"]`, 2, "", false},
+		{`你好世界`, 1, "", false},
+	} {
+		lines, err := parseTranslatedLines(c.content, c.n)
+		if (err == nil) != c.valid {
+			t.Errorf("Expected valid=%v for %v, got %v", c.valid, c.content, err)
+		} else if err == nil && strings.Join(lines, "|") != c.expect {
+			t.Errorf("Expected %v, got %v", c.expect, lines)
+		}
+	}
+}
+
+func TestTranslateSegment(t *testing.T) {
+	asr := &TranscriptAsrResult{Text: "Hello world", Segments: []TranscriptAsrSegment{
+		{ID: 0, Start: 0, End: 1.5, Text: "Hello"}, {ID: 1, Start: 1.5, End: 3, Text: "world"},
+	}}
+
+	for _, c := range []struct {
+		name   string
+		answer string
+		expect string
+	}{
+		{"lines", `["你好","世界"]`, "0-1.5:你好|1.5-3:世界"},
+		{"fallback", `你好世界`, "0-3:你好世界"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var request openai.ChatCompletionRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&request)
+				json.NewEncoder(w).Encode(&openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+					{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: c.answer}},
+				}})
+			}))
+			defer server.Close()
+
+			config := &TranscriptConfig{SecretKey: "key", BaseURL: server.URL, TranslateLanguages: []string{"zh"}}
+			trans := config.translateOptions()
+			client := NewTranscriptTask().translateClient(trans)
+			segments, err := translateSegment(context.Background(), client, trans, "zh", asr)
+			if err != nil {
+				t.Fatalf("Translate err %+v", err)
+			}
+
+			var r []string
+			for _, s := range segments {
+				r = append(r, fmt.Sprintf("%v-%v:%v", s.Start, s.End, s.Text))
+			}
+			if strings.Join(r, "|") != c.expect {
+				t.Errorf("Expected %v, got %v", c.expect, r)
+			}
+			if request.Model != openai.GPT3Dot5Turbo || len(request.Messages) != 2 || request.Messages[1].Content != `["Hello","world"]` {
+				t.Errorf("Invalid request %v", request)
+			}
+		})
+	}
+}
+
+func TestBuildLiveM3u8ForVariantCC(t *testing.T) {
+	_, body, err := buildLiveM3u8ForVariantCC(context.Background(), 1000, "stream.m3u8", []*HlsSubtitleRendition{
+		{Language: "en", URI: "subtitles.m3u8"}, {Language: "zh", URI: "subtitles-zh.m3u8"},
+	})
+	if err != nil {
+		t.Fatalf("Build err %+v", err)
+	}
+
+	for _, expect := range []string{
+		`LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,FORCED=NO,URI="subtitles.m3u8"`,
+		`LANGUAGE="zh",DEFAULT=NO,AUTOSELECT=YES,FORCED=NO,URI="subtitles-zh.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=1000,SUBTITLES="subs"`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("Expected %v in %v", expect, body)
+		}
+	}
+}
+
+func TestTranscriptConfig_Translate(t *testing.T) {
+	config := &TranscriptConfig{SecretKey: "key", BaseURL: "https://api.openai.com/v1"}
+	if config.translateEnabled() {
+		t.Errorf("Expected disabled without languages")
+	}
+
+	config.TranslateLanguages = []string{"zh", "pt-BR"}
+	if err := config.Validate(); err != nil || !config.translateEnabled() {
+		t.Errorf("Expected enabled, err %v", err)
+	}
+
+	// Use the AI provider of transcript, if not set for translation.
+	if trans := config.translateOptions(); trans.AISecretKey != "key" || trans.AIBaseURL != config.BaseURL {
+		t.Errorf("Expected provider of transcript, got %v", trans.String())
+	}
+
+	// Use the chat settings of translation.
+	config.Translation = &SrsAssistant{
+		SrsAssistantProvider: SrsAssistantProvider{AISecretKey: "other"},
+		SrsAssistantChat:     SrsAssistantChat{AIChatEnabled: true, AIChatModel: "gpt-4o"},
+	}
+	if trans := config.translateOptions(); trans.AISecretKey != "other" || trans.AIChatModel != "gpt-4o" {
+		t.Errorf("Expected translation settings, got %v", trans.String())
+	}
+
+	config.Translation.AIChatEnabled = false
+	if config.translateEnabled() {
+		t.Errorf("Expected disabled by translation settings")
+	}
+
+	for _, lang := range []string{"z", "zh/../x", `zh"`, "zh.vtt"} {
+		config.TranslateLanguages = []string{lang}
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected invalid language %v", lang)
+		}
+	}
+}
+
+func TestTranscriptTask_TranslateSubtitles(t *testing.T) {
+	var running, peak int
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		if running++; running > peak {
+			peak = running
+		}
+		lock.Unlock()
+
+		time.Sleep(30 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		json.NewEncoder(w).Encode(&openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: `["Hi"]`}},
+		}})
+	}))
+	defer server.Close()
+
+	task := NewTranscriptTask()
+	task.config = TranscriptConfig{
+		SecretKey: "key", BaseURL: server.URL, Language: "en",
+		TranslateLanguages: []string{"en", "zh", "fr", "de", "ja", "ko", "es"},
+	}
+	segment := &TranscriptSegment{AsrText: &TranscriptAsrResult{Text: "Hello", Segments: []TranscriptAsrSegment{
+		{ID: 0, Start: 0, End: 1, Text: "Hello"},
+	}}}
+
+	task.translateSubtitles(context.Background(), segment)
+	if len(segment.Translations) != 6 || segment.Translations["zh"][0].Text != "Hi" {
+		t.Errorf("Invalid translations %v", segment.Translations)
+	}
+	if peak < 2 || peak > maxTranslateConcurrency {
+		t.Errorf("Expected concurrency in [2, %v], got %v", maxTranslateConcurrency, peak)
+	}
+
+	// Reuse the client, unless the provider is changed.
+	trans := task.config.translateOptions()
+	if client := task.translateClient(trans); client != task.translator {
+		t.Errorf("Expected the same client")
+	}
+	trans.AIBaseURL = "http://127.0.0.1:8000/v1"
+	if client := task.translateClient(trans); client == nil || task.translatorProvider.AIBaseURL != trans.AIBaseURL {
+		t.Errorf("Expected a new client")
+	}
+}
@@ -0,0 +1,976 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The roles of user, which grants a set of scopes, see userRoleScopes.
+const (
+	// The admin manages the system, users and keys.
+	RoleAdmin = "admin"
+	// The operator manages the streams, recording and live rooms, but not the system.
+	RoleOperator = "operator"
+	// The viewer only queries the streams and recording.
+	RoleViewer = "viewer"
+)
+
+// userRoleScopes is the scopes granted to each role.
+var userRoleScopes = map[string][]string{
+	RoleAdmin: {ScopeAdmin},
+	RoleOperator: {
+		ScopeStreamsWrite, ScopeRecordWrite, ScopeRoomsManage, ScopeMetricsRead, ScopeEventsRead,
+	},
+	RoleViewer: {
+		ScopeStreamsRead, ScopeRecordRead, ScopeMetricsRead, ScopeEventsRead,
+	},
+}
+
+const (
+	// The default name of the first admin user, which is created by init or migrated from MGMT_PASSWORD.
+	userDefaultAdmin = "admin"
+	// The expire duration of login session.
+	userSessionExpire = 30 * 24 * time.Hour
+	// The cost of bcrypt to hash password, and the max bytes of password which bcrypt supports.
+	userPasswordCost     = 12
+	userPasswordMaxBytes = 72
+	// The TOTP period and digits, see RFC6238, which is the default of most authenticator apps.
+	userTOTPPeriod = 30
+	userTOTPDigits = 6
+)
+
+// User is an account to login the console, the password is hashed and never responded.
+type User struct {
+	// The user ID, which is the sub of JWT.
+	ID string `json:"id"`
+	// The login name of user, such as admin.
+	Name string `json:"name"`
+	// The role of user, such as admin, operator or viewer.
+	Role string `json:"role"`
+	// The hashed password, see hashUserPassword.
+	Password string `json:"password,omitempty"`
+	// The TOTP secret in base32, which is pending to enable if not enabled.
+	TOTPSecret string `json:"totpSecret,omitempty"`
+	// Whether TOTP two-factor is enabled.
+	TOTPEnabled bool `json:"totp"`
+	// The last used TOTP step, to reject the replayed code.
+	TOTPStep int64 `json:"totpStep,omitempty"`
+	// The create and update time in RFC3339.
+	CreateAt string `json:"createAt"`
+	UpdateAt string `json:"updateAt"`
+}
+
+func (v *User) String() string {
+	return fmt.Sprintf("id=%v, name=%v, role=%v, totp=%v, create=%v, update=%v",
+		v.ID, v.Name, v.Role, v.TOTPEnabled, v.CreateAt, v.UpdateAt)
+}
+
+// Public returns a copy of user without the password and TOTP secret, to respond to client.
+func (v *User) Public() *User {
+	return &User{
+		ID: v.ID, Name: v.Name, Role: v.Role, TOTPEnabled: v.TOTPEnabled, CreateAt: v.CreateAt, UpdateAt: v.UpdateAt,
+	}
+}
+
+// UserSession is a login session of user, which is the jti of JWT, and can be revoked.
+type UserSession struct {
+	// The session ID, which is the jti of JWT.
+	ID string `json:"id"`
+	// The user ID and name of session.
+	UserID string `json:"userId"`
+	Name   string `json:"name"`
+	// The client IP and user agent when login.
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// The create and expire time in RFC3339.
+	CreateAt string `json:"createAt"`
+	ExpireAt string `json:"expireAt"`
+}
+
+func (v *UserSession) String() string {
+	return fmt.Sprintf("id=%v, user=%v, name=%v, ip=%v, create=%v, expire=%v",
+		v.ID, v.UserID, v.Name, v.IP, v.CreateAt, v.ExpireAt)
+}
+
+// Expired returns whether the session is expired at the time of now.
+func (v *UserSession) Expired(now time.Time) bool {
+	expireAt, err := time.Parse(time.RFC3339, v.ExpireAt)
+	return err != nil || now.After(expireAt)
+}
+
+// parseUserRole parse and validate the role, default to viewer if empty.
+func parseUserRole(role string) (string, error) {
+	if role = strings.ToLower(strings.TrimSpace(role)); role == "" {
+		return RoleViewer, nil
+	}
+	if _, ok := userRoleScopes[role]; !ok {
+		return "", errors.Errorf("invalid role %v", role)
+	}
+	return role, nil
+}
+
+// hashUserPassword hash the password by bcrypt with random salt. Note that bcrypt only uses the first 72 bytes
+// of password, so we reject the longer password rather than truncate it silently.
+func hashUserPassword(password string) (string, error) {
+	if len(password) > userPasswordMaxBytes {
+		return "", errors.Errorf("password exceeds %v bytes", userPasswordMaxBytes)
+	}
+
+	b, err := bcrypt.GenerateFromPassword([]byte(password), userPasswordCost)
+	if err != nil {
+		return "", errors.Wrapf(err, "bcrypt")
+	}
+	return string(b), nil
+}
+
+// verifyUserPassword returns whether the password matches the hashed password.
+func verifyUserPassword(hashed, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+}
+
+// createTOTPSecret create a random TOTP secret in base32 without padding.
+func createTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.Wrapf(err, "create secret")
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// totpCode generate the TOTP code of step by HMAC-SHA1, see RFC6238 and RFC4226.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrapf(err, "decode secret")
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// verifyTOTPCode verify the code at time of now, allow one step of clock drift, and reject the code of step not
+// after the last used step. Returns the matched step.
+func verifyTOTPCode(secret, code string, lastStep int64, now time.Time) (int64, error) {
+	if code = strings.TrimSpace(code); len(code) != userTOTPDigits {
+		return 0, errors.Errorf("invalid code %v", code)
+	}
+
+	current := now.Unix() / userTOTPPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		expect, err := totpCode(secret, step)
+		if err != nil {
+			return 0, errors.Wrapf(err, "generate code")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expect)) == 1 {
+			if step <= lastStep {
+				return 0, errors.Errorf("code of step %v already used", step)
+			}
+			return step, nil
+		}
+	}
+	return 0, errors.New("code not match")
+}
+
+// totpURL build the otpauth URL for authenticator apps to scan, see
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func totpURL(name, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", "Oryx")
+	q.Set("period", fmt.Sprintf("%v", userTOTPPeriod))
+	q.Set("digits", fmt.Sprintf("%v", userTOTPDigits))
+	return fmt.Sprintf("otpauth://totp/%v?%v", url.PathEscape("Oryx:"+name), q.Encode())
+}
+
+// createUserSessionToken build the JWT of user session, with the user ID as sub and the session ID as jti.
+func createUserSessionToken(apiSecret string, user *User, session *UserSession) (string, error) {
+	createAt, err := time.Parse(time.RFC3339, session.CreateAt)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse create %v", session.CreateAt)
+	}
+	expireAt, err := time.Parse(time.RFC3339, session.ExpireAt)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse expire %v", session.ExpireAt)
+	}
+
+	claims := apiKeyClaims{
+		Version: "1.0",
+		Scope:   strings.Join(userRoleScopes[user.Role], " "),
+		Role:    user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        session.ID,
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(createAt),
+			ExpiresAt: jwt.NewNumericDate(expireAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(apiSecret))
+	if err != nil {
+		return "", errors.Wrapf(err, "jwt sign")
+	}
+	return token, nil
+}
+
+// createUserSession create a login session for user, save it and returns the session and token.
+func createUserSession(ctx context.Context, apiSecret string, user *User, r *http.Request) (*UserSession, string, error) {
+	// Remove the expired sessions of all users, because the session is only removed when listed or revoked.
+	if _, err := loadUserSessions(ctx, ""); err != nil {
+		return nil, "", errors.Wrapf(err, "load sessions")
+	}
+
+	ip := playAuthClientIP(r)
+	now := time.Now()
+	session := &UserSession{
+		ID: uuid.NewString(), UserID: user.ID, Name: user.Name, IP: ip, UserAgent: r.UserAgent(),
+		CreateAt: now.Format(time.RFC3339), ExpireAt: now.Add(userSessionExpire).Format(time.RFC3339),
+	}
+
+	token, err := createUserSessionToken(apiSecret, user, session)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "create token for %v", session.String())
+	}
+
+	if b, err := json.Marshal(session); err != nil {
+		return nil, "", errors.Wrapf(err, "marshal %v", session.String())
+	} else if err := rdb.HSet(ctx, SRS_USER_SESSIONS, session.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return nil, "", errors.Wrapf(err, "hset %v %v", SRS_USER_SESSIONS, session.ID)
+	}
+	return session, token, nil
+}
+
+// verifyUserSession verify the session of JWT, and returns the user, whose current role should be used instead
+// of the role in JWT, so that the change of role takes effect immediately.
+func verifyUserSession(ctx context.Context, claims *apiKeyClaims) (*User, error) {
+	if claims.ID == "" {
+		return nil, errors.New("no session")
+	}
+
+	session, err := loadUserSession(ctx, claims.ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load session %v", claims.ID)
+	}
+	if session == nil {
+		return nil, errors.Errorf("session %v revoked or expired", claims.ID)
+	}
+	if session.UserID != claims.Subject {
+		return nil, errors.Errorf("session %v not match user %v", claims.ID, claims.Subject)
+	}
+
+	user, err := loadUser(ctx, session.UserID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load user %v", session.UserID)
+	}
+	if user == nil {
+		return nil, errors.Errorf("no user %v", session.UserID)
+	}
+	return user, nil
+}
+
+// parseUserToken parse the token or bearer of user session, and returns the user and session ID.
+func parseUserToken(ctx context.Context, apiSecret, token string, header http.Header) (*User, string, error) {
+	if authParts := strings.Split(header.Get("Authorization"), " "); len(authParts) == 2 && strings.ToLower(authParts[0]) == "bearer" {
+		token = authParts[1]
+	}
+	if token == "" {
+		return nil, "", errors.New("no token")
+	}
+
+	claims, err := parseAPIKeyToken(apiSecret, token)
+	if err != nil {
+		return nil, "", err
+	}
+	if claims.Subject == "" {
+		return nil, "", errors.New("not user token")
+	}
+
+	user, err := verifyUserSession(ctx, claims)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "verify session")
+	}
+	return user, claims.ID, nil
+}
+
+// loadUser load the user by ID, returns nil if not exists.
+func loadUser(ctx context.Context, id string) (*User, error) {
+	b, err := rdb.HGet(ctx, SRS_USERS, id).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_USERS, id)
+	}
+	if b == "" {
+		return nil, nil
+	}
+
+	var user User
+	if err := json.Unmarshal([]byte(b), &user); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &user, nil
+}
+
+// loadUsers load all users, sorted by create time.
+func loadUsers(ctx context.Context) ([]*User, error) {
+	values, err := rdb.HGetAll(ctx, SRS_USERS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_USERS)
+	}
+
+	users := []*User{}
+	for id, value := range values {
+		var user User
+		if err := json.Unmarshal([]byte(value), &user); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, value)
+		}
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].CreateAt < users[j].CreateAt
+	})
+	return users, nil
+}
+
+// loadUserByName load the user by name, which is case insensitive, returns nil if not exists.
+func loadUserByName(ctx context.Context, name string) (*User, error) {
+	users, err := loadUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if strings.EqualFold(user.Name, name) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func saveUser(ctx context.Context, user *User) error {
+	user.UpdateAt = time.Now().Format(time.RFC3339)
+	if b, err := json.Marshal(user); err != nil {
+		return errors.Wrapf(err, "marshal %v", user.String())
+	} else if err := rdb.HSet(ctx, SRS_USERS, user.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_USERS, user.ID)
+	}
+	return nil
+}
+
+// userCreateLock serialize the check and save of new user, to keep the name unique and initialize the system
+// only once, because both load the users then save a new one.
+var userCreateLock sync.Mutex
+
+// newUser build a user with name, password and role, the password is hashed, but not saved.
+func newUser(name, password, role string) (*User, error) {
+	if name = strings.TrimSpace(name); name == "" {
+		return nil, errors.New("no name")
+	}
+	if password == "" {
+		return nil, errors.New("no password")
+	}
+
+	hashed, err := hashUserPassword(password)
+	if err != nil {
+		return nil, errors.Wrapf(err, "hash password")
+	}
+
+	return &User{
+		ID: uuid.NewString(), Name: name, Role: role, Password: hashed, CreateAt: time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// createUser create a user with name, password and role, the name must be unique.
+func createUser(ctx context.Context, name, password, role string) (*User, error) {
+	user, err := newUser(name, password, role)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new user %v", name)
+	}
+
+	userCreateLock.Lock()
+	defer userCreateLock.Unlock()
+
+	if previous, err := loadUserByName(ctx, user.Name); err != nil {
+		return nil, errors.Wrapf(err, "load user %v", user.Name)
+	} else if previous != nil {
+		return nil, errors.Errorf("user %v exists", user.Name)
+	}
+
+	if err := saveUser(ctx, user); err != nil {
+		return nil, errors.Wrapf(err, "save %v", user.String())
+	}
+	return user, nil
+}
+
+// initAdminUser create the admin user to initialize the system, only if there is no user.
+func initAdminUser(ctx context.Context, password string) (*User, error) {
+	user, err := newUser(userDefaultAdmin, password, RoleAdmin)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new user %v", userDefaultAdmin)
+	}
+
+	userCreateLock.Lock()
+	defer userCreateLock.Unlock()
+
+	if initialized, err := hasUsers(ctx); err != nil {
+		return nil, errors.Wrapf(err, "query users")
+	} else if initialized {
+		return nil, errors.New("already initialized")
+	}
+
+	if err := saveUser(ctx, user); err != nil {
+		return nil, errors.Wrapf(err, "save %v", user.String())
+	}
+	return user, nil
+}
+
+// clearMgmtPassword remove the plaintext MGMT_PASSWORD from .env and environment, after it's migrated to
+// the admin user, because it's no longer used.
+func clearMgmtPassword(ctx context.Context) error {
+	envFile := path.Join(conf.Pwd, "containers/data/config/.env")
+	if _, err := os.Stat(envFile); err == nil {
+		envs, err := godotenv.Read(envFile)
+		if err != nil {
+			return errors.Wrapf(err, "load %v", envFile)
+		}
+
+		if _, ok := envs["MGMT_PASSWORD"]; ok {
+			delete(envs, "MGMT_PASSWORD")
+			if err := godotenv.Write(envs, envFile); err != nil {
+				return errors.Wrapf(err, "write %v", envFile)
+			}
+		}
+	}
+
+	if err := os.Unsetenv("MGMT_PASSWORD"); err != nil {
+		return errors.Wrapf(err, "unset MGMT_PASSWORD")
+	}
+	logger.Tf(ctx, "clear MGMT_PASSWORD in %v", envFile)
+	return nil
+}
+
+// hasUsers returns whether there is any user, that is, the system is initialized by users.
+func hasUsers(ctx context.Context) (bool, error) {
+	n, err := rdb.HLen(ctx, SRS_USERS).Result()
+	if err != nil && err != redis.Nil {
+		return false, errors.Wrapf(err, "hlen %v", SRS_USERS)
+	}
+	return n > 0, nil
+}
+
+// isLastAdmin returns whether the user is the only admin, which should never be removed or downgraded.
+func isLastAdmin(ctx context.Context, user *User) (bool, error) {
+	if user.Role != RoleAdmin {
+		return false, nil
+	}
+
+	users, err := loadUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, u := range users {
+		if u.ID != user.ID && u.Role == RoleAdmin {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// loadUserSession load the session by ID, returns nil if not exists, revoked or expired, and remove the
+// expired session.
+func loadUserSession(ctx context.Context, id string) (*UserSession, error) {
+	b, err := rdb.HGet(ctx, SRS_USER_SESSIONS, id).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_USER_SESSIONS, id)
+	}
+	if b == "" {
+		return nil, nil
+	}
+
+	var session UserSession
+	if err := json.Unmarshal([]byte(b), &session); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+
+	if session.Expired(time.Now()) {
+		if err := rdb.HDel(ctx, SRS_USER_SESSIONS, id).Err(); err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hdel %v %v", SRS_USER_SESSIONS, id)
+		}
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// loadUserSessions load the active sessions of user, or all users if userID is empty, and remove the expired
+// sessions. The sessions are sorted by create time.
+func loadUserSessions(ctx context.Context, userID string) ([]*UserSession, error) {
+	values, err := rdb.HGetAll(ctx, SRS_USER_SESSIONS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_USER_SESSIONS)
+	}
+
+	now := time.Now()
+	sessions := []*UserSession{}
+	for id, value := range values {
+		var session UserSession
+		if err := json.Unmarshal([]byte(value), &session); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, value)
+		}
+
+		if session.Expired(now) {
+			if err := rdb.HDel(ctx, SRS_USER_SESSIONS, id).Err(); err != nil && err != redis.Nil {
+				return nil, errors.Wrapf(err, "hdel %v %v", SRS_USER_SESSIONS, id)
+			}
+			continue
+		}
+
+		if userID == "" || session.UserID == userID {
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreateAt < sessions[j].CreateAt
+	})
+	return sessions, nil
+}
+
+// revokeUserSessions revoke all sessions of user, for example, when password reset or user removed.
+func revokeUserSessions(ctx context.Context, userID string) (int, error) {
+	sessions, err := loadUserSessions(ctx, userID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "load sessions of %v", userID)
+	}
+
+	for _, session := range sessions {
+		if err := rdb.HDel(ctx, SRS_USER_SESSIONS, session.ID).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "hdel %v %v", SRS_USER_SESSIONS, session.ID)
+		}
+	}
+	return len(sessions), nil
+}
+
+func handleMgmtUsers(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/users/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, name, password, role string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Name     *string `json:"name"`
+				Password *string `json:"password"`
+				Role     *string `json:"role"`
+			}{
+				Token: &token, Name: &name, Password: &password, Role: &role,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			role, err := parseUserRole(role)
+			if err != nil {
+				return errors.Wrapf(err, "parse role")
+			}
+
+			user, err := createUser(ctx, name, password, role)
+			if err != nil {
+				return errors.Wrapf(err, "create user %v", name)
+			}
+
+			ohttp.WriteData(ctx, w, r, user.Public())
+			logger.Tf(ctx, "users create ok, %v, token=%vB", user.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			users, err := loadUsers(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load users")
+			}
+
+			publicUsers := []*User{}
+			for _, user := range users {
+				publicUsers = append(publicUsers, user.Public())
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Users []*User `json:"users"`
+			}{
+				Users: publicUsers,
+			})
+			logger.Tf(ctx, "users query ok, users=%v, token=%vB", len(users), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id, role, password string
+			var disableTOTP bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token       *string `json:"token"`
+				ID          *string `json:"id"`
+				Role        *string `json:"role"`
+				Password    *string `json:"password"`
+				DisableTOTP *bool   `json:"disableTotp"`
+			}{
+				Token: &token, ID: &id, Role: &role, Password: &password, DisableTOTP: &disableTOTP,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			user, err := loadUser(ctx, id)
+			if err != nil {
+				return errors.Wrapf(err, "load user %v", id)
+			}
+			if user == nil {
+				return errors.Errorf("no user %v", id)
+			}
+
+			if role != "" {
+				if role, err = parseUserRole(role); err != nil {
+					return errors.Wrapf(err, "parse role")
+				}
+				if role != RoleAdmin {
+					if last, err := isLastAdmin(ctx, user); err != nil {
+						return errors.Wrapf(err, "check admin %v", user.String())
+					} else if last {
+						return errors.Errorf("user %v is the last admin", user.Name)
+					}
+				}
+				user.Role = role
+			}
+
+			// Reset the password and TOTP by admin, for example, the user forgets the password or lost the
+			// authenticator, and revoke the sessions of user.
+			var revoked int
+			if password != "" || disableTOTP {
+				if password != "" {
+					if user.Password, err = hashUserPassword(password); err != nil {
+						return errors.Wrapf(err, "hash password")
+					}
+				}
+				if disableTOTP {
+					user.TOTPEnabled, user.TOTPSecret, user.TOTPStep = false, "", 0
+				}
+
+				if revoked, err = revokeUserSessions(ctx, user.ID); err != nil {
+					return errors.Wrapf(err, "revoke sessions of %v", user.String())
+				}
+			}
+
+			if err := saveUser(ctx, user); err != nil {
+				return errors.Wrapf(err, "save %v", user.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, user.Public())
+			logger.Tf(ctx, "users update ok, %v, password=%vB, disableTotp=%v, revoked=%v, token=%vB",
+				user.String(), len(password), disableTOTP, revoked, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			user, err := loadUser(ctx, id)
+			if err != nil {
+				return errors.Wrapf(err, "load user %v", id)
+			}
+			if user == nil {
+				return errors.Errorf("no user %v", id)
+			}
+
+			if last, err := isLastAdmin(ctx, user); err != nil {
+				return errors.Wrapf(err, "check admin %v", user.String())
+			} else if last {
+				return errors.Errorf("user %v is the last admin", user.Name)
+			}
+
+			if err := rdb.HDel(ctx, SRS_USERS, user.ID).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_USERS, user.ID)
+			}
+
+			revoked, err := revokeUserSessions(ctx, user.ID)
+			if err != nil {
+				return errors.Wrapf(err, "revoke sessions of %v", user.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "users remove ok, %v, revoked=%v, token=%vB", user.String(), revoked, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/totp/setup"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			user, _, err := parseUserToken(ctx, apiSecret, token, r.Header)
+			if err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if user.TOTPEnabled {
+				return errors.Errorf("user %v totp already enabled", user.Name)
+			}
+
+			// The secret is pending, until the user enables it by a valid code.
+			if user.TOTPSecret, err = createTOTPSecret(); err != nil {
+				return errors.Wrapf(err, "create totp secret")
+			}
+			if err := saveUser(ctx, user); err != nil {
+				return errors.Wrapf(err, "save %v", user.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Secret string `json:"secret"`
+				URL    string `json:"url"`
+			}{
+				Secret: user.TOTPSecret, URL: totpURL(user.Name, user.TOTPSecret),
+			})
+			logger.Tf(ctx, "users totp setup ok, %v, token=%vB", user.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	for _, enable := range []bool{true, false} {
+		ep = "/terraform/v1/mgmt/users/totp/disable"
+		if enable {
+			ep = "/terraform/v1/mgmt/users/totp/enable"
+		}
+
+		enable := enable
+		logger.Tf(ctx, "Handle %v", ep)
+		handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+			if err := func() error {
+				var token, code string
+				if err := ParseBody(ctx, r.Body, &struct {
+					Token *string `json:"token"`
+					Code  *string `json:"code"`
+				}{
+					Token: &token, Code: &code,
+				}); err != nil {
+					return errors.Wrapf(err, "parse body")
+				}
+
+				apiSecret := envApiSecret()
+				user, _, err := parseUserToken(ctx, apiSecret, token, r.Header)
+				if err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+
+				if user.TOTPSecret == "" {
+					return errors.Errorf("user %v totp not setup", user.Name)
+				}
+				if user.TOTPEnabled == enable {
+					return errors.Errorf("user %v totp already enabled=%v", user.Name, enable)
+				}
+
+				step, err := verifyTOTPCode(user.TOTPSecret, code, user.TOTPStep, time.Now())
+				if err != nil {
+					return errors.Wrapf(err, "verify totp")
+				}
+
+				user.TOTPEnabled, user.TOTPStep = enable, step
+				if !enable {
+					user.TOTPSecret, user.TOTPStep = "", 0
+				}
+				if err := saveUser(ctx, user); err != nil {
+					return errors.Wrapf(err, "save %v", user.String())
+				}
+
+				ohttp.WriteData(ctx, w, r, user.Public())
+				logger.Tf(ctx, "users totp update ok, %v, token=%vB", user.String(), len(token))
+				return nil
+			}(); err != nil {
+				ohttp.WriteError(ctx, w, r, err)
+			}
+		})
+	}
+
+	ep = "/terraform/v1/mgmt/sessions/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, userID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				UserID *string `json:"userId"`
+			}{
+				Token: &token, UserID: &userID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			// The admin queries the sessions of all users, while other users only query their own sessions.
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				user, _, err := parseUserToken(ctx, apiSecret, token, r.Header)
+				if err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+				userID = user.ID
+			}
+
+			sessions, err := loadUserSessions(ctx, userID)
+			if err != nil {
+				return errors.Wrapf(err, "load sessions")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Sessions []*UserSession `json:"sessions"`
+			}{
+				Sessions: sessions,
+			})
+			logger.Tf(ctx, "sessions query ok, user=%v, sessions=%v, token=%vB", userID, len(sessions), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/sessions/revoke"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			// The admin revokes any session, while other users only revoke their own sessions, for example,
+			// to logout. The current session is revoked if no id.
+			apiSecret := envApiSecret()
+			var userID string
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil || id == "" {
+				user, sessionID, err := parseUserToken(ctx, apiSecret, token, r.Header)
+				if err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+				userID, id = user.ID, ChooseNotEmpty(id, sessionID)
+			}
+
+			session, err := loadUserSession(ctx, id)
+			if err != nil {
+				return errors.Wrapf(err, "load session %v", id)
+			}
+			if session == nil || (userID != "" && session.UserID != userID) {
+				return errors.Errorf("no session %v", id)
+			}
+
+			if err := rdb.HDel(ctx, SRS_USER_SESSIONS, session.ID).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_USER_SESSIONS, session.ID)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "sessions revoke ok, %v, token=%vB", session.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
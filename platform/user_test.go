@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserPassword(t *testing.T) {
+	hashed, err := hashUserPassword("secret")
+	if err != nil {
+		t.Fatalf("hash err %+v", err)
+	}
+	if !strings.HasPrefix(hashed, "$2a$") || strings.Contains(hashed, "secret") {
+		t.Errorf("unexpected hash %v", hashed)
+	}
+
+	if !verifyUserPassword(hashed, "secret") {
+		t.Errorf("expect password match")
+	}
+	if verifyUserPassword(hashed, "Secret") {
+		t.Errorf("expect password not match")
+	}
+	if verifyUserPassword("secret", "secret") {
+		t.Errorf("expect plaintext not match")
+	}
+
+	// Reject the password which bcrypt truncates.
+	if _, err := hashUserPassword(strings.Repeat("x", userPasswordMaxBytes+1)); err == nil {
+		t.Errorf("expect password too long")
+	}
+
+	// Same password with different salt.
+	if other, err := hashUserPassword("secret"); err != nil || other == hashed {
+		t.Errorf("expect different hash, err %v", err)
+	}
+}
+
+func TestTOTPCode(t *testing.T) {
+	// The secret is base32 of 12345678901234567890, see RFC6238 Appendix B.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		if got, err := totpCode(secret, unix/userTOTPPeriod); err != nil || got != want {
+			t.Errorf("time=%v got %v, want %v, err %v", unix, got, want, err)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	if step, err := verifyTOTPCode(secret, "081804", 0, now); err != nil || step != 1111111109/userTOTPPeriod {
+		t.Errorf("expect code match, step=%v, err %v", step, err)
+	}
+	// Allow one step of clock drift.
+	if _, err := verifyTOTPCode(secret, "081804", 0, now.Add(userTOTPPeriod*time.Second)); err != nil {
+		t.Errorf("expect code match with drift, err %v", err)
+	}
+	if _, err := verifyTOTPCode(secret, "081804", 0, now.Add(3*userTOTPPeriod*time.Second)); err == nil {
+		t.Errorf("expect code expired")
+	}
+	// Reject the replayed code.
+	if _, err := verifyTOTPCode(secret, "081804", 1111111109/userTOTPPeriod, now); err == nil {
+		t.Errorf("expect code replayed")
+	}
+	if _, err := verifyTOTPCode(secret, "000000", 0, now); err == nil {
+		t.Errorf("expect code not match")
+	}
+
+	if url := totpURL("admin", secret); !strings.HasPrefix(url, "otpauth://totp/Oryx:admin?") ||
+		!strings.Contains(url, "secret="+secret) {
+		t.Errorf("unexpected url %v", url)
+	}
+}
+
+func TestUserRole(t *testing.T) {
+	for role, want := range map[string]string{"": RoleViewer, " Admin ": RoleAdmin, "operator": RoleOperator} {
+		if got, err := parseUserRole(role); err != nil || got != want {
+			t.Errorf("role %v got %v, want %v, err %v", role, got, want, err)
+		}
+	}
+	if _, err := parseUserRole("root"); err == nil {
+		t.Errorf("expect invalid role")
+	}
+
+	for _, tc := range []struct {
+		role, scope string
+		want        bool
+	}{
+		{RoleAdmin, ScopeAdmin, true},
+		{RoleOperator, ScopeStreamsWrite, true},
+		{RoleOperator, ScopeRecordRead, true},
+		{RoleOperator, ScopeAdmin, false},
+		{RoleViewer, ScopeStreamsRead, true},
+		{RoleViewer, ScopeStreamsWrite, false},
+		{RoleViewer, ScopeRoomsManage, false},
+	} {
+		if got := scopeAllowed(userRoleScopes[tc.role], tc.scope); got != tc.want {
+			t.Errorf("role %v scope %v got %v, want %v", tc.role, tc.scope, got, tc.want)
+		}
+	}
+}
+
+func TestUserSessionToken(t *testing.T) {
+	apiSecret := "test-secret"
+	now := time.Now()
+	user := &User{ID: "u-1", Name: "alice", Role: RoleOperator}
+	session := &UserSession{
+		ID: "s-1", UserID: user.ID, CreateAt: now.Format(time.RFC3339),
+		ExpireAt: now.Add(userSessionExpire).Format(time.RFC3339),
+	}
+
+	token, err := createUserSessionToken(apiSecret, user, session)
+	if err != nil {
+		t.Fatalf("create token err %+v", err)
+	}
+
+	claims, err := parseAPIKeyToken(apiSecret, token)
+	if err != nil {
+		t.Fatalf("parse token err %+v", err)
+	}
+	if claims.Subject != "u-1" || claims.ID != "s-1" || claims.Role != RoleOperator {
+		t.Errorf("unexpected claims sub=%v, jti=%v, role=%v", claims.Subject, claims.ID, claims.Role)
+	}
+
+	if session.Expired(now) || !session.Expired(now.Add(userSessionExpire+time.Second)) {
+		t.Errorf("unexpected session expired")
+	}
+
+	if b := user.Public(); b.Password != "" || b.TOTPSecret != "" {
+		t.Errorf("expect no secrets in public user")
+	}
+}
+
+func TestNewUser(t *testing.T) {
+	user, err := newUser(" alice ", "secret", RoleOperator)
+	if err != nil {
+		t.Fatalf("new user err %+v", err)
+	}
+	if user.Name != "alice" || user.Role != RoleOperator || user.ID == "" || !verifyUserPassword(user.Password, "secret") {
+		t.Errorf("unexpected user %v", user.String())
+	}
+
+	if _, err := newUser(" ", "secret", RoleAdmin); err == nil {
+		t.Errorf("expect no name")
+	}
+	if _, err := newUser("alice", "", RoleAdmin); err == nil {
+		t.Errorf("expect no password")
+	}
+}
@@ -266,10 +266,14 @@ const (
 	SRS_RECORD_PATTERNS      = "SRS_RECORD_PATTERNS"
 	SRS_RECORD_M3U8_WORKING  = "SRS_RECORD_M3U8_WORKING"
 	SRS_RECORD_M3U8_ARTIFACT = "SRS_RECORD_M3U8_ARTIFACT"
+	SRS_RECORD_RETENTION     = "SRS_RECORD_RETENTION"
+	SRS_RECORD_CONTROL       = "SRS_RECORD_CONTROL"
+	SRS_RECORD_SCHEDULES     = "SRS_RECORD_SCHEDULES"
 	// For cloud storage.
 	SRS_DVR_PATTERNS      = "SRS_DVR_PATTERNS"
 	SRS_DVR_M3U8_WORKING  = "SRS_DVR_M3U8_WORKING"
 	SRS_DVR_M3U8_ARTIFACT = "SRS_DVR_M3U8_ARTIFACT"
+	SRS_DVR_S3            = "SRS_DVR_S3"
 	// For cloud VoD.
 	SRS_VOD_PATTERNS      = "SRS_VOD_PATTERNS"
 	SRS_VOD_M3U8_WORKING  = "SRS_VOD_M3U8_WORKING"
@@ -289,8 +293,10 @@ const (
 	SRS_TRANSCODE_CONFIG = "SRS_TRANSCODE_CONFIG"
 	SRS_TRANSCODE_TASK   = "SRS_TRANSCODE_TASK"
 	// For transcription.
-	SRS_TRANSCRIPT_CONFIG = "SRS_TRANSCRIPT_CONFIG"
-	SRS_TRANSCRIPT_TASK   = "SRS_TRANSCRIPT_TASK"
+	SRS_TRANSCRIPT_CONFIG  = "SRS_TRANSCRIPT_CONFIG"
+	SRS_TRANSCRIPT_TASK    = "SRS_TRANSCRIPT_TASK"
+	SRS_TRANSCRIPT_SESSION = "SRS_TRANSCRIPT_SESSION"
+	SRS_TRANSCRIPT_CUES    = "SRS_TRANSCRIPT_CUES"
 	// For OCR.
 	SRS_OCR_CONFIG = "SRS_OCR_CONFIG"
 	SRS_OCR_TASK   = "SRS_OCR_TASK"
@@ -298,6 +304,9 @@ const (
 	SRS_STREAM_ACTIVE     = "SRS_STREAM_ACTIVE"
 	SRS_STREAM_SRT_ACTIVE = "SRS_STREAM_SRT_ACTIVE"
 	SRS_STREAM_RTC_ACTIVE = "SRS_STREAM_RTC_ACTIVE"
+	// For stream keys, and the publishers verified by key.
+	SRS_STREAM_KEYS           = "SRS_STREAM_KEYS"
+	SRS_STREAM_KEY_PUBLISHERS = "SRS_STREAM_KEY_PUBLISHERS"
 	// For feature statistics.
 	SRS_STAT_COUNTER = "SRS_STAT_COUNTER"
 	// For container and images.
@@ -307,9 +316,21 @@ const (
 	// For dubbing service.
 	SRS_DUBBING_PROJECTS = "SRS_DUBBING_PROJECTS"
 	SRS_DUBBING_TASKS    = "SRS_DUBBING_TASKS"
+	// For callback delivery, the queue to retry and the history of deliveries.
+	SRS_HOOKS_QUEUE    = "SRS_HOOKS_QUEUE"
+	SRS_HOOKS_DELIVERY = "SRS_HOOKS_DELIVERY"
+	SRS_HOOKS_HISTORY  = "SRS_HOOKS_HISTORY"
+	// The boot epoch of platform events, increased when platform restarts.
+	SRS_EVENTS_EPOCH = "SRS_EVENTS_EPOCH"
 	// About authentication.
 	SRS_AUTH_SECRET    = "SRS_AUTH_SECRET"
 	SRS_SECRET_PUBLISH = "SRS_SECRET_PUBLISH"
+	// For scoped API keys, and the last used time of keys.
+	SRS_API_KEYS      = "SRS_API_KEYS"
+	SRS_API_KEYS_USED = "SRS_API_KEYS_USED"
+	// For user accounts, and the login sessions of users.
+	SRS_USERS         = "SRS_USERS"
+	SRS_USER_SESSIONS = "SRS_USER_SESSIONS"
 	// For system settings.
 	SRS_LOCALE          = "SRS_LOCALE"
 	SRS_FIRST_BOOT      = "SRS_FIRST_BOOT"
@@ -320,6 +341,8 @@ const (
 	SRS_BEIAN           = "SRS_BEIAN"
 	SRS_HTTPS           = "SRS_HTTPS"
 	SRS_HTTPS_DOMAIN    = "SRS_HTTPS_DOMAIN"
+	SRS_HTTPS_ACME      = "SRS_HTTPS_ACME"
+	SRS_HTTPS_CERTS     = "SRS_HTTPS_CERTS"
 	SRS_HOOKS           = "SRS_HOOKS"
 	SRS_SYS_LIMITS      = "SRS_SYS_LIMITS"
 	SRS_SYS_OPENAI      = "SRS_SYS_OPENAI"
@@ -1084,15 +1107,29 @@ func buildLiveM3u8ForLocal(
 	return
 }
 
+// HlsSubtitleRendition is a subtitle rendition of variant m3u8, the first one is the default.
+type HlsSubtitleRendition struct {
+	// The language of subtitle, such as en.
+	Language string
+	// The uri of subtitle m3u8.
+	URI string
+}
+
 // buildLiveM3u8ForVariantCC go generate variant m3u8 with CC(Closed Caption).
 func buildLiveM3u8ForVariantCC(
-	ctx context.Context, bitrate int64, lang, stream, subtitles string,
+	ctx context.Context, bitrate int64, stream string, renditions []*HlsSubtitleRendition,
 ) (contentType, m3u8Body string, err error) {
 	var sb strings.Builder
 	sb.WriteString("#EXTM3U\n")
-	fmt.Fprintf(&sb, `#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Subtitle-%v",LANGUAGE="%v",DEFAULT=YES,AUTOSELECT=YES,FORCED=NO,URI="%v"`,
-		strings.ToUpper(lang), lang, subtitles)
-	sb.WriteString("\n")
+	for index, r := range renditions {
+		isDefault := "NO"
+		if index == 0 {
+			isDefault = "YES"
+		}
+		fmt.Fprintf(&sb, `#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Subtitle-%v",LANGUAGE="%v",DEFAULT=%v,AUTOSELECT=YES,FORCED=NO,URI="%v"`,
+			strings.ToUpper(r.Language), r.Language, isDefault, r.URI)
+		sb.WriteString("\n")
+	}
 	fmt.Fprintf(&sb, `#EXT-X-STREAM-INF:BANDWIDTH=%v,SUBTITLES="subs"`, bitrate)
 	sb.WriteString("\n")
 	sb.WriteString(stream)
@@ -1171,10 +1208,18 @@ type M3u8VoDArtifact struct {
 	Files []*TsFile `json:"files"`
 
 	// For DVR only.
+	// The storage of DVR, cos or s3, empty for cos.
+	Storage string `json:"storage,omitempty"`
 	// The COS bucket name.
 	Bucket string `json:"bucket"`
 	// The COS bucket region.
 	Region string `json:"region"`
+	// The S3 endpoint, empty for AWS S3.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Whether S3 uses path style.
+	PathStyle bool `json:"pathStyle,omitempty"`
+	// The prefix of S3 object key.
+	Prefix string `json:"prefix,omitempty"`
 
 	// For VoD only.
 	// The file ID generated by VoD commit.
@@ -1198,6 +1243,12 @@ func (v *M3u8VoDArtifact) String() string {
 	if v.Region != "" {
 		sb.WriteString(fmt.Sprintf(", region=%v", v.Region))
 	}
+	if v.Endpoint != "" {
+		sb.WriteString(fmt.Sprintf(", endpoint=%v", v.Endpoint))
+	}
+	if v.Prefix != "" {
+		sb.WriteString(fmt.Sprintf(", prefix=%v", v.Prefix))
+	}
 	if v.FileID != "" {
 		sb.WriteString(fmt.Sprintf(", fileId=%v", v.FileID))
 	}
@@ -1343,10 +1394,17 @@ func ParseBody(ctx context.Context, r io.ReadCloser, v interface{}) error {
 	return nil
 }
 
-// Authenticate check by Bearer or token.
+// Authenticate check by Bearer or token, which requires the admin scope.
 // If use bearer secret, there is the header Authorization: Bearer {apiSecret}.
 // If use token, there is a JWT token which is signed by apiSecret.
 func Authenticate(ctx context.Context, apiSecret, token string, header http.Header) error {
+	return AuthenticateWithScope(ctx, apiSecret, token, header, ScopeAdmin)
+}
+
+// AuthenticateWithScope check by Bearer or token, and the required scope such as streams:read.
+// If use bearer secret, it's allowed for all scopes.
+// If use bearer or token of JWT, the scope claim must allow the required scope, see APIKey.
+func AuthenticateWithScope(ctx context.Context, apiSecret, token string, header http.Header, scope string) error {
 	// Check system api secret.
 	if apiSecret == "" {
 		return errors.New("no api secret")
@@ -1374,27 +1432,40 @@ func Authenticate(ctx context.Context, apiSecret, token string, header http.Head
 			return errors.Wrapf(err, "parse bearer token")
 		}
 
-		if subtle.ConstantTimeCompare([]byte(authSecret), []byte(apiSecret)) != 1 {
-			return errors.New("invalid bearer token")
+		if subtle.ConstantTimeCompare([]byte(authSecret), []byte(apiSecret)) == 1 {
+			return nil
+		}
+
+		// The bearer might be an API key, which is a JWT signed by apiSecret.
+		if err := verifyAPIKeyToken(ctx, apiSecret, authSecret, scope); err != nil {
+			return errors.Wrapf(err, "invalid bearer token")
 		}
 		return nil
 	}
 
 	// Verify token first, @see https://www.npmjs.com/package/jsonwebtoken#errors--codes
-	// See https://pkg.go.dev/github.com/golang-jwt/jwt/v4#example-Parse-Hmac
-	if _, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(apiSecret), nil
-	}); err != nil {
-		return errors.Wrapf(err, "verify token %v", token)
+	if err := verifyAPIKeyToken(ctx, apiSecret, token, scope); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// httpStatusError is an error with HTTP status, which is used by ohttp.WriteError as the status of response,
+// see ohttp.HTTPStatus. Note that it must be the outermost error, so never wrap it.
+type httpStatusError struct {
+	error
+	status int
+}
+
+func newHTTPStatusError(status int, err error) error {
+	return &httpStatusError{error: err, status: status}
+}
+
+func (v *httpStatusError) Status() int {
+	return v.status
+}
+
 // ChooseNotEmpty choose the first not empty string.
 func ChooseNotEmpty(strings ...string) string {
 	for _, str := range strings {
@@ -1810,6 +1881,10 @@ type FFmpegHeartbeat struct {
 	// To cancel the FFmpeg.
 	cancelFFmpeg context.CancelFunc
 
+	// The task type and ID for metrics, such as forward and the platform, ignore if empty. Note that the ID
+	// should be stable across restarts of task, never use the task UUID, to bound the series of metrics.
+	MetricsTask, MetricsID string
+
 	// Exit when published for a duration.
 	MaxStreamDuration time.Duration
 	// The abnormal slow speed, such as 0.5x.
@@ -1854,6 +1929,14 @@ func (v *FFmpegHeartbeat) Polling(ctx context.Context, stderr io.Reader) {
 	logger.Tf(ctx, "FFmpeg: Start to polling heartbeat, start=%v, msd=%v, afs=%v",
 		v.starttime, v.MaxStreamDuration, v.AbnormalFastSpeed)
 
+	// Update the metrics of FFmpeg, when start and quit.
+	if v.MetricsTask != "" {
+		platformMetrics.OnFFmpegStart(v.MetricsTask, v.MetricsID)
+		platformEvents.Publish(ctx, EventTaskState, "", map[string]string{
+			"task": v.MetricsTask, "id": v.MetricsID, "state": "running",
+		})
+	}
+
 	// Print the extra logs when quit.
 	go func() {
 		<-pollingReadyCtx.Done()
@@ -1862,6 +1945,13 @@ func (v *FFmpegHeartbeat) Polling(ctx context.Context, stderr io.Reader) {
 		case <-ctx.Done():
 		case <-v.PollingCtx.Done():
 		}
+
+		if v.MetricsTask != "" {
+			platformMetrics.OnFFmpegStop(v.MetricsTask, v.MetricsID)
+			platformEvents.Publish(ctx, EventTaskState, "", map[string]interface{}{
+				"task": v.MetricsTask, "id": v.MetricsID, "state": "stopped", "normally": v.exitingNormally,
+			})
+		}
 		logger.Tf(ctx, "FFmpeg: Quit exit-normally=%v, parsed=%v, failed=%v,<%v>, speed=%v,%v,%v,<%v>, not-change=%v,<%v>, extra logs is %v",
 			v.exitingNormally, v.parsedCount, v.failedParsedCount, v.lastFailedParsed, v.failedSpeedCount,
 			v.veryFastSpeedCount, v.verySlowSpeedCount, v.lastFailedSpeed, v.notChangedCount, v.lastNotChanged,
@@ -1968,6 +2058,9 @@ func (v *FFmpegHeartbeat) Polling(ctx context.Context, stderr io.Reader) {
 
 		v.update, v.parsedCount = time.Now(), v.parsedCount+1
 		v.line, v.timestamp, v.speed = line, timestamp, speed
+		if v.MetricsTask != "" {
+			platformMetrics.OnFFmpegSpeed(v.MetricsTask, v.MetricsID, speed)
+		}
 		if !firstNormalFrame {
 			firstNormalFrame, v.firstReadyTime = true, time.Now()
 			firstReadyCancel()
@@ -0,0 +1,373 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// VLivePlaylistMode is the mode to play the items of playlist.
+type VLivePlaylistMode string
+
+const VLivePlaylistModeOrder VLivePlaylistMode = "order"
+const VLivePlaylistModeShuffle VLivePlaylistMode = "shuffle"
+
+// The grace duration for a scheduled slot to start, if missed, wait for the next day.
+const vLiveSlotGrace = 60 * time.Second
+
+// The resolution to transcode the items of playout, if the files have different codec parameters.
+const vLivePlayoutWidth = 1280
+const vLivePlayoutHeight = 720
+
+// VLivePlaylistItem is an item of playlist, which refers to a file of vLive.
+type VLivePlaylistItem struct {
+	// The UUID of file in vLive files.
+	File string `json:"file"`
+	// The start point in seconds to trim, 0 to start from the beginning.
+	Start float64 `json:"start,omitempty"`
+	// The end point in seconds to trim, 0 to play to the end of file.
+	End float64 `json:"end,omitempty"`
+}
+
+func (v *VLivePlaylistItem) String() string {
+	return fmt.Sprintf("file=%v, start=%v, end=%v", v.File, v.Start, v.End)
+}
+
+// VLiveScheduleSlot is a wall-clock scheduled item, for example, play a file at 20:00 daily.
+type VLiveScheduleSlot struct {
+	VLivePlaylistItem
+	// The local time of day to play, for example, 20:00.
+	At string `json:"at"`
+	// The days of week to play, 0 is Sunday, empty for daily.
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+}
+
+func (v *VLiveScheduleSlot) String() string {
+	return fmt.Sprintf("%v, at=%v, weekdays=%v", v.VLivePlaylistItem.String(), v.At, v.Weekdays)
+}
+
+// Occurrence returns the time of slot on the day of t, and whether the slot is scheduled at that day.
+func (v *VLiveScheduleSlot) Occurrence(t time.Time) (time.Time, bool) {
+	at, err := time.Parse("15:04", v.At)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	occur := time.Date(t.Year(), t.Month(), t.Day(), at.Hour(), at.Minute(), 0, 0, t.Location())
+	if len(v.Weekdays) == 0 {
+		return occur, true
+	}
+	for _, weekday := range v.Weekdays {
+		if weekday == occur.Weekday() {
+			return occur, true
+		}
+	}
+	return occur, false
+}
+
+// VLivePlaylist is the playout schedule of vLive.
+type VLivePlaylist struct {
+	// The play mode, order or shuffle.
+	Mode VLivePlaylistMode `json:"mode"`
+	// Whether loop the whole playlist.
+	Loop bool `json:"loop"`
+	// The items of playlist, empty to play all files.
+	Items []*VLivePlaylistItem `json:"items,omitempty"`
+	// The scheduled slots by wall-clock.
+	Slots []*VLiveScheduleSlot `json:"slots,omitempty"`
+}
+
+func (v *VLivePlaylist) String() string {
+	return fmt.Sprintf("mode=%v, loop=%v, items=%v, slots=%v", v.Mode, v.Loop, len(v.Items), len(v.Slots))
+}
+
+// Validate the playlist with the files of vLive.
+func (v *VLivePlaylist) Validate(files []*FFprobeSource) error {
+	if v.Mode != "" && v.Mode != VLivePlaylistModeOrder && v.Mode != VLivePlaylistModeShuffle {
+		return errors.Errorf("invalid mode %v", v.Mode)
+	}
+
+	validateItem := func(item *VLivePlaylistItem) error {
+		if vLiveFindFile(files, item.File) == nil {
+			return errors.Errorf("no file %v", item.File)
+		}
+		if item.Start < 0 || item.End < 0 {
+			return errors.Errorf("invalid trim start=%v, end=%v", item.Start, item.End)
+		}
+		if item.End > 0 && item.End <= item.Start {
+			return errors.Errorf("invalid trim start=%v, end=%v", item.Start, item.End)
+		}
+		return nil
+	}
+
+	for _, item := range v.Items {
+		if item == nil {
+			return errors.New("empty item")
+		}
+		if err := validateItem(item); err != nil {
+			return errors.Wrapf(err, "item %v", item.String())
+		}
+	}
+
+	for _, slot := range v.Slots {
+		if slot == nil {
+			return errors.New("empty slot")
+		}
+		if _, err := time.Parse("15:04", slot.At); err != nil {
+			return errors.Wrapf(err, "parse at %v", slot.At)
+		}
+		for _, weekday := range slot.Weekdays {
+			if weekday < time.Sunday || weekday > time.Saturday {
+				return errors.Errorf("invalid weekday %v", weekday)
+			}
+		}
+		if err := validateItem(&slot.VLivePlaylistItem); err != nil {
+			return errors.Wrapf(err, "slot %v", slot.String())
+		}
+	}
+
+	return nil
+}
+
+func vLiveFindFile(files []*FFprobeSource, uuid string) *FFprobeSource {
+	for _, file := range files {
+		if file.UUID == uuid {
+			return file
+		}
+	}
+	return nil
+}
+
+// VLivePlayoutItem is an item to play by the playout scheduler.
+type VLivePlayoutItem struct {
+	// The file to play.
+	File *FFprobeSource `json:"-"`
+	// The UUID of file.
+	UUID string `json:"uuid"`
+	// The name of file.
+	Name string `json:"name"`
+	// The start point in seconds.
+	Start float64 `json:"start"`
+	// The duration in seconds to play, 0 to play to the end of file.
+	Duration float64 `json:"duration"`
+	// Whether it's a scheduled slot.
+	Slot bool `json:"slot"`
+	// The wall-clock time to start playing.
+	StartedAt string `json:"started_at,omitempty"`
+}
+
+func (v *VLivePlayoutItem) String() string {
+	return fmt.Sprintf("uuid=%v, name=%v, start=%v, duration=%v, slot=%v",
+		v.UUID, v.Name, v.Start, v.Duration, v.Slot)
+}
+
+// VLivePlayout is the scheduler to select the items to play, from the playlist and slots.
+type VLivePlayout struct {
+	// The playlist to schedule.
+	playlist *VLivePlaylist
+	// The files of vLive.
+	files []*FFprobeSource
+	// The items to play, all files if no items in playlist.
+	items []*VLivePlaylistItem
+	// The order of items to play, shuffled for shuffle mode.
+	order []int
+	// The position in order of next item.
+	cursor int
+	// Whether finished the playlist, for no loop mode.
+	finished bool
+	// The last fired occurrence of each slot.
+	fired map[int]time.Time
+	// The random source for shuffle.
+	random *rand.Rand
+}
+
+// NewVLivePlayout create a playout scheduler for playlist. If playlist is nil, loop all files in order.
+func NewVLivePlayout(playlist *VLivePlaylist, files []*FFprobeSource, opts ...func(*VLivePlayout)) *VLivePlayout {
+	if playlist == nil {
+		playlist = &VLivePlaylist{Mode: VLivePlaylistModeOrder, Loop: true}
+	}
+
+	v := &VLivePlayout{
+		playlist: playlist, files: files, fired: make(map[int]time.Time),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	v.items = playlist.Items
+	if len(v.items) == 0 {
+		for _, file := range files {
+			v.items = append(v.items, &VLivePlaylistItem{File: file.UUID})
+		}
+	}
+
+	v.reorder()
+	return v
+}
+
+// reorder the items, shuffle for shuffle mode.
+func (v *VLivePlayout) reorder() {
+	v.order, v.cursor = make([]int, len(v.items)), 0
+	for i := range v.order {
+		v.order[i] = i
+	}
+
+	if v.playlist.Mode == VLivePlaylistModeShuffle {
+		v.random.Shuffle(len(v.order), func(i, j int) {
+			v.order[i], v.order[j] = v.order[j], v.order[i]
+		})
+	}
+}
+
+// dueSlot returns the slot which should be played at now, and its occurrence.
+func (v *VLivePlayout) dueSlot(now time.Time) (int, time.Time) {
+	for i, slot := range v.playlist.Slots {
+		occur, ok := slot.Occurrence(now)
+		if !ok || now.Before(occur) || !now.Before(occur.Add(vLiveSlotGrace)) {
+			continue
+		}
+		if fired, ok := v.fired[i]; ok && fired.Equal(occur) {
+			continue
+		}
+		return i, occur
+	}
+	return -1, time.Time{}
+}
+
+// NextSlot returns the time of the next scheduled slot after now, or zero time if no slots.
+func (v *VLivePlayout) NextSlot(now time.Time) time.Time {
+	var next time.Time
+	for _, slot := range v.playlist.Slots {
+		// Search today and the next week, for the slots of some weekdays.
+		for day := 0; day <= 7; day++ {
+			occur, ok := slot.Occurrence(now.AddDate(0, 0, day))
+			if !ok || !occur.After(now) {
+				continue
+			}
+			if next.IsZero() || occur.Before(next) {
+				next = occur
+			}
+			break
+		}
+	}
+	return next
+}
+
+// build the item to play at now, limit the duration to the next slot.
+func (v *VLivePlayout) build(item *VLivePlaylistItem, slot bool, now time.Time) *VLivePlayoutItem {
+	file := vLiveFindFile(v.files, item.File)
+	if file == nil {
+		return nil
+	}
+
+	r := &VLivePlayoutItem{
+		File: file, UUID: file.UUID, Name: file.Name, Start: item.Start, Slot: slot,
+		StartedAt: now.Format(time.RFC3339),
+	}
+	if item.End > 0 {
+		r.Duration = item.End - item.Start
+	}
+
+	// Stop the item when the next slot starts.
+	if next := v.NextSlot(now); !next.IsZero() {
+		if left := next.Sub(now).Seconds(); r.Duration == 0 || left < r.Duration {
+			r.Duration = left
+		}
+	}
+	return r
+}
+
+// Next returns the item to play at now, or nil if nothing to play, for example, the playlist is finished
+// and wait for the next slot.
+func (v *VLivePlayout) Next(now time.Time) *VLivePlayoutItem {
+	if i, occur := v.dueSlot(now); i >= 0 {
+		v.fired[i] = occur
+		return v.build(&v.playlist.Slots[i].VLivePlaylistItem, true, now)
+	}
+
+	// Try each item at most once, to avoid endless loop if all files are removed.
+	for i := 0; i < len(v.items) && !v.finished; i++ {
+		item := v.items[v.order[v.cursor]]
+
+		// Reorder when the playlist is done, so that we're able to peek the next item.
+		if v.cursor++; v.cursor >= len(v.order) {
+			if v.finished = !v.playlist.Loop; !v.finished {
+				v.reorder()
+			}
+		}
+
+		if r := v.build(item, false, now); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// Peek returns the item to play after the current item, which ends at the end time.
+func (v *VLivePlayout) Peek(end time.Time) *VLivePlayoutItem {
+	if next := v.NextSlot(end.Add(-time.Second)); !next.IsZero() && !next.After(end) {
+		for _, slot := range v.playlist.Slots {
+			if occur, ok := slot.Occurrence(next); ok && occur.Equal(next) {
+				return v.build(&slot.VLivePlaylistItem, true, next)
+			}
+		}
+	}
+
+	if v.finished || len(v.order) == 0 {
+		return nil
+	}
+	return v.build(v.items[v.order[v.cursor]], false, end)
+}
+
+// Files returns the files to play by the playout, of the items and slots.
+func (v *VLivePlayout) Files() []*FFprobeSource {
+	var files []*FFprobeSource
+	appendFile := func(uuid string) {
+		if file := vLiveFindFile(v.files, uuid); file != nil && vLiveFindFile(files, uuid) == nil {
+			files = append(files, file)
+		}
+	}
+
+	for _, item := range v.items {
+		appendFile(item.File)
+	}
+	for _, slot := range v.playlist.Slots {
+		appendFile(slot.File)
+	}
+	return files
+}
+
+// vLiveSameCodecs returns whether the files share the same codec parameters by ffprobe, so that they are
+// able to be concatenated by copy. Note that the file without probe information is never the same.
+func vLiveSameCodecs(files []*FFprobeSource) bool {
+	for _, file := range files {
+		if file.Video == nil && file.Audio == nil {
+			return false
+		}
+	}
+
+	for i := 1; i < len(files); i++ {
+		a, b := files[0], files[i]
+		if (a.Video == nil) != (b.Video == nil) || (a.Audio == nil) != (b.Audio == nil) {
+			return false
+		}
+
+		if a.Video != nil && (a.Video.CodecName != b.Video.CodecName || a.Video.Profile != b.Video.Profile ||
+			a.Video.Width != b.Video.Width || a.Video.Height != b.Video.Height ||
+			a.Video.PixFormat != b.Video.PixFormat) {
+			return false
+		}
+
+		if a.Audio != nil && (a.Audio.CodecName != b.Audio.CodecName || a.Audio.Profile != b.Audio.Profile ||
+			a.Audio.SampleRate != b.Audio.SampleRate || a.Audio.Channels != b.Audio.Channels) {
+			return false
+		}
+	}
+	return true
+}
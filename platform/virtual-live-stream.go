@@ -58,16 +58,34 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		if err := func() error {
 			var token, action string
 			var userConf VLiveConfigure
-			if err := ParseBody(ctx, r.Body, &struct {
+			body := struct {
 				Token  *string `json:"token"`
 				Action *string `json:"action"`
 				*VLiveConfigure
+				// The raw playlist, to identify the null to clear it, from not specified to keep it.
+				Playlist json.RawMessage `json:"playlist"`
+				// The raw fallbacks, to identify the null or empty to clear it, from not specified to keep it.
+				Fallbacks json.RawMessage `json:"fallbacks"`
 			}{
 				Token: &token, Action: &action, VLiveConfigure: &userConf,
-			}); err != nil {
+			}
+			if err := ParseBody(ctx, r.Body, &body); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
 
+			if len(body.Playlist) > 0 {
+				userConf.playlistSpecified = true
+				if err := json.Unmarshal(body.Playlist, &userConf.Playlist); err != nil {
+					return errors.Wrapf(err, "unmarshal playlist %v", string(body.Playlist))
+				}
+			}
+			if len(body.Fallbacks) > 0 {
+				userConf.fallbacksSpecified = true
+				if err := json.Unmarshal(body.Fallbacks, &userConf.Fallbacks); err != nil {
+					return errors.Wrapf(err, "unmarshal fallbacks %v", string(body.Fallbacks))
+				}
+			}
+
 			apiSecret := envApiSecret()
 			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
 				return errors.Wrapf(err, "authenticate")
@@ -98,6 +116,14 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				if len(userConf.Files) == 0 {
 					return errors.New("no files")
 				}
+				if err := ValidateFallbacks(userConf.Fallbacks); err != nil {
+					return errors.Wrapf(err, "validate fallbacks")
+				}
+				if userConf.Playlist != nil {
+					if err := userConf.Playlist.Validate(userConf.Files); err != nil {
+						return errors.Wrapf(err, "validate playlist %v", userConf.Playlist.String())
+					}
+				}
 			}
 
 			if action == "update" {
@@ -182,8 +208,10 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 					var pid int32
 					var inputUUID, frame, update, starttime, ready string
+					var current, next *VLivePlayoutItem
 					if task := vLiveWorker.GetTask(config.Platform); task != nil {
 						pid, inputUUID, frame, update, starttime, ready = task.queryFrame()
+						current, next = task.queryPlayout()
 					}
 
 					elem := map[string]interface{}{
@@ -193,6 +221,9 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 						"label":    config.Label,
 						"files":    config.Files,
 					}
+					if config.Playlist != nil {
+						elem["playlist"] = config.Playlist
+					}
 
 					if pid > 0 {
 						elem["source"] = inputUUID
@@ -202,6 +233,10 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 							"log":    frame,
 							"update": update,
 						}
+						if current != nil {
+							elem["current"] = current
+							elem["next"] = next
+						}
 					}
 
 					res = append(res, elem)
@@ -1004,11 +1039,20 @@ type VLiveConfigure struct {
 
 	// The input files for vLive.
 	Files []*FFprobeSource `json:"files"`
+	// The fallback sources in order, used when the input is not available.
+	Fallbacks []*FFprobeSource `json:"fallbacks,omitempty"`
+	// The playlist to schedule the files, nil to loop the files in order.
+	Playlist *VLivePlaylist `json:"playlist,omitempty"`
+
+	// Whether the playlist is specified by request, null to clear it.
+	playlistSpecified bool
+	// Whether the fallbacks is specified by request, null or empty to clear it.
+	fallbacksSpecified bool
 }
 
 func (v VLiveConfigure) String() string {
-	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v",
-		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Files,
+	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, playlist=(%v), fallbacks=%v",
+		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Files, v.Playlist, len(v.Fallbacks),
 	)
 }
 
@@ -1020,6 +1064,17 @@ func (v *VLiveConfigure) Update(u *VLiveConfigure) error {
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
 	v.Files = append([]*FFprobeSource{}, u.Files...)
+	// Keep the fallbacks if not specified, for the legacy clients, or clear it if null or empty.
+	if u.fallbacksSpecified || u.Fallbacks != nil {
+		v.Fallbacks = nil
+		if len(u.Fallbacks) > 0 {
+			v.Fallbacks = append([]*FFprobeSource{}, u.Fallbacks...)
+		}
+	}
+	// Keep the playlist if not specified, for the legacy clients, or clear it if null.
+	if u.playlistSpecified || u.Playlist != nil {
+		v.Playlist = u.Playlist
+	}
 	return nil
 }
 
@@ -1050,6 +1105,15 @@ type VLiveTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// The failover of input.
+	failover FailoverInputs
+
+	// The playout scheduler, for playlist.
+	playout *VLivePlayout
+	// Whether transcode the items of playout, because the files have different codec parameters.
+	transcode bool
+	// The current and next item of playout.
+	current, next *VLivePlayoutItem
 
 	// The configure for vLive task.
 	config *VLiveConfigure
@@ -1100,6 +1164,9 @@ func (v *VLiveTask) Restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	// Use the primary input for new configure, before cancel the FFmpeg.
+	v.failover.Reset()
+
 	if v.cancel != nil {
 		v.cancel()
 	}
@@ -1107,10 +1174,18 @@ func (v *VLiveTask) Restart(ctx context.Context) error {
 	// Reload config from redis.
 	if b, err := rdb.HGet(ctx, SRS_VLIVE_CONFIG, v.Platform).Result(); err != nil {
 		return errors.Wrapf(err, "hget %v %v", SRS_VLIVE_CONFIG, v.Platform)
-	} else if err = json.Unmarshal([]byte(b), v.config); err != nil {
-		return errors.Wrapf(err, "unmarshal %v", b)
+	} else {
+		// Load into a new object, because the cleared fields are omitted.
+		var config VLiveConfigure
+		if err = json.Unmarshal([]byte(b), &config); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+		v.config = &config
 	}
 
+	// Reset the playout, to use the new playlist.
+	v.playout, v.current, v.next = nil, nil, nil
+
 	return nil
 }
 
@@ -1145,6 +1220,42 @@ func (v *VLiveTask) queryFrame() (int32, string, string, string, string, string)
 	return v.PID, v.inputUUID, v.frame, update, starttime, ready
 }
 
+func (v *VLiveTask) queryPlayout() (*VLivePlayoutItem, *VLivePlayoutItem) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.current, v.next
+}
+
+// nextPlayoutItem select the item to play, and peek the next item.
+func (v *VLiveTask) nextPlayoutItem(ctx context.Context) *VLivePlayoutItem {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.playout == nil {
+		v.playout = NewVLivePlayout(v.config.Playlist, v.config.Files)
+		v.transcode = !vLiveSameCodecs(v.playout.Files())
+		logger.Tf(ctx, "vLive: Create playout for platform=%v, playlist=(%v), transcode=%v",
+			v.Platform, v.config.Playlist, v.transcode)
+	}
+
+	now := time.Now()
+	v.current, v.next = v.playout.Next(now), nil
+	if v.current == nil {
+		return nil
+	}
+
+	// Guess the end of current item, to peek the next item.
+	duration := v.current.Duration
+	if duration == 0 && v.current.File.Format != nil {
+		if fd, err := strconv.ParseFloat(v.current.File.Format.Duration, 64); err == nil && fd > v.current.Start {
+			duration = fd - v.current.Start
+		}
+	}
+	v.next = v.playout.Peek(now.Add(time.Duration(duration * float64(time.Second))))
+
+	return v.current
+}
+
 func (v *VLiveTask) Initialize(ctx context.Context, w *VLiveWorker) error {
 	v.vLiveWorker = w
 	logger.Tf(ctx, "vLive: Initialize uuid=%v, platform=%v", v.UUID, v.Platform)
@@ -1173,26 +1284,84 @@ func (v *VLiveTask) Run(ctx context.Context) error {
 		return file
 	}
 
+	// Use playout for playlist or multiple files, or loop the only file.
+	usePlayout := func() bool {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		return v.config.Playlist != nil || len(v.config.Files) > 1
+	}
+
 	pfn := func(ctx context.Context) error {
 		// Ignore when not enabled.
 		if !v.config.Enabled {
 			return nil
 		}
 
-		// Use a active stream as input.
-		input := selectInputFile()
-		if input == nil {
+		// Use the fallback if the primary input failed, either the playout or the file.
+		fallbacks := v.config.Fallbacks
+		active, generation := v.failover.Active()
+
+		var err error
+		if active > 0 && active <= len(fallbacks) {
+			input := fallbacks[active-1]
+			logger.Tf(ctx, "vLive: Use fallback=%v as input for platform=%v", input.Target, v.Platform)
+			err = v.doVirtualLiveStream(ctx, input)
+		} else if usePlayout() {
+			// Start vLive task with playlist. Note that the playout returns without error when waiting
+			// for the next slot, which is not a failure.
+			if err = v.doPlayoutLiveStream(ctx); err == nil {
+				return nil
+			}
+		} else {
+			// Use a active stream as input.
+			input := selectInputFile()
+			if input == nil {
+				return nil
+			}
+
+			// Start vLive task.
+			err = v.doVirtualLiveStream(ctx, input)
+		}
+
+		// Switch to next input if failed, not caused by user or recovered. Note that the clean exit of FFmpeg,
+		// for example, the end of stream, is not a failure of input.
+		if err != nil && ctx.Err() == nil && v.failover.OnFailed(generation, len(fallbacks)+1) {
+			logger.Wf(ctx, "vLive: Switch input for platform=%v, failover %v, err %v", v.Platform, v.failover.String(), err)
 			return nil
 		}
 
-		// Start vLive task.
-		if err := v.doVirtualLiveStream(ctx, input); err != nil {
+		if err != nil {
 			return errors.Wrapf(err, "do vLive")
 		}
 
 		return nil
 	}
 
+	// Probe the primary input, switch back when it's recovered. Wait for the probe when the task quit.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v.failover.Probe(ctx, func(ctx context.Context) bool {
+			v.lock.Lock()
+			var primary *FFprobeSource
+			if len(v.config.Files) > 0 {
+				primary = v.config.Files[0]
+			}
+			v.lock.Unlock()
+
+			return primary != nil && probeFailoverSource(ctx, primary)
+		}, func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			if v.cancel != nil {
+				v.cancel()
+			}
+		})
+	}()
+
 	for ctx.Err() == nil {
 		if err := pfn(ctx); err != nil {
 			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
@@ -1217,20 +1386,17 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 	// Create context for current task.
 	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
-	v.cancel = cancel
 
-	// Build input URL.
-	host := "localhost"
+	v.lock.Lock()
+	v.cancel = cancel
+	v.lock.Unlock()
 
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	outputURL := v.buildOutputURL()
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
+	heartbeat.MetricsTask, heartbeat.MetricsID = FFmpegTaskVLive, v.Platform
 	v.starttime, v.firstReadyTime = &heartbeat.starttime, nil
 	defer func() {
 		v.starttime = nil
@@ -1346,3 +1512,223 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 
 	return err
 }
+
+// buildOutputURL build the output URL by server and secret.
+func (v *VLiveTask) buildOutputURL() string {
+	// Build input URL.
+	host := "localhost"
+
+	// Build output URL.
+	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
+	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
+		outputServer += "/"
+	}
+	return fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+}
+
+// doPlayoutLiveStream play the items of playlist one by one. The output FFmpeg keeps the connection to the
+// server, and reads the MPEG-TS from stdin, while each item is transmuxed by a FFmpeg and written to it, with
+// continuous timestamps, so that the downstream connection is not dropped when switching items.
+func (v *VLiveTask) doPlayoutLiveStream(ctx context.Context) error {
+	// Wait for the item to play, for example, the next slot when playlist is finished.
+	item := v.nextPlayoutItem(ctx)
+	if item == nil {
+		return nil
+	}
+
+	// Create context for current task.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	v.lock.Lock()
+	v.cancel = cancel
+	v.lock.Unlock()
+
+	// Build output URL.
+	outputURL := v.buildOutputURL()
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+	heartbeat.MetricsTask, heartbeat.MetricsID = FFmpegTaskVLive, v.Platform
+	v.starttime, v.firstReadyTime = &heartbeat.starttime, nil
+	defer func() {
+		v.starttime = nil
+	}()
+
+	// Start FFmpeg process, read MPEG-TS from stdin.
+	args := []string{"-f", "mpegts", "-i", "pipe:0", "-c", "copy"}
+	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
+	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
+		args = append(args, "-f", "flv")
+	} else if strings.HasPrefix(outputURL, "srt://") {
+		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	}
+	args = append(args, outputURL)
+	// Create the command object.
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe stdin")
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.PID = int32(cmd.Process.Pid)
+	v.Output = outputURL
+	defer func() {
+		// If we got a PID, sleep for a while, to avoid too fast restart.
+		if v.PID > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanup(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "vLive: Start playout, platform=%v, output=%v, pid=%v", v.Platform, outputURL, v.PID)
+
+	// Pull the latest log frame.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.firstReadyCtx.Done():
+			v.firstReadyTime = &heartbeat.firstReadyTime
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-heartbeat.FrameLogs:
+				v.updateFrame(frame)
+			}
+		}
+	}()
+
+	// Feed the items to FFmpeg, close the stdin when playlist is finished, then FFmpeg quit.
+	feedDone := make(chan struct{})
+	go func() {
+		defer close(feedDone)
+		defer stdin.Close()
+
+		// The offset in seconds of output timestamp, to make the timestamp continuous.
+		var offset float64
+		for item != nil && ctx.Err() == nil {
+			starttime := time.Now()
+			if err := v.doPlayoutItem(ctx, item, offset, stdin); err != nil && ctx.Err() == nil {
+				logger.Wf(ctx, "vLive: Ignore item %v, err %+v", item.String(), err)
+
+				// Avoid too fast retry, if the item failed.
+				select {
+				case <-ctx.Done():
+				case <-time.After(1 * time.Second):
+				}
+			}
+
+			offset += time.Since(starttime).Seconds()
+			item = v.nextPlayoutItem(ctx)
+		}
+	}()
+
+	// Process terminated, or user cancel the process.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "vLive: Playout stopping, platform=%v, pid=%v", v.Platform, v.PID)
+
+	err = cmd.Wait()
+	cancel()
+	<-feedDone
+	logger.Tf(ctx, "vLive: Playout done, platform=%v, pid=%v, err=%v", v.Platform, v.PID, err)
+
+	return err
+}
+
+// doPlayoutItem transmux the item to MPEG-TS, with the offset of timestamp, and write to w.
+func (v *VLiveTask) doPlayoutItem(ctx context.Context, item *VLivePlayoutItem, offset float64, w io.Writer) error {
+	input := item.File
+
+	args := []string{}
+	if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL {
+		args = append(args, "-re")
+	}
+	if item.Start > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", item.Start))
+	}
+	// For RTSP stream source, always use TCP transport.
+	if strings.HasPrefix(input.Target, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+
+	// Rebuild the stream url, because it may contain special characters.
+	if strings.Contains(input.Target, "://") || input.Type == FFprobeSourceTypeStream {
+		// Validate the protocol to prevent SSRF or local file access.
+		if err := ValidateServerURL(input.Target); err != nil {
+			return errors.Wrapf(err, "validate %v", input.Target)
+		}
+
+		if u, err := RebuildStreamURL(input.Target); err != nil {
+			return errors.Wrapf(err, "rebuild %v", input.Target)
+		} else {
+			args = append(args, "-i", u.String())
+		}
+	} else {
+		args = append(args, "-i", input.Target)
+	}
+
+	if item.Duration > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.3f", item.Duration))
+	}
+
+	v.lock.Lock()
+	v.Input, v.inputUUID = input.Target, input.UUID
+	transcode := v.transcode
+	v.lock.Unlock()
+
+	// Copy the codec if all files share the same codec parameters, or transcode to the same parameters,
+	// because the output FFmpeg copies the MPEG-TS stream which should not change codec parameters. Note that
+	// the timestamp of item is reset to start from 0, by setpts or make_zero for copy, because the input
+	// might not start from 0, then the offset makes the timestamp continuous.
+	if transcode {
+		args = append(args,
+			"-vf", fmt.Sprintf("setpts=PTS-STARTPTS,scale=%v:%v:force_original_aspect_ratio=decrease,pad=%v:%v:(ow-iw)/2:(oh-ih)/2,setsar=1",
+				vLivePlayoutWidth, vLivePlayoutHeight, vLivePlayoutWidth, vLivePlayoutHeight),
+			"-af", "asetpts=PTS-STARTPTS",
+			"-c:v", "libx264", "-profile:v", "high", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+			"-r", "25", "-g", "50", "-c:a", "aac", "-ar", "44100", "-ac", "2",
+		)
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-avoid_negative_ts", "make_zero")
+	args = append(args, "-output_ts_offset", fmt.Sprintf("%.3f", offset), "-f", "mpegts", "pipe:1")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	logger.Tf(ctx, "vLive: Play item %v, platform=%v, offset=%.3f", item.String(), v.Platform, offset)
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+	return nil
+}
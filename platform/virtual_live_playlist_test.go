@@ -0,0 +1,192 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newTestVLiveFiles(uuids ...string) []*FFprobeSource {
+	var files []*FFprobeSource
+	for _, uuid := range uuids {
+		files = append(files, &FFprobeSource{UUID: uuid, Name: uuid + ".mp4", Type: FFprobeSourceTypeFile})
+	}
+	return files
+}
+
+func TestVLivePlayout_Order(t *testing.T) {
+	files := newTestVLiveFiles("a", "b", "c")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+	// No playlist, loop all files in order.
+	playout := NewVLivePlayout(nil, files)
+	for _, expect := range []string{"a", "b", "c", "a", "b"} {
+		if item := playout.Next(now); item == nil || item.UUID != expect {
+			t.Fatalf("Expected %v, got %v", expect, item)
+		}
+	}
+
+	// Play the items once, with trim.
+	playout = NewVLivePlayout(&VLivePlaylist{Items: []*VLivePlaylistItem{
+		{File: "c", Start: 10, End: 40}, {File: "a"},
+	}}, files)
+	if item := playout.Next(now); item == nil || item.UUID != "c" || item.Start != 10 || item.Duration != 30 {
+		t.Errorf("Expected c trimmed, got %v", item)
+	}
+	if item := playout.Peek(now); item == nil || item.UUID != "a" {
+		t.Errorf("Expected next a, got %v", item)
+	}
+	if item := playout.Next(now); item == nil || item.UUID != "a" || item.Duration != 0 {
+		t.Errorf("Expected a, got %v", item)
+	}
+	if item := playout.Next(now); item != nil {
+		t.Errorf("Expected finished, got %v", item)
+	}
+	if item := playout.Peek(now); item != nil {
+		t.Errorf("Expected no next, got %v", item)
+	}
+}
+
+func TestVLivePlayout_Shuffle(t *testing.T) {
+	files := newTestVLiveFiles("a", "b", "c", "d")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+	playout := NewVLivePlayout(&VLivePlaylist{Mode: VLivePlaylistModeShuffle, Loop: true}, files, func(v *VLivePlayout) {
+		v.random = rand.New(rand.NewSource(1))
+	})
+
+	// Each round should play all files once.
+	for round := 0; round < 3; round++ {
+		played := make(map[string]bool)
+		for i := 0; i < len(files); i++ {
+			peek := playout.Peek(now)
+			item := playout.Next(now)
+			if item == nil || peek == nil || peek.UUID != item.UUID {
+				t.Fatalf("Expected peek %v equals %v", peek, item)
+			}
+			played[item.UUID] = true
+		}
+		if len(played) != len(files) {
+			t.Errorf("Expected all files played in round %v, got %v", round, played)
+		}
+	}
+}
+
+func TestVLivePlayout_Slots(t *testing.T) {
+	files := newTestVLiveFiles("a", "show")
+	playout := NewVLivePlayout(&VLivePlaylist{Loop: true, Items: []*VLivePlaylistItem{{File: "a"}}, Slots: []*VLiveScheduleSlot{
+		{VLivePlaylistItem: VLivePlaylistItem{File: "show"}, At: "20:00"},
+	}}, files)
+
+	// The item is cut at the slot.
+	now := time.Date(2024, 1, 1, 19, 59, 0, 0, time.Local)
+	if item := playout.Next(now); item == nil || item.UUID != "a" || item.Duration != 60 {
+		t.Errorf("Expected a cut at slot, got %v", item)
+	}
+	if item := playout.Peek(now.Add(60 * time.Second)); item == nil || item.UUID != "show" || !item.Slot {
+		t.Errorf("Expected next show, got %v", item)
+	}
+
+	// The slot is played once at the time.
+	now = time.Date(2024, 1, 1, 20, 0, 1, 0, time.Local)
+	if item := playout.Next(now); item == nil || item.UUID != "show" || !item.Slot {
+		t.Errorf("Expected show, got %v", item)
+	}
+	if item := playout.Next(now); item == nil || item.UUID != "a" {
+		t.Errorf("Expected a, got %v", item)
+	}
+
+	// Missed the slot, wait for next day.
+	playout = NewVLivePlayout(playout.playlist, files)
+	now = time.Date(2024, 1, 1, 20, 5, 0, 0, time.Local)
+	if item := playout.Next(now); item == nil || item.UUID != "a" {
+		t.Errorf("Expected a, got %v", item)
+	}
+	if next := playout.NextSlot(now); !next.Equal(time.Date(2024, 1, 2, 20, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected next slot tomorrow, got %v", next)
+	}
+
+	// The slot of some weekdays, 2024-01-01 is Monday.
+	slot := &VLiveScheduleSlot{At: "08:30", Weekdays: []time.Weekday{time.Wednesday}}
+	playout = NewVLivePlayout(&VLivePlaylist{Slots: []*VLiveScheduleSlot{slot}}, files)
+	if next := playout.NextSlot(now); !next.Equal(time.Date(2024, 1, 3, 8, 30, 0, 0, time.Local)) {
+		t.Errorf("Expected next slot on Wednesday, got %v", next)
+	}
+}
+
+func TestVLivePlaylist_Validate(t *testing.T) {
+	files := newTestVLiveFiles("a")
+	for _, c := range []struct {
+		playlist *VLivePlaylist
+		valid    bool
+	}{
+		{&VLivePlaylist{}, true},
+		{&VLivePlaylist{Mode: VLivePlaylistModeShuffle, Items: []*VLivePlaylistItem{{File: "a", Start: 1, End: 2}}}, true},
+		{&VLivePlaylist{Mode: "random"}, false},
+		{&VLivePlaylist{Items: []*VLivePlaylistItem{{File: "b"}}}, false},
+		{&VLivePlaylist{Items: []*VLivePlaylistItem{{File: "a", Start: 2, End: 1}}}, false},
+		{&VLivePlaylist{Slots: []*VLiveScheduleSlot{{VLivePlaylistItem: VLivePlaylistItem{File: "a"}, At: "20:00"}}}, true},
+		{&VLivePlaylist{Slots: []*VLiveScheduleSlot{{VLivePlaylistItem: VLivePlaylistItem{File: "a"}, At: "25:00"}}}, false},
+		{&VLivePlaylist{Slots: []*VLiveScheduleSlot{{VLivePlaylistItem: VLivePlaylistItem{File: "a"}, At: "20:00", Weekdays: []time.Weekday{7}}}}, false},
+	} {
+		if err := c.playlist.Validate(files); (err == nil) != c.valid {
+			t.Errorf("Expected valid=%v for %v, got %v", c.valid, c.playlist.String(), err)
+		}
+	}
+}
+
+func TestVLivePlayout_SameCodecs(t *testing.T) {
+	files := newTestVLiveFiles("a", "b", "c")
+	for _, file := range files {
+		file.Video = &FFprobeVideo{CodecName: "h264", Profile: "High", Width: 1280, Height: 720, PixFormat: "yuv420p"}
+		file.Audio = &FFprobeAudio{CodecName: "aac", Profile: "LC", SampleRate: "44100", Channels: 2}
+	}
+
+	// Only the files of playlist are checked.
+	files[2].Video.Width = 1920
+	playout := NewVLivePlayout(&VLivePlaylist{Items: []*VLivePlaylistItem{{File: "b"}, {File: "a"}, {File: "b"}}}, files)
+	if got := playout.Files(); len(got) != 2 || got[0].UUID != "b" || got[1].UUID != "a" {
+		t.Errorf("Expected files b and a, got %v", got)
+	}
+	if !vLiveSameCodecs(playout.Files()) {
+		t.Errorf("Expected same codecs")
+	}
+
+	if vLiveSameCodecs(NewVLivePlayout(nil, files).Files()) {
+		t.Errorf("Expected different resolution")
+	}
+
+	files[2].Video.Width, files[2].Audio.SampleRate = 1280, "48000"
+	if vLiveSameCodecs(files) {
+		t.Errorf("Expected different sample rate")
+	}
+
+	files[2].Audio, files[2].Video = nil, nil
+	if vLiveSameCodecs(files) {
+		t.Errorf("Expected no probe information")
+	}
+}
+
+func TestVLiveConfigure_Update(t *testing.T) {
+	conf := &VLiveConfigure{
+		Files: newTestVLiveFiles("a"), Fallbacks: newTestVLiveFiles("b"), Playlist: &VLivePlaylist{Loop: true},
+	}
+
+	// Keep the playlist and fallbacks if not specified.
+	if err := conf.Update(&VLiveConfigure{Files: newTestVLiveFiles("a")}); err != nil {
+		t.Fatalf("update err %+v", err)
+	}
+	if conf.Playlist == nil || len(conf.Fallbacks) != 1 {
+		t.Errorf("Expected kept, playlist=%v, fallbacks=%v", conf.Playlist, conf.Fallbacks)
+	}
+
+	// Clear the playlist and fallbacks if specified as null or empty.
+	if err := conf.Update(&VLiveConfigure{
+		Files: newTestVLiveFiles("a"), Fallbacks: []*FFprobeSource{}, playlistSpecified: true,
+	}); err != nil {
+		t.Fatalf("update err %+v", err)
+	}
+	if conf.Playlist != nil || conf.Fallbacks != nil {
+		t.Errorf("Expected cleared, playlist=%v, fallbacks=%v", conf.Playlist, conf.Fallbacks)
+	}
+}
@@ -421,6 +421,9 @@ function LiveRoomAssistant({room, requesting, updateRoom}) {
   const [aiTtsEnabled, setAiTtsEnabled] = React.useState(room.aiTtsEnabled);
   const [aiAsrLanguage, setAiAsrLanguage] = React.useState(room.aiAsrLanguage || language || 'en');
   const [aiAsrPrompt, setAiAsrPrompt] = React.useState(room.aiAsrPrompt || 'user-ai');
+  const [aiAsrProvider, setAiAsrProvider] = React.useState(room.aiAsrProvider || 'openai');
+  const [aiAsrSecretKey, setAiAsrSecretKey] = React.useState(room.aiAsrSecretKey);
+  const [aiAsrBaseURL, setAiAsrBaseURL] = React.useState(room.aiAsrBaseURL);
   const [aiChatModel, setAiChatModel] = React.useState(room.aiChatModel || 'gpt-3.5-turbo');
   const [aiChatPrompt, setAiChatPrompt] = React.useState(room.aiChatPrompt || 'You are a helpful assistant.');
   const [aiChatMaxWindow, setAiChatMaxWindow] = React.useState(room.aiChatMaxWindow || 5);
@@ -464,14 +467,15 @@ function LiveRoomAssistant({room, requesting, updateRoom}) {
       aiChatPrompt, aiChatMaxWindow: parseInt(aiChatMaxWindow),
       aiChatMaxWords: parseInt(aiChatMaxWords), aiAsrEnabled: !!aiAsrEnabled,
       aiChatEnabled: !!aiChatEnabled, aiTtsEnabled: !!aiTtsEnabled,
-      aiAsrPrompt,
+      aiAsrPrompt, aiAsrProvider, aiAsrSecretKey, aiAsrBaseURL,
       aiPostEnabled: !!aiPostEnabled, aiPostModel, aiPostPrompt,
       aiPostMaxWindow: parseInt(aiPostMaxWindow), aiPostMaxWords: parseInt(aiPostMaxWords),
     })
   }, [
     updateRoom, room, aiName, aiProvider, aiSecretKey, aiBaseURL, aiAsrLanguage, aiChatModel, aiChatPrompt,
     aiChatMaxWindow, aiChatMaxWords, aiAsrEnabled, aiChatEnabled, aiTtsEnabled, aiAsrPrompt, aiOrganization,
-    aiPostEnabled, aiPostModel, aiPostPrompt, aiPostMaxWindow, aiPostMaxWords,
+    aiPostEnabled, aiPostModel, aiPostPrompt, aiPostMaxWindow, aiPostMaxWords, aiAsrProvider, aiAsrSecretKey,
+    aiAsrBaseURL,
   ]);
 
   const onDisableRoom = React.useCallback((e) => {
@@ -594,6 +598,27 @@ function LiveRoomAssistant({room, requesting, updateRoom}) {
               <option value="user-ai">User Input + AI Output</option>
             </Form.Select>
           </Form.Group>
+          <Form.Group className="mb-3" controlId={`${baseId}-asr-provider`}>
+            <Form.Label>{t('lr.room.asrProvider')}</Form.Label>
+            <Form.Text> * {t('lr.room.asrProvider2')}</Form.Text>
+            <Form.Select defaultValue={aiAsrProvider} onChange={(e) => setAiAsrProvider(e.target.value)}>
+              <option value="">--{t('helper.noSelect')}--</option>
+              <option value="openai">OpenAI</option>
+              <option value="whisper">Whisper</option>
+            </Form.Select>
+          </Form.Group>
+          <Form.Group className="mb-3" controlId={`${baseId}-asr-url`}>
+            <Form.Label>{t('lr.room.asrURL')}</Form.Label>
+            <Form.Text> * {t('lr.room.asrURL2')}. &nbsp;
+              {t('helper.eg')} <code>http://127.0.0.1:8080</code>
+            </Form.Text>
+            <Form.Control as="input" defaultValue={aiAsrBaseURL} onChange={(e) => setAiAsrBaseURL(e.target.value)}/>
+          </Form.Group>
+          <Form.Group className="mb-3" controlId={`${baseId}-asr-key`}>
+            <Form.Label>{t('lr.room.asrKey')}</Form.Label>
+            <Form.Text> * {t('lr.room.asrKey2')}</Form.Text>
+            <Form.Control as="input" type="password" defaultValue={aiAsrSecretKey} onChange={(e) => setAiAsrSecretKey(e.target.value)}/>
+          </Form.Group>
           <LiveRoomAssistantUpdateButtons {...{requesting, onUpdateRoom, onDisableRoom}} />
         </Card.Body>}
         {configItem === 'chat' && <Card.Body>